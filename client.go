@@ -2,9 +2,14 @@ package wgctrl
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgctrlinternal"
 	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wguser"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -12,15 +17,89 @@ import (
 var _ wginternal.Client = &Client{}
 
 // A Client provides access to WireGuard device information.
+//
+// A Client is safe for concurrent use by multiple goroutines. Operations on
+// independent devices may be performed in parallel; see
+// Options.MaxNetlinkConns.
 type Client struct {
 	// Seamlessly use different wginternal.Client implementations to provide an
 	// interface similar to wg(8).
 	cs []wginternal.Client
 }
 
+func init() {
+	// Allow package wgctrltest to create a Client which controls fake
+	// devices, without adding to the wgctrl API.
+	wgctrlinternal.NewClient = func(c wginternal.Client) interface{} {
+		return newWithClient(c)
+	}
+}
+
+// Options specifies optional configuration for a Client. The zero value of
+// Options applies the same configuration as New.
+type Options struct {
+	// PoolUserspaceConns specifies that connections to userspace WireGuard
+	// devices should be kept open and reused by subsequent operations,
+	// rather than dialing a new connection for every operation. Userspace
+	// device discovery results are cached as well, and are refreshed
+	// automatically if a device cannot be found or reached.
+	//
+	// Pooling is useful when devices are polled frequently.
+	PoolUserspaceConns bool
+
+	// UserspaceDiscoveryTTL specifies how long userspace device discovery
+	// results are cached when PoolUserspaceConns is set. If zero, a default
+	// value is used.
+	UserspaceDiscoveryTTL time.Duration
+
+	// Trace, if not nil, is called with a record of every request and
+	// response exchanged with a device, such as userspace configuration
+	// protocol messages or Linux generic netlink attributes. Private and
+	// preshared keys are redacted from every record.
+	//
+	// Trace is currently only supported for Linux kernel devices and
+	// userspace devices. Exchanges with FreeBSD, OpenBSD, and Windows kernel
+	// devices are not traced.
+	//
+	// Trace may be called concurrently from multiple goroutines.
+	Trace wgtypes.TraceFunc
+
+	// MaxNetlinkConns specifies the maximum number of generic netlink
+	// connections used concurrently to control Linux kernel devices. Each
+	// operation has exclusive use of a connection, so up to MaxNetlinkConns
+	// operations proceed in parallel and additional operations wait for a
+	// connection to become available. If zero, GOMAXPROCS is used.
+	//
+	// MaxNetlinkConns has no effect on other platforms.
+	MaxNetlinkConns int
+
+	// LinkInfo specifies that each Device should be annotated with
+	// information about its network interface, such as its MTU, operational
+	// state, and interface statistics, in Device.Link.
+	//
+	// LinkInfo is currently only supported for Linux kernel devices.
+	LinkInfo bool
+
+	// Workers specifies the maximum number of devices fetched concurrently
+	// by each WireGuard implementation when enumerating devices with Devices
+	// or DevicesPartial. If zero, GOMAXPROCS is used. On Linux, kernel
+	// devices are additionally bounded by MaxNetlinkConns.
+	Workers int
+}
+
 // New creates a new Client.
 func New() (*Client, error) {
-	cs, err := newClients()
+	return NewWithOptions(nil)
+}
+
+// NewWithOptions creates a new Client using the optional configuration in
+// opts. If opts is nil, NewWithOptions is equivalent to New.
+func NewWithOptions(opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	cs, err := newClients(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +109,21 @@ func New() (*Client, error) {
 	}, nil
 }
 
+// newWithClient creates a Client which uses only c.
+func newWithClient(c wginternal.Client) *Client {
+	return &Client{cs: []wginternal.Client{c}}
+}
+
+// userspaceConfig produces the configuration for the userspace client.
+func (o *Options) userspaceConfig() *wguser.Config {
+	return &wguser.Config{
+		Pool:         o.PoolUserspaceConns,
+		DiscoveryTTL: o.UserspaceDiscoveryTTL,
+		Trace:        o.Trace,
+		Workers:      o.Workers,
+	}
+}
+
 // Close releases resources used by a Client.
 func (c *Client) Close() error {
 	for _, wgc := range c.cs {
@@ -42,20 +136,82 @@ func (c *Client) Close() error {
 }
 
 // Devices retrieves all WireGuard devices on this system.
+//
+// Devices are fetched concurrently. If any device cannot be fetched, an error
+// is returned; use DevicesPartial to retrieve the remaining devices.
 func (c *Client) Devices() ([]*wgtypes.Device, error) {
+	devs := make([][]*wgtypes.Device, len(c.cs))
+	errs := make([]error, len(c.cs))
+	c.each(func(i int, wgc wginternal.Client) {
+		devs[i], errs[i] = wgc.Devices()
+	})
+
 	var out []*wgtypes.Device
-	for _, wgc := range c.cs {
-		devs, err := wgc.Devices()
-		if err != nil {
-			return nil, err
+	for i := range c.cs {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
 
-		out = append(out, devs...)
+		out = append(out, devs[i]...)
 	}
 
 	return out, nil
 }
 
+// DevicesPartial retrieves all WireGuard devices on this system which can be
+// fetched successfully, along with the errors for devices which could not be
+// fetched, keyed by device name. errs is nil if every device was fetched.
+//
+// DevicesPartial is useful for monitoring, where a single broken device should
+// not prevent reporting the remaining devices. A non-nil error indicates that
+// devices could not be enumerated by at least one WireGuard implementation;
+// devices from the other implementations are still returned.
+func (c *Client) DevicesPartial() (ds []*wgtypes.Device, errs map[string]error, err error) {
+	devs := make([][]*wgtypes.Device, len(c.cs))
+	derrs := make([]map[string]error, len(c.cs))
+	cerrs := make([]error, len(c.cs))
+	c.each(func(i int, wgc wginternal.Client) {
+		if pc, ok := wgc.(wginternal.PartialClient); ok {
+			devs[i], derrs[i], cerrs[i] = pc.DevicesPartial()
+			return
+		}
+
+		devs[i], cerrs[i] = wgc.Devices()
+	})
+
+	for i := range c.cs {
+		if cerrs[i] != nil && err == nil {
+			err = cerrs[i]
+		}
+
+		ds = append(ds, devs[i]...)
+
+		for name, derr := range derrs[i] {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[name] = derr
+		}
+	}
+
+	return ds, errs, err
+}
+
+// each calls fn concurrently for each of the Client's WireGuard
+// implementations and waits for all calls to complete.
+func (c *Client) each(fn func(i int, wgc wginternal.Client)) {
+	var wg sync.WaitGroup
+	wg.Add(len(c.cs))
+	for i, wgc := range c.cs {
+		go func(i int, wgc wginternal.Client) {
+			defer wg.Done()
+			fn(i, wgc)
+		}(i, wgc)
+	}
+
+	wg.Wait()
+}
+
 // Device retrieves a WireGuard device by its interface name.
 //
 // If the device specified by name does not exist or is not a WireGuard device,
@@ -76,6 +232,30 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 	return nil, os.ErrNotExist
 }
 
+// DeviceByIndex retrieves a WireGuard device by its interface index. Unlike
+// an interface name, an interface index remains stable when a device is
+// renamed, which makes it suitable for correlating devices with other sources
+// of network interface information, such as rtnetlink events.
+//
+// If the device specified by index does not exist or is not a WireGuard
+// device, an error is returned which can be checked using
+// `errors.Is(err, os.ErrNotExist)`.
+func (c *Client) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	for _, wgc := range c.cs {
+		d, err := wgc.DeviceByIndex(index)
+		switch {
+		case err == nil:
+			return d, nil
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
 // ConfigureDevice configures a WireGuard device by its interface name.
 //
 // Because the zero value of some Go types may be significant to WireGuard for
@@ -99,3 +279,68 @@ func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
 
 	return os.ErrNotExist
 }
+
+// ConfigureDeviceTransactional configures a WireGuard device by its interface
+// name, like ConfigureDevice, but restores the device's previous
+// configuration if the configuration cannot be applied completely.
+//
+// Large configurations may be applied in several steps, so a failure can
+// otherwise leave a device partially configured, for example with its peers
+// removed by ReplacePeers but not yet replaced. ConfigureDeviceTransactional
+// takes a snapshot of the device before applying cfg and, on failure, applies
+// only the changes necessary to return the device to that snapshot, so that
+// unaffected peers keep their sessions. Peers which must be restored lose
+// their statistics, such as their transfer counters.
+//
+// If the configuration fails, a *RollbackError is returned which contains
+// both the original error and the result of the rollback.
+//
+// If the device specified by name does not exist or is not a WireGuard device,
+// an error is returned which can be checked using `errors.Is(err, os.ErrNotExist)`.
+func (c *Client) ConfigureDeviceTransactional(name string, cfg wgtypes.Config) error {
+	for _, wgc := range c.cs {
+		prev, err := wgc.Device(name)
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		default:
+			return err
+		}
+
+		if err := wgc.ConfigureDevice(name, cfg); err != nil {
+			return &RollbackError{
+				Err:         err,
+				RollbackErr: wginternal.Rollback(wgc, name, prev),
+			}
+		}
+
+		return nil
+	}
+
+	return os.ErrNotExist
+}
+
+// A RollbackError is returned by Client.ConfigureDeviceTransactional when a
+// configuration could not be applied.
+type RollbackError struct {
+	// Err is the error which caused the configuration to fail.
+	Err error
+
+	// RollbackErr is the error which occurred while restoring the device's
+	// previous configuration, or nil if the previous configuration was
+	// restored successfully.
+	RollbackErr error
+}
+
+// Error implements error.
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("wgctrl: failed to configure device: %v (rollback failed: %v)", e.Err, e.RollbackErr)
+	}
+
+	return fmt.Sprintf("wgctrl: failed to configure device: %v (rolled back)", e.Err)
+}
+
+// Unwrap returns the error which caused the configuration to fail.
+func (e *RollbackError) Unwrap() error { return e.Err }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
@@ -66,6 +67,55 @@ func TestClientDevices(t *testing.T) {
 	}
 }
 
+func TestClientDevicesPartial(t *testing.T) {
+	var (
+		wg0 = &wgtypes.Device{Name: "wg0"}
+		wg2 = &wgtypes.Device{Name: "wg2"}
+		wg3 = &wgtypes.Device{Name: "wg3"}
+	)
+
+	c := &Client{
+		cs: []wginternal.Client{
+			// One device is broken, but the others are still reported.
+			&testPartialClient{
+				DevicesPartialFunc: func() ([]*wgtypes.Device, map[string]error, error) {
+					return []*wgtypes.Device{wg0, wg2}, map[string]error{"wg1": errFoo}, nil
+				},
+			},
+			// Devices cannot be enumerated at all.
+			&testClient{
+				DevicesFunc: func() ([]*wgtypes.Device, error) {
+					return nil, os.ErrPermission
+				},
+			},
+			// Clients which don't support partial results fall back to Devices.
+			&testClient{
+				DevicesFunc: func() ([]*wgtypes.Device, error) {
+					return []*wgtypes.Device{wg3}, nil
+				},
+			},
+		},
+	}
+
+	ds, errs, err := c.DevicesPartial()
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected permission denied, but got: %v", err)
+	}
+
+	if diff := cmp.Diff([]*wgtypes.Device{wg0, wg2, wg3}, ds); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]error{"wg1": errFoo}, errs, cmpErrors); diff != "" {
+		t.Fatalf("unexpected device errors (-want +got):\n%s", diff)
+	}
+
+	// Devices reports the first error in backend order.
+	if _, err := c.Devices(); !errors.Is(err, errFoo) {
+		t.Fatalf("expected device error, but got: %v", err)
+	}
+}
+
 func TestClientDevice(t *testing.T) {
 	type deviceFunc func(name string) (*wgtypes.Device, error)
 
@@ -149,6 +199,43 @@ func TestClientDevice(t *testing.T) {
 	}
 }
 
+func TestClientDeviceByIndex(t *testing.T) {
+	var (
+		notExist = func(_ int) (*wgtypes.Device, error) {
+			return nil, os.ErrNotExist
+		}
+
+		returnDevice = func(index int) (*wgtypes.Device, error) {
+			if index != 1 {
+				panic("unexpected index")
+			}
+
+			return okDevice, nil
+		}
+	)
+
+	c := &Client{
+		cs: []wginternal.Client{
+			&testClient{DeviceByIndexFunc: notExist},
+			&testClient{DeviceByIndexFunc: returnDevice},
+		},
+	}
+
+	d, err := c.DeviceByIndex(1)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if diff := cmp.Diff(okDevice, d); diff != "" {
+		t.Fatalf("unexpected device (-want +got):\n%s", diff)
+	}
+
+	c.cs = c.cs[:1]
+	if _, err := c.DeviceByIndex(1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected is not exist, but got: %v", err)
+	}
+}
+
 func TestClientConfigureDevice(t *testing.T) {
 	type configFunc func(name string, cfg wgtypes.Config) error
 
@@ -224,10 +311,163 @@ func TestClientConfigureDevice(t *testing.T) {
 	}
 }
 
+func TestClientConfigureDeviceTransactional(t *testing.T) {
+	var (
+		peerA = wgtypes.Key{0x01}
+		peerB = wgtypes.Key{0x02}
+	)
+
+	tests := []struct {
+		name       string
+		rollbackOK bool
+	}{
+		{
+			name:       "rolled back",
+			rollbackOK: true,
+		},
+		{
+			name: "rollback failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A device whose peer is replaced by a configuration which
+			// fails part-way through.
+			dev := &wgtypes.Device{
+				Name:  "wg0",
+				Peers: []wgtypes.Peer{{PublicKey: peerA}},
+			}
+
+			var applied []wgtypes.Config
+			configure := func(_ string, cfg wgtypes.Config) error {
+				applied = append(applied, cfg)
+
+				if len(applied) == 1 {
+					// Apply only the first half of the configuration.
+					dev = &wgtypes.Device{
+						Name:  "wg0",
+						Peers: []wgtypes.Peer{{PublicKey: peerB}},
+					}
+					return errFoo
+				}
+
+				if !tt.rollbackOK {
+					return os.ErrPermission
+				}
+
+				return nil
+			}
+
+			c := &Client{
+				cs: []wginternal.Client{
+					&testClient{
+						DeviceFunc: func(_ string) (*wgtypes.Device, error) {
+							return nil, os.ErrNotExist
+						},
+					},
+					&testClient{
+						DeviceFunc: func(_ string) (*wgtypes.Device, error) {
+							d := *dev
+							return &d, nil
+						},
+						ConfigureDeviceFunc: configure,
+					},
+				},
+			}
+
+			err := c.ConfigureDeviceTransactional("wg0", wgtypes.Config{
+				ReplacePeers: true,
+				Peers:        []wgtypes.PeerConfig{{PublicKey: peerB}},
+			})
+
+			var rerr *RollbackError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("expected RollbackError, but got: %v", err)
+			}
+
+			if !errors.Is(err, errFoo) {
+				t.Fatalf("RollbackError must wrap the original error: %v", err)
+			}
+
+			if tt.rollbackOK && rerr.RollbackErr != nil {
+				t.Fatalf("unexpected rollback error: %v", rerr.RollbackErr)
+			}
+			if !tt.rollbackOK && !errors.Is(rerr.RollbackErr, os.ErrPermission) {
+				t.Fatalf("expected rollback permission error, but got: %v", rerr.RollbackErr)
+			}
+
+			// The rollback must restore the original peer and remove the new
+			// one.
+			psk := wgtypes.Key{}
+			var keepalive time.Duration
+			want := wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   peerA,
+						PresharedKey:                &psk,
+						PersistentKeepaliveInterval: &keepalive,
+						ReplaceAllowedIPs:           true,
+					},
+					{
+						PublicKey: peerB,
+						Remove:    true,
+					},
+				},
+			}
+
+			if diff := cmp.Diff(2, len(applied)); diff != "" {
+				t.Fatalf("unexpected number of configurations (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(want, applied[1]); diff != "" {
+				t.Fatalf("unexpected rollback Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientConfigureDeviceTransactionalOK(t *testing.T) {
+	var calls int
+	c := &Client{
+		cs: []wginternal.Client{
+			&testClient{
+				DeviceFunc: func(_ string) (*wgtypes.Device, error) {
+					return okDevice, nil
+				},
+				ConfigureDeviceFunc: func(_ string, _ wgtypes.Config) error {
+					calls++
+					return nil
+				},
+			},
+		},
+	}
+
+	if err := c.ConfigureDeviceTransactional("wg0", wgtypes.Config{}); err != nil {
+		t.Fatalf("failed to configure device: %v", err)
+	}
+
+	if diff := cmp.Diff(1, calls); diff != "" {
+		t.Fatalf("unexpected number of configurations (-want +got):\n%s", diff)
+	}
+
+	// No backend has the device.
+	c.cs[0] = &testClient{
+		DeviceFunc: func(_ string) (*wgtypes.Device, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	if err := c.ConfigureDeviceTransactional("wg0", wgtypes.Config{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected is not exist, but got: %v", err)
+	}
+}
+
 type testClient struct {
 	CloseFunc           func() error
 	DevicesFunc         func() ([]*wgtypes.Device, error)
 	DeviceFunc          func(name string) (*wgtypes.Device, error)
+	DeviceByIndexFunc   func(index int) (*wgtypes.Device, error)
 	ConfigureDeviceFunc func(name string, cfg wgtypes.Config) error
 }
 
@@ -237,6 +477,28 @@ func (c *testClient) Device(name string) (*wgtypes.Device, error) {
 	return c.DeviceFunc(name)
 }
 
+func (c *testClient) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	return c.DeviceByIndexFunc(index)
+}
+
 func (c *testClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
 	return c.ConfigureDeviceFunc(name, cfg)
 }
+
+type testPartialClient struct {
+	testClient
+	DevicesPartialFunc func() ([]*wgtypes.Device, map[string]error, error)
+}
+
+func (c *testPartialClient) Devices() ([]*wgtypes.Device, error) {
+	ds, errs, err := c.DevicesPartialFunc()
+	for _, derr := range errs {
+		return nil, derr
+	}
+
+	return ds, err
+}
+
+func (c *testPartialClient) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	return c.DevicesPartialFunc()
+}
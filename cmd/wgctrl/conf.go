@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// showconfMain implements the showconf subcommand.
+func showconfMain(e *env, args []string) int {
+	out, rest, err := parseOutput(args)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+	}
+	if err != nil || len(rest) != 2 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s %s <interface>\n", e.prog, args[0], outputUsage)
+		return 1
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+		return 1
+	}
+
+	d, err := c.Device(rest[1])
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+		return 1
+	}
+
+	// The text format is a configuration file, which always contains keys.
+	if out.format == formatText {
+		_, _ = e.stdout.Write(wgconf.Marshal(d))
+		return 0
+	}
+
+	if err := newPrinter(e, out).structured([]*wgtypes.Device{d}, false); err != nil {
+		fmt.Fprintf(e.stderr, "Unable to write output: %s\n", strerror(err))
+		return 1
+	}
+
+	return 0
+}
+
+// setconfMain implements the setconf, addconf, and syncconf subcommands.
+func setconfMain(e *env, args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s <interface> <configuration filename>\n", e.prog, args[0])
+		return 1
+	}
+
+	f, err := os.Open(args[2])
+	if err != nil {
+		fmt.Fprintf(e.stderr, "%s: %s\n", args[2], strerror(err))
+		return 1
+	}
+	defer f.Close()
+
+	cfg, err := wgconf.Parse(f, nil)
+	if err != nil {
+		fmt.Fprintln(e.stderr, strings.TrimPrefix(err.Error(), "wgconf: "))
+		fmt.Fprintln(e.stderr, "Configuration parsing error")
+		return 1
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to modify interface: %s\n", strerror(err))
+		return 1
+	}
+
+	switch args[0] {
+	case "setconf":
+		cfg = wgconf.Replace(cfg)
+	case "syncconf":
+		d, err := c.Device(args[1])
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+			return 1
+		}
+
+		cfg = wgconf.Sync(d, cfg)
+	}
+
+	if err := c.ConfigureDevice(args[1], *cfg); err != nil {
+		fmt.Fprintf(e.stderr, "Unable to modify interface: %s\n", strerror(err))
+		return 1
+	}
+
+	return 0
+}
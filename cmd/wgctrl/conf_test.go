@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestShowconf(t *testing.T) {
+	const want = `[Interface]
+ListenPort = 51820
+FwMark = 0x10
+PrivateKey = ` + testPrivate + `
+
+[Peer]
+PublicKey = ` + testPeerA + `
+
+[Peer]
+PublicKey = ` + testPeerB + `
+PresharedKey = ` + testPSK + `
+AllowedIPs = 10.0.0.0/24, ::/96, fd00::/64
+Endpoint = 192.0.2.1:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = ` + testPeerC + `
+AllowedIPs = 192.168.1.0/24
+Endpoint = [fd00::1]:51820
+`
+
+	e, stdout, stderr := testEnv(&testClient{devices: []*wgtypes.Device{testDevice()}})
+	if diff := cmp.Diff(0, run(e, []string{"showconf", "wg0"})); diff != "" {
+		t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(want, stdout.String()); diff != "" {
+		t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("", stderr.String()); diff != "" {
+		t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetconf(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		return path
+	}
+
+	var (
+		conf = write("wg0.conf", `[Interface]
+ListenPort = 51820
+
+[Peer]
+PublicKey = `+testPeerB+`
+PresharedKey = `+testPSK+`
+AllowedIPs = 10.0.0.0/24, ::ffff:0.0.0.0/96, fd00::/64
+PersistentKeepalive = 25
+`)
+		bad = write("bad.conf", "[Interface]\nAddress = 10.0.0.1/24\n")
+
+		zero wgtypes.Key
+		port = 51820
+		mark = 0
+		psk  = mustKey(testPSK)
+	)
+
+	peer := wgtypes.PeerConfig{
+		PublicKey:                   mustKey(testPeerB),
+		PresharedKey:                &psk,
+		PersistentKeepaliveInterval: durPtr(testDevice().Peers[1].PersistentKeepaliveInterval),
+		ReplaceAllowedIPs:           true,
+		AllowedIPs: []net.IPNet{
+			mustCIDR("10.0.0.0/24"),
+			mustCIDR("::ffff:0.0.0.0/96"),
+			mustCIDR("fd00::/64"),
+		},
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		cfgs   []wgtypes.Config
+		stderr string
+	}{
+		{
+			name: "setconf",
+			args: []string{"setconf", "wg0", conf},
+			cfgs: []wgtypes.Config{{
+				PrivateKey:   &zero,
+				ListenPort:   &port,
+				FirewallMark: &mark,
+				ReplacePeers: true,
+				Peers:        []wgtypes.PeerConfig{peer},
+			}},
+		},
+		{
+			name: "addconf",
+			args: []string{"addconf", "wg0", conf},
+			cfgs: []wgtypes.Config{{
+				ListenPort: &port,
+				Peers:      []wgtypes.PeerConfig{peer},
+			}},
+		},
+		{
+			name: "syncconf",
+			args: []string{"syncconf", "wg0", conf},
+			cfgs: []wgtypes.Config{{
+				PrivateKey:   &zero,
+				FirewallMark: &mark,
+				Peers: []wgtypes.PeerConfig{
+					{
+						// Only the allowed IP in IPv4-mapped form differs.
+						PublicKey:         mustKey(testPeerB),
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        peer.AllowedIPs,
+					},
+					{PublicKey: mustKey(testPeerA), Remove: true},
+					{PublicKey: mustKey(testPeerC), Remove: true},
+				},
+			}},
+		},
+		{
+			name:   "usage",
+			args:   []string{"setconf", "wg0"},
+			code:   1,
+			stderr: "Usage: wgctrl setconf <interface> <configuration filename>\n",
+		},
+		{
+			name:   "missing file",
+			args:   []string{"addconf", "wg0", filepath.Join(dir, "missing.conf")},
+			code:   1,
+			stderr: filepath.Join(dir, "missing.conf") + ": No such file or directory\n",
+		},
+		{
+			name:   "parse error",
+			args:   []string{"setconf", "wg0", bad},
+			code:   1,
+			stderr: "line 2: unrecognized line: Address=10.0.0.1/24\nConfiguration parsing error\n",
+		},
+		{
+			name:   "not exist",
+			args:   []string{"syncconf", "wg1", conf},
+			code:   1,
+			stderr: "Unable to access interface: No such device\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &testClient{devices: []*wgtypes.Device{testDevice()}}
+			e, stdout, stderr := testEnv(c)
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.cfgs, c.configured); diff != "" {
+				t.Fatalf("unexpected Configs (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("", stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func durPtr(d time.Duration) *time.Duration { return &d }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// diffMain implements the diff subcommand, which compares a device with a
+// configuration file. Like diff(1), it exits with status 0 if they match, 1
+// if they differ, and 2 if an error occurs.
+func diffMain(e *env, args []string) int {
+	out, rest, err := parseOutput(args)
+	if err == nil && out.format == formatDump {
+		err = fmt.Errorf("Invalid output format: `%s'", out.format)
+	}
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+	}
+	if err != nil || len(rest) != 3 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s [--format=text|json|jsonl] <interface> <configuration filename>\n", e.prog, args[0])
+		return 2
+	}
+
+	f, err := os.Open(rest[2])
+	if err != nil {
+		fmt.Fprintf(e.stderr, "%s: %s\n", rest[2], strerror(err))
+		return 2
+	}
+	defer f.Close()
+
+	// Accept wg-quick(8) configuration files, and only compare the settings
+	// which are applied by wg(8).
+	cfg, err := wgquick.Parse(f, nil)
+	if err != nil {
+		s := strings.TrimPrefix(err.Error(), "wgquick: ")
+		fmt.Fprintln(e.stderr, strings.TrimPrefix(s, "wgconf: "))
+		fmt.Fprintln(e.stderr, "Configuration parsing error")
+		return 2
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+		return 2
+	}
+
+	d, err := c.Device(rest[1])
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+		return 2
+	}
+
+	drift := wgconf.Diff(d, &cfg.Device)
+
+	p := newPrinter(e, out)
+	switch {
+	case out.format != formatText:
+		// Structured output is printed even if there are no differences.
+		if err := p.jsonDrift(d.Name, drift); err != nil {
+			fmt.Fprintf(e.stderr, "Unable to write output: %s\n", strerror(err))
+			return 2
+		}
+	case !drift.Empty():
+		p.drift(d.Name, drift)
+	}
+
+	if drift.Empty() {
+		return 0
+	}
+
+	return 1
+}
+
+// drift prints the differences between the device with name and its
+// configuration, with each changed setting shown as "device -> config".
+func (p *printer) drift(name string, d *wgconf.Drift) {
+	p.printf("%sinterface%s: %s%s%s\n", p.c(termGreen+termBold), p.c(termReset), p.c(termGreen), name, p.c(termReset))
+	p.fieldDrift(d.Fields)
+
+	peer := func(k wgtypes.Key, status string) {
+		p.printf("\n%speer%s: %s%s%s\n", p.c(termYellow+termBold), p.c(termReset), p.c(termYellow), k, p.c(termReset))
+		if status != "" {
+			p.printf("  %s%s%s\n", p.c(termRed), status, p.c(termReset))
+		}
+	}
+
+	for _, pc := range d.Missing {
+		peer(pc.PublicKey, "missing from interface")
+	}
+	for _, pd := range d.Changed {
+		peer(pd.PublicKey, "")
+		p.fieldDrift(pd.Fields)
+	}
+	for _, extra := range d.Extra {
+		peer(extra.PublicKey, "not in configuration")
+	}
+}
+
+// fieldDrift prints changed settings.
+func (p *printer) fieldDrift(fs []wgconf.FieldDrift) {
+	for _, f := range fs {
+		p.printf("  %s%s%s: %s -> %s\n", p.c(termBold), f.Name, p.c(termReset), f.Device, f.Config)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		return path
+	}
+
+	var (
+		same = write("same.conf", `[Interface]
+ListenPort = 51820
+FwMark = 0x10
+PrivateKey = `+testPrivate+`
+
+[Peer]
+PublicKey = `+testPeerA+`
+
+[Peer]
+PublicKey = `+testPeerC+`
+AllowedIPs = 192.168.1.0/24
+
+[Peer]
+PublicKey = `+testPeerB+`
+PresharedKey = `+testPSK+`
+AllowedIPs = fd00::/64, ::/96, 10.0.0.0/24
+PersistentKeepalive = 25
+`)
+		drift = write("drift.conf", `[Interface]
+ListenPort = 51821
+FwMark = 0x10
+PrivateKey = `+testPrivate+`
+
+[Peer]
+PublicKey = `+testPublic+`
+AllowedIPs = 10.0.9.0/24
+
+[Peer]
+PublicKey = `+testPeerB+`
+AllowedIPs = 10.0.0.0/24
+Endpoint = 192.0.2.2:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = `+testPeerC+`
+AllowedIPs = 192.168.1.0/24
+`)
+		quick = write("wg0.conf", `[Interface]
+Address = 10.0.0.1/24, fd00::1/64
+DNS = 192.0.2.53, example.com
+MTU = 1420
+Table = off
+PreUp = echo up
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PreDown = echo down
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+SaveConfig = false
+ListenPort = 51821
+FwMark = 0x10
+PrivateKey = `+testPrivate+`
+
+[Peer]
+PublicKey = `+testPeerA+`
+
+[Peer]
+PublicKey = `+testPeerC+`
+AllowedIPs = 192.168.1.0/24
+
+[Peer]
+PublicKey = `+testPeerB+`
+PresharedKey = `+testPSK+`
+AllowedIPs = fd00::/64, ::/96, 10.0.0.0/24
+PersistentKeepalive = 25
+`)
+		bad = write("bad.conf", "[Interface]\nFoo = bar\n")
+	)
+
+	tests := []struct {
+		name   string
+		args   []string
+		closed bool
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name: "same",
+			args: []string{"diff", "wg0", same},
+		},
+		{
+			name: "drift",
+			args: []string{"diff", "wg0", drift},
+			code: 1,
+			stdout: `interface: wg0
+  listening port: 51820 -> 51821
+
+peer: ` + testPublic + `
+  missing from interface
+
+peer: ` + testPeerB + `
+  preshared key: (hidden) -> (none)
+  endpoint: 192.0.2.1:51820 -> 192.0.2.2:51820
+  allowed ips: 10.0.0.0/24, ::/96, fd00::/64 -> 10.0.0.0/24
+
+peer: ` + testPeerA + `
+  not in configuration
+`,
+		},
+		{
+			// wg-quick(8) settings are ignored.
+			name:   "wg-quick",
+			args:   []string{"diff", "wg0", quick},
+			code:   1,
+			stdout: "interface: wg0\n  listening port: 51820 -> 51821\n",
+		},
+		{
+			name: "json",
+			args: []string{"diff", "--format=json", "wg0", drift},
+			code: 1,
+			stdout: `{
+  "schema_version": 1,
+  "name": "wg0",
+  "fields": [
+    {
+      "name": "listening port",
+      "device": "51820",
+      "config": "51821"
+    }
+  ],
+  "missing_peers": [
+    "` + testPublic + `"
+  ],
+  "extra_peers": [
+    "` + testPeerA + `"
+  ],
+  "changed_peers": [
+    {
+      "public_key": "` + testPeerB + `",
+      "fields": [
+        {
+          "name": "preshared key",
+          "device": "(hidden)",
+          "config": "(none)"
+        },
+        {
+          "name": "endpoint",
+          "device": "192.0.2.1:51820",
+          "config": "192.0.2.2:51820"
+        },
+        {
+          "name": "allowed ips",
+          "device": "10.0.0.0/24, ::/96, fd00::/64",
+          "config": "10.0.0.0/24"
+        }
+      ]
+    }
+  ]
+}
+`,
+		},
+		{
+			name:   "jsonl same",
+			args:   []string{"diff", "wg0", same, "--format", "jsonl"},
+			stdout: `{"schema_version":1,"name":"wg0","fields":[],"missing_peers":[],"extra_peers":[],"changed_peers":[]}` + "\n",
+		},
+		{
+			name:   "usage",
+			args:   []string{"diff", "wg0"},
+			code:   2,
+			stderr: "Usage: wgctrl diff [--format=text|json|jsonl] <interface> <configuration filename>\n",
+		},
+		{
+			name:   "dump",
+			args:   []string{"diff", "--format=dump", "wg0", same},
+			code:   2,
+			stderr: "Invalid output format: `dump'\nUsage: wgctrl diff [--format=text|json|jsonl] <interface> <configuration filename>\n",
+		},
+		{
+			name:   "parse error",
+			args:   []string{"diff", "wg0", bad},
+			code:   2,
+			stderr: "line 2: unrecognized line: Foo=bar\nConfiguration parsing error\n",
+		},
+		{
+			name:   "closed pipe",
+			args:   []string{"diff", "--format=json", "wg0", same},
+			closed: true,
+			code:   2,
+			stderr: "Unable to write output: Broken pipe\n",
+		},
+		{
+			name:   "no device",
+			args:   []string{"diff", "wg1", same},
+			code:   2,
+			stderr: "Unable to access interface: No such device\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{devices: []*wgtypes.Device{testDevice()}})
+			if tt.closed {
+				e.stdout = errWriter{err: syscall.EPIPE}
+			}
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
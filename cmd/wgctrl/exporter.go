@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgmetrics"
+)
+
+// exporterMain implements the exporter subcommand, which serves device and
+// peer metrics over HTTP for Prometheus.
+func exporterMain(e *env, args []string) int {
+	fset := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+
+	labels := make(labelFlag)
+	fset.Var(labels, "label", "")
+
+	var (
+		listen      = fset.String("listen", ":9586", "")
+		path        = fset.String("path", "/metrics", "")
+		peerLabels  = fset.String("peer-labels", "", "")
+		maxPeers    = fset.Int("max-peers", 10000, "")
+		minInterval = fset.Duration("min-interval", time.Second, "")
+	)
+
+	if err := fset.Parse(args[1:]); err != nil || fset.NArg() != 0 || !strings.HasPrefix(*path, "/") {
+		fmt.Fprintf(e.stderr, "Usage: %s %s [--listen <address>] [--path <path>] [--peer-labels <file>] [--label <name>=<value>]... [--max-peers <count>] [--min-interval <duration>]\n",
+			e.prog, args[0])
+		return 1
+	}
+
+	opts := &wgmetrics.Options{
+		Labels:      labels,
+		MaxPeers:    *maxPeers,
+		MinInterval: *minInterval,
+	}
+
+	if *peerLabels != "" {
+		f, err := os.Open(*peerLabels)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to open peer labels: %s\n", strerror(err))
+			return 1
+		}
+
+		opts.PeerLabels, err = wgmetrics.ParsePeerLabels(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to parse peer labels: %s\n", metricsError(err))
+			return 1
+		}
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+		return 1
+	}
+
+	h, err := wgmetrics.New(c, opts)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Invalid exporter configuration: %s\n", metricsError(err))
+		return 1
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*path, h)
+
+	fmt.Fprintf(e.stderr, "Serving metrics on %s%s\n", *listen, *path)
+	if err := e.serve(*listen, mux); err != nil {
+		fmt.Fprintf(e.stderr, "Unable to serve metrics: %s\n", strerror(err))
+		return 1
+	}
+
+	return 0
+}
+
+// metricsError returns the message of an error from package wgmetrics
+// without its package prefix.
+func metricsError(err error) string {
+	return strings.TrimPrefix(err.Error(), "wgmetrics: ")
+}
+
+// A labelFlag is a flag.Value which collects repeated name=value flags.
+type labelFlag map[string]string
+
+var _ flag.Value = labelFlag(nil)
+
+func (f labelFlag) String() string { return "" }
+
+func (f labelFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid label %q", s)
+	}
+
+	f[name] = value
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestExporter(t *testing.T) {
+	dir := t.TempDir()
+	labels := filepath.Join(dir, "labels")
+	if err := os.WriteFile(labels, []byte(testPeerA+" Alice's laptop\n"), 0o644); err != nil {
+		t.Fatalf("failed to write labels: %v", err)
+	}
+	invalid := filepath.Join(dir, "invalid")
+	if err := os.WriteFile(invalid, []byte("foo bar\n"), 0o644); err != nil {
+		t.Fatalf("failed to write labels: %v", err)
+	}
+
+	const usage = "Usage: wgctrl exporter [--listen <address>] [--path <path>] [--peer-labels <file>] [--label <name>=<value>]... [--max-peers <count>] [--min-interval <duration>]\n"
+
+	tests := []struct {
+		name     string
+		args     []string
+		serveErr error
+		code     int
+		addr     string
+		path     string
+		metrics  []string
+		stderr   string
+	}{
+		{
+			name:    "default",
+			args:    []string{"exporter"},
+			addr:    ":9586",
+			path:    "/metrics",
+			metrics: []string{`wireguard_device_listen_port{device="wg0"} 51820`},
+			stderr:  "Serving metrics on :9586/metrics\n",
+		},
+		{
+			name: "labels",
+			args: []string{"exporter", "--listen", "127.0.0.1:9000", "--path", "/wg", "--label", "host=gw1", "--peer-labels", labels},
+			addr: "127.0.0.1:9000",
+			path: "/wg",
+			metrics: []string{
+				`wireguard_device_listen_port{device="wg0",host="gw1"} 51820`,
+				`wireguard_peer_receive_bytes_total{device="wg0",public_key="` + testPeerA + `",name="Alice's laptop",host="gw1"} 100`,
+			},
+			stderr: "Serving metrics on 127.0.0.1:9000/wg\n",
+		},
+		{
+			name:   "arguments",
+			args:   []string{"exporter", "wg0"},
+			code:   1,
+			stderr: usage,
+		},
+		{
+			name:   "bad label",
+			args:   []string{"exporter", "--label", "host"},
+			code:   1,
+			stderr: usage,
+		},
+		{
+			name:   "reserved label",
+			args:   []string{"exporter", "--label", "device=wg0"},
+			code:   1,
+			stderr: "Invalid exporter configuration: label \"device\" is reserved\n",
+		},
+		{
+			name:   "invalid peer labels",
+			args:   []string{"exporter", "--peer-labels", invalid},
+			code:   1,
+			stderr: "Unable to parse peer labels: line 1: invalid public key: wgtypes: failed to parse base64-encoded key: illegal base64 data at input byte 0\n",
+		},
+		{
+			name:     "serve error",
+			args:     []string{"exporter"},
+			serveErr: errors.New("address already in use"),
+			code:     1,
+			addr:     ":9586",
+			path:     "/metrics",
+			stderr:   "Serving metrics on :9586/metrics\nUnable to serve metrics: address already in use\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{{
+					Name:       "wg0",
+					ListenPort: 51820,
+					Peers: []wgtypes.Peer{{
+						PublicKey:    mustKey(testPeerA),
+						ReceiveBytes: 100,
+					}},
+				}},
+			})
+
+			var addr, body string
+			e.serve = func(a string, h http.Handler) error {
+				addr = a
+
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+				b, err := io.ReadAll(w.Result().Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+				body = string(b)
+
+				return tt.serveErr
+			}
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.addr, addr); diff != "" {
+				t.Fatalf("unexpected address (-want +got):\n%s", diff)
+			}
+
+			for _, m := range tt.metrics {
+				if !strings.Contains(body, m+"\n") {
+					t.Fatalf("metrics do not contain %q:\n%s", m, body)
+				}
+			}
+		})
+	}
+}
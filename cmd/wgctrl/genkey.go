@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// genkeyMain implements the genkey and genpsk subcommands.
+func genkeyMain(e *env, args []string) int {
+	generate := wgtypes.GeneratePrivateKey
+	switch {
+	case args[0] == "genpsk":
+		generate = wgtypes.GenerateKey
+	case len(args) > 1:
+		return genkeyDir(e, args)
+	}
+
+	if len(args) != 1 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s\n", e.prog, args[0])
+		return 1
+	}
+
+	if worldAccessible(e.stdout) {
+		fmt.Fprint(e.stderr, "Warning: writing to world accessible file.\nConsider setting the umask to 077 and trying again.\n")
+	}
+
+	k, err := generate()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "%s: Unable to generate key: %s\n", e.prog, strerror(err))
+		return 1
+	}
+
+	fmt.Fprintln(e.stdout, k)
+	return 0
+}
+
+// genkeyDir implements genkey with arguments, which generates keypairs into
+// a directory. For each keypair, the private key is written to N.key and the
+// public key to N.pub, where N is the zero-padded index of the keypair.
+func genkeyDir(e *env, args []string) int {
+	fset := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+
+	var (
+		dir   = fset.String("dir", "", "")
+		count = fset.Int("count", 1, "")
+	)
+
+	if err := fset.Parse(args[1:]); err != nil || *dir == "" || *count < 1 || fset.NArg() > 0 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s [--dir <directory> [--count <number of keypairs>]]\n", e.prog, args[0])
+		return 1
+	}
+
+	// Only the owner may access the keys.
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		fmt.Fprintf(e.stderr, "%s: %s\n", *dir, strerror(err))
+		return 1
+	}
+
+	width := len(fmt.Sprint(*count - 1))
+	for i := 0; i < *count; i++ {
+		priv, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "%s: Unable to generate key: %s\n", e.prog, strerror(err))
+			return 1
+		}
+
+		name := filepath.Join(*dir, fmt.Sprintf("%0*d", width, i))
+		for _, f := range []struct {
+			path string
+			key  wgtypes.Key
+		}{
+			{path: name + ".key", key: priv},
+			{path: name + ".pub", key: priv.PublicKey()},
+		} {
+			if err := writeKey(f.path, f.key); err != nil {
+				fmt.Fprintf(e.stderr, "%s: %s\n", f.path, strerror(err))
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// writeKey writes k to a new file at path which only its owner can access.
+// Existing files are never overwritten.
+func writeKey(path string, k wgtypes.Key) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(f, k); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// pubkeyMain implements the pubkey subcommand.
+func pubkeyMain(e *env, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s\n", e.prog, args[0])
+		return 1
+	}
+
+	// Like wg(8), permit trailing whitespace and NUL bytes after the key.
+	n := base64.StdEncoding.EncodedLen(wgtypes.KeyLen)
+	b, err := io.ReadAll(e.stdin)
+	if err != nil || len(b) < n {
+		fmt.Fprintf(e.stderr, "%s: Key is not the correct length or format\n", e.prog)
+		return 1
+	}
+
+	s, trailing := string(b[:n]), string(b[n:])
+	if strings.TrimFunc(trailing, func(r rune) bool { return r == 0 || unicode.IsSpace(r) }) != "" {
+		fmt.Fprintf(e.stderr, "%s: Trailing characters found after key\n", e.prog)
+		return 1
+	}
+
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		fmt.Fprintf(e.stderr, "%s: Key is not the correct length or format\n", e.prog)
+		return 1
+	}
+
+	fmt.Fprintln(e.stdout, k.PublicKey())
+	return 0
+}
+
+// worldAccessible reports whether w is a regular file which other users can
+// access, in which case writing secrets to it is unsafe.
+func worldAccessible(w io.Writer) bool {
+	// Windows does not report meaningful permission bits.
+	if runtime.GOOS == "windows" {
+		return false
+	}
+
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode().IsRegular() && fi.Mode().Perm()&0o007 != 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestGenkey(t *testing.T) {
+	for _, name := range []string{"genkey", "genpsk"} {
+		t.Run(name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{})
+			if diff := cmp.Diff(0, run(e, []string{name})); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			k, err := wgtypes.ParseKey(strings.TrimSuffix(stdout.String(), "\n"))
+			if err != nil {
+				t.Fatalf("failed to parse key: %v", err)
+			}
+			if k == (wgtypes.Key{}) {
+				t.Fatal("generated zero key")
+			}
+
+			if diff := cmp.Diff("", stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGenkeyWorldAccessible(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping, Windows does not report permission bits")
+	}
+
+	f, err := os.OpenFile(filepath.Join(t.TempDir(), "key"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0o604); err != nil {
+		t.Fatalf("failed to change file mode: %v", err)
+	}
+
+	e, _, stderr := testEnv(&testClient{})
+	e.stdout = f
+
+	if diff := cmp.Diff(0, run(e, []string{"genkey"})); diff != "" {
+		t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+	}
+
+	const want = "Warning: writing to world accessible file.\nConsider setting the umask to 077 and trying again.\n"
+	if diff := cmp.Diff(want, stderr.String()); diff != "" {
+		t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+	}
+}
+
+func TestGenkeyDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	e, _, stderr := testEnv(&testClient{})
+	if diff := cmp.Diff(0, run(e, []string{"genkey", "--dir", dir, "--count", "11"})); diff != "" {
+		t.Fatalf("unexpected exit status (-want +got):\n%s\n%s", diff, stderr)
+	}
+
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if diff := cmp.Diff(22, len(fis)); diff != "" {
+		t.Fatalf("unexpected number of files (-want +got):\n%s", diff)
+	}
+
+	read := func(name string) wgtypes.Key {
+		path := filepath.Join(dir, name)
+		if runtime.GOOS != "windows" {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat: %v", err)
+			}
+			if perm := fi.Mode().Perm(); perm&^0o600 != 0 {
+				t.Fatalf("unexpected permissions for %s: %o", name, perm)
+			}
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read key: %v", err)
+		}
+
+		k, err := wgtypes.ParseKey(strings.TrimSpace(string(b)))
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+
+		return k
+	}
+
+	if diff := cmp.Diff(read("07.key").PublicKey(), read("07.pub")); diff != "" {
+		t.Fatalf("unexpected public key (-want +got):\n%s", diff)
+	}
+	if read("00.key") == read("10.key") {
+		t.Fatal("generated duplicate keys")
+	}
+
+	// Existing keys must not be overwritten.
+	e, _, stderr = testEnv(&testClient{})
+	if diff := cmp.Diff(1, run(e, []string{"genkey", "--dir", dir, "--count", "11"})); diff != "" {
+		t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(stderr.String(), "00.key: File exists") {
+		t.Fatalf("unexpected stderr: %q", stderr)
+	}
+}
+
+func TestPubkey(t *testing.T) {
+	tests := []struct {
+		name   string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "OK",
+			stdin:  testPrivate + "\n\x00 ",
+			stdout: testPublic + "\n",
+		},
+		{
+			name:   "short",
+			stdin:  testPrivate[:43],
+			code:   1,
+			stderr: "wgctrl: Key is not the correct length or format\n",
+		},
+		{
+			name:   "invalid",
+			stdin:  strings.Repeat("!", 44),
+			code:   1,
+			stderr: "wgctrl: Key is not the correct length or format\n",
+		},
+		{
+			name:   "trailing",
+			stdin:  testPrivate + "\nfoo",
+			code:   1,
+			stderr: "wgctrl: Trailing characters found after key\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{})
+			e.stdin = strings.NewReader(tt.stdin)
+
+			if diff := cmp.Diff(tt.code, run(e, []string{"pubkey"})); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
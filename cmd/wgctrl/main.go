@@ -1,93 +1,293 @@
-// Command wgctrl is a testing utility for interacting with WireGuard via package
-// wgctrl.
+// Command wgctrl is a utility for interacting with WireGuard via package
+// wgctrl. Its subcommands and their output are compatible with wg(8), so
+// scripts written for wg(8) can use wgctrl instead.
+//
+// # Machine-readable output
+//
+// The show and showconf subcommands accept --format to select an output
+// format: text (the default, as printed by wg(8)), json, jsonl, or dump. The
+// dump format is the same as wg(8)'s "dump" parameter. Secret keys, which
+// are private and preshared keys, are printed as "(hidden)" in the json,
+// jsonl, and dump formats unless --show-keys is given. Parameters such as
+// "endpoints" can only be used with the text format.
+//
+// The json format prints a single document:
+//
+//	{
+//	  "schema_version": 1,
+//	  "devices": [ <device>, ... ]
+//	}
+//
+// The jsonl format prints each device as a single line, which contains
+// "schema_version" in addition to the fields of the device. For "show
+// interfaces", the json format prints {"schema_version": 1, "interfaces":
+// ["wg0", ...]} and the jsonl format prints {"schema_version": 1, "name":
+// "wg0"} for each interface.
+//
+// A device has the following fields:
+//
+//	name           string: the interface name
+//	index          number: the interface index, or 0 if unknown
+//	type           string: the implementation, such as "Linux kernel"
+//	private_key    string: base64 key, "(hidden)", or null if not set
+//	public_key     string: base64 key, or null if not set
+//	listen_port    number: the listening port
+//	firewall_mark  number: the firewall mark, or 0 if not set
+//	peers          array: the device's peers
+//	link           object: interface information, only present if reported
+//
+// A peer has the following fields:
+//
+//	public_key                     string: base64 key
+//	preshared_key                  string: base64 key, "(hidden)", or null
+//	endpoint                       string: "host:port", or null if not set
+//	persistent_keepalive_interval  number: seconds, or 0 if disabled
+//	last_handshake_time            string: RFC 3339 time, or null if never
+//	receive_bytes                  number: bytes received from the peer
+//	transmit_bytes                 number: bytes sent to the peer
+//	allowed_ips                    array: CIDR strings
+//	protocol_version               number: the WireGuard protocol version
+//
+// A link has the fields mtu, flags (an array of names such as "up"),
+// oper_state, alias, and stats, which is null or contains the interface's
+// counters with snake_case names, such as "receive_packets".
+//
+// The diff subcommand accepts --format=json or --format=jsonl to print the
+// differences between an interface and its configuration as a single
+// document, indented for json and on a single line for jsonl, even if there
+// are no differences:
+//
+//	schema_version  number: the schema version
+//	name            string: the interface name
+//	fields          array: the changed interface settings
+//	missing_peers   array: base64 keys of configured peers not on the interface
+//	extra_peers     array: base64 keys of peers not in the configuration
+//	changed_peers   array: objects with public_key and fields
+//
+// A changed setting in fields has the fields name, device, and config, with
+// values formatted as in the text format. Secret keys are never printed.
+//
+// Fields may be added without notice, but schema_version is incremented
+// whenever a field is removed, renamed, or changes meaning.
 package main
 
 import (
-	"flag"
 	"fmt"
-	"log"
-	"net"
-	"strings"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+// A client is the subset of *wgctrl.Client used by subcommands, so that
+// subcommands can be tested with fake devices.
+type client interface {
+	DevicesPartial() ([]*wgtypes.Device, map[string]error, error)
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// An env is the environment in which a subcommand runs.
+type env struct {
+	// prog is the program name used in usage messages.
+	prog string
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	// terminal reports whether stdout is a terminal.
+	terminal bool
+
+	getenv func(key string) string
+	now    func() time.Time
+	sleep  func(d time.Duration)
+
+	// client returns a client for WireGuard devices.
+	client func() (client, error)
+
+	// serve serves HTTP requests with h on the TCP address addr until an
+	// error occurs.
+	serve func(addr string, h http.Handler) error
+
+	// system configures the network stack for the up and down subcommands,
+	// or nil to use the operating system's network stack.
+	system wgquick.System
+}
+
+// A command is a wgctrl subcommand. run is called with the subcommand's
+// arguments, including the subcommand name, and returns the exit status.
+type command struct {
+	name        string
+	description string
+	run         func(e *env, args []string) int
+}
+
+// commands are the available subcommands, in the order shown in usage
+// messages.
+var commands = []command{
+	{
+		name:        "show",
+		description: "Shows the current configuration and device information",
+		run:         showMain,
+	},
+	{
+		name:        "showconf",
+		description: "Shows the current configuration of a given WireGuard interface, for use with `setconf'",
+		run:         showconfMain,
+	},
+	{
+		name:        "set",
+		description: "Change the current configuration, add peers, remove peers, or change peers",
+		run:         setMain,
+	},
+	{
+		name:        "setconf",
+		description: "Applies a configuration file to a WireGuard interface",
+		run:         setconfMain,
+	},
+	{
+		name:        "addconf",
+		description: "Appends a configuration file to a WireGuard interface",
+		run:         setconfMain,
+	},
+	{
+		name:        "syncconf",
+		description: "Synchronizes a configuration file to a WireGuard interface",
+		run:         setconfMain,
+	},
+	{
+		name:        "diff",
+		description: "Compares a WireGuard interface with a configuration file and exits non-zero if they differ",
+		run:         diffMain,
+	},
+	{
+		name:        "genkey",
+		description: "Generates a new private key and writes it to stdout",
+		run:         genkeyMain,
+	},
+	{
+		name:        "genpsk",
+		description: "Generates a new preshared key and writes it to stdout",
+		run:         genkeyMain,
+	},
+	{
+		name:        "pubkey",
+		description: "Reads a private key from stdin and writes a public key to stdout",
+		run:         pubkeyMain,
+	},
+	{
+		name:        "top",
+		description: "Shows a continuously updated table of peers and their transfer rates",
+		run:         topMain,
+	},
+	{
+		name:        "exporter",
+		description: "Serves device and peer metrics over HTTP in the OpenMetrics format",
+		run:         exporterMain,
+	},
+	{
+		name:        "up",
+		description: "Creates and configures an interface from a wg-quick(8) configuration file",
+		run:         quickMain,
+	},
+	{
+		name:        "down",
+		description: "Removes an interface created by `up'",
+		run:         quickMain,
+	},
+}
+
 func main() {
-	flag.Parse()
+	var c *wgctrl.Client
 
-	c, err := wgctrl.New()
-	if err != nil {
-		log.Fatalf("failed to open wgctrl: %v", err)
-	}
-	defer c.Close()
+	e := &env{
+		prog:     filepath.Base(os.Args[0]),
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		terminal: isTerminal(os.Stdout),
+		getenv:   os.Getenv,
+		now:      time.Now,
+		sleep:    time.Sleep,
+		serve: func(addr string, h http.Handler) error {
+			s := &http.Server{
+				Addr:              addr,
+				Handler:           h,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
 
-	var devices []*wgtypes.Device
-	if device := flag.Arg(0); device != "" {
-		d, err := c.Device(device)
-		if err != nil {
-			log.Fatalf("failed to get device %q: %v", device, err)
-		}
+			return s.ListenAndServe()
+		},
+		client: func() (client, error) {
+			if c != nil {
+				return c, nil
+			}
 
-		devices = append(devices, d)
-	} else {
-		devices, err = c.Devices()
-		if err != nil {
-			log.Fatalf("failed to get devices: %v", err)
-		}
+			wgc, err := wgctrl.New()
+			if err != nil {
+				return nil, err
+			}
+
+			c = wgc
+			return c, nil
+		},
+	}
+
+	code := run(e, os.Args[1:])
+	if c != nil {
+		_ = c.Close()
 	}
 
-	for _, d := range devices {
-		printDevice(d)
+	os.Exit(code)
+}
 
-		for _, p := range d.Peers {
-			printPeer(p)
+// run runs the subcommand specified by args and returns the exit status.
+func run(e *env, args []string) int {
+	if len(args) == 1 {
+		switch args[0] {
+		case "-h", "--help", "help":
+			usage(e, e.stdout)
+			return 0
 		}
 	}
-}
 
-func printDevice(d *wgtypes.Device) {
-	const f = `interface: %s (%s)
-  public key: %s
-  private key: (hidden)
-  listening port: %d
+	if len(args) == 0 {
+		// Like wg(8), show all devices by default.
+		return showMain(e, []string{"show"})
+	}
 
-`
+	for _, c := range commands {
+		if args[0] == c.name {
+			return c.run(e, args)
+		}
+	}
 
-	fmt.Printf(
-		f,
-		d.Name,
-		d.Type.String(),
-		d.PublicKey.String(),
-		d.ListenPort)
+	fmt.Fprintf(e.stderr, "Invalid subcommand: `%s'\n", args[0])
+	usage(e, e.stderr)
+	return 1
 }
 
-func printPeer(p wgtypes.Peer) {
-	const f = `peer: %s
-  endpoint: %s
-  allowed ips: %s
-  latest handshake: %s
-  transfer: %d B received, %d B sent
-
-`
-
-	fmt.Printf(
-		f,
-		p.PublicKey.String(),
-		// TODO(mdlayher): get right endpoint with getnameinfo.
-		p.Endpoint.String(),
-		ipsString(p.AllowedIPs),
-		p.LastHandshakeTime.String(),
-		p.ReceiveBytes,
-		p.TransmitBytes,
-	)
+// usage prints the top-level usage message to w.
+func usage(e *env, w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <cmd> [<args>]\n\n", e.prog)
+	fmt.Fprintf(w, "Available subcommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s: %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(w, "You may pass `--help' to any of these subcommands to view usage.\n")
 }
 
-func ipsString(ipns []net.IPNet) string {
-	ss := make([]string, 0, len(ipns))
-	for _, ipn := range ipns {
-		ss = append(ss, ipn.String())
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
 	}
 
-	return strings.Join(ss, ", ")
+	return fi.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name: "default show",
+			args: nil,
+			stdout: `interface: wg0
+  listening port: 51820
+`,
+		},
+		{
+			name: "help",
+			args: []string{"--help"},
+			stdout: `Usage: wgctrl <cmd> [<args>]
+
+Available subcommands:
+` + subcommandList() + "You may pass `--help' to any of these subcommands to view usage.\n",
+		},
+		{
+			name: "invalid",
+			args: []string{"foo"},
+			code: 1,
+			stderr: "Invalid subcommand: `foo'\n" + `Usage: wgctrl <cmd> [<args>]
+
+Available subcommands:
+` + subcommandList() + "You may pass `--help' to any of these subcommands to view usage.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{{Name: "wg0", ListenPort: 51820}},
+			})
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func subcommandList() string {
+	var b strings.Builder
+	for _, c := range commands {
+		b.WriteString("  " + c.name + ": " + c.description + "\n")
+	}
+
+	return b.String()
+}
+
+// testNow is the current time in tests.
+var testNow = time.Unix(1700000000, 0)
+
+// testEnv creates an env which uses c and captures output.
+func testEnv(c *testClient) (*env, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	e := &env{
+		prog:   "wgctrl",
+		stdin:  strings.NewReader(""),
+		stdout: &stdout,
+		stderr: &stderr,
+		getenv: func(string) string { return "" },
+		now:    func() time.Time { return testNow },
+		sleep:  func(time.Duration) {},
+		client: func() (client, error) { return c, nil },
+	}
+
+	return e, &stdout, &stderr
+}
+
+var _ client = &testClient{}
+
+// A testClient is a client with fixed devices.
+type testClient struct {
+	devices []*wgtypes.Device
+	errs    map[string]error
+
+	configured []wgtypes.Config
+}
+
+func (c *testClient) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	return c.devices, c.errs, nil
+}
+
+func (c *testClient) Device(name string) (*wgtypes.Device, error) {
+	if err, ok := c.errs[name]; ok {
+		return nil, err
+	}
+
+	for _, d := range c.devices {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (c *testClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if _, err := c.Device(name); err != nil {
+		return err
+	}
+
+	c.configured = append(c.configured, cfg)
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Output formats for read commands, selected with --format.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatDump  = "dump"
+)
+
+// outputUsage describes the output flags in usage messages.
+const outputUsage = "[--format=text|json|jsonl|dump] [--show-keys]"
+
+// An output is the output configuration of a read command.
+type output struct {
+	// format is one of the format constants.
+	format string
+
+	// showKeys specifies that secret keys are printed rather than redacted
+	// in the machine-readable formats.
+	showKeys bool
+}
+
+// parseOutput removes the --format and --show-keys flags from the arguments
+// of a read command, and returns the output configuration and remaining
+// arguments. Flags may appear anywhere after the subcommand name.
+func parseOutput(args []string) (output, []string, error) {
+	out := output{format: formatText}
+	rest := []string{args[0]}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--show-keys":
+			out.showKeys = true
+			continue
+		case arg == "--format":
+			if i == len(args)-1 {
+				return output{}, nil, fmt.Errorf("Missing value for `--format'")
+			}
+
+			i++
+			out.format = args[i]
+		case strings.HasPrefix(arg, "--format="):
+			out.format = strings.TrimPrefix(arg, "--format=")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+
+		switch out.format {
+		case formatText, formatJSON, formatJSONL, formatDump:
+		default:
+			return output{}, nil, fmt.Errorf("Invalid output format: `%s'", out.format)
+		}
+	}
+
+	return out, rest, nil
+}
+
+// jsonSchemaVersion is the version of the JSON schema documented in the
+// package comment. It is incremented whenever a field is removed, renamed,
+// or changes meaning; adding fields does not change the version.
+const jsonSchemaVersion = 1
+
+// hidden replaces secret keys which are redacted.
+const hidden = "(hidden)"
+
+// A jsonDevices is the document printed for devices with --format=json.
+type jsonDevices struct {
+	SchemaVersion int          `json:"schema_version"`
+	Devices       []jsonDevice `json:"devices"`
+}
+
+// A jsonInterfaces is the document printed for show interfaces with
+// --format=json.
+type jsonInterfaces struct {
+	SchemaVersion int      `json:"schema_version"`
+	Interfaces    []string `json:"interfaces"`
+}
+
+// A jsonInterface is a line printed for show interfaces with --format=jsonl.
+type jsonInterface struct {
+	SchemaVersion int    `json:"schema_version"`
+	Name          string `json:"name"`
+}
+
+// A jsonDevice is the JSON representation of a wgtypes.Device.
+type jsonDevice struct {
+	// SchemaVersion is only set for --format=jsonl, where each device is a
+	// separate document.
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	Name         string     `json:"name"`
+	Index        int        `json:"index"`
+	Type         string     `json:"type"`
+	PrivateKey   *string    `json:"private_key"`
+	PublicKey    *string    `json:"public_key"`
+	ListenPort   int        `json:"listen_port"`
+	FirewallMark int        `json:"firewall_mark"`
+	Peers        []jsonPeer `json:"peers"`
+	Link         *jsonLink  `json:"link,omitempty"`
+}
+
+// A jsonPeer is the JSON representation of a wgtypes.Peer.
+type jsonPeer struct {
+	PublicKey                   string   `json:"public_key"`
+	PresharedKey                *string  `json:"preshared_key"`
+	Endpoint                    *string  `json:"endpoint"`
+	PersistentKeepaliveInterval int      `json:"persistent_keepalive_interval"`
+	LastHandshakeTime           *string  `json:"last_handshake_time"`
+	ReceiveBytes                int64    `json:"receive_bytes"`
+	TransmitBytes               int64    `json:"transmit_bytes"`
+	AllowedIPs                  []string `json:"allowed_ips"`
+	ProtocolVersion             int      `json:"protocol_version"`
+}
+
+// A jsonLink is the JSON representation of a wgtypes.Link.
+type jsonLink struct {
+	MTU       int            `json:"mtu"`
+	Flags     []string       `json:"flags"`
+	OperState string         `json:"oper_state"`
+	Alias     string         `json:"alias"`
+	Stats     *jsonLinkStats `json:"stats"`
+}
+
+// A jsonLinkStats is the JSON representation of a wgtypes.LinkStats.
+type jsonLinkStats struct {
+	ReceivePackets          uint64 `json:"receive_packets"`
+	TransmitPackets         uint64 `json:"transmit_packets"`
+	ReceiveBytes            uint64 `json:"receive_bytes"`
+	TransmitBytes           uint64 `json:"transmit_bytes"`
+	ReceiveErrors           uint64 `json:"receive_errors"`
+	TransmitErrors          uint64 `json:"transmit_errors"`
+	ReceiveDropped          uint64 `json:"receive_dropped"`
+	TransmitDropped         uint64 `json:"transmit_dropped"`
+	Multicast               uint64 `json:"multicast"`
+	Collisions              uint64 `json:"collisions"`
+	ReceiveLengthErrors     uint64 `json:"receive_length_errors"`
+	ReceiveOverErrors       uint64 `json:"receive_over_errors"`
+	ReceiveCRCErrors        uint64 `json:"receive_crc_errors"`
+	ReceiveFrameErrors      uint64 `json:"receive_frame_errors"`
+	ReceiveFIFOErrors       uint64 `json:"receive_fifo_errors"`
+	ReceiveMissedErrors     uint64 `json:"receive_missed_errors"`
+	TransmitAbortedErrors   uint64 `json:"transmit_aborted_errors"`
+	TransmitCarrierErrors   uint64 `json:"transmit_carrier_errors"`
+	TransmitFIFOErrors      uint64 `json:"transmit_fifo_errors"`
+	TransmitHeartbeatErrors uint64 `json:"transmit_heartbeat_errors"`
+	TransmitWindowErrors    uint64 `json:"transmit_window_errors"`
+	ReceiveCompressed       uint64 `json:"receive_compressed"`
+	TransmitCompressed      uint64 `json:"transmit_compressed"`
+	ReceiveNoHandler        uint64 `json:"receive_no_handler"`
+}
+
+// A jsonDriftDoc is the document printed by diff with --format=json or
+// --format=jsonl.
+type jsonDriftDoc struct {
+	SchemaVersion int              `json:"schema_version"`
+	Name          string           `json:"name"`
+	Fields        []jsonFieldDrift `json:"fields"`
+	MissingPeers  []string         `json:"missing_peers"`
+	ExtraPeers    []string         `json:"extra_peers"`
+	ChangedPeers  []jsonPeerDrift  `json:"changed_peers"`
+}
+
+// A jsonFieldDrift is the JSON representation of a wgconf.FieldDrift.
+type jsonFieldDrift struct {
+	Name   string `json:"name"`
+	Device string `json:"device"`
+	Config string `json:"config"`
+}
+
+// A jsonPeerDrift is the JSON representation of a wgconf.PeerDrift.
+type jsonPeerDrift struct {
+	PublicKey string           `json:"public_key"`
+	Fields    []jsonFieldDrift `json:"fields"`
+}
+
+// jsonDrift prints the differences between the device with name and its
+// configuration in the JSON format selected by p's output.
+func (p *printer) jsonDrift(name string, d *wgconf.Drift) error {
+	fields := func(fs []wgconf.FieldDrift) []jsonFieldDrift {
+		out := make([]jsonFieldDrift, 0, len(fs))
+		for _, f := range fs {
+			out = append(out, jsonFieldDrift(f))
+		}
+
+		return out
+	}
+
+	doc := jsonDriftDoc{
+		SchemaVersion: jsonSchemaVersion,
+		Name:          name,
+		Fields:        fields(d.Fields),
+		MissingPeers:  make([]string, 0, len(d.Missing)),
+		ExtraPeers:    make([]string, 0, len(d.Extra)),
+		ChangedPeers:  make([]jsonPeerDrift, 0, len(d.Changed)),
+	}
+
+	for _, pc := range d.Missing {
+		doc.MissingPeers = append(doc.MissingPeers, pc.PublicKey.String())
+	}
+	for _, peer := range d.Extra {
+		doc.ExtraPeers = append(doc.ExtraPeers, peer.PublicKey.String())
+	}
+	for _, pd := range d.Changed {
+		doc.ChangedPeers = append(doc.ChangedPeers, jsonPeerDrift{
+			PublicKey: pd.PublicKey.String(),
+			Fields:    fields(pd.Fields),
+		})
+	}
+
+	return p.encode(doc, p.format == formatJSON)
+}
+
+// structured prints ds in the machine-readable format selected by p's
+// output, prefixing dump lines with device names if withInterface is set.
+func (p *printer) structured(ds []*wgtypes.Device, withInterface bool) error {
+	switch p.format {
+	case formatJSON:
+		doc := jsonDevices{
+			SchemaVersion: jsonSchemaVersion,
+			Devices:       make([]jsonDevice, 0, len(ds)),
+		}
+		for _, d := range ds {
+			doc.Devices = append(doc.Devices, p.jsonDevice(d))
+		}
+
+		return p.encode(doc, true)
+	case formatJSONL:
+		for _, d := range ds {
+			jd := p.jsonDevice(d)
+			jd.SchemaVersion = jsonSchemaVersion
+			if err := p.encode(jd, false); err != nil {
+				return err
+			}
+		}
+	case formatDump:
+		for _, d := range ds {
+			p.dump(d, withInterface)
+		}
+	}
+
+	return nil
+}
+
+// interfaces prints the names of devices in the format selected by p's
+// output.
+func (p *printer) interfaces(names []string) error {
+	switch p.format {
+	case formatJSON:
+		if names == nil {
+			names = []string{}
+		}
+
+		return p.encode(jsonInterfaces{SchemaVersion: jsonSchemaVersion, Interfaces: names}, true)
+	case formatJSONL:
+		for _, name := range names {
+			if err := p.encode(jsonInterface{SchemaVersion: jsonSchemaVersion, Name: name}, false); err != nil {
+				return err
+			}
+		}
+	default:
+		if len(names) > 0 {
+			p.printf("%s\n", strings.Join(names, " "))
+		}
+	}
+
+	return nil
+}
+
+// encode prints v as JSON, indented if indent is set. All of the encoded
+// types are defined in this file, so errors are caused by writing the
+// output, such as to a closed pipe.
+func (p *printer) encode(v interface{}, indent bool) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+
+	return enc.Encode(v)
+}
+
+// jsonDevice converts d to its JSON representation.
+func (p *printer) jsonDevice(d *wgtypes.Device) jsonDevice {
+	jd := jsonDevice{
+		Name:         d.Name,
+		Index:        d.Index,
+		Type:         d.Type.String(),
+		PrivateKey:   p.jsonKey(d.PrivateKey, true),
+		PublicKey:    p.jsonKey(d.PublicKey, false),
+		ListenPort:   d.ListenPort,
+		FirewallMark: d.FirewallMark,
+		Peers:        make([]jsonPeer, 0, len(d.Peers)),
+	}
+
+	for _, peer := range d.Peers {
+		jp := jsonPeer{
+			PublicKey:                   peer.PublicKey.String(),
+			PresharedKey:                p.jsonKey(peer.PresharedKey, true),
+			PersistentKeepaliveInterval: int(peer.PersistentKeepaliveInterval / time.Second),
+			ReceiveBytes:                peer.ReceiveBytes,
+			TransmitBytes:               peer.TransmitBytes,
+			AllowedIPs:                  make([]string, 0, len(peer.AllowedIPs)),
+			ProtocolVersion:             peer.ProtocolVersion,
+		}
+
+		if peer.Endpoint != nil {
+			s := wgfmt.Endpoint(peer.Endpoint)
+			jp.Endpoint = &s
+		}
+		if sec := handshake(peer.LastHandshakeTime); sec != 0 {
+			s := time.Unix(sec, 0).UTC().Format(time.RFC3339)
+			jp.LastHandshakeTime = &s
+		}
+		for _, ipn := range peer.AllowedIPs {
+			jp.AllowedIPs = append(jp.AllowedIPs, wgfmt.AllowedIP(ipn))
+		}
+
+		jd.Peers = append(jd.Peers, jp)
+	}
+
+	if l := d.Link; l != nil {
+		jd.Link = &jsonLink{
+			MTU:       l.MTU,
+			Flags:     linkFlags(l),
+			OperState: l.OperState.String(),
+			Alias:     l.Alias,
+		}
+
+		if s := l.Stats; s != nil {
+			js := jsonLinkStats(*s)
+			jd.Link.Stats = &js
+		}
+	}
+
+	return jd
+}
+
+// jsonKey returns the JSON representation of k: null if k is not set, or
+// a redacted value if secret is set and keys are not shown.
+func (p *printer) jsonKey(k wgtypes.Key, secret bool) *string {
+	if k == (wgtypes.Key{}) {
+		return nil
+	}
+
+	s := k.String()
+	if secret && p.redact {
+		s = hidden
+	}
+
+	return &s
+}
+
+// linkFlags returns the names of l's flags.
+func linkFlags(l *wgtypes.Link) []string {
+	ss := []string{}
+	if l.Flags != 0 {
+		ss = strings.Split(l.Flags.String(), "|")
+	}
+
+	return ss
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		out  output
+		rest []string
+		err  string
+	}{
+		{
+			name: "default",
+			args: []string{"show", "wg0"},
+			out:  output{format: formatText},
+			rest: []string{"show", "wg0"},
+		},
+		{
+			name: "equals",
+			args: []string{"show", "--format=jsonl", "all"},
+			out:  output{format: formatJSONL},
+			rest: []string{"show", "all"},
+		},
+		{
+			name: "separate",
+			args: []string{"show", "wg0", "--show-keys", "--format", "dump"},
+			out:  output{format: formatDump, showKeys: true},
+			rest: []string{"show", "wg0"},
+		},
+		{
+			name: "invalid",
+			args: []string{"show", "--format=yaml"},
+			err:  "Invalid output format: `yaml'",
+		},
+		{
+			name: "missing",
+			args: []string{"show", "--format"},
+			err:  "Missing value for `--format'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, rest, err := parseOutput(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, but got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.out, out, cmp.AllowUnexported(output{})); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.rest, rest); diff != "" {
+				t.Fatalf("unexpected arguments (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShowFormat(t *testing.T) {
+	d := &wgtypes.Device{
+		Name:       "wg0",
+		Index:      3,
+		Type:       wgtypes.LinuxKernel,
+		PrivateKey: mustKey(testPrivate),
+		PublicKey:  mustKey(testPublic),
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{{
+			PublicKey:    mustKey(testPeerA),
+			PresharedKey: mustKey(testPSK),
+			Endpoint: &net.UDPAddr{
+				IP:   net.ParseIP("fd00::1"),
+				Port: 51820,
+			},
+			AllowedIPs:                  []net.IPNet{mustCIDR("10.0.0.0/24"), mustCIDR("fd00::/64")},
+			LastHandshakeTime:           testNow.Add(-30 * time.Second),
+			ReceiveBytes:                100,
+			TransmitBytes:               200,
+			PersistentKeepaliveInterval: 25 * time.Second,
+			ProtocolVersion:             1,
+		}},
+		Link: &wgtypes.Link{
+			MTU:       1420,
+			Flags:     net.FlagUp | net.FlagPointToPoint,
+			OperState: wgtypes.OperUnknown,
+			Stats:     &wgtypes.LinkStats{ReceivePackets: 1},
+		},
+	}
+
+	const wg0 = `{
+      "name": "wg0",
+      "index": 3,
+      "type": "Linux kernel",
+      "private_key": "` + testPrivate + `",
+      "public_key": "` + testPublic + `",
+      "listen_port": 51820,
+      "firewall_mark": 0,
+      "peers": [
+        {
+          "public_key": "` + testPeerA + `",
+          "preshared_key": "` + testPSK + `",
+          "endpoint": "[fd00::1]:51820",
+          "persistent_keepalive_interval": 25,
+          "last_handshake_time": "2023-11-14T22:12:50Z",
+          "receive_bytes": 100,
+          "transmit_bytes": 200,
+          "allowed_ips": [
+            "10.0.0.0/24",
+            "fd00::/64"
+          ],
+          "protocol_version": 1
+        }
+      ],
+      "link": {
+        "mtu": 1420,
+        "flags": [
+          "up",
+          "pointtopoint"
+        ],
+        "oper_state": "unknown",
+        "alias": "",
+        "stats": {
+          "receive_packets": 1,
+          "transmit_packets": 0,
+          "receive_bytes": 0,
+          "transmit_bytes": 0,
+          "receive_errors": 0,
+          "transmit_errors": 0,
+          "receive_dropped": 0,
+          "transmit_dropped": 0,
+          "multicast": 0,
+          "collisions": 0,
+          "receive_length_errors": 0,
+          "receive_over_errors": 0,
+          "receive_crc_errors": 0,
+          "receive_frame_errors": 0,
+          "receive_fifo_errors": 0,
+          "receive_missed_errors": 0,
+          "transmit_aborted_errors": 0,
+          "transmit_carrier_errors": 0,
+          "transmit_fifo_errors": 0,
+          "transmit_heartbeat_errors": 0,
+          "transmit_window_errors": 0,
+          "receive_compressed": 0,
+          "transmit_compressed": 0,
+          "receive_no_handler": 0
+        }
+      }
+    }`
+
+	const usage = "Usage: wgctrl show [--format=text|json|jsonl|dump] [--show-keys] { <interface> | all | interfaces } [public-key | private-key | listen-port | fwmark | peers | preshared-keys | endpoints | allowed-ips | latest-handshakes | transfer | persistent-keepalive | dump]\n"
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "json",
+			args:   []string{"show", "wg0", "--format=json", "--show-keys"},
+			stdout: "{\n  \"schema_version\": 1,\n  \"devices\": [\n    " + wg0 + "\n  ]\n}\n",
+		},
+		{
+			name: "jsonl",
+			args: []string{"show", "all", "--format=jsonl"},
+			stdout: `{"schema_version":1,"name":"wg0","index":3,"type":"Linux kernel","private_key":"(hidden)","public_key":"` + testPublic + `","listen_port":51820,"firewall_mark":0,"peers":[{"public_key":"` + testPeerA + `","preshared_key":"(hidden)","endpoint":"[fd00::1]:51820","persistent_keepalive_interval":25,"last_handshake_time":"2023-11-14T22:12:50Z","receive_bytes":100,"transmit_bytes":200,"allowed_ips":["10.0.0.0/24","fd00::/64"],"protocol_version":1}],"link":{"mtu":1420,"flags":["up","pointtopoint"],"oper_state":"unknown","alias":"","stats":{"receive_packets":1,"transmit_packets":0,"receive_bytes":0,"transmit_bytes":0,"receive_errors":0,"transmit_errors":0,"receive_dropped":0,"transmit_dropped":0,"multicast":0,"collisions":0,"receive_length_errors":0,"receive_over_errors":0,"receive_crc_errors":0,"receive_frame_errors":0,"receive_fifo_errors":0,"receive_missed_errors":0,"transmit_aborted_errors":0,"transmit_carrier_errors":0,"transmit_fifo_errors":0,"transmit_heartbeat_errors":0,"transmit_window_errors":0,"receive_compressed":0,"transmit_compressed":0,"receive_no_handler":0}}}
+{"schema_version":1,"name":"wg1","index":0,"type":"unknown","private_key":null,"public_key":null,"listen_port":0,"firewall_mark":0,"peers":[]}
+`,
+		},
+		{
+			name: "dump",
+			args: []string{"show", "all", "--format=dump"},
+			stdout: "wg0\t(hidden)\t" + testPublic + "\t51820\toff\n" +
+				"wg0\t" + testPeerA + "\t(hidden)\t[fd00::1]:51820\t10.0.0.0/24,fd00::/64\t1699999970\t100\t200\t25\n" +
+				"wg1\t(none)\t(none)\t0\toff\n",
+		},
+		{
+			name: "dump keys",
+			args: []string{"show", "--format", "dump", "--show-keys", "wg0"},
+			stdout: testPrivate + "\t" + testPublic + "\t51820\toff\n" +
+				testPeerA + "\t" + testPSK + "\t[fd00::1]:51820\t10.0.0.0/24,fd00::/64\t1699999970\t100\t200\t25\n",
+		},
+		{
+			name:   "interfaces json",
+			args:   []string{"show", "interfaces", "--format=json"},
+			stdout: "{\n  \"schema_version\": 1,\n  \"interfaces\": [\n    \"wg0\",\n    \"wg1\"\n  ]\n}\n",
+		},
+		{
+			name:   "interfaces jsonl",
+			args:   []string{"show", "--format=jsonl", "interfaces"},
+			stdout: `{"schema_version":1,"name":"wg0"}` + "\n" + `{"schema_version":1,"name":"wg1"}` + "\n",
+		},
+		{
+			name:   "showconf json",
+			args:   []string{"showconf", "--format=json", "--show-keys", "wg0"},
+			stdout: "{\n  \"schema_version\": 1,\n  \"devices\": [\n    " + wg0 + "\n  ]\n}\n",
+		},
+		{
+			name:   "parameter",
+			args:   []string{"show", "wg0", "dump", "--format=json"},
+			code:   1,
+			stderr: usage,
+		},
+		{
+			name:   "invalid",
+			args:   []string{"show", "--format=xml"},
+			code:   1,
+			stderr: "Invalid output format: `xml'\n" + usage,
+		},
+		{
+			name:   "showconf invalid",
+			args:   []string{"showconf", "--format=xml", "wg0"},
+			code:   1,
+			stderr: "Invalid output format: `xml'\nUsage: wgctrl showconf [--format=text|json|jsonl|dump] [--show-keys] <interface>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{d, {Name: "wg1"}},
+			})
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShowFormatWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "json", args: []string{"show", "--format=json", "wg0"}},
+		{name: "jsonl all", args: []string{"show", "--format=jsonl", "all"}},
+		{name: "interfaces", args: []string{"show", "--format=json", "interfaces"}},
+		{name: "showconf", args: []string{"showconf", "--format=json", "wg0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{{Name: "wg0"}},
+			})
+
+			// Like the read end of a pipe which was closed by head(1).
+			e.stdout = errWriter{err: syscall.EPIPE}
+
+			if diff := cmp.Diff(1, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit code (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("Unable to write output: Broken pipe\n", stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// An errWriter is an io.Writer which always returns err.
+type errWriter struct{ err error }
+
+func (w errWriter) Write([]byte) (int, error) { return 0, w.err }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
+)
+
+// quickMain implements the up and down subcommands, which bring a WireGuard
+// interface up or down like wg-quick(8).
+func quickMain(e *env, args []string) int {
+	fset := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+
+	dryRun := fset.Bool("dry-run", false, "")
+
+	if err := fset.Parse(args[1:]); err != nil || fset.NArg() != 1 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s [--dry-run] <CONFIG_FILE | INTERFACE>\n", e.prog, args[0])
+		return 1
+	}
+
+	cfg, err := wgquick.Load(fset.Arg(0), nil)
+	if err != nil {
+		fmt.Fprintln(e.stderr, quickError(err))
+		return 1
+	}
+
+	if fileAccessible(cfg.Path) {
+		fmt.Fprintf(e.stderr, "Warning: `%s' is world accessible\n", cfg.Path)
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+		return 1
+	}
+
+	opts := &wgquick.Options{
+		System: e.system,
+		Output: e.stderr,
+	}
+
+	plan, apply := wgquick.PlanUp, wgquick.Up
+	if args[0] == "down" {
+		plan, apply = wgquick.PlanDown, wgquick.Down
+	}
+
+	if *dryRun {
+		steps, err := plan(c, cfg, opts)
+		if err != nil {
+			fmt.Fprintln(e.stderr, quickError(err))
+			return 1
+		}
+
+		for _, s := range steps {
+			fmt.Fprintf(e.stdout, "[#] %s\n", s)
+		}
+
+		return 0
+	}
+
+	if err := apply(c, cfg, opts); err != nil {
+		fmt.Fprintln(e.stderr, quickError(err))
+		return 1
+	}
+
+	return 0
+}
+
+// quickError formats an error from package wgquick like wg-quick.
+func quickError(err error) string {
+	s := err.Error()
+	for _, prefix := range []string{"wgquick: ", "wgconf: "} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+
+	return fmt.Sprintf("[!] %s", s)
+}
+
+// fileAccessible reports whether the file at path can be accessed by users
+// other than its owner and group.
+func fileAccessible(path string) bool {
+	// Windows does not report meaningful permission bits.
+	if runtime.GOOS == "windows" {
+		return false
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode().Perm()&0o007 != 0
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestQuick(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg0.conf")
+
+	conf := `[Interface]
+PrivateKey = ` + testPrivate + `
+Address = 10.0.0.2/24
+MTU = 1420
+PostUp = echo %i
+
+[Peer]
+PublicKey = ` + testPeerA + `
+AllowedIPs = 10.0.0.0/24
+`
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+		calls  []string
+	}{
+		{
+			name:   "usage",
+			args:   []string{"up"},
+			code:   1,
+			stderr: "Usage: wgctrl up [--dry-run] <CONFIG_FILE | INTERFACE>\n",
+		},
+		{
+			name:   "bad name",
+			args:   []string{"up", filepath.Join(dir, "wg0.txt")},
+			code:   1,
+			stderr: fmt.Sprintf("[!] the config file must be a valid interface name, followed by .conf: %q\n", filepath.Join(dir, "wg0.txt")),
+		},
+		{
+			name: "dry run up",
+			args: []string{"up", "--dry-run", path},
+			stdout: `[#] ip link add wg0 type wireguard
+[#] wg setconf wg0 <(wg-quick strip ` + path + `)
+[#] ip -4 address add 10.0.0.2/24 dev wg0
+[#] ip link set mtu 1420 up dev wg0
+[#] ip -4 route add 10.0.0.0/24 dev wg0
+[#] echo wg0
+`,
+		},
+		{
+			name: "up",
+			args: []string{"up", path},
+			stderr: `[#] ip link add wg0 type wireguard
+[#] wg setconf wg0 <(wg-quick strip ` + path + `)
+[#] ip -4 address add 10.0.0.2/24 dev wg0
+[#] ip link set mtu 1420 up dev wg0
+[#] ip -4 route add 10.0.0.0/24 dev wg0
+[#] echo wg0
+`,
+			calls: []string{
+				"AddLink wg0",
+				"AddAddress wg0 10.0.0.2/24",
+				"SetLinkUp wg0 1420",
+				"AddRoute 10.0.0.0/24 wg0",
+				"Run echo wg0",
+			},
+		},
+		{
+			name:   "down",
+			args:   []string{"down", path},
+			stderr: "[#] ip link delete dev wg0\n",
+			calls:  []string{"DeleteLink wg0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := &testSystem{}
+			e, stdout, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{{Name: "wg0"}},
+			})
+			e.system = sys
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.calls, sys.calls); diff != "" {
+				t.Fatalf("unexpected system calls (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+var _ wgquick.System = &testSystem{}
+
+// A testSystem is a wgquick.System with no interfaces, which records the
+// calls which change the system.
+type testSystem struct {
+	calls []string
+}
+
+func (s *testSystem) call(format string, a ...interface{}) error {
+	s.calls = append(s.calls, fmt.Sprintf(format, a...))
+	return nil
+}
+
+func (s *testSystem) LinkExists(string) (bool, error) { return false, nil }
+func (s *testSystem) AddLink(name string) error       { return s.call("AddLink %s", name) }
+func (s *testSystem) DeleteLink(name string) error    { return s.call("DeleteLink %s", name) }
+func (s *testSystem) LinkMTU(string) (int, error)     { return 1500, nil }
+
+func (s *testSystem) SetLinkUp(name string, mtu int) error {
+	return s.call("SetLinkUp %s %d", name, mtu)
+}
+
+func (s *testSystem) AddAddress(name string, addr net.IPNet) error {
+	return s.call("AddAddress %s %s", name, addr.String())
+}
+
+func (s *testSystem) Addresses(string) ([]net.IPNet, error) { return nil, nil }
+func (s *testSystem) RouteMTU(net.IP) (int, error)          { return 1500, nil }
+func (s *testSystem) TableInUse(int) (bool, error)          { return false, nil }
+
+func (s *testSystem) AddRoute(r wgquick.Route) error {
+	return s.call("AddRoute %s %s", r.Destination.String(), r.Device)
+}
+
+func (s *testSystem) AddRule(r wgquick.Rule) error    { return s.call("AddRule %s", r) }
+func (s *testSystem) DeleteRule(r wgquick.Rule) error { return s.call("DeleteRule %s", r) }
+
+func (s *testSystem) SetSysctl(key, value string) error {
+	return s.call("SetSysctl %s %s", key, value)
+}
+
+func (s *testSystem) SetDNS(name string, _ []net.IP, _ []string) error {
+	return s.call("SetDNS %s", name)
+}
+
+func (s *testSystem) UnsetDNS(name string) error { return s.call("UnsetDNS %s", name) }
+func (s *testSystem) Run(command string) error   { return s.call("Run %s", command) }
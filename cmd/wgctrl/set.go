@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+)
+
+// setMain implements the set subcommand.
+func setMain(e *env, args []string) int {
+	if len(args) < 3 {
+		fmt.Fprintf(e.stderr, "Usage: %s %s <interface> [listen-port <port>] [fwmark <mark>] [private-key <file path>] [peer <base64 public key> [remove] [preshared-key <file path>] [endpoint <ip>:<port>] [persistent-keepalive <interval seconds>] [allowed-ips [+|-]<ip1>/<cidr1>[,[+|-]<ip2>/<cidr2>]...] ]...\n", e.prog, args[0])
+		return 1
+	}
+
+	cfg, err := wgconf.ParseSet(args[2:], nil)
+	if err != nil {
+		fmt.Fprintln(e.stderr, strings.TrimPrefix(err.Error(), "wgconf: "))
+		return 1
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to modify interface: %s\n", strerror(err))
+		return 1
+	}
+
+	if err := c.ConfigureDevice(args[1], *cfg); err != nil {
+		fmt.Fprintf(e.stderr, "Unable to modify interface: %s\n", strerror(err))
+		return 1
+	}
+
+	return 0
+}
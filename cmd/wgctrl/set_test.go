@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSet(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(key, []byte(testPrivate+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	const usage = "Usage: wgctrl set <interface> [listen-port <port>] [fwmark <mark>] [private-key <file path>] [peer <base64 public key> [remove] [preshared-key <file path>] [endpoint <ip>:<port>] [persistent-keepalive <interval seconds>] [allowed-ips [+|-]<ip1>/<cidr1>[,[+|-]<ip2>/<cidr2>]...] ]...\n"
+
+	port := 51820
+	privateKey := mustKey(testPrivate)
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		cfgs   []wgtypes.Config
+		stderr string
+	}{
+		{
+			name: "OK",
+			args: []string{"wg0", "listen-port", "51820", "private-key", key, "peer", testPeerA, "allowed-ips", "-192.0.2.0/24"},
+			cfgs: []wgtypes.Config{{
+				PrivateKey: &privateKey,
+				ListenPort: &port,
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:        mustKey(testPeerA),
+					RemoveAllowedIPs: []net.IPNet{mustCIDR("192.0.2.0/24")},
+				}},
+			}},
+		},
+		{
+			name:   "usage",
+			args:   []string{"wg0"},
+			code:   1,
+			stderr: usage,
+		},
+		{
+			name:   "invalid argument",
+			args:   []string{"wg0", "foo", "bar"},
+			code:   1,
+			stderr: "invalid argument: foo\n",
+		},
+		{
+			name:   "not exist",
+			args:   []string{"wg1", "listen-port", "51820"},
+			code:   1,
+			stderr: "Unable to modify interface: No such device\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &testClient{devices: []*wgtypes.Device{{Name: "wg0"}}}
+			e, stdout, stderr := testEnv(c)
+
+			if diff := cmp.Diff(tt.code, run(e, append([]string{"set"}, tt.args...))); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.cfgs, c.configured); diff != "" {
+				t.Fatalf("unexpected Configs (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("", stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// showMain implements the show subcommand.
+func showMain(e *env, args []string) int {
+	name := args[0]
+	usage := func() {
+		fmt.Fprintf(e.stderr, "Usage: %s %s %s { <interface> | all | interfaces } [public-key | private-key | listen-port | fwmark | peers | preshared-keys | endpoints | allowed-ips | latest-handshakes | transfer | persistent-keepalive | dump]\n", e.prog, name, outputUsage)
+	}
+
+	out, args, err := parseOutput(args)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		usage()
+		return 1
+	}
+
+	// Parameters select fields of the text format.
+	if len(args) > 3 || (len(args) == 3 && out.format != formatText) {
+		usage()
+		return 1
+	}
+
+	p := newPrinter(e, out)
+
+	switch {
+	case len(args) == 1 || args[1] == "all":
+		c, err := e.client()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+			return 1
+		}
+
+		ds, errs, err := c.DevicesPartial()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+			return 1
+		}
+
+		for _, name := range sortedNames(errs) {
+			fmt.Fprintf(e.stderr, "Unable to access interface %s: %s\n", name, strerror(errs[name]))
+		}
+
+		switch {
+		case p.format != formatText:
+			if err := p.structured(ds, true); err != nil {
+				fmt.Fprintf(e.stderr, "Unable to write output: %s\n", strerror(err))
+				return 1
+			}
+		case len(args) == 3:
+			for _, d := range ds {
+				if !p.ugly(d, args[2], true) {
+					usage()
+					return 1
+				}
+			}
+		default:
+			for i, d := range ds {
+				p.pretty(d)
+				if i < len(ds)-1 {
+					p.printf("\n")
+				}
+			}
+		}
+
+		// Like wg(8), report inaccessible devices but only fail if there are
+		// devices and none of them could be accessed.
+		if len(ds) == 0 && len(errs) > 0 {
+			return 1
+		}
+
+		return 0
+	case args[1] == "interfaces":
+		if len(args) > 2 {
+			usage()
+			return 1
+		}
+
+		c, err := e.client()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+			return 1
+		}
+
+		ds, errs, err := c.DevicesPartial()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+			return 1
+		}
+
+		names := make([]string, 0, len(ds)+len(errs))
+		for _, d := range ds {
+			names = append(names, d.Name)
+		}
+		names = append(names, sortedNames(errs)...)
+
+		if err := p.interfaces(names); err != nil {
+			fmt.Fprintf(e.stderr, "Unable to write output: %s\n", strerror(err))
+			return 1
+		}
+
+		return 0
+	case len(args) == 2 && (args[1] == "-h" || args[1] == "--help" || args[1] == "help"):
+		usage()
+		return 0
+	default:
+		c, err := e.client()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+			return 1
+		}
+
+		d, err := c.Device(args[1])
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to access interface: %s\n", strerror(err))
+			return 1
+		}
+
+		switch {
+		case p.format != formatText:
+			if err := p.structured([]*wgtypes.Device{d}, false); err != nil {
+				fmt.Fprintf(e.stderr, "Unable to write output: %s\n", strerror(err))
+				return 1
+			}
+		case len(args) == 3:
+			if !p.ugly(d, args[2], false) {
+				usage()
+				return 1
+			}
+		default:
+			p.pretty(d)
+		}
+
+		return 0
+	}
+}
+
+// Terminal escape sequences used by wg(8).
+const (
+	termRed    = "\x1b[31m"
+	termGreen  = "\x1b[32m"
+	termYellow = "\x1b[33m"
+	termBold   = "\x1b[1m"
+	termReset  = "\x1b[0m"
+)
+
+// A printer prints device information in the formats used by wg(8).
+type printer struct {
+	w      io.Writer
+	stderr io.Writer
+	now    time.Time
+
+	// color reports whether terminal escape sequences are printed, and f
+	// formats values with the same colors.
+	color bool
+	f     wgfmt.Formatter
+
+	// showKeys reports whether private and preshared keys are printed in
+	// the human-readable format.
+	showKeys bool
+
+	// format is the output format selected with --format, and redact
+	// reports whether secret keys are redacted in that format.
+	format string
+	redact bool
+}
+
+// newPrinter creates a printer for out which writes to e.stdout, and which
+// applies the WG_COLOR_MODE and WG_HIDE_KEYS environment variables like
+// wg(8).
+func newPrinter(e *env, out output) *printer {
+	color := e.terminal
+	switch e.getenv("WG_COLOR_MODE") {
+	case "always":
+		color = true
+	case "never":
+		color = false
+	}
+
+	return &printer{
+		w:        e.stdout,
+		stderr:   e.stderr,
+		now:      e.now(),
+		color:    color,
+		f:        wgfmt.Formatter{Color: color},
+		showKeys: out.showKeys || e.getenv("WG_HIDE_KEYS") == "never",
+		format:   out.format,
+		// The dump parameter of the text format prints keys like wg(8),
+		// but the machine-readable formats only print them on request.
+		redact: out.format != formatText && !out.showKeys,
+	}
+}
+
+// printf prints plain output.
+func (p *printer) printf(format string, a ...interface{}) {
+	fmt.Fprintf(p.w, format, a...)
+}
+
+// c returns the terminal escape sequence s if color output is enabled.
+func (p *printer) c(s string) string {
+	if !p.color {
+		return ""
+	}
+
+	return s
+}
+
+// pretty prints d in the human-readable format.
+func (p *printer) pretty(d *wgtypes.Device) {
+	p.printf("%s", p.c(termReset))
+	p.printf("%sinterface%s: %s%s%s\n", p.c(termGreen+termBold), p.c(termReset), p.c(termGreen), d.Name, p.c(termReset))
+	if d.PublicKey != (wgtypes.Key{}) {
+		p.printf("  %spublic key%s: %s\n", p.c(termBold), p.c(termReset), d.PublicKey)
+	}
+	if d.PrivateKey != (wgtypes.Key{}) {
+		p.printf("  %sprivate key%s: %s\n", p.c(termBold), p.c(termReset), p.maskedKey(d.PrivateKey))
+	}
+	if d.ListenPort != 0 {
+		p.printf("  %slistening port%s: %d\n", p.c(termBold), p.c(termReset), d.ListenPort)
+	}
+	if d.FirewallMark != 0 {
+		p.printf("  %sfwmark%s: 0x%x\n", p.c(termBold), p.c(termReset), d.FirewallMark)
+	}
+
+	if len(d.Peers) == 0 {
+		return
+	}
+
+	p.printf("\n")
+
+	peers := sortPeers(d.Peers)
+	for i, peer := range peers {
+		p.printf("%speer%s: %s%s%s\n", p.c(termYellow+termBold), p.c(termReset), p.c(termYellow), peer.PublicKey, p.c(termReset))
+		if peer.PresharedKey != (wgtypes.Key{}) {
+			p.printf("  %spreshared key%s: %s\n", p.c(termBold), p.c(termReset), p.maskedKey(peer.PresharedKey))
+		}
+		if peer.Endpoint != nil {
+			p.printf("  %sendpoint%s: %s\n", p.c(termBold), p.c(termReset), wgfmt.Endpoint(peer.Endpoint))
+		}
+
+		p.printf("  %sallowed ips%s: ", p.c(termBold), p.c(termReset))
+		if len(peer.AllowedIPs) == 0 {
+			p.printf("(none)\n")
+		}
+		for j, ipn := range peer.AllowedIPs {
+			sep := ", "
+			if j == len(peer.AllowedIPs)-1 {
+				sep = "\n"
+			}
+
+			p.printf("%s%s", p.f.AllowedIP(ipn), sep)
+		}
+
+		if handshake(peer.LastHandshakeTime) != 0 {
+			p.printf("  %slatest handshake%s: %s\n", p.c(termBold), p.c(termReset), p.f.Handshake(p.now, peer.LastHandshakeTime))
+		}
+		if peer.ReceiveBytes != 0 || peer.TransmitBytes != 0 {
+			p.printf("  %stransfer%s: %s\n", p.c(termBold), p.c(termReset), p.f.Transfer(peer.ReceiveBytes, peer.TransmitBytes))
+		}
+		if peer.PersistentKeepaliveInterval > 0 {
+			p.printf("  %spersistent keepalive%s: %s\n", p.c(termBold), p.c(termReset), p.f.Keepalive(peer.PersistentKeepaliveInterval))
+		}
+
+		if i < len(peers)-1 {
+			p.printf("\n")
+		}
+	}
+}
+
+// ugly prints the field of d specified by param in the machine-readable
+// format, prefixed by the device name if withInterface is set. ugly reports
+// whether param is valid.
+func (p *printer) ugly(d *wgtypes.Device, param string, withInterface bool) bool {
+	prefix := func() {
+		if withInterface {
+			p.printf("%s\t", d.Name)
+		}
+	}
+
+	switch param {
+	case "public-key":
+		prefix()
+		p.printf("%s\n", maybeKey(d.PublicKey))
+	case "private-key":
+		prefix()
+		p.printf("%s\n", maybeKey(d.PrivateKey))
+	case "listen-port":
+		prefix()
+		p.printf("%d\n", d.ListenPort)
+	case "fwmark":
+		prefix()
+		p.printf("%s\n", fwmark(d.FirewallMark))
+	case "endpoints":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%s\n", peer.PublicKey, maybeEndpoint(peer.Endpoint))
+		}
+	case "allowed-ips":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%s\n", peer.PublicKey, allowedIPs(peer.AllowedIPs, " "))
+		}
+	case "latest-handshakes":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%d\n", peer.PublicKey, handshake(peer.LastHandshakeTime))
+		}
+	case "transfer":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%d\t%d\n", peer.PublicKey, uint64(peer.ReceiveBytes), uint64(peer.TransmitBytes))
+		}
+	case "persistent-keepalive":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%s\n", peer.PublicKey, keepalive(peer.PersistentKeepaliveInterval))
+		}
+	case "preshared-keys":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\t%s\n", peer.PublicKey, maybeKey(peer.PresharedKey))
+		}
+	case "peers":
+		for _, peer := range d.Peers {
+			prefix()
+			p.printf("%s\n", peer.PublicKey)
+		}
+	case "dump":
+		p.dump(d, withInterface)
+	default:
+		fmt.Fprintf(p.stderr, "Invalid parameter: `%s'\n", param)
+		return false
+	}
+
+	return true
+}
+
+// dump prints all of the information about d in the machine-readable
+// format.
+func (p *printer) dump(d *wgtypes.Device, withInterface bool) {
+	prefix := func() {
+		if withInterface {
+			p.printf("%s\t", d.Name)
+		}
+	}
+
+	prefix()
+	p.printf("%s\t%s\t%d\t%s\n", p.secretKey(d.PrivateKey), maybeKey(d.PublicKey), d.ListenPort, fwmark(d.FirewallMark))
+
+	for _, peer := range d.Peers {
+		prefix()
+		p.printf("%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			peer.PublicKey,
+			p.secretKey(peer.PresharedKey),
+			maybeEndpoint(peer.Endpoint),
+			allowedIPs(peer.AllowedIPs, ","),
+			handshake(peer.LastHandshakeTime),
+			uint64(peer.ReceiveBytes),
+			uint64(peer.TransmitBytes),
+			keepalive(peer.PersistentKeepaliveInterval),
+		)
+	}
+}
+
+// maskedKey returns k if keys are shown, or "(hidden)" otherwise.
+func (p *printer) maskedKey(k wgtypes.Key) string {
+	if p.showKeys {
+		return k.String()
+	}
+
+	return "(hidden)"
+}
+
+// secretKey returns the secret key k for the dump format, or "(hidden)" if
+// k is set and keys are redacted.
+func (p *printer) secretKey(k wgtypes.Key) string {
+	if p.redact && k != (wgtypes.Key{}) {
+		return hidden
+	}
+
+	return maybeKey(k)
+}
+
+// sortPeers returns a copy of peers sorted by most recent handshake, with
+// peers which have never completed a handshake last.
+func sortPeers(peers []wgtypes.Peer) []wgtypes.Peer {
+	out := make([]wgtypes.Peer, len(peers))
+	copy(out, peers)
+
+	never := func(t time.Time) bool {
+		return t.IsZero() || (t.Unix() == 0 && t.Nanosecond() == 0)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].LastHandshakeTime, out[j].LastHandshakeTime
+		if never(a) || never(b) {
+			return !never(a) && never(b)
+		}
+
+		return a.After(b)
+	})
+
+	return out
+}
+
+// maybeKey returns k, or "(none)" if k is not set.
+func maybeKey(k wgtypes.Key) string {
+	if k == (wgtypes.Key{}) {
+		return "(none)"
+	}
+
+	return k.String()
+}
+
+// maybeEndpoint returns the formatted endpoint addr, or "(none)" if addr is
+// not set.
+func maybeEndpoint(addr *net.UDPAddr) string {
+	if addr == nil {
+		return "(none)"
+	}
+
+	return wgfmt.Endpoint(addr)
+}
+
+// allowedIPs formats ipns separated by sep, or "(none)" if ipns is empty.
+func allowedIPs(ipns []net.IPNet, sep string) string {
+	if len(ipns) == 0 {
+		return "(none)"
+	}
+
+	ss := make([]string, 0, len(ipns))
+	for _, ipn := range ipns {
+		ss = append(ss, wgfmt.AllowedIP(ipn))
+	}
+
+	return strings.Join(ss, sep)
+}
+
+// handshake returns the UNIX timestamp of t, or 0 if t is not set.
+func handshake(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// keepalive formats a persistent keepalive interval in seconds, or "off" if
+// it is disabled.
+func keepalive(d time.Duration) string {
+	if d <= 0 {
+		return "off"
+	}
+
+	return strconv.Itoa(int(d / time.Second))
+}
+
+// fwmark formats a firewall mark, or "off" if it is not set.
+func fwmark(v int) string {
+	if v == 0 {
+		return "off"
+	}
+
+	return fmt.Sprintf("0x%x", v)
+}
+
+// sortedNames returns the device names in errs in sorted order.
+func sortedNames(errs map[string]error) []string {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// strerror describes err like strerror(3), for compatibility with wg(8)
+// error messages.
+func strerror(err error) string {
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &errno):
+		// Go's error strings match the C library's, apart from case.
+		s := errno.Error()
+		r, n := utf8.DecodeRuneInString(s)
+		return string(unicode.ToUpper(r)) + s[n:]
+	case errors.Is(err, os.ErrNotExist):
+		return "No such device"
+	case errors.Is(err, os.ErrPermission):
+		return "Operation not permitted"
+	default:
+		return err.Error()
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	testPrivate = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	testPublic  = "pOCSkrZRwni5dyxWn1+puxPZBrRqtoyd+dwrRAn4ogk="
+	testPeerA   = "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	testPeerB   = "AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM="
+	testPSK     = "BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ="
+	testPeerC   = "BQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQU="
+)
+
+// testDevice returns a device with peers in various states.
+func testDevice() *wgtypes.Device {
+	return &wgtypes.Device{
+		Name:         "wg0",
+		PrivateKey:   mustKey(testPrivate),
+		PublicKey:    mustKey(testPublic),
+		ListenPort:   51820,
+		FirewallMark: 0x10,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey: mustKey(testPeerA),
+			},
+			{
+				PublicKey:    mustKey(testPeerB),
+				PresharedKey: mustKey(testPSK),
+				Endpoint: &net.UDPAddr{
+					IP:   net.IPv4(192, 0, 2, 1),
+					Port: 51820,
+				},
+				AllowedIPs: []net.IPNet{
+					mustCIDR("10.0.0.0/24"),
+					// An IPv6 prefix which net.IP.String formats as IPv4.
+					{IP: make(net.IP, net.IPv6len), Mask: net.CIDRMask(96, 128)},
+					mustCIDR("fd00::/64"),
+				},
+				LastHandshakeTime:           testNow.Add(-1*time.Hour - 2*time.Minute - 3*time.Second),
+				ReceiveBytes:                1536,
+				TransmitBytes:               3 * 1024 * 1024,
+				PersistentKeepaliveInterval: 25 * time.Second,
+			},
+			{
+				PublicKey: mustKey(testPeerC),
+				Endpoint: &net.UDPAddr{
+					IP:   net.ParseIP("fd00::1"),
+					Port: 51820,
+				},
+				AllowedIPs:        []net.IPNet{mustCIDR("192.168.1.0/24")},
+				LastHandshakeTime: testNow.Add(-30 * time.Second),
+				ReceiveBytes:      100,
+			},
+		},
+	}
+}
+
+func TestShow(t *testing.T) {
+	// Make the second peer's allowed IPs include ::ffff:0.0.0.0/96.
+	d := testDevice()
+	copy(d.Peers[1].AllowedIPs[1].IP[10:12], []byte{0xff, 0xff})
+
+	const pretty = `interface: wg0
+  public key: ` + testPublic + `
+  private key: (hidden)
+  listening port: 51820
+  fwmark: 0x10
+
+peer: ` + testPeerC + `
+  endpoint: [fd00::1]:51820
+  allowed ips: 192.168.1.0/24
+  latest handshake: 30 seconds ago
+  transfer: 100 B received, 0 B sent
+
+peer: ` + testPeerB + `
+  preshared key: (hidden)
+  endpoint: 192.0.2.1:51820
+  allowed ips: 10.0.0.0/24, ::ffff:0.0.0.0/96, fd00::/64
+  latest handshake: 1 hour, 2 minutes, 3 seconds ago
+  transfer: 1.50 KiB received, 3.00 MiB sent
+  persistent keepalive: every 25 seconds
+
+peer: ` + testPeerA + `
+  allowed ips: (none)
+`
+
+	const usage = "Usage: wgctrl show [--format=text|json|jsonl|dump] [--show-keys] { <interface> | all | interfaces } [public-key | private-key | listen-port | fwmark | peers | preshared-keys | endpoints | allowed-ips | latest-handshakes | transfer | persistent-keepalive | dump]\n"
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "pretty",
+			args:   []string{"wg0"},
+			stdout: pretty,
+		},
+		{
+			name: "pretty all",
+			args: []string{"all"},
+			stdout: pretty + `
+interface: wg1
+`,
+		},
+		{
+			name:   "pretty keys",
+			args:   []string{"wg0"},
+			env:    map[string]string{"WG_HIDE_KEYS": "never"},
+			stdout: strings.NewReplacer("private key: (hidden)", "private key: "+testPrivate, "preshared key: (hidden)", "preshared key: "+testPSK).Replace(pretty),
+		},
+		{
+			name:   "color",
+			args:   []string{"wg1"},
+			env:    map[string]string{"WG_COLOR_MODE": "always"},
+			stdout: "\x1b[0m\x1b[32m\x1b[1minterface\x1b[0m: \x1b[32mwg1\x1b[0m\n",
+		},
+		{
+			name: "dump",
+			args: []string{"wg0", "dump"},
+			stdout: testPrivate + "\t" + testPublic + "\t51820\t0x10\n" +
+				testPeerA + "\t(none)\t(none)\t(none)\t0\t0\t0\toff\n" +
+				testPeerB + "\t" + testPSK + "\t192.0.2.1:51820\t10.0.0.0/24,::ffff:0.0.0.0/96,fd00::/64\t1699996277\t1536\t3145728\t25\n" +
+				testPeerC + "\t(none)\t[fd00::1]:51820\t192.168.1.0/24\t1699999970\t100\t0\toff\n",
+		},
+		{
+			name: "dump all",
+			args: []string{"all", "dump"},
+			stdout: "wg0\t" + testPrivate + "\t" + testPublic + "\t51820\t0x10\n" +
+				"wg0\t" + testPeerA + "\t(none)\t(none)\t(none)\t0\t0\t0\toff\n" +
+				"wg0\t" + testPeerB + "\t" + testPSK + "\t192.0.2.1:51820\t10.0.0.0/24,::ffff:0.0.0.0/96,fd00::/64\t1699996277\t1536\t3145728\t25\n" +
+				"wg0\t" + testPeerC + "\t(none)\t[fd00::1]:51820\t192.168.1.0/24\t1699999970\t100\t0\toff\n" +
+				"wg1\t(none)\t(none)\t0\toff\n",
+		},
+		{
+			name:   "public-key",
+			args:   []string{"all", "public-key"},
+			stdout: "wg0\t" + testPublic + "\nwg1\t(none)\n",
+		},
+		{
+			name:   "private-key",
+			args:   []string{"wg0", "private-key"},
+			stdout: testPrivate + "\n",
+		},
+		{
+			name:   "listen-port",
+			args:   []string{"wg1", "listen-port"},
+			stdout: "0\n",
+		},
+		{
+			name:   "fwmark",
+			args:   []string{"all", "fwmark"},
+			stdout: "wg0\t0x10\nwg1\toff\n",
+		},
+		{
+			name:   "peers",
+			args:   []string{"wg0", "peers"},
+			stdout: testPeerA + "\n" + testPeerB + "\n" + testPeerC + "\n",
+		},
+		{
+			name:   "all peers",
+			args:   []string{"all", "peers"},
+			stdout: "wg0\t" + testPeerA + "\nwg0\t" + testPeerB + "\nwg0\t" + testPeerC + "\n",
+		},
+		{
+			name:   "preshared-keys",
+			args:   []string{"wg0", "preshared-keys"},
+			stdout: testPeerA + "\t(none)\n" + testPeerB + "\t" + testPSK + "\n" + testPeerC + "\t(none)\n",
+		},
+		{
+			name:   "endpoints",
+			args:   []string{"wg0", "endpoints"},
+			stdout: testPeerA + "\t(none)\n" + testPeerB + "\t192.0.2.1:51820\n" + testPeerC + "\t[fd00::1]:51820\n",
+		},
+		{
+			name:   "allowed-ips",
+			args:   []string{"wg0", "allowed-ips"},
+			stdout: testPeerA + "\t(none)\n" + testPeerB + "\t10.0.0.0/24 ::ffff:0.0.0.0/96 fd00::/64\n" + testPeerC + "\t192.168.1.0/24\n",
+		},
+		{
+			name:   "latest-handshakes",
+			args:   []string{"wg0", "latest-handshakes"},
+			stdout: testPeerA + "\t0\n" + testPeerB + "\t1699996277\n" + testPeerC + "\t1699999970\n",
+		},
+		{
+			name:   "transfer",
+			args:   []string{"wg0", "transfer"},
+			stdout: testPeerA + "\t0\t0\n" + testPeerB + "\t1536\t3145728\n" + testPeerC + "\t100\t0\n",
+		},
+		{
+			name:   "persistent-keepalive",
+			args:   []string{"wg0", "persistent-keepalive"},
+			stdout: testPeerA + "\toff\n" + testPeerB + "\t25\n" + testPeerC + "\toff\n",
+		},
+		{
+			name: "interfaces",
+			args: []string{"interfaces"},
+			// Inaccessible devices are still listed.
+			stdout: "wg0 wg1 wg3\n",
+		},
+		{
+			name:   "help",
+			args:   []string{"--help"},
+			stderr: usage,
+		},
+		{
+			name:   "too many arguments",
+			args:   []string{"wg0", "dump", "foo"},
+			code:   1,
+			stderr: usage,
+		},
+		{
+			name:   "invalid parameter",
+			args:   []string{"all", "foo"},
+			code:   1,
+			stderr: "Invalid parameter: `foo'\n" + usage,
+		},
+		{
+			name:   "not exist",
+			args:   []string{"wg2"},
+			code:   1,
+			stderr: "Unable to access interface: No such device\n",
+		},
+		{
+			name:   "permission denied",
+			args:   []string{"wg3"},
+			code:   1,
+			stderr: "Unable to access interface: Operation not permitted\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{
+				devices: []*wgtypes.Device{d, {Name: "wg1"}},
+				errs:    map[string]error{"wg3": syscall.EPERM},
+			})
+			e.getenv = func(key string) string { return tt.env[key] }
+
+			// Errors for inaccessible devices are only reported for "all".
+			if tt.args[0] == "all" {
+				e.client = func() (client, error) {
+					return &testClient{devices: []*wgtypes.Device{d, {Name: "wg1"}}}, nil
+				}
+			}
+
+			args := append([]string{"show"}, tt.args...)
+			if diff := cmp.Diff(tt.code, showMain(e, args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShowAllErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices []*wgtypes.Device
+		code    int
+		stdout  string
+	}{
+		{
+			name:    "partial",
+			devices: []*wgtypes.Device{{Name: "wg0"}},
+			stdout:  "interface: wg0\n",
+		},
+		{
+			// No devices are printed, and the exit status reports the
+			// failure.
+			name: "all failed",
+			code: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, stdout, stderr := testEnv(&testClient{
+				devices: tt.devices,
+				errs:    map[string]error{"wg1": errors.New("driver failure")},
+			})
+
+			if diff := cmp.Diff(tt.code, showMain(e, []string{"show"})); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("Unable to access interface wg1: driver failure\n", stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func mustKey(s string) wgtypes.Key {
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return k
+}
+
+func mustCIDR(s string) net.IPNet {
+	_, ipn, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return *ipn
+}
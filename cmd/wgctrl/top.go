@@ -0,0 +1,381 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Terminal escape sequences which move the cursor to the top left of the
+// screen and clear it.
+const termClear = "\x1b[H\x1b[2J"
+
+// topSorts are the valid values of the top subcommand's --sort flag.
+var topSorts = []string{"rx", "tx", "handshake", "interface", "peer", "endpoint"}
+
+// topMain implements the top subcommand, which polls devices and prints a
+// table of peers with their transfer rates until interrupted.
+func topMain(e *env, args []string) int {
+	fset := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+
+	var (
+		interval = fset.Duration("interval", time.Second, "")
+		sortBy   = fset.String("sort", "rx", "")
+		prefix   = fset.String("peer", "", "")
+		stale    = fset.Duration("stale", 3*time.Minute, "")
+		count    = fset.Int("count", 0, "")
+	)
+
+	usage := func() {
+		fmt.Fprintf(e.stderr, "Usage: %s %s [--interval <duration>] [--sort %s] [--peer <public key prefix>] [--stale <duration>] [--count <updates>] [<interface>...]\n",
+			e.prog, args[0], strings.Join(topSorts, "|"))
+	}
+
+	if err := fset.Parse(args[1:]); err != nil || *interval <= 0 || *count < 0 || !validSort(*sortBy) {
+		usage()
+		return 1
+	}
+
+	c, err := e.client()
+	if err != nil {
+		fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+		return 1
+	}
+
+	t := &top{
+		p:      newPrinter(e, output{format: formatText}),
+		clear:  e.terminal,
+		sortBy: *sortBy,
+		stale:  *stale,
+		filter: topFilter{
+			devices: fset.Args(),
+			prefix:  *prefix,
+		},
+	}
+
+	var prev *snapshot
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			e.sleep(*interval)
+		}
+
+		ds, errs, err := c.DevicesPartial()
+		if err != nil {
+			fmt.Fprintf(e.stderr, "Unable to list interfaces: %s\n", strerror(err))
+			return 1
+		}
+
+		// Only report inaccessible devices once, rather than on every
+		// update.
+		if i == 0 {
+			for _, name := range sortedNames(errs) {
+				fmt.Fprintf(e.stderr, "Unable to access interface %s: %s\n", name, strerror(errs[name]))
+			}
+		}
+
+		cur := newSnapshot(e.now(), ds)
+		t.render(cur, prev)
+		prev = cur
+	}
+
+	return 0
+}
+
+// validSort reports whether s is a valid value for the --sort flag.
+func validSort(s string) bool {
+	for _, v := range topSorts {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A peerID identifies a peer of a device.
+type peerID struct {
+	device string
+	key    wgtypes.Key
+}
+
+// A snapshot is the state of devices at a point in time.
+type snapshot struct {
+	at      time.Time
+	devices []*wgtypes.Device
+	peers   map[peerID]*wgtypes.Peer
+}
+
+// newSnapshot creates a snapshot of ds at time at.
+func newSnapshot(at time.Time, ds []*wgtypes.Device) *snapshot {
+	s := &snapshot{
+		at:      at,
+		devices: ds,
+		peers:   make(map[peerID]*wgtypes.Peer),
+	}
+
+	for _, d := range ds {
+		for i := range d.Peers {
+			s.peers[peerID{device: d.Name, key: d.Peers[i].PublicKey}] = &d.Peers[i]
+		}
+	}
+
+	return s
+}
+
+// A topFilter selects the peers shown by top.
+type topFilter struct {
+	// devices are the names of the devices to show, or empty for all
+	// devices.
+	devices []string
+
+	// prefix is a prefix of the base64 public keys of the peers to show.
+	prefix string
+}
+
+// device reports whether the filter matches the device with name.
+func (f topFilter) device(name string) bool {
+	if len(f.devices) == 0 {
+		return true
+	}
+
+	for _, d := range f.devices {
+		if d == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A topRow is a peer shown by top.
+type topRow struct {
+	device string
+	peer   *wgtypes.Peer
+
+	// rated reports whether the transfer rates are known, which requires a
+	// previous snapshot.
+	rated          bool
+	rxRate, txRate float64
+
+	stale bool
+}
+
+// rows returns the peers in cur which match t's filter, with transfer rates
+// computed from prev if it is not nil.
+func (t *top) rows(cur, prev *snapshot) []topRow {
+	var rows []topRow
+	for _, d := range cur.devices {
+		if !t.filter.device(d.Name) {
+			continue
+		}
+
+		for i := range d.Peers {
+			peer := &d.Peers[i]
+			if !strings.HasPrefix(peer.PublicKey.String(), t.filter.prefix) {
+				continue
+			}
+
+			r := topRow{device: d.Name, peer: peer}
+
+			sec := handshake(peer.LastHandshakeTime)
+			r.stale = sec == 0 || cur.at.Sub(time.Unix(sec, 0)) > t.stale
+
+			if prev != nil {
+				if old, ok := prev.peers[peerID{device: d.Name, key: peer.PublicKey}]; ok {
+					elapsed := cur.at.Sub(prev.at).Seconds()
+					r.rated = elapsed > 0
+					if r.rated {
+						r.rxRate = rate(old.ReceiveBytes, peer.ReceiveBytes, elapsed)
+						r.txRate = rate(old.TransmitBytes, peer.TransmitBytes, elapsed)
+					}
+				}
+			}
+
+			rows = append(rows, r)
+		}
+	}
+
+	return rows
+}
+
+// rate returns the rate of change from old to cur bytes over elapsed
+// seconds. Counters which decreased, such as when a peer is removed and
+// added again, have a rate of zero.
+func rate(old, cur int64, elapsed float64) float64 {
+	if cur < old {
+		return 0
+	}
+
+	return float64(cur-old) / elapsed
+}
+
+// sortRows sorts rows by the column specified by by. Rates are sorted in
+// descending order, and handshakes from most to least recent.
+func sortRows(rows []topRow, by string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+
+		switch by {
+		case "rx":
+			if a.rxRate != b.rxRate {
+				return a.rxRate > b.rxRate
+			}
+		case "tx":
+			if a.txRate != b.txRate {
+				return a.txRate > b.txRate
+			}
+		case "handshake":
+			ha, hb := handshake(a.peer.LastHandshakeTime), handshake(b.peer.LastHandshakeTime)
+			if ha != hb {
+				return ha > hb
+			}
+		case "endpoint":
+			ea, eb := maybeEndpoint(a.peer.Endpoint), maybeEndpoint(b.peer.Endpoint)
+			if ea != eb {
+				return ea < eb
+			}
+		case "peer":
+			ka, kb := a.peer.PublicKey.String(), b.peer.PublicKey.String()
+			if ka != kb {
+				return ka < kb
+			}
+		}
+
+		// Break ties in a stable order.
+		if a.device != b.device {
+			return a.device < b.device
+		}
+
+		return a.peer.PublicKey.String() < b.peer.PublicKey.String()
+	})
+}
+
+// A top prints tables of peers for the top subcommand.
+type top struct {
+	p      *printer
+	clear  bool
+	sortBy string
+	stale  time.Duration
+	filter topFilter
+
+	// updates is the number of tables printed.
+	updates int
+}
+
+// topColumns are the column headings of the table.
+var topColumns = []string{"INTERFACE", "PEER", "ENDPOINT", "HANDSHAKE", "KEEPALIVE", "RX/S", "TX/S", "RX", "TX"}
+
+// peerWidth is the number of characters of peer public keys which are shown.
+const peerWidth = 12
+
+// render prints a table of the peers in cur, with rates computed from prev.
+func (t *top) render(cur, prev *snapshot) {
+	rows := t.rows(cur, prev)
+	sortRows(rows, t.sortBy)
+
+	var nstale int
+	cells := [][]string{topColumns}
+	for _, r := range rows {
+		if r.stale {
+			nstale++
+		}
+
+		rx, tx := "-", "-"
+		if r.rated {
+			rx, tx = topBytes(int64(r.rxRate))+"/s", topBytes(int64(r.txRate))+"/s"
+		}
+
+		ka := keepalive(r.peer.PersistentKeepaliveInterval)
+		if ka != "off" {
+			ka += "s"
+		}
+
+		cells = append(cells, []string{
+			r.device,
+			r.peer.PublicKey.String()[:peerWidth],
+			maybeEndpoint(r.peer.Endpoint),
+			age(cur.at, r.peer.LastHandshakeTime),
+			ka,
+			rx,
+			tx,
+			topBytes(r.peer.ReceiveBytes),
+			topBytes(r.peer.TransmitBytes),
+		})
+	}
+
+	widths := make([]int, len(topColumns))
+	for _, row := range cells {
+		for i, c := range row {
+			if n := utf8.RuneCountInString(c); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	p := t.p
+	switch {
+	case t.clear:
+		p.printf("%s", termClear)
+	case t.updates > 0:
+		p.printf("\n")
+	}
+	t.updates++
+
+	p.printf("%s  peers: %d  stale: %d  sort: %s\n\n", cur.at.Format("15:04:05"), len(rows), nstale, t.sortBy)
+
+	for i, row := range cells {
+		var b strings.Builder
+		for j, c := range row {
+			b.WriteString(c)
+			if j < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(c)+2))
+			}
+		}
+
+		line := strings.TrimRight(b.String(), " ")
+		switch {
+		case i == 0:
+			line = p.c(termBold) + line + p.c(termReset)
+		case rows[i-1].stale:
+			line = p.c(termRed) + line + p.c(termReset)
+		}
+
+		p.printf("%s\n", line)
+	}
+}
+
+// age describes the time elapsed between the handshake t and now compactly,
+// such as "1m3s", or "never" if t is not set.
+func age(now, t time.Time) string {
+	sec := handshake(t)
+	if sec == 0 {
+		return "never"
+	}
+
+	d := now.Unix() - sec
+	switch {
+	case d < 0:
+		return "future"
+	case d < 60:
+		return fmt.Sprintf("%ds", d)
+	case d < 60*60:
+		return fmt.Sprintf("%dm%ds", d/60, d%60)
+	case d < 24*60*60:
+		return fmt.Sprintf("%dh%dm", d/(60*60), d/60%60)
+	default:
+		return fmt.Sprintf("%dd%dh", d/(24*60*60), d/(60*60)%24)
+	}
+}
+
+// topBytes describes a number of bytes using binary units, without color.
+func topBytes(n int64) string {
+	return wgfmt.Formatter{}.Bytes(n)
+}
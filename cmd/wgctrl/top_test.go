@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestTop(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name: "rates",
+			args: []string{"top", "--count", "2"},
+			stdout: `00:00:00  peers: 3  stale: 2  sort: rx
+
+INTERFACE  PEER          ENDPOINT         HANDSHAKE  KEEPALIVE  RX/S  TX/S  RX        TX
+wg0        AgICAgICAgIC  (none)           never      off        -     -     0 B       0 B
+wg0        AwMDAwMDAwMD  192.0.2.1:51820  10s        25s        -     -     1.00 KiB  0 B
+wg1        BQUFBQUFBQUF  [fd00::1]:51820  1h0m       off        -     -     0 B       0 B
+
+00:00:02  peers: 3  stale: 2  sort: rx
+
+INTERFACE  PEER          ENDPOINT         HANDSHAKE  KEEPALIVE  RX/S        TX/S     RX        TX
+wg0        AwMDAwMDAwMD  192.0.2.1:51820  12s        25s        1.00 MiB/s  512 B/s  2.00 MiB  1.00 KiB
+wg0        AgICAgICAgIC  (none)           never      off        0 B/s       0 B/s    0 B       0 B
+wg1        BQUFBQUFBQUF  [fd00::1]:51820  1h0m       off        0 B/s       0 B/s    0 B       0 B
+`,
+		},
+		{
+			name: "filter",
+			args: []string{"top", "--count", "1", "--sort", "handshake", "--peer", "Aw", "wg0"},
+			stdout: `00:00:00  peers: 1  stale: 0  sort: handshake
+
+INTERFACE  PEER          ENDPOINT         HANDSHAKE  KEEPALIVE  RX/S  TX/S  RX        TX
+wg0        AwMDAwMDAwMD  192.0.2.1:51820  10s        25s        -     -     1.00 KiB  0 B
+`,
+		},
+		{
+			name: "color",
+			args: []string{"top", "--count", "1", "--peer", "BQ"},
+			env:  map[string]string{"WG_COLOR_MODE": "always"},
+			stdout: "00:00:00  peers: 1  stale: 1  sort: rx\n\n" +
+				"\x1b[1mINTERFACE  PEER          ENDPOINT         HANDSHAKE  KEEPALIVE  RX/S  TX/S  RX   TX\x1b[0m\n" +
+				"\x1b[31mwg1        BQUFBQUFBQUF  [fd00::1]:51820  1h0m       off        -     -     0 B  0 B\x1b[0m\n",
+		},
+		{
+			name:   "invalid sort",
+			args:   []string{"top", "--sort", "foo"},
+			code:   1,
+			stderr: "Usage: wgctrl top [--interval <duration>] [--sort rx|tx|handshake|interface|peer|endpoint] [--peer <public key prefix>] [--stale <duration>] [--count <updates>] [<interface>...]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			wg0 := &wgtypes.Device{
+				Name: "wg0",
+				Peers: []wgtypes.Peer{
+					{PublicKey: mustKey(testPeerA)},
+					{
+						PublicKey: mustKey(testPeerB),
+						Endpoint: &net.UDPAddr{
+							IP:   net.IPv4(192, 0, 2, 1),
+							Port: 51820,
+						},
+						LastHandshakeTime:           start.Add(-10 * time.Second),
+						ReceiveBytes:                1024,
+						PersistentKeepaliveInterval: 25 * time.Second,
+					},
+				},
+			}
+			wg1 := &wgtypes.Device{
+				Name: "wg1",
+				Peers: []wgtypes.Peer{{
+					PublicKey: mustKey(testPeerC),
+					Endpoint: &net.UDPAddr{
+						IP:   net.ParseIP("fd00::1"),
+						Port: 51820,
+					},
+					LastHandshakeTime: start.Add(-1 * time.Hour),
+				}},
+			}
+
+			e, stdout, stderr := testEnv(&testClient{devices: []*wgtypes.Device{wg0, wg1}})
+			e.getenv = func(key string) string { return tt.env[key] }
+
+			now := start
+			e.now = func() time.Time { return now }
+			e.sleep = func(d time.Duration) {
+				if d != time.Second {
+					t.Fatalf("unexpected interval: %v", d)
+				}
+
+				// Advance the clock and update the counters by replacing the
+				// device's peers, as a client would return new devices.
+				now = now.Add(2 * time.Second)
+				peers := append([]wgtypes.Peer(nil), wg0.Peers...)
+				peers[1].ReceiveBytes += 2 * 1024 * 1024
+				peers[1].TransmitBytes += 1024
+				wg0.Peers = peers
+			}
+
+			if diff := cmp.Diff(tt.code, run(e, tt.args)); diff != "" {
+				t.Fatalf("unexpected exit status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stdout, stdout.String()); diff != "" {
+				t.Fatalf("unexpected stdout (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.stderr, stderr.String()); diff != "" {
+				t.Fatalf("unexpected stderr (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_age(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 5 * time.Second, want: "5s"},
+		{d: 63 * time.Second, want: "1m3s"},
+		{d: 2*time.Hour + 5*time.Minute + 3*time.Second, want: "2h5m"},
+		{d: 49 * time.Hour, want: "2d1h"},
+		{d: -time.Minute, want: "future"},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, age(now, now.Add(-tt.d))); diff != "" {
+			t.Fatalf("unexpected age for %v (-want +got):\n%s", tt.d, diff)
+		}
+	}
+
+	if diff := cmp.Diff("never", age(now, time.Time{})); diff != "" {
+		t.Fatalf("unexpected age for zero time (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,13 @@
+// Package wgctrlinternal gives other packages of this module access to
+// unexported functionality of package wgctrl, which cannot be imported here
+// because it imports the internal packages itself.
+//
+// This package is internal-only and not meant for end users to consume.
+package wgctrlinternal
+
+import "golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+
+// NewClient creates a *wgctrl.Client which uses only c, and is set when
+// package wgctrl is initialized. Callers must import package wgctrl and
+// assert that the result is a *wgctrl.Client.
+var NewClient func(c wginternal.Client) interface{}
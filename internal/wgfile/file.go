@@ -0,0 +1,62 @@
+// Package wgfile provides atomic replacement and locking of files which are
+// shared by several packages of wgctrl.
+//
+// This package is internal-only and not meant for end users to consume.
+package wgfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile atomically replaces the file at path with b, so that readers
+// observe either the previous or the new contents, but never a partially
+// written file. The new file is only accessible by its owner.
+func WriteFile(path string, b []byte) error {
+	// A temporary file in the same directory is renamed over path, because
+	// a rename is only atomic within a single file system.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Lock acquires an exclusive lock for the file at path, waiting until it is
+// available, and returns a function which releases the lock. The lock is
+// held on a separate lock file named path + ".lock", which is created if
+// necessary, so that the file at path may be replaced by WriteFile while
+// the lock is held.
+//
+// Locks are advisory, and exclude both other processes and other calls to
+// Lock within the same process.
+func Lock(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, &os.PathError{Op: "lock", Path: f.Name(), Err: err}
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
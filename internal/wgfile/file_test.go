@@ -0,0 +1,85 @@
+package wgfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgfile"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	for _, s := range []string{"foo\n", "bar\n"} {
+		if err := wgfile.WriteFile(path, []byte(s)); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if diff := cmp.Diff(s, string(b)); diff != "" {
+			t.Fatalf("unexpected contents (-want +got):\n%s", diff)
+		}
+	}
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if diff := cmp.Diff(1, len(entries)); diff != "" {
+		t.Fatalf("unexpected number of files (-want +got):\n%s", diff)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if diff := cmp.Diff(os.FileMode(0o600), fi.Mode().Perm()); diff != "" {
+		t.Fatalf("unexpected permissions (-want +got):\n%s", diff)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+
+	unlock, err := wgfile.Lock(path)
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := wgfile.Lock(path)
+		if err != nil {
+			panic(err)
+		}
+		locked <- unlock
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("lock was acquired while it was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock was not acquired after it was released")
+	}
+}
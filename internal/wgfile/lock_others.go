@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package wgfile
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile returns an error because file locking is not implemented on this
+// platform.
+func lockFile(_ *os.File) error {
+	return fmt.Errorf("file locking is not supported on %s", runtime.GOOS)
+}
+
+// unlockFile does nothing because file locking is not implemented on this
+// platform.
+func unlockFile(_ *os.File) error {
+	return nil
+}
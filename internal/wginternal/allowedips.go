@@ -0,0 +1,109 @@
+package wginternal
+
+import (
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RemoveAllowedIPs emulates the PeerConfig.RemoveAllowedIPs field for drivers
+// which cannot natively remove individual allowed IPs from a peer.
+//
+// If any peer in cfg specifies allowed IPs to remove, device is called to
+// retrieve the current state of the device, and each affected peer is
+// rewritten to replace its allowed IPs with the remaining list. Otherwise,
+// cfg is returned unmodified and device is not called.
+func RemoveAllowedIPs(cfg wgtypes.Config, device func() (*wgtypes.Device, error)) (wgtypes.Config, error) {
+	var remove bool
+	for _, p := range cfg.Peers {
+		if len(p.RemoveAllowedIPs) > 0 {
+			remove = true
+			break
+		}
+	}
+	if !remove {
+		return cfg, nil
+	}
+
+	// Peers which are replaced by this configuration don't have any existing
+	// allowed IPs to consider.
+	existing := make(map[wgtypes.Key][]net.IPNet)
+	if !cfg.ReplacePeers {
+		d, err := device()
+		if err != nil {
+			return wgtypes.Config{}, err
+		}
+
+		for _, p := range d.Peers {
+			existing[p.PublicKey] = p.AllowedIPs
+		}
+	}
+
+	// Don't modify the caller's peers.
+	peers := make([]wgtypes.PeerConfig, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		if len(p.RemoveAllowedIPs) == 0 {
+			peers = append(peers, p)
+			continue
+		}
+
+		switch {
+		case p.Remove:
+			// Nothing to do; the peer is removed anyway.
+		case p.ReplaceAllowedIPs:
+			p.AllowedIPs = subtractIPs(p.AllowedIPs, p.RemoveAllowedIPs)
+		default:
+			ips, ok := existing[p.PublicKey]
+			if ok {
+				// The peer exists, so its allowed IPs must be replaced with
+				// the remaining list.
+				p.ReplaceAllowedIPs = true
+			}
+
+			all := make([]net.IPNet, 0, len(ips)+len(p.AllowedIPs))
+			all = append(all, ips...)
+			all = append(all, p.AllowedIPs...)
+			p.AllowedIPs = subtractIPs(all, p.RemoveAllowedIPs)
+		}
+
+		p.RemoveAllowedIPs = nil
+		peers = append(peers, p)
+	}
+
+	cfg.Peers = peers
+	return cfg, nil
+}
+
+// subtractIPs returns the prefixes in ipns which do not appear in remove, in
+// their original order and without duplicates.
+func subtractIPs(ipns, remove []net.IPNet) []net.IPNet {
+	seen := make(map[string]struct{}, len(ipns)+len(remove))
+	for _, ipn := range remove {
+		seen[prefixKey(ipn)] = struct{}{}
+	}
+
+	out := make([]net.IPNet, 0, len(ipns))
+	for _, ipn := range ipns {
+		k := prefixKey(ipn)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+
+		seen[k] = struct{}{}
+		out = append(out, ipn)
+	}
+
+	return out
+}
+
+// prefixKey produces a canonical string for the prefix described by ipn, so
+// that equivalent prefixes compare equal regardless of host bits or the
+// length of the IP address representation.
+func prefixKey(ipn net.IPNet) string {
+	masked := net.IPNet{
+		IP:   ipn.IP.Mask(ipn.Mask),
+		Mask: ipn.Mask,
+	}
+
+	return masked.String()
+}
@@ -0,0 +1,186 @@
+package wginternal_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRemoveAllowedIPs(t *testing.T) {
+	var (
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+
+		ipA = wgtest.MustCIDR("192.0.2.1/32")
+		ipB = wgtest.MustCIDR("192.0.2.2/32")
+		ipC = wgtest.MustCIDR("2001:db8::/64")
+		ipD = wgtest.MustCIDR("198.51.100.0/24")
+
+		errDevice = errors.New("device error")
+	)
+
+	device := &wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:  peerA,
+			AllowedIPs: []net.IPNet{ipA, ipB, ipC},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		cfg    wgtypes.Config
+		device func() (*wgtypes.Device, error)
+		want   wgtypes.Config
+		err    error
+	}{
+		{
+			name: "no removals",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:  peerA,
+					AllowedIPs: []net.IPNet{ipD},
+				}},
+			},
+			device: func() (*wgtypes.Device, error) {
+				panic("shouldn't be called")
+			},
+			want: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:  peerA,
+					AllowedIPs: []net.IPNet{ipD},
+				}},
+			},
+		},
+		{
+			name: "device error",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:        peerA,
+					RemoveAllowedIPs: []net.IPNet{ipA},
+				}},
+			},
+			device: func() (*wgtypes.Device, error) {
+				return nil, errDevice
+			},
+			err: errDevice,
+		},
+		{
+			name: "existing peer",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:  peerA,
+					AllowedIPs: []net.IPNet{ipD},
+					// Host bits are ignored when matching prefixes.
+					RemoveAllowedIPs: []net.IPNet{ipB, {
+						IP:   net.ParseIP("2001:db8::1"),
+						Mask: net.CIDRMask(64, 128),
+					}},
+				}},
+			},
+			want: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:         peerA,
+					ReplaceAllowedIPs: true,
+					AllowedIPs:        []net.IPNet{ipA, ipD},
+				}},
+			},
+		},
+		{
+			name: "new peer",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:        peerB,
+					AllowedIPs:       []net.IPNet{ipA, ipD},
+					RemoveAllowedIPs: []net.IPNet{ipD},
+				}},
+			},
+			want: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:  peerB,
+					AllowedIPs: []net.IPNet{ipA},
+				}},
+			},
+		},
+		{
+			name: "replace allowed IPs",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:         peerA,
+					ReplaceAllowedIPs: true,
+					AllowedIPs:        []net.IPNet{ipA, ipD},
+					RemoveAllowedIPs:  []net.IPNet{ipA},
+				}},
+			},
+			want: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:         peerA,
+					ReplaceAllowedIPs: true,
+					AllowedIPs:        []net.IPNet{ipD},
+				}},
+			},
+		},
+		{
+			name: "replace peers",
+			cfg: wgtypes.Config{
+				ReplacePeers: true,
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:        peerA,
+					AllowedIPs:       []net.IPNet{ipD},
+					RemoveAllowedIPs: []net.IPNet{ipA},
+				}},
+			},
+			device: func() (*wgtypes.Device, error) {
+				panic("shouldn't be called")
+			},
+			want: wgtypes.Config{
+				ReplacePeers: true,
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:  peerA,
+					AllowedIPs: []net.IPNet{ipD},
+				}},
+			},
+		},
+		{
+			name: "remove peer",
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:        peerA,
+					Remove:           true,
+					RemoveAllowedIPs: []net.IPNet{ipA},
+				}},
+			},
+			want: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey: peerA,
+					Remove:    true,
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := tt.device
+			if fn == nil {
+				fn = func() (*wgtypes.Device, error) { return device, nil }
+			}
+
+			cfg, err := wginternal.RemoveAllowedIPs(tt.cfg, fn)
+			if diff := cmp.Diff(tt.err, err, cmp.Comparer(errors.Is)); diff != "" {
+				t.Fatalf("unexpected error (-want +got):\n%s", diff)
+			}
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, cfg); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
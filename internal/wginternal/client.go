@@ -17,5 +17,6 @@ type Client interface {
 	io.Closer
 	Devices() ([]*wgtypes.Device, error)
 	Device(name string) (*wgtypes.Device, error)
+	DeviceByIndex(index int) (*wgtypes.Device, error)
 	ConfigureDevice(name string, cfg wgtypes.Config) error
 }
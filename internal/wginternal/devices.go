@@ -0,0 +1,76 @@
+package wginternal
+
+import (
+	"runtime"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A PartialClient is a Client which can report the devices which were
+// fetched successfully even when other devices could not be fetched.
+type PartialClient interface {
+	Client
+
+	// DevicesPartial returns the devices which were fetched successfully,
+	// and the errors for devices which could not be fetched, keyed by device
+	// name. A non-nil error indicates that devices could not be enumerated.
+	DevicesPartial() ([]*wgtypes.Device, map[string]error, error)
+}
+
+// FetchDevices fetches the devices specified by names by calling fetch with
+// the index of each name, using up to workers concurrent goroutines. If
+// workers is zero, GOMAXPROCS is used.
+//
+// FetchDevices returns the devices which were fetched successfully in the
+// order of names, and the errors for the remaining devices keyed by name.
+// first is the error for the earliest device in names which failed, for
+// callers which must report a single error.
+func FetchDevices(names []string, workers int, fetch func(i int) (*wgtypes.Device, error)) (ds []*wgtypes.Device, errs map[string]error, first error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(names) {
+		workers = len(names)
+	}
+
+	var (
+		results = make([]*wgtypes.Device, len(names))
+		rerrs   = make([]error, len(names))
+
+		wg   sync.WaitGroup
+		work = make(chan int)
+	)
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i], rerrs[i] = fetch(i)
+			}
+		}()
+	}
+
+	for i := range names {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	ds = make([]*wgtypes.Device, 0, len(names))
+	for i, err := range rerrs {
+		if err == nil {
+			ds = append(ds, results[i])
+			continue
+		}
+
+		if errs == nil {
+			errs = make(map[string]error)
+			first = err
+		}
+		errs[names[i]] = err
+	}
+
+	return ds, errs, first
+}
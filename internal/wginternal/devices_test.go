@@ -0,0 +1,95 @@
+package wginternal_test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestFetchDevices(t *testing.T) {
+	const (
+		n       = 20
+		workers = 4
+	)
+
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		names = append(names, "wg"+strconv.Itoa(i))
+	}
+
+	var (
+		mu             sync.Mutex
+		active, maxAct int
+	)
+
+	ds, errs, first := wginternal.FetchDevices(names, workers, func(i int) (*wgtypes.Device, error) {
+		mu.Lock()
+		active++
+		if active > maxAct {
+			maxAct = active
+		}
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+
+		// Every third device is broken.
+		if i%3 == 1 {
+			return nil, errors.New(names[i] + " is broken")
+		}
+
+		return &wgtypes.Device{Name: names[i]}, nil
+	})
+
+	if maxAct > workers {
+		t.Fatalf("too many concurrent fetches: %d > %d", maxAct, workers)
+	}
+
+	var (
+		wantDevices []*wgtypes.Device
+		wantErrs    = make(map[string]string)
+	)
+	for i, name := range names {
+		if i%3 == 1 {
+			wantErrs[name] = name + " is broken"
+			continue
+		}
+
+		wantDevices = append(wantDevices, &wgtypes.Device{Name: name})
+	}
+
+	if diff := cmp.Diff(wantDevices, ds); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+
+	gotErrs := make(map[string]string)
+	for name, err := range errs {
+		gotErrs[name] = err.Error()
+	}
+
+	if diff := cmp.Diff(wantErrs, gotErrs); diff != "" {
+		t.Fatalf("unexpected errors (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("wg1 is broken", first.Error()); diff != "" {
+		t.Fatalf("unexpected first error (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchDevicesEmpty(t *testing.T) {
+	ds, errs, first := wginternal.FetchDevices(nil, 0, func(_ int) (*wgtypes.Device, error) {
+		panic("shouldn't be called")
+	})
+
+	if len(ds) != 0 || errs != nil || first != nil {
+		t.Fatalf("unexpected results: %v, %v, %v", ds, errs, first)
+	}
+}
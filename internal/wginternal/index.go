@@ -0,0 +1,48 @@
+package wginternal
+
+import (
+	"net"
+	"os"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// InterfaceIndex returns the index of the network interface specified by
+// name, or 0 if the operating system does not report one.
+func InterfaceIndex(name string) int {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0
+	}
+
+	return ifi.Index
+}
+
+// DeviceByIndex fetches a device by its interface index using c, for Clients
+// which can only address devices by name.
+//
+// The interface's current name is resolved immediately before the device is
+// fetched, and the device's index is verified afterward so that a device
+// which was renamed in the meantime is never mistaken for another.
+func DeviceByIndex(c Client, index int) (*wgtypes.Device, error) {
+	if index <= 0 {
+		return nil, os.ErrNotExist
+	}
+
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		// No such interface.
+		return nil, os.ErrNotExist
+	}
+
+	d, err := c.Device(ifi.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Index != index {
+		return nil, os.ErrNotExist
+	}
+
+	return d, nil
+}
@@ -0,0 +1,80 @@
+package wginternal_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDeviceByIndex(t *testing.T) {
+	ifis, err := net.Interfaces()
+	if err != nil || len(ifis) == 0 {
+		t.Skipf("skipping, no network interfaces available: %v", err)
+	}
+	ifi := ifis[0]
+
+	tests := []struct {
+		name  string
+		index int
+		d     *wgtypes.Device
+		ok    bool
+	}{
+		{
+			name:  "invalid",
+			index: 0,
+		},
+		{
+			name:  "renamed",
+			index: ifi.Index,
+			// Reported index does not match the requested index, as if the
+			// interface was replaced after its name was resolved.
+			d: &wgtypes.Device{Name: ifi.Name, Index: ifi.Index + 1},
+		},
+		{
+			name:  "OK",
+			index: ifi.Index,
+			d:     &wgtypes.Device{Name: ifi.Name, Index: ifi.Index},
+			ok:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &testClient{device: func(name string) (*wgtypes.Device, error) {
+				if name != ifi.Name {
+					return nil, os.ErrNotExist
+				}
+
+				return tt.d, nil
+			}}
+
+			d, err := wginternal.DeviceByIndex(c, tt.index)
+			if !tt.ok {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected is not exist, but got: %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get device: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.d, d); diff != "" {
+				t.Fatalf("unexpected device (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+type testClient struct {
+	wginternal.Client
+	device func(name string) (*wgtypes.Device, error)
+}
+
+func (c *testClient) Device(name string) (*wgtypes.Device, error) { return c.device(name) }
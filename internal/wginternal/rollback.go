@@ -0,0 +1,171 @@
+package wginternal
+
+import (
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Rollback restores the configuration of the device specified by name to the
+// configuration in prev, a snapshot taken before the device was modified.
+//
+// Only the differences between the device's current state and prev are
+// applied, so that peers which were unaffected keep their sessions.
+func Rollback(c Client, name string, prev *wgtypes.Device) error {
+	cur, err := c.Device(name)
+	if err != nil {
+		return err
+	}
+
+	cfg, ok := RestoreConfig(prev, cur)
+	if !ok {
+		// Nothing changed.
+		return nil
+	}
+
+	return c.ConfigureDevice(name, cfg)
+}
+
+// RestoreConfig produces a configuration which changes the device in cur so
+// that its configuration matches the device in want. RestoreConfig reports
+// false if the devices' configurations already match.
+func RestoreConfig(want, cur *wgtypes.Device) (wgtypes.Config, bool) {
+	var (
+		cfg     wgtypes.Config
+		changed bool
+	)
+
+	if want.PrivateKey != cur.PrivateKey {
+		k := want.PrivateKey
+		cfg.PrivateKey = &k
+		changed = true
+	}
+	if want.ListenPort != cur.ListenPort {
+		port := want.ListenPort
+		cfg.ListenPort = &port
+		changed = true
+	}
+	if want.FirewallMark != cur.FirewallMark {
+		mark := want.FirewallMark
+		cfg.FirewallMark = &mark
+		changed = true
+	}
+
+	curPeers := make(map[wgtypes.Key]*wgtypes.Peer, len(cur.Peers))
+	for i := range cur.Peers {
+		curPeers[cur.Peers[i].PublicKey] = &cur.Peers[i]
+	}
+
+	wantPeers := make(map[wgtypes.Key]struct{}, len(want.Peers))
+	for i := range want.Peers {
+		wp := &want.Peers[i]
+		wantPeers[wp.PublicKey] = struct{}{}
+
+		cp, ok := curPeers[wp.PublicKey]
+		if ok && wp.Endpoint == nil && cp.Endpoint != nil {
+			// An endpoint cannot be cleared, so the peer must be removed
+			// and recreated.
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+				PublicKey: wp.PublicKey,
+				Remove:    true,
+			})
+			ok = false
+		}
+
+		if !ok {
+			cfg.Peers = append(cfg.Peers, peerConfig(wp))
+			changed = true
+			continue
+		}
+
+		pcfg, pchanged := restorePeer(wp, cp)
+		if pchanged {
+			cfg.Peers = append(cfg.Peers, pcfg)
+			changed = true
+		}
+	}
+
+	// Remove any peers which did not previously exist.
+	for _, cp := range cur.Peers {
+		if _, ok := wantPeers[cp.PublicKey]; ok {
+			continue
+		}
+
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+			PublicKey: cp.PublicKey,
+			Remove:    true,
+		})
+		changed = true
+	}
+
+	return cfg, changed
+}
+
+// peerConfig produces a configuration which fully describes p.
+func peerConfig(p *wgtypes.Peer) wgtypes.PeerConfig {
+	psk := p.PresharedKey
+	keepalive := p.PersistentKeepaliveInterval
+
+	return wgtypes.PeerConfig{
+		PublicKey:                   p.PublicKey,
+		PresharedKey:                &psk,
+		Endpoint:                    p.Endpoint,
+		PersistentKeepaliveInterval: &keepalive,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  p.AllowedIPs,
+	}
+}
+
+// restorePeer produces a configuration which changes the peer in cur to
+// match the peer in want, and reports whether any changes are necessary.
+func restorePeer(want, cur *wgtypes.Peer) (wgtypes.PeerConfig, bool) {
+	var (
+		pcfg    = wgtypes.PeerConfig{PublicKey: want.PublicKey}
+		changed bool
+	)
+
+	if want.PresharedKey != cur.PresharedKey {
+		psk := want.PresharedKey
+		pcfg.PresharedKey = &psk
+		changed = true
+	}
+
+	if want.Endpoint != nil && (cur.Endpoint == nil || want.Endpoint.String() != cur.Endpoint.String()) {
+		pcfg.Endpoint = want.Endpoint
+		changed = true
+	}
+
+	if want.PersistentKeepaliveInterval != cur.PersistentKeepaliveInterval {
+		keepalive := want.PersistentKeepaliveInterval
+		pcfg.PersistentKeepaliveInterval = &keepalive
+		changed = true
+	}
+
+	if !samePrefixes(want.AllowedIPs, cur.AllowedIPs) {
+		pcfg.ReplaceAllowedIPs = true
+		pcfg.AllowedIPs = want.AllowedIPs
+		changed = true
+	}
+
+	return pcfg, changed
+}
+
+// samePrefixes reports whether x and y contain the same set of prefixes,
+// regardless of order.
+func samePrefixes(x, y []net.IPNet) bool {
+	xs := make(map[string]struct{}, len(x))
+	for _, ipn := range x {
+		xs[prefixKey(ipn)] = struct{}{}
+	}
+
+	ys := make(map[string]struct{}, len(y))
+	for _, ipn := range y {
+		k := prefixKey(ipn)
+		if _, ok := xs[k]; !ok {
+			return false
+		}
+		ys[k] = struct{}{}
+	}
+
+	return len(xs) == len(ys)
+}
@@ -0,0 +1,169 @@
+package wginternal_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRestoreConfig(t *testing.T) {
+	var (
+		priv  = wgtest.MustPrivateKey()
+		psk   = wgtest.MustPresharedKey()
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+
+		ipA = wgtest.MustCIDR("192.0.2.1/32")
+		ipB = wgtest.MustCIDR("192.0.2.2/32")
+
+		endpoint = wgtest.MustUDPAddr("[2001:db8::1]:51820")
+
+		zero wgtypes.Key
+	)
+
+	// base is the configuration of the device before it was modified.
+	base := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			PrivateKey: priv,
+			ListenPort: 51820,
+			Peers: []wgtypes.Peer{{
+				PublicKey:                   peerA,
+				PresharedKey:                psk,
+				Endpoint:                    endpoint,
+				PersistentKeepaliveInterval: 25 * time.Second,
+				AllowedIPs:                  []net.IPNet{ipA, ipB},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		prev, cur func(d *wgtypes.Device)
+		cfg       wgtypes.Config
+		ok        bool
+	}{
+		{
+			name: "unchanged",
+			cur: func(d *wgtypes.Device) {
+				// Allowed IP order and statistics are not significant.
+				d.Peers[0].AllowedIPs = []net.IPNet{ipB, ipA}
+				d.Peers[0].ReceiveBytes = 1024
+			},
+		},
+		{
+			name: "device",
+			cur: func(d *wgtypes.Device) {
+				d.PrivateKey = wgtest.MustPrivateKey()
+				d.ListenPort = 0
+				d.FirewallMark = 1
+			},
+			cfg: wgtypes.Config{
+				PrivateKey:   &priv,
+				ListenPort:   intPtr(51820),
+				FirewallMark: intPtr(0),
+			},
+			ok: true,
+		},
+		{
+			name: "peers replaced",
+			cur: func(d *wgtypes.Device) {
+				d.Peers = []wgtypes.Peer{{PublicKey: peerB}}
+			},
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   peerA,
+						PresharedKey:                &psk,
+						Endpoint:                    endpoint,
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs:                  []net.IPNet{ipA, ipB},
+					},
+					{
+						PublicKey: peerB,
+						Remove:    true,
+					},
+				},
+			},
+			ok: true,
+		},
+		{
+			name: "peer modified",
+			cur: func(d *wgtypes.Device) {
+				d.Peers[0].PresharedKey = zero
+				d.Peers[0].Endpoint = wgtest.MustUDPAddr("192.0.2.1:51820")
+				d.Peers[0].PersistentKeepaliveInterval = 0
+				d.Peers[0].AllowedIPs = []net.IPNet{ipA}
+			},
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:                   peerA,
+					PresharedKey:                &psk,
+					Endpoint:                    endpoint,
+					PersistentKeepaliveInterval: durPtr(25 * time.Second),
+					ReplaceAllowedIPs:           true,
+					AllowedIPs:                  []net.IPNet{ipA, ipB},
+				}},
+			},
+			ok: true,
+		},
+		{
+			name: "endpoint cleared",
+			prev: func(d *wgtypes.Device) {
+				d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: peerB})
+			},
+			cur: func(d *wgtypes.Device) {
+				// The endpoint was previously unset, and can only be cleared
+				// by recreating the peer.
+				d.Peers = append(d.Peers, wgtypes.Peer{
+					PublicKey: peerB,
+					Endpoint:  endpoint,
+				})
+			},
+			cfg: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey: peerB,
+						Remove:    true,
+					},
+					{
+						PublicKey:                   peerB,
+						PresharedKey:                &zero,
+						PersistentKeepaliveInterval: durPtr(0),
+						ReplaceAllowedIPs:           true,
+					},
+				},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := base()
+			if tt.prev != nil {
+				tt.prev(want)
+			}
+
+			cur := base()
+			tt.cur(cur)
+
+			cfg, ok := wginternal.RestoreConfig(want, cur)
+			if diff := cmp.Diff(tt.ok, ok); diff != "" {
+				t.Fatalf("unexpected changed result (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.cfg, cfg); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func durPtr(d time.Duration) *time.Duration { return &d }
+func intPtr(v int) *int                     { return &v }
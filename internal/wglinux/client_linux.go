@@ -6,8 +6,13 @@ package wglinux
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"runtime"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/mdlayher/genetlink"
 	"github.com/mdlayher/netlink"
@@ -17,24 +22,131 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var _ wginternal.Client = &Client{}
+var _ wginternal.PartialClient = &Client{}
 
-// A Client provides access to Linux WireGuard netlink information.
+// A Client provides access to Linux WireGuard netlink information. A Client
+// is safe for concurrent use by multiple goroutines.
 type Client struct {
-	c      *genetlink.Conn
+	conns  *connPool
 	family genetlink.Family
 
 	interfaces func() ([]string, error)
+
+	// links and link fetch information about all WireGuard interfaces and
+	// the interface with the specified index, and are only used when
+	// linkInfo is set.
+	links    func() ([]rtnlLink, error)
+	link     func(index int) (*rtnlLink, error)
+	linkInfo bool
+
+	// workers is the number of devices fetched concurrently by Devices.
+	workers int
+
+	// msgLimit is the maximum length of a netlink message accepted by the
+	// kernel from this socket, or 0 if unknown.
+	msgLimit int
+
+	// removal is the method used to remove individual allowed IPs, accessed
+	// atomically. See the removal constants.
+	removal int32
+
+	// trace, if not nil, receives a record of every netlink exchange.
+	trace wgtypes.TraceFunc
 }
 
-// New creates a new Client and returns whether or not the generic netlink
-// interface is available.
-func New() (*Client, bool, error) {
-	c, err := genetlink.Dial(nil)
+// A Config specifies optional configuration for a Client. A nil Config
+// applies the default configuration.
+type Config struct {
+	// Trace, if not nil, is called with a record of every netlink request
+	// and response exchanged with the kernel.
+	Trace wgtypes.TraceFunc
+
+	// MaxConns specifies the maximum number of generic netlink connections
+	// used concurrently by a Client. Operations are performed in parallel
+	// using up to MaxConns connections, and additional operations wait for a
+	// connection to become available. If zero, GOMAXPROCS is used.
+	MaxConns int
+
+	// LinkInfo specifies that each Device should be annotated with
+	// information about its network interface, such as its MTU and
+	// statistics, using rtnetlink.
+	LinkInfo bool
+
+	// Workers specifies the maximum number of devices fetched concurrently
+	// when enumerating devices. If zero, MaxConns is used.
+	Workers int
+}
+
+// New creates a new Client using the optional configuration in cfg and
+// returns whether or not the generic netlink interface is available.
+func New(cfg *Config) (*Client, bool, error) {
+	return newClient(cfg, dial)
+}
+
+// NewWithDial creates a new Client like New, but opens generic netlink
+// connections using dial rather than connecting to the kernel, and
+// enumerates devices using interfaces rather than rtnetlink. It allows
+// package wgctrltest to connect to a fake WireGuard generic netlink family,
+// which is assumed to support all WireGuard generic netlink features. Link
+// information is not available, so cfg.LinkInfo is ignored.
+func NewWithDial(cfg *Config, dial func() (*genetlink.Conn, error), interfaces func() ([]string, error)) (*Client, error) {
+	if cfg != nil {
+		c := *cfg
+		c.LinkInfo = false
+		cfg = &c
+	}
+
+	wgc, ok, err := newClient(cfg, dial)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("wglinux: WireGuard generic netlink family not found")
+	}
+
+	wgc.interfaces = interfaces
+	wgc.removal = removalNative
+
+	return wgc, nil
+}
+
+// newClient creates a new Client using the optional configuration in cfg,
+// which opens generic netlink connections using dial.
+func newClient(cfg *Config, dial func() (*genetlink.Conn, error)) (*Client, bool, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c, err := dial()
 	if err != nil {
 		return nil, false, err
 	}
 
+	size := cfg.MaxConns
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	wgc, ok, err := initClient(c, dial, size)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	wgc.trace = cfg.Trace
+	wgc.linkInfo = cfg.LinkInfo
+	if cfg.Workers > 0 {
+		wgc.workers = cfg.Workers
+	}
+	return wgc, true, nil
+}
+
+// dial opens a generic netlink connection.
+func dial() (*genetlink.Conn, error) {
+	c, err := genetlink.Dial(nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// Best effort version of netlink.Config.Strict due to CentOS 7.
 	for _, o := range []netlink.ConnOption{
 		netlink.ExtendedAcknowledge,
@@ -43,11 +155,12 @@ func New() (*Client, bool, error) {
 		_ = c.SetOption(o, true)
 	}
 
-	return initClient(c)
+	return c, nil
 }
 
-// initClient is the internal Client constructor used in some tests.
-func initClient(c *genetlink.Conn) (*Client, bool, error) {
+// initClient is the internal Client constructor used in some tests. The
+// Client uses c and up to size connections opened by dial.
+func initClient(c *genetlink.Conn, dial func() (*genetlink.Conn, error), size int) (*Client, bool, error) {
 	f, err := c.GetFamily(unix.WG_GENL_NAME)
 	if err != nil {
 		_ = c.Close()
@@ -61,42 +174,91 @@ func initClient(c *genetlink.Conn) (*Client, bool, error) {
 	}
 
 	return &Client{
-		c:      c,
+		conns:  newConnPool(c, dial, size),
 		family: f,
 
 		// By default, gather only WireGuard interfaces using rtnetlink.
 		interfaces: rtnlInterfaces,
+		links:      rtnlLinks,
+		link:       rtnlLinkByIndex,
+
+		removal: initialRemoval(),
+
+		workers:  size,
+		msgLimit: sendLimit(c),
 	}, true, nil
 }
 
 // Close implements wginternal.Client.
 func (c *Client) Close() error {
-	return c.c.Close()
+	return c.conns.Close()
 }
 
 // Devices implements wginternal.Client.
 func (c *Client) Devices() ([]*wgtypes.Device, error) {
+	ds, _, err := c.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// DevicesPartial implements wginternal.PartialClient.
+func (c *Client) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	ds, errs, err := c.devices()
+	if err != nil && errs == nil {
+		// Devices could not be enumerated at all.
+		return nil, nil, err
+	}
+
+	return ds, errs, nil
+}
+
+// devices fetches all devices concurrently. If errs is nil, err reports a
+// failure to enumerate devices. Otherwise, err is the first error in errs.
+func (c *Client) devices() (ds []*wgtypes.Device, errs map[string]error, err error) {
 	// By default, rtnetlink is used to fetch a list of all interfaces and then
 	// filter that list to only find WireGuard interfaces.
 	//
 	// The remainder of this function assumes that any returned device from this
 	// function is a valid WireGuard device.
-	ifis, err := c.interfaces()
-	if err != nil {
-		return nil, err
+	var links []rtnlLink
+	if c.linkInfo {
+		// The same rtnetlink dump also provides link information.
+		links, err = c.links()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		ifis, err := c.interfaces()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		links = make([]rtnlLink, 0, len(ifis))
+		for _, ifi := range ifis {
+			links = append(links, rtnlLink{name: ifi})
+		}
 	}
 
-	ds := make([]*wgtypes.Device, 0, len(ifis))
-	for _, ifi := range ifis {
-		d, err := c.Device(ifi)
+	names := make([]string, 0, len(links))
+	for _, l := range links {
+		names = append(names, l.name)
+	}
+
+	return wginternal.FetchDevices(names, c.workers, func(i int) (*wgtypes.Device, error) {
+		d, err := c.getDevice(netlink.Attribute{
+			Type: unix.WGDEVICE_A_IFNAME,
+			Data: nlenc.Bytes(links[i].name),
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		ds = append(ds, d)
-	}
-
-	return ds, nil
+		d.Link = links[i].link
+		return d, nil
+	})
 }
 
 // Device implements wginternal.Client.
@@ -106,12 +268,56 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 		return nil, os.ErrNotExist
 	}
 
-	// Fetching a device by interface index is possible as well, but we only
-	// support fetching by name as it seems to be more convenient in general.
-	b, err := netlink.MarshalAttributes([]netlink.Attribute{{
+	d, err := c.getDevice(netlink.Attribute{
 		Type: unix.WGDEVICE_A_IFNAME,
 		Data: nlenc.Bytes(name),
-	}})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.addLink(d)
+}
+
+// DeviceByIndex implements wginternal.Client.
+func (c *Client) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	// Don't bother querying netlink with invalid input.
+	if index <= 0 {
+		return nil, os.ErrNotExist
+	}
+
+	d, err := c.getDevice(netlink.Attribute{
+		Type: unix.WGDEVICE_A_IFINDEX,
+		Data: nlenc.Uint32Bytes(uint32(index)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.addLink(d)
+}
+
+// addLink populates d.Link if link information was requested.
+func (c *Client) addLink(d *wgtypes.Device) (*wgtypes.Device, error) {
+	if !c.linkInfo {
+		return d, nil
+	}
+
+	l, err := c.link(d.Index)
+	if err != nil {
+		return nil, err
+	}
+	if l != nil {
+		d.Link = l.link
+	}
+
+	return d, nil
+}
+
+// getDevice fetches a device identified by either its name or interface index
+// in attr.
+func (c *Client) getDevice(attr netlink.Attribute) (*wgtypes.Device, error) {
+	b, err := netlink.MarshalAttributes([]netlink.Attribute{attr})
 	if err != nil {
 		return nil, err
 	}
@@ -126,8 +332,57 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 
 // ConfigureDevice implements wginternal.Client.
 func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if !removesAllowedIPs(cfg) {
+		return c.configure(name, cfg)
+	}
+
+	switch atomic.LoadInt32(&c.removal) {
+	case removalNative:
+		return c.configure(name, cfg)
+	case removalEmulated:
+		return c.configureEmulated(name, cfg)
+	}
+
+	// It is not known yet whether the kernel supports the allowed IP flags
+	// attribute. Kernels which do not support it reject it with EINVAL, in
+	// which case removal is emulated instead.
+	err := c.configure(name, cfg)
+	if err == nil {
+		atomic.StoreInt32(&c.removal, removalNative)
+		return nil
+	}
+	if !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+
+	// The EINVAL may have been caused by another part of the configuration,
+	// so only remember to emulate removal if emulation succeeds.
+	if err := c.configureEmulated(name, cfg); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&c.removal, removalEmulated)
+	return nil
+}
+
+// configureEmulated configures a device using cfg, replacing the allowed IPs
+// of peers rather than removing individual allowed IPs.
+func (c *Client) configureEmulated(name string, cfg wgtypes.Config) error {
+	cfg, err := wginternal.RemoveAllowedIPs(cfg, func() (*wgtypes.Device, error) {
+		return c.Device(name)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.configure(name, cfg)
+}
+
+// configure configures a device using cfg.
+func (c *Client) configure(name string, cfg wgtypes.Config) error {
 	// Large configurations are split into batches for use with netlink.
-	for _, b := range buildBatches(cfg) {
+	batches := buildBatches(name, cfg, c.msgLimit)
+	for i, b := range batches {
 		attrs, err := configAttrs(name, b)
 		if err != nil {
 			return err
@@ -137,13 +392,24 @@ func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
 		// output messages are unused.  The netlink package checks and trims the
 		// status code value.
 		if _, err := c.execute(unix.WG_CMD_SET_DEVICE, netlink.Request|netlink.Acknowledge, attrs); err != nil {
-			return err
+			return batchError(err, name, i, len(batches))
 		}
 	}
 
 	return nil
 }
 
+// removesAllowedIPs reports whether cfg removes individual allowed IPs.
+func removesAllowedIPs(cfg wgtypes.Config) bool {
+	for _, p := range cfg.Peers {
+		if len(p.RemoveAllowedIPs) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // execute executes a single WireGuard netlink request with the specified command,
 // header flags, and attribute arguments.
 func (c *Client) execute(command uint8, flags netlink.HeaderFlags, attrb []byte) ([]genetlink.Message, error) {
@@ -155,32 +421,114 @@ func (c *Client) execute(command uint8, flags netlink.HeaderFlags, attrb []byte)
 		Data: attrb,
 	}
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
-	if err == nil {
-		return msgs, nil
+	conn, err := c.conns.get()
+	if err != nil {
+		return nil, err
 	}
 
-	// We don't want to expose netlink errors directly to callers so unpack to
-	// something more generic.
-	oerr, ok := err.(*netlink.OpError)
-	if !ok {
-		// Expect all errors to conform to netlink.OpError.
-		return nil, fmt.Errorf("wglinux: netlink operation returned non-netlink error (please file a bug: https://golang.zx2c4.com/wireguard/wgctrl): %v", err)
+	start := time.Now()
+	msgs, err := conn.Execute(msg, c.family.ID, flags)
+	c.conns.put(conn, err)
+	if err != nil {
+		err = executeError(command, attrb, err)
 	}
 
-	switch oerr.Err {
-	// Convert "no such device" and "not a wireguard device" to an error
-	// compatible with os.ErrNotExist for easy checking.
-	case unix.ENODEV, unix.ENOTSUP:
-		return nil, os.ErrNotExist
-	default:
-		// Expose the inner error directly (such as EPERM).
-		return nil, oerr.Err
+	c.traceExecute(start, command, attrb, msgs, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// Methods used to remove individual allowed IPs.
+const (
+	// removalUnknown indicates that support for the WGALLOWEDIP_F_REMOVE_ME
+	// flag, which first appeared in Linux 6.16, has not been determined yet.
+	removalUnknown int32 = iota
+
+	// removalNative indicates that the kernel supports the flag.
+	removalNative
+
+	// removalEmulated indicates that the kernel does not support the flag,
+	// so removal is emulated by replacing the allowed IPs of peers.
+	removalEmulated
+)
+
+// initialRemoval returns the method used to remove individual allowed IPs
+// before any removal has been attempted.
+//
+// Since Linux 5.2, the kernel parses nested attributes strictly and rejects
+// the unknown allowed IP flags attribute with EINVAL, so support for the flag
+// is determined by attempting to use it. Older kernels, which may run the
+// out-of-tree WireGuard module, silently ignore unknown attributes and would
+// add IPs which were meant to be removed, so removal is always emulated.
+func initialRemoval() int32 {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return removalEmulated
+	}
+
+	if !kernelAtLeast(unix.ByteSliceToString(u.Release[:]), 5, 2) {
+		return removalEmulated
+	}
+
+	return removalUnknown
+}
+
+// kernelAtLeast reports whether the kernel release string is at least the
+// specified major and minor version.
+func kernelAtLeast(release string, major, minor int) bool {
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return false
+	}
+
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// sendLimit returns the maximum length of a netlink message which the kernel
+// will accept from c, or 0 if it cannot be determined.
+func sendLimit(c *genetlink.Conn) int {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0
+	}
+
+	var (
+		n    int
+		serr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		n, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	}); err != nil || serr != nil {
+		return 0
 	}
+
+	// The kernel reserves a small amount of the send buffer for its own
+	// bookkeeping; see netlink_sendmsg.
+	return n - 32
 }
 
 // rtnlInterfaces uses rtnetlink to fetch a list of WireGuard interfaces.
 func rtnlInterfaces() ([]string, error) {
+	links, err := rtnlLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	ifis := make([]string, 0, len(links))
+	for _, l := range links {
+		ifis = append(ifis, l.name)
+	}
+
+	return ifis, nil
+}
+
+// rtnlLinks uses rtnetlink to fetch information about all WireGuard
+// interfaces.
+func rtnlLinks() ([]rtnlLink, error) {
 	// Use the stdlib's rtnetlink helpers to get ahold of a table of all
 	// interfaces, so we can begin filtering it down to just WireGuard devices.
 	tab, err := syscall.NetlinkRIB(unix.RTM_GETLINK, unix.AF_UNSPEC)
@@ -196,10 +544,64 @@ func rtnlInterfaces() ([]string, error) {
 	return parseRTNLInterfaces(msgs)
 }
 
+// rtnlLinkByIndex uses rtnetlink to fetch information about the interface
+// with the specified index, and returns nil if it is not a WireGuard
+// interface.
+func rtnlLinkByIndex(index int) (*rtnlLink, error) {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wglinux: failed to dial rtnetlink: %v", err)
+	}
+	defer c.Close()
+
+	ifi := unix.IfInfomsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(index),
+	}
+
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETLINK,
+			Flags: netlink.Request,
+		},
+		Data: (*(*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifi)))[:],
+	})
+	if err != nil {
+		if errors.Is(err, unix.ENODEV) {
+			// The interface was removed after the device was fetched.
+			return nil, os.ErrNotExist
+		}
+
+		return nil, fmt.Errorf("wglinux: failed to get interface %d from rtnetlink: %v", index, err)
+	}
+
+	smsgs := make([]syscall.NetlinkMessage, 0, len(msgs))
+	for _, m := range msgs {
+		smsgs = append(smsgs, syscall.NetlinkMessage{
+			Header: syscall.NlMsghdr{Type: uint16(m.Header.Type)},
+			Data:   m.Data,
+		})
+	}
+
+	links, err := parseRTNLInterfaces(smsgs)
+	if err != nil || len(links) == 0 {
+		return nil, err
+	}
+
+	return &links[0], nil
+}
+
+// An rtnlLink is a WireGuard interface reported by rtnetlink.
+type rtnlLink struct {
+	name  string
+	index int
+	link  *wgtypes.Link
+}
+
 // parseRTNLInterfaces unpacks rtnetlink messages and returns WireGuard
-// interface names.
-func parseRTNLInterfaces(msgs []syscall.NetlinkMessage) ([]string, error) {
-	var ifis []string
+// interfaces.
+func parseRTNLInterfaces(msgs []syscall.NetlinkMessage) ([]rtnlLink, error) {
+	var links []rtnlLink
 	for _, m := range msgs {
 		// Only deal with link messages, and they must have an ifinfomsg
 		// structure appear before the attributes.
@@ -211,6 +613,8 @@ func parseRTNLInterfaces(msgs []syscall.NetlinkMessage) ([]string, error) {
 			return nil, fmt.Errorf("wglinux: rtnetlink message is too short for ifinfomsg: %d", len(m.Data))
 		}
 
+		ifi := (*unix.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+
 		ad, err := netlink.NewAttributeDecoder(m.Data[syscall.SizeofIfInfomsg:])
 		if err != nil {
 			return nil, err
@@ -218,16 +622,25 @@ func parseRTNLInterfaces(msgs []syscall.NetlinkMessage) ([]string, error) {
 
 		// Determine the interface's name and if it's a WireGuard device.
 		var (
-			ifi  string
+			name string
 			isWG bool
+			link = wgtypes.Link{Flags: linkFlags(ifi.Flags)}
 		)
 
 		for ad.Next() {
 			switch ad.Type() {
 			case unix.IFLA_IFNAME:
-				ifi = ad.String()
+				name = ad.String()
 			case unix.IFLA_LINKINFO:
 				ad.Do(isWGKind(&isWG))
+			case unix.IFLA_MTU:
+				link.MTU = int(ad.Uint32())
+			case unix.IFLA_OPERSTATE:
+				link.OperState = wgtypes.OperState(ad.Uint8())
+			case unix.IFLA_IFALIAS:
+				link.Alias = ad.String()
+			case unix.IFLA_STATS64:
+				link.Stats = parseLinkStats(ad.Bytes())
 			}
 		}
 
@@ -237,11 +650,77 @@ func parseRTNLInterfaces(msgs []syscall.NetlinkMessage) ([]string, error) {
 
 		if isWG {
 			// Found one; append it to the list.
-			ifis = append(ifis, ifi)
+			links = append(links, rtnlLink{
+				name:  name,
+				index: int(ifi.Index),
+				link:  &link,
+			})
 		}
 	}
 
-	return ifis, nil
+	return links, nil
+}
+
+// linkFlags converts rtnetlink interface flags to net.Flags.
+func linkFlags(flags uint32) net.Flags {
+	var f net.Flags
+	for _, ff := range []struct {
+		iff  uint32
+		flag net.Flags
+	}{
+		{iff: unix.IFF_UP, flag: net.FlagUp},
+		{iff: unix.IFF_BROADCAST, flag: net.FlagBroadcast},
+		{iff: unix.IFF_LOOPBACK, flag: net.FlagLoopback},
+		{iff: unix.IFF_POINTOPOINT, flag: net.FlagPointToPoint},
+		{iff: unix.IFF_MULTICAST, flag: net.FlagMulticast},
+		{iff: unix.IFF_RUNNING, flag: net.FlagRunning},
+	} {
+		if flags&ff.iff != 0 {
+			f |= ff.flag
+		}
+	}
+
+	return f
+}
+
+// parseLinkStats parses a struct rtnl_link_stats64. Older kernels report
+// fewer counters, so only the counters present in b are populated.
+func parseLinkStats(b []byte) *wgtypes.LinkStats {
+	var s wgtypes.LinkStats
+	for i, v := range []*uint64{
+		&s.ReceivePackets,
+		&s.TransmitPackets,
+		&s.ReceiveBytes,
+		&s.TransmitBytes,
+		&s.ReceiveErrors,
+		&s.TransmitErrors,
+		&s.ReceiveDropped,
+		&s.TransmitDropped,
+		&s.Multicast,
+		&s.Collisions,
+		&s.ReceiveLengthErrors,
+		&s.ReceiveOverErrors,
+		&s.ReceiveCRCErrors,
+		&s.ReceiveFrameErrors,
+		&s.ReceiveFIFOErrors,
+		&s.ReceiveMissedErrors,
+		&s.TransmitAbortedErrors,
+		&s.TransmitCarrierErrors,
+		&s.TransmitFIFOErrors,
+		&s.TransmitHeartbeatErrors,
+		&s.TransmitWindowErrors,
+		&s.ReceiveCompressed,
+		&s.TransmitCompressed,
+		&s.ReceiveNoHandler,
+	} {
+		if len(b) < (i+1)*8 {
+			break
+		}
+
+		*v = nlenc.Uint64(b[i*8 : (i+1)*8])
+	}
+
+	return &s
 }
 
 // wgKind is the IFLA_INFO_KIND value for WireGuard devices.
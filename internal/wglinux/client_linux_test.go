@@ -9,9 +9,11 @@ import (
 	"net"
 	"os"
 	"os/user"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/mdlayher/genetlink"
@@ -136,7 +138,7 @@ func TestLinuxClientIsPermission(t *testing.T) {
 		t.Skip("skipping, test must be run without elevated privileges")
 	}
 
-	c, ok, err := New()
+	c, ok, err := New(nil)
 	if err != nil {
 		t.Fatalf("failed to create Client: %v", err)
 	}
@@ -152,13 +154,211 @@ func TestLinuxClientIsPermission(t *testing.T) {
 	}
 }
 
+func TestLinuxClientConcurrent(t *testing.T) {
+	const n = 4
+
+	// Each device has two peers which are returned in separate messages, so
+	// that interleaved dump responses would be detected.
+	peer := func(name string, i int) wgtypes.Key {
+		var k wgtypes.Key
+		copy(k[:], name)
+		k[wgtypes.KeyLen-1] = byte(i)
+		return k
+	}
+
+	// Block the first n requests until all of them are in flight, which can
+	// only happen if requests are performed in parallel.
+	var (
+		inFlight sync.WaitGroup
+		started  = make(chan struct{})
+		once     sync.Once
+		mu       sync.Mutex
+		requests int
+	)
+	inFlight.Add(n)
+
+	fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		mu.Lock()
+		requests++
+		first := requests <= n
+		mu.Unlock()
+
+		if first {
+			inFlight.Done()
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				panicf("timed out waiting for parallel requests")
+			}
+		}
+
+		if greq.Header.Command == unix.WG_CMD_SET_DEVICE {
+			return []genetlink.Message{{}}, nil
+		}
+
+		attrs, err := netlink.UnmarshalAttributes(greq.Data)
+		if err != nil {
+			return nil, err
+		}
+		name := nlenc.String(attrs[0].Data)
+
+		msg := func(i int) genetlink.Message {
+			k := peer(name, i)
+			return genetlink.Message{
+				Data: m([]netlink.Attribute{
+					{
+						Type: unix.WGDEVICE_A_IFNAME,
+						Data: nlenc.Bytes(name),
+					},
+					{
+						Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
+						Data: m(netlink.Attribute{
+							Type: netlink.Nested,
+							Data: m(netlink.Attribute{
+								Type: unix.WGPEER_A_PUBLIC_KEY,
+								Data: k[:],
+							}),
+						}),
+					},
+				}...),
+			}
+		}
+
+		return []genetlink.Message{msg(0), msg(1)}, nil
+	}
+
+	c := testClient(t, fn)
+	defer c.Close()
+
+	go func() {
+		inFlight.Wait()
+		once.Do(func() { close(started) })
+	}()
+
+	var wg sync.WaitGroup
+	errC := make(chan error, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("wg%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				d, err := c.Device(name)
+				if err != nil {
+					errC <- err
+					return
+				}
+
+				if d.Name != name || len(d.Peers) != 2 ||
+					d.Peers[0].PublicKey != peer(name, 0) ||
+					d.Peers[1].PublicKey != peer(name, 1) {
+					errC <- fmt.Errorf("unexpected device for %q: %+v", name, d)
+					return
+				}
+
+				if err := c.ConfigureDevice(name, wgtypes.Config{}); err != nil {
+					errC <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errC)
+
+	for err := range errC {
+		t.Fatalf("failed to perform concurrent operations: %v", err)
+	}
+
+	if _, err := c.Device(okName); err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close client: %v", err)
+	}
+
+	if _, err := c.Device(okName); !errors.Is(err, errClosed) {
+		t.Fatalf("expected closed client error, but got: %v", err)
+	}
+}
+
+func TestLinuxClientDevicesPartial(t *testing.T) {
+	names := []string{"wg0", "wg1", "wg2", "wg3"}
+
+	fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		attrs, err := netlink.UnmarshalAttributes(greq.Data)
+		if err != nil {
+			return nil, err
+		}
+		name := nlenc.String(attrs[0].Data)
+
+		if name == "wg1" {
+			return nil, unix.EPERM
+		}
+
+		return []genetlink.Message{{
+			Data: m(netlink.Attribute{
+				Type: unix.WGDEVICE_A_IFNAME,
+				Data: nlenc.Bytes(name),
+			}),
+		}}, nil
+	}
+
+	c := testClient(t, fn)
+	defer c.Close()
+
+	c.interfaces = func() ([]string, error) {
+		return names, nil
+	}
+
+	ds, errs, err := c.DevicesPartial()
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	var want []*wgtypes.Device
+	for _, name := range []string{"wg0", "wg2", "wg3"} {
+		want = append(want, &wgtypes.Device{
+			Name: name,
+			Type: wgtypes.LinuxKernel,
+		})
+	}
+
+	if diff := cmp.Diff(want, ds); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs["wg1"], unix.EPERM) {
+		t.Fatalf("expected permission denied for wg1, but got: %v", errs)
+	}
+
+	// Devices reports the first error rather than partial results.
+	if _, err := c.Devices(); !errors.Is(err, unix.EPERM) {
+		t.Fatalf("expected permission denied, but got: %v", err)
+	}
+
+	// Failing to enumerate devices is reported as an error.
+	errList := errors.New("failed to list interfaces")
+	c.interfaces = func() ([]string, error) {
+		return nil, errList
+	}
+
+	if _, _, err := c.DevicesPartial(); !errors.Is(err, errList) {
+		t.Fatalf("expected enumeration error, but got: %v", err)
+	}
+}
+
 func Test_initClientNotExist(t *testing.T) {
 	conn := genltest.Dial(func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
 		// Simulate genetlink family not found.
 		return nil, genltest.Error(int(unix.ENOENT))
 	})
 
-	_, ok, err := initClient(conn)
+	_, ok, err := initClient(conn, nil, 1)
 	if err != nil {
 		t.Fatalf("failed to open Client: %v", err)
 	}
@@ -176,11 +376,30 @@ func Test_parseRTNLInterfaces(t *testing.T) {
 		return append(ifinfomsg, nltest.MustMarshalAttributes(attrs)...)
 	}
 
+	// marshalLink is like marshalAttrs, but also sets the interface index and
+	// flags in the ifinfomsg structure.
+	marshalLink := func(index int32, flags uint32, attrs []netlink.Attribute) []byte {
+		ifi := unix.IfInfomsg{
+			Index: index,
+			Flags: flags,
+		}
+		b := (*(*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifi)))[:]
+
+		return append(b, nltest.MustMarshalAttributes(attrs)...)
+	}
+
+	// stats is a struct rtnl_link_stats64 with each counter set to its
+	// position, truncated as reported by older kernels.
+	stats := make([]byte, 0, 23*8)
+	for i := 1; i <= 23; i++ {
+		stats = append(stats, nlenc.Uint64Bytes(uint64(i))...)
+	}
+
 	tests := []struct {
-		name string
-		msgs []syscall.NetlinkMessage
-		ifis []string
-		ok   bool
+		name  string
+		msgs  []syscall.NetlinkMessage
+		links []rtnlLink
+		ok    bool
 	}{
 		{
 			name: "short ifinfomsg",
@@ -231,11 +450,27 @@ func Test_parseRTNLInterfaces(t *testing.T) {
 					Header: syscall.NlMsghdr{
 						Type: unix.RTM_NEWLINK,
 					},
-					Data: marshalAttrs([]netlink.Attribute{
+					Data: marshalLink(okIndex, unix.IFF_UP|unix.IFF_POINTOPOINT|unix.IFF_RUNNING, []netlink.Attribute{
 						{
 							Type: unix.IFLA_IFNAME,
 							Data: nlenc.Bytes(okName),
 						},
+						{
+							Type: unix.IFLA_MTU,
+							Data: nlenc.Uint32Bytes(1420),
+						},
+						{
+							Type: unix.IFLA_OPERSTATE,
+							Data: []byte{6}, // IF_OPER_UP
+						},
+						{
+							Type: unix.IFLA_IFALIAS,
+							Data: nlenc.Bytes("vpn"),
+						},
+						{
+							Type: unix.IFLA_STATS64,
+							Data: stats,
+						},
 						{
 							Type: unix.IFLA_LINKINFO,
 							Data: m([]netlink.Attribute{
@@ -253,14 +488,48 @@ func Test_parseRTNLInterfaces(t *testing.T) {
 					}),
 				},
 			},
-			ifis: []string{okName},
-			ok:   true,
+			links: []rtnlLink{{
+				name:  okName,
+				index: okIndex,
+				link: &wgtypes.Link{
+					MTU:       1420,
+					Flags:     net.FlagUp | net.FlagPointToPoint | net.FlagRunning,
+					OperState: wgtypes.OperUp,
+					Alias:     "vpn",
+					Stats: &wgtypes.LinkStats{
+						ReceivePackets:          1,
+						TransmitPackets:         2,
+						ReceiveBytes:            3,
+						TransmitBytes:           4,
+						ReceiveErrors:           5,
+						TransmitErrors:          6,
+						ReceiveDropped:          7,
+						TransmitDropped:         8,
+						Multicast:               9,
+						Collisions:              10,
+						ReceiveLengthErrors:     11,
+						ReceiveOverErrors:       12,
+						ReceiveCRCErrors:        13,
+						ReceiveFrameErrors:      14,
+						ReceiveFIFOErrors:       15,
+						ReceiveMissedErrors:     16,
+						TransmitAbortedErrors:   17,
+						TransmitCarrierErrors:   18,
+						TransmitFIFOErrors:      19,
+						TransmitHeartbeatErrors: 20,
+						TransmitWindowErrors:    21,
+						ReceiveCompressed:       22,
+						TransmitCompressed:      23,
+					},
+				},
+			}},
+			ok: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ifis, err := parseRTNLInterfaces(tt.msgs)
+			links, err := parseRTNLInterfaces(tt.msgs)
 
 			if tt.ok && err != nil {
 				t.Fatalf("failed to parse interfaces: %v", err)
@@ -272,7 +541,7 @@ func Test_parseRTNLInterfaces(t *testing.T) {
 				return
 			}
 
-			if diff := cmp.Diff(tt.ifis, ifis); diff != "" {
+			if diff := cmp.Diff(tt.links, links, cmp.AllowUnexported(rtnlLink{})); diff != "" {
 				t.Fatalf("unexpected interfaces (-want +got):\n%s", diff)
 			}
 		})
@@ -281,16 +550,19 @@ func Test_parseRTNLInterfaces(t *testing.T) {
 
 const familyID = 20
 
-func testClient(t *testing.T, fn genltest.Func) *Client {
+func testClient(t testing.TB, fn genltest.Func) *Client {
 	family := genetlink.Family{
 		ID:      familyID,
 		Version: unix.WG_GENL_VERSION,
 		Name:    unix.WG_GENL_NAME,
 	}
 
-	conn := genltest.Dial(genltest.ServeFamily(family, fn))
+	dial := func() (*genetlink.Conn, error) {
+		return genltest.Dial(genltest.ServeFamily(family, fn)), nil
+	}
 
-	c, ok, err := initClient(conn)
+	conn, _ := dial()
+	c, ok, err := initClient(conn, dial, 4)
 	if err != nil {
 		t.Fatalf("failed to open Client: %v", err)
 	}
@@ -301,6 +573,7 @@ func testClient(t *testing.T, fn genltest.Func) *Client {
 	c.interfaces = func() ([]string, error) {
 		return []string{okName}, nil
 	}
+	c.removal = removalNative
 
 	return c
 }
@@ -327,7 +600,7 @@ func diffAttrs(x, y []netlink.Attribute) string {
 
 func mustAllowedIPs(ipns []net.IPNet) []byte {
 	ae := netlink.NewAttributeEncoder()
-	if err := encodeAllowedIPs(ipns)(ae); err != nil {
+	if err := encodeAllowedIPs(ipns, nil)(ae); err != nil {
 		panicf("failed to create allowed IP attributes: %v", err)
 	}
 
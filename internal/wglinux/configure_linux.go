@@ -6,6 +6,7 @@ package wglinux
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"net"
 	"unsafe"
 
@@ -52,36 +53,49 @@ func configAttrs(name string, cfg wgtypes.Config) ([]byte, error) {
 	return ae.Encode()
 }
 
-// ipBatchChunk is a tunable allowed IP batch limit per peer.
+// Allowed IP attributes and flags which are not yet defined in x/sys/unix.
+const (
+	wgAllowedIPAFlags    = 4 // WGALLOWEDIP_A_FLAGS
+	wgAllowedIPFRemoveMe = 1 // WGALLOWEDIP_F_REMOVE_ME
+)
+
+// Netlink message size limits used when splitting configurations into
+// batches.
+const (
+	// nlaHeaderLen is the length of a netlink attribute header.
+	nlaHeaderLen = 4
+
+	// maxAttrLen is the maximum length of a netlink attribute, including its
+	// header, as its length is stored in a 16-bit integer. All peers in a
+	// batch are nested within a single WGDEVICE_A_PEERS attribute, so this
+	// bounds the size of every batch.
+	maxAttrLen = math.MaxUint16
+
+	// msgHeaderLen is the combined length of the netlink and generic netlink
+	// headers which precede the attributes in each message.
+	msgHeaderLen = 16 + 4
+)
+
+// buildBatches produces a batch of configs from a single config, if needed.
 //
-// Because we don't necessarily know how much space a given peer will occupy,
-// we play it safe and use a reasonably small value.  Note that this constant
-// is used both in this package and tests, so be aware when making changes.
-const ipBatchChunk = 256
-
-// peerBatchChunk specifies the number of peers that can appear in a
-// configuration before we start splitting it into chunks.
-const peerBatchChunk = 32
-
-// shouldBatch determines if a configuration is sufficiently complex that it
-// should be split into batches.
-func shouldBatch(cfg wgtypes.Config) bool {
-	if len(cfg.Peers) > peerBatchChunk {
-		return true
+// Batches are sized according to the encoded length of each peer's
+// attributes, so that every message fits within the netlink attribute length
+// limit and within limit bytes, if limit is greater than zero.
+func buildBatches(name string, cfg wgtypes.Config, limit int) []wgtypes.Config {
+	// The payload of WGDEVICE_A_PEERS in each message may not exceed budget.
+	budget := maxAttrLen - nlaHeaderLen
+	if limit > 0 {
+		if n := limit - msgHeaderLen - deviceLen(name, cfg) - nlaHeaderLen; n < budget {
+			budget = n
+		}
 	}
 
-	var ips int
+	// Is this a small configuration; no need to batch?
+	var total int
 	for _, p := range cfg.Peers {
-		ips += len(p.AllowedIPs)
+		total += peerLen(p)
 	}
-
-	return ips > ipBatchChunk
-}
-
-// buildBatches produces a batch of configs from a single config, if needed.
-func buildBatches(cfg wgtypes.Config) []wgtypes.Config {
-	// Is this a small configuration; no need to batch?
-	if !shouldBatch(cfg) {
+	if total <= budget {
 		return []wgtypes.Config{cfg}
 	}
 
@@ -94,34 +108,28 @@ func buildBatches(cfg wgtypes.Config) []wgtypes.Config {
 	// peer has its allowed IPs split into multiple batches.
 	knownPeers := make(map[wgtypes.Key]struct{})
 
-	batches := make([]wgtypes.Config, 0)
+	var (
+		batches []wgtypes.Config
+		batch   = base
+		used    int
+	)
+
+	flush := func() {
+		if len(batch.Peers) == 0 {
+			return
+		}
+
+		batches = append(batches, batch)
+		batch = base
+		used = 0
+	}
+
 	for _, p := range cfg.Peers {
-		batch := base
-
-		// Iterate until no more allowed IPs.
-		var done bool
-		for !done {
-			var tmp []net.IPNet
-			if len(p.AllowedIPs) < ipBatchChunk {
-				// IPs all fit within a batch; we are done.
-				tmp = make([]net.IPNet, len(p.AllowedIPs))
-				copy(tmp, p.AllowedIPs)
-				done = true
-			} else {
-				// IPs are larger than a single batch, copy a batch out and
-				// advance the cursor.
-				tmp = make([]net.IPNet, ipBatchChunk)
-				copy(tmp, p.AllowedIPs[:ipBatchChunk])
-
-				p.AllowedIPs = p.AllowedIPs[ipBatchChunk:]
-
-				if len(p.AllowedIPs) == 0 {
-					// IPs ended on a batch boundary; no more IPs left so end
-					// iteration after this loop.
-					done = true
-				}
-			}
+		// Iterate until no more allowed IPs, placing IPs to add before IPs
+		// to remove so that removals are still applied last.
+		add, remove := p.AllowedIPs, p.RemoveAllowedIPs
 
+		for {
 			pcfg := wgtypes.PeerConfig{
 				// PublicKey denotes the peer and must be present.
 				PublicKey: p.PublicKey,
@@ -134,9 +142,6 @@ func buildBatches(cfg wgtypes.Config) []wgtypes.Config {
 				// It'd be a bit weird to have a remove peer message with many
 				// IPs, but just in case, add this to every peer's message.
 				Remove: p.Remove,
-
-				// The IPs for this chunk.
-				AllowedIPs: tmp,
 			}
 
 			// Only pass certain fields on the first occurrence of a peer, so
@@ -152,12 +157,68 @@ func buildBatches(cfg wgtypes.Config) []wgtypes.Config {
 				pcfg.ReplaceAllowedIPs = p.ReplaceAllowedIPs
 			}
 
-			// Add a peer configuration to this batch and keep going.
-			batch.Peers = []wgtypes.PeerConfig{pcfg}
-			batches = append(batches, batch)
+			n := peerLen(pcfg)
+
+			// Start a new batch if this peer and its next allowed IP won't fit
+			// in the current one.
+			next := n
+			switch {
+			case len(add) > 0:
+				next += nlaHeaderLen + allowedIPLen(add[0], false)
+			case len(remove) > 0:
+				next += nlaHeaderLen + allowedIPLen(remove[0], true)
+			}
+			if used > 0 && used+next > budget {
+				flush()
+			}
+
+			if len(add) > 0 || len(remove) > 0 {
+				n += nlaHeaderLen
+			}
+
+			// Fill the remaining space with as many IPs as possible, always
+			// placing at least one IP so that progress is made.
+			var i, j int
+			for ; i < len(add); i++ {
+				l := allowedIPLen(add[i], false)
+				if i > 0 && used+n+l > budget {
+					break
+				}
+				n += l
+			}
+			if i == len(add) {
+				for ; j < len(remove); j++ {
+					l := allowedIPLen(remove[j], true)
+					if i+j > 0 && used+n+l > budget {
+						break
+					}
+					n += l
+				}
+			}
+
+			if i > 0 {
+				pcfg.AllowedIPs = add[:i:i]
+			}
+			if j > 0 {
+				pcfg.RemoveAllowedIPs = remove[:j:j]
+			}
+			add, remove = add[i:], remove[j:]
+
+			batch.Peers = append(batch.Peers, pcfg)
+			used += n
+
+			// No more IPs left, so move on to the next peer.
+			if len(add) == 0 && len(remove) == 0 {
+				break
+			}
+
+			// This batch is full, but the peer has more IPs.
+			flush()
 		}
 	}
 
+	flush()
+
 	// Do not allow peer replacement beyond the first message in a batch,
 	// so we don't overwrite our previous batch work.
 	for i := range batches {
@@ -169,6 +230,87 @@ func buildBatches(cfg wgtypes.Config) []wgtypes.Config {
 	return batches
 }
 
+// nlaLen returns the aligned length of a netlink attribute with a payload of
+// n bytes.
+func nlaLen(n int) int {
+	return (nlaHeaderLen + n + 3) &^ 3
+}
+
+// deviceLen returns the encoded length of the device attributes in cfg which
+// precede WGDEVICE_A_PEERS.
+func deviceLen(name string, cfg wgtypes.Config) int {
+	// Interface name is a NULL-terminated string.
+	n := nlaLen(len(name) + 1)
+
+	if cfg.PrivateKey != nil {
+		n += nlaLen(wgtypes.KeyLen)
+	}
+	if cfg.ListenPort != nil {
+		n += nlaLen(2)
+	}
+	if cfg.FirewallMark != nil {
+		n += nlaLen(4)
+	}
+	if cfg.ReplacePeers {
+		n += nlaLen(4)
+	}
+
+	return n
+}
+
+// peerLen returns the encoded length of p as an element of WGDEVICE_A_PEERS,
+// as produced by encodePeer.
+func peerLen(p wgtypes.PeerConfig) int {
+	n := nlaLen(wgtypes.KeyLen)
+
+	if p.Remove || p.ReplaceAllowedIPs || p.UpdateOnly {
+		n += nlaLen(4)
+	}
+	if p.PresharedKey != nil {
+		n += nlaLen(wgtypes.KeyLen)
+	}
+	if p.Endpoint != nil {
+		if isIPv6(p.Endpoint.IP) {
+			n += nlaLen(unix.SizeofSockaddrInet6)
+		} else {
+			n += nlaLen(unix.SizeofSockaddrInet4)
+		}
+	}
+	if p.PersistentKeepaliveInterval != nil {
+		n += nlaLen(2)
+	}
+
+	if len(p.AllowedIPs) > 0 || len(p.RemoveAllowedIPs) > 0 {
+		n += nlaHeaderLen
+		for _, ipn := range p.AllowedIPs {
+			n += allowedIPLen(ipn, false)
+		}
+		for _, ipn := range p.RemoveAllowedIPs {
+			n += allowedIPLen(ipn, true)
+		}
+	}
+
+	return nlaHeaderLen + n
+}
+
+// allowedIPLen returns the encoded length of ipn as an element of
+// WGPEER_A_ALLOWEDIPS, as produced by encodeAllowedIP.
+func allowedIPLen(ipn net.IPNet, remove bool) int {
+	n := nlaLen(2) + nlaLen(1)
+
+	if isIPv6(ipn.IP) {
+		n += nlaLen(net.IPv6len)
+	} else {
+		n += nlaLen(net.IPv4len)
+	}
+
+	if remove {
+		n += nlaLen(4)
+	}
+
+	return nlaHeaderLen + n
+}
+
 // encodePeer returns a function to encode PeerConfig nested attributes.
 func encodePeer(p wgtypes.PeerConfig) func(ae *netlink.AttributeEncoder) error {
 	return func(ae *netlink.AttributeEncoder) error {
@@ -202,8 +344,8 @@ func encodePeer(p wgtypes.PeerConfig) func(ae *netlink.AttributeEncoder) error {
 		}
 
 		// Only apply allowed IPs if necessary.
-		if len(p.AllowedIPs) > 0 {
-			ae.Nested(unix.WGPEER_A_ALLOWEDIPS, encodeAllowedIPs(p.AllowedIPs))
+		if len(p.AllowedIPs) > 0 || len(p.RemoveAllowedIPs) > 0 {
+			ae.Nested(unix.WGPEER_A_ALLOWEDIPS, encodeAllowedIPs(p.AllowedIPs, p.RemoveAllowedIPs))
 		}
 
 		return nil
@@ -247,35 +389,63 @@ func encodeSockaddr(endpoint net.UDPAddr) func() ([]byte, error) {
 }
 
 // encodeAllowedIPs returns a function to encode allowed IP nested attributes.
-func encodeAllowedIPs(ipns []net.IPNet) func(ae *netlink.AttributeEncoder) error {
+// The IPs in add are added to a peer, and then the IPs in remove are removed
+// from it.
+func encodeAllowedIPs(add, remove []net.IPNet) func(ae *netlink.AttributeEncoder) error {
 	return func(ae *netlink.AttributeEncoder) error {
-		for i, ipn := range ipns {
-			if !isValidIP(ipn.IP) {
-				return fmt.Errorf("wglinux: invalid allowed IP: %s", ipn.IP.String())
-			}
+		// Netlink arrays use type as an array index.
+		var i uint16
+		for _, ips := range []struct {
+			ipns  []net.IPNet
+			flags uint32
+		}{
+			{ipns: add},
+			{ipns: remove, flags: wgAllowedIPFRemoveMe},
+		} {
+			for _, ipn := range ips.ipns {
+				if err := encodeAllowedIP(ae, i, ipn, ips.flags); err != nil {
+					return err
+				}
 
-			family := uint16(unix.AF_INET6)
-			if !isIPv6(ipn.IP) {
-				// Make sure address is 4 bytes if IPv4.
-				family = unix.AF_INET
-				ipn.IP = ipn.IP.To4()
+				i++
 			}
-
-			// Netlink arrays use type as an array index.
-			ae.Nested(uint16(i), func(nae *netlink.AttributeEncoder) error {
-				nae.Uint16(unix.WGALLOWEDIP_A_FAMILY, family)
-				nae.Bytes(unix.WGALLOWEDIP_A_IPADDR, ipn.IP)
-
-				ones, _ := ipn.Mask.Size()
-				nae.Uint8(unix.WGALLOWEDIP_A_CIDR_MASK, uint8(ones))
-				return nil
-			})
 		}
 
 		return nil
 	}
 }
 
+// encodeAllowedIP encodes a single allowed IP with the specified flags as
+// element i of a netlink array.
+func encodeAllowedIP(ae *netlink.AttributeEncoder, i uint16, ipn net.IPNet, flags uint32) error {
+	if !isValidIP(ipn.IP) {
+		return fmt.Errorf("wglinux: invalid allowed IP: %s", ipn.IP.String())
+	}
+
+	family := uint16(unix.AF_INET6)
+	if !isIPv6(ipn.IP) {
+		// Make sure address is 4 bytes if IPv4.
+		family = unix.AF_INET
+		ipn.IP = ipn.IP.To4()
+	}
+
+	ae.Nested(i, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint16(unix.WGALLOWEDIP_A_FAMILY, family)
+		nae.Bytes(unix.WGALLOWEDIP_A_IPADDR, ipn.IP)
+
+		ones, _ := ipn.Mask.Size()
+		nae.Uint8(unix.WGALLOWEDIP_A_CIDR_MASK, uint8(ones))
+
+		if flags != 0 {
+			nae.Uint32(wgAllowedIPAFlags, flags)
+		}
+
+		return nil
+	})
+
+	return nil
+}
+
 // isValidIP determines if IP is a valid IPv4 or IPv6 address.
 func isValidIP(ip net.IP) bool {
 	return ip.To16() != nil
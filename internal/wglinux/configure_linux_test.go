@@ -5,10 +5,12 @@ package wglinux
 
 import (
 	"net"
+	"strconv"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/mdlayher/genetlink"
 	"github.com/mdlayher/genetlink/genltest"
 	"github.com/mdlayher/netlink"
@@ -272,21 +274,242 @@ func TestLinuxClientConfigureDevice(t *testing.T) {
 	}
 }
 
+func TestLinuxClientConfigureDeviceRemoveAllowedIPs(t *testing.T) {
+	var (
+		peer = wgtest.MustPublicKey()
+
+		ipA = wgtest.MustCIDR("192.0.2.0/24")
+		ipB = wgtest.MustCIDR("2001:db8::/64")
+		ipC = wgtest.MustCIDR("198.51.100.0/24")
+	)
+
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:        peer,
+			AllowedIPs:       []net.IPNet{ipC},
+			RemoveAllowedIPs: []net.IPNet{ipA},
+		}},
+	}
+
+	// The peer attributes with native and emulated removal.
+	native := []netlink.Attribute{
+		{
+			Type: unix.WGPEER_A_PUBLIC_KEY,
+			Data: peer[:],
+		},
+		{
+			Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+			Data: m([]netlink.Attribute{
+				{
+					Type: netlink.Nested,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGALLOWEDIP_A_FAMILY,
+							Data: nlenc.Uint16Bytes(unix.AF_INET),
+						},
+						{
+							Type: unix.WGALLOWEDIP_A_IPADDR,
+							Data: ipC.IP.To4(),
+						},
+						{
+							Type: unix.WGALLOWEDIP_A_CIDR_MASK,
+							Data: nlenc.Uint8Bytes(24),
+						},
+					}...),
+				},
+				{
+					Type: netlink.Nested | 1,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGALLOWEDIP_A_FAMILY,
+							Data: nlenc.Uint16Bytes(unix.AF_INET),
+						},
+						{
+							Type: unix.WGALLOWEDIP_A_IPADDR,
+							Data: ipA.IP.To4(),
+						},
+						{
+							Type: unix.WGALLOWEDIP_A_CIDR_MASK,
+							Data: nlenc.Uint8Bytes(24),
+						},
+						{
+							Type: wgAllowedIPAFlags,
+							Data: nlenc.Uint32Bytes(wgAllowedIPFRemoveMe),
+						},
+					}...),
+				},
+			}...),
+		},
+	}
+
+	emulated := []netlink.Attribute{
+		{
+			Type: unix.WGPEER_A_PUBLIC_KEY,
+			Data: peer[:],
+		},
+		{
+			Type: unix.WGPEER_A_FLAGS,
+			Data: nlenc.Uint32Bytes(unix.WGPEER_F_REPLACE_ALLOWEDIPS),
+		},
+		{
+			Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+			Data: mustAllowedIPs([]net.IPNet{ipB, ipC}),
+		},
+	}
+
+	tests := []struct {
+		name        string
+		removal     int32
+		reject      bool
+		peer        []netlink.Attribute
+		wantRemoval int32
+	}{
+		{
+			name:        "native",
+			removal:     removalNative,
+			peer:        native,
+			wantRemoval: removalNative,
+		},
+		{
+			name:        "emulated",
+			removal:     removalEmulated,
+			peer:        emulated,
+			wantRemoval: removalEmulated,
+		},
+		{
+			name:        "unknown supported",
+			removal:     removalUnknown,
+			peer:        native,
+			wantRemoval: removalNative,
+		},
+		{
+			name:        "unknown rejected",
+			removal:     removalUnknown,
+			reject:      true,
+			peer:        emulated,
+			wantRemoval: removalEmulated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				attrs []netlink.Attribute
+				sets  int
+			)
+			fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+				if greq.Header.Command == unix.WG_CMD_GET_DEVICE {
+					// Report the peer's current allowed IPs.
+					return []genetlink.Message{{
+						Data: m(netlink.Attribute{
+							Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
+							Data: m(netlink.Attribute{
+								Type: netlink.Nested,
+								Data: m([]netlink.Attribute{
+									{
+										Type: unix.WGPEER_A_PUBLIC_KEY,
+										Data: peer[:],
+									},
+									{
+										Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+										Data: mustAllowedIPs([]net.IPNet{ipA, ipB}),
+									},
+								}...),
+							}),
+						}),
+					}}, nil
+				}
+
+				sets++
+				if tt.reject && sets == 1 {
+					// Reject the unknown allowed IP flags attribute.
+					return nil, genltest.Error(int(unix.EINVAL))
+				}
+
+				var err error
+				attrs, err = netlink.UnmarshalAttributes(greq.Data)
+				if err != nil {
+					return nil, err
+				}
+
+				return []genetlink.Message{{}}, nil
+			}
+
+			c := testClient(t, fn)
+			defer c.Close()
+
+			c.removal = tt.removal
+
+			if err := c.ConfigureDevice(okName, cfg); err != nil {
+				t.Fatalf("failed to configure device: %v", err)
+			}
+
+			want := []netlink.Attribute{
+				{
+					Type: unix.WGDEVICE_A_IFNAME,
+					Data: nlenc.Bytes(okName),
+				},
+				{
+					Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
+					Data: m(netlink.Attribute{
+						Type: netlink.Nested,
+						Data: m(tt.peer...),
+					}),
+				},
+			}
+
+			if diff := diffAttrs(want, attrs); diff != "" {
+				t.Fatalf("unexpected request attributes (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantRemoval, c.removal); diff != "" {
+				t.Fatalf("unexpected removal method (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_kernelAtLeast(t *testing.T) {
+	tests := []struct {
+		release string
+		ok      bool
+	}{
+		{release: "5.2.0", ok: true},
+		{release: "5.4.0-150-generic", ok: true},
+		{release: "6.17.2-arch1-1", ok: true},
+		{release: "5.1.21", ok: false},
+		{release: "4.19.0-18-amd64", ok: false},
+		{release: "3.10.0-1160.el7.x86_64", ok: false},
+		{release: "garbage", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			if diff := cmp.Diff(tt.ok, kernelAtLeast(tt.release, 5, 2)); diff != "" {
+				t.Fatalf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 	nameAttr := netlink.Attribute{
 		Type: unix.WGDEVICE_A_IFNAME,
 		Data: nlenc.Bytes(okName),
 	}
 
+	// Each IPv6 allowed IP occupies 40 bytes and each peer occupies 52 bytes
+	// before its allowed IPs, so at most 1636 IPs fit in a single message
+	// with a single peer.
 	var (
 		peerA    = wgtest.MustPublicKey()
-		peerAIPs = generateIPs(ipBatchChunk + 1)
+		peerAIPs = generateIPs(2000)
 
 		peerB    = wgtest.MustPublicKey()
-		peerBIPs = generateIPs(ipBatchChunk / 2)
+		peerBIPs = generateIPs(100)
 
 		peerC    = wgtest.MustPublicKey()
-		peerCIPs = generateIPs(ipBatchChunk * 3)
+		peerCIPs = generateIPs(4000)
 
 		peerD = wgtest.MustPublicKey()
 	)
@@ -320,13 +543,21 @@ func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 		},
 	}
 
-	var allAttrs []netlink.Attribute
+	var (
+		msgs     int
+		allAttrs []netlink.Attribute
+	)
 	fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		if l := nlaHeaderLen + len(greq.Data); l > maxAttrLen {
+			t.Fatalf("message attributes exceed maximum length: %d", l)
+		}
+
 		attrs, err := netlink.UnmarshalAttributes(greq.Data)
 		if err != nil {
 			return nil, err
 		}
 
+		msgs++
 		allAttrs = append(allAttrs, attrs...)
 
 		// Data currently unused; send a message to acknowledge request.
@@ -340,6 +571,10 @@ func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 		t.Fatalf("failed to configure: %v", err)
 	}
 
+	if diff := cmp.Diff(4, msgs); diff != "" {
+		t.Fatalf("unexpected number of messages (-want +got):\n%s", diff)
+	}
+
 	want := []netlink.Attribute{
 		// First peer, first chunk.
 		nameAttr,
@@ -362,79 +597,72 @@ func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 					},
 					{
 						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerAIPs[:ipBatchChunk]),
-					},
-				}...),
-			}),
-		},
-		// First peer, final chunk.
-		nameAttr,
-		{
-			Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
-			Data: m(netlink.Attribute{
-				Type: netlink.Nested,
-				Data: m([]netlink.Attribute{
-					{
-						Type: unix.WGPEER_A_PUBLIC_KEY,
-						Data: peerA[:],
-					},
-					{
-						Type: unix.WGPEER_A_FLAGS,
-						Data: nlenc.Uint32Bytes(unix.WGPEER_F_UPDATE_ONLY),
-					},
-					// Not first chunk; don't replace IPs.
-					{
-						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerAIPs[ipBatchChunk:]),
-					},
-				}...),
-			}),
-		},
-		// Second peer, only chunk.
-		nameAttr,
-		// This is not the first peer; don't replace existing peers.
-		{
-			Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
-			Data: m(netlink.Attribute{
-				Type: netlink.Nested,
-				Data: m([]netlink.Attribute{
-					{
-						Type: unix.WGPEER_A_PUBLIC_KEY,
-						Data: peerB[:],
-					},
-					{
-						Type: unix.WGPEER_A_FLAGS,
-						Data: nlenc.Uint32Bytes(unix.WGPEER_F_REPLACE_ALLOWEDIPS | unix.WGPEER_F_UPDATE_ONLY),
-					},
-					{
-						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerBIPs),
+						Data: mustAllowedIPs(peerAIPs[:1636]),
 					},
 				}...),
 			}),
 		},
-		// Third peer, first chunk.
+		// First peer, final chunk; second peer, only chunk; third peer,
+		// first chunk.
 		nameAttr,
-		// This is not the first peer; don't replace existing peers.
+		// Not the first message; don't replace existing peers.
 		{
 			Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
-			Data: m(netlink.Attribute{
-				Type: netlink.Nested,
-				Data: m([]netlink.Attribute{
-					{
-						Type: unix.WGPEER_A_PUBLIC_KEY,
-						Data: peerC[:],
-					},
-					{
-						Type: unix.WGPEER_A_FLAGS,
-						Data: nlenc.Uint32Bytes(unix.WGPEER_F_REPLACE_ALLOWEDIPS | unix.WGPEER_F_UPDATE_ONLY),
-					},
-					{
-						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerCIPs[:ipBatchChunk]),
-					},
-				}...),
-			}),
+			Data: m([]netlink.Attribute{
+				{
+					Type: netlink.Nested,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGPEER_A_PUBLIC_KEY,
+							Data: peerA[:],
+						},
+						{
+							Type: unix.WGPEER_A_FLAGS,
+							Data: nlenc.Uint32Bytes(unix.WGPEER_F_UPDATE_ONLY),
+						},
+						// Not first chunk; don't replace IPs.
+						{
+							Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+							Data: mustAllowedIPs(peerAIPs[1636:]),
+						},
+					}...),
+				},
+				{
+					Type: netlink.Nested | 1,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGPEER_A_PUBLIC_KEY,
+							Data: peerB[:],
+						},
+						{
+							Type: unix.WGPEER_A_FLAGS,
+							Data: nlenc.Uint32Bytes(unix.WGPEER_F_REPLACE_ALLOWEDIPS | unix.WGPEER_F_UPDATE_ONLY),
+						},
+						{
+							Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+							Data: mustAllowedIPs(peerBIPs),
+						},
+					}...),
+				},
+				{
+					Type: netlink.Nested | 2,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGPEER_A_PUBLIC_KEY,
+							Data: peerC[:],
+						},
+						{
+							Type: unix.WGPEER_A_FLAGS,
+							Data: nlenc.Uint32Bytes(unix.WGPEER_F_REPLACE_ALLOWEDIPS | unix.WGPEER_F_UPDATE_ONLY),
+						},
+						// Fill the remaining space in the message.
+						{
+							Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+							Data: mustAllowedIPs(peerCIPs[:1170]),
+						},
+					}...),
+				},
+			}...),
 		},
 		// Third peer, second chunk.
 		nameAttr,
@@ -454,52 +682,48 @@ func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 					// Not first chunk; don't replace IPs.
 					{
 						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerCIPs[ipBatchChunk : ipBatchChunk*2]),
+						Data: mustAllowedIPs(peerCIPs[1170 : 1170+1636]),
 					},
 				}...),
 			}),
 		},
-		// Third peer, final chunk.
+		// Third peer, final chunk; fourth peer, only chunk.
 		nameAttr,
 		{
 			Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
-			Data: m(netlink.Attribute{
-				Type: netlink.Nested,
-				Data: m([]netlink.Attribute{
-					{
-						Type: unix.WGPEER_A_PUBLIC_KEY,
-						Data: peerC[:],
-					},
-					{
-						Type: unix.WGPEER_A_FLAGS,
-						Data: nlenc.Uint32Bytes(unix.WGPEER_F_UPDATE_ONLY),
-					},
-					// Not first chunk; don't replace IPs.
-					{
-						Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
-						Data: mustAllowedIPs(peerCIPs[ipBatchChunk*2:]),
-					},
-				}...),
-			}),
-		},
-		// Fourth peer, only chunk.
-		nameAttr,
-		{
-			Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
-			Data: m(netlink.Attribute{
-				Type: netlink.Nested,
-				Data: m([]netlink.Attribute{
-					{
-						Type: unix.WGPEER_A_PUBLIC_KEY,
-						Data: peerD[:],
-					},
-					// Not first chunk; don't replace IPs.
-					{
-						Type: unix.WGPEER_A_FLAGS,
-						Data: nlenc.Uint32Bytes(unix.WGPEER_F_REMOVE_ME),
-					},
-				}...),
-			}),
+			Data: m([]netlink.Attribute{
+				{
+					Type: netlink.Nested,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGPEER_A_PUBLIC_KEY,
+							Data: peerC[:],
+						},
+						{
+							Type: unix.WGPEER_A_FLAGS,
+							Data: nlenc.Uint32Bytes(unix.WGPEER_F_UPDATE_ONLY),
+						},
+						// Not first chunk; don't replace IPs.
+						{
+							Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+							Data: mustAllowedIPs(peerCIPs[1170+1636:]),
+						},
+					}...),
+				},
+				{
+					Type: netlink.Nested | 1,
+					Data: m([]netlink.Attribute{
+						{
+							Type: unix.WGPEER_A_PUBLIC_KEY,
+							Data: peerD[:],
+						},
+						{
+							Type: unix.WGPEER_A_FLAGS,
+							Data: nlenc.Uint32Bytes(unix.WGPEER_F_REMOVE_ME),
+						},
+					}...),
+				},
+			}...),
 		},
 	}
 
@@ -508,6 +732,247 @@ func TestLinuxClientConfigureDeviceLargePeerIPChunks(t *testing.T) {
 	}
 }
 
+func Test_buildBatchesLimit(t *testing.T) {
+	// A small message limit must split even a modest configuration, and
+	// every message must respect that limit.
+	const limit = 1024
+
+	cfg := wgtypes.Config{
+		PrivateKey:   keyPtr(wgtest.MustPrivateKey()),
+		ListenPort:   intPtr(51820),
+		ReplacePeers: true,
+	}
+
+	var ips int
+	for i := 0; i < 50; i++ {
+		p := wgtypes.PeerConfig{
+			PublicKey:                   wgtest.MustPublicKey(),
+			PresharedKey:                keyPtr(wgtest.MustPresharedKey()),
+			Endpoint:                    wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+			PersistentKeepaliveInterval: durPtr(25 * time.Second),
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  generateIPs(i),
+			RemoveAllowedIPs:            []net.IPNet{wgtest.MustCIDR("192.0.2.0/24")},
+		}
+
+		ips += len(p.AllowedIPs) + len(p.RemoveAllowedIPs)
+		cfg.Peers = append(cfg.Peers, p)
+	}
+
+	batches := buildBatches(okName, cfg, limit)
+
+	var gotIPs int
+	for i, b := range batches {
+		if i > 0 && b.ReplacePeers {
+			t.Fatalf("batch %d must not replace peers", i)
+		}
+
+		attrs, err := configAttrs(okName, b)
+		if err != nil {
+			t.Fatalf("failed to encode batch %d: %v", i, err)
+		}
+
+		if l := msgHeaderLen + len(attrs); l > limit {
+			t.Fatalf("batch %d exceeds message limit: %d > %d", i, l, limit)
+		}
+
+		for _, p := range b.Peers {
+			gotIPs += len(p.AllowedIPs) + len(p.RemoveAllowedIPs)
+		}
+	}
+
+	if diff := cmp.Diff(ips, gotIPs); diff != "" {
+		t.Fatalf("unexpected number of allowed IPs (-want +got):\n%s", diff)
+	}
+}
+
+func Test_peerLen(t *testing.T) {
+	tests := []struct {
+		name string
+		p    wgtypes.PeerConfig
+	}{
+		{
+			name: "public key",
+			p:    wgtypes.PeerConfig{PublicKey: wgtest.MustPublicKey()},
+		},
+		{
+			name: "IPv4",
+			p: wgtypes.PeerConfig{
+				PublicKey:                   wgtest.MustPublicKey(),
+				Remove:                      true,
+				PresharedKey:                keyPtr(wgtest.MustPresharedKey()),
+				Endpoint:                    wgtest.MustUDPAddr("192.0.2.1:51820"),
+				PersistentKeepaliveInterval: durPtr(25 * time.Second),
+				AllowedIPs: []net.IPNet{
+					wgtest.MustCIDR("192.0.2.0/24"),
+					wgtest.MustCIDR("198.51.100.1/32"),
+				},
+			},
+		},
+		{
+			name: "IPv6",
+			p: wgtypes.PeerConfig{
+				PublicKey:        wgtest.MustPublicKey(),
+				UpdateOnly:       true,
+				Endpoint:         wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+				AllowedIPs:       generateIPs(3),
+				RemoveAllowedIPs: []net.IPNet{wgtest.MustCIDR("2001:db8::/32")},
+			},
+		},
+		{
+			name: "remove only",
+			p: wgtypes.PeerConfig{
+				PublicKey:        wgtest.MustPublicKey(),
+				RemoveAllowedIPs: []net.IPNet{wgtest.MustCIDR("192.0.2.0/24")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ae := netlink.NewAttributeEncoder()
+			ae.Nested(0, encodePeer(tt.p))
+
+			b, err := ae.Encode()
+			if err != nil {
+				t.Fatalf("failed to encode peer: %v", err)
+			}
+
+			if diff := cmp.Diff(len(b), peerLen(tt.p)); diff != "" {
+				t.Fatalf("unexpected peer length (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLinuxClientConfigureDeviceMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         wgtypes.Config
+		msgs, chunk int
+	}{
+		{
+			name: "small",
+			cfg:  benchmarkConfig(10, 1),
+			msgs: 1,
+			// Configurations with few peers and IPs were never split.
+			chunk: 1,
+		},
+		{
+			name: "many peers",
+			cfg:  benchmarkConfig(10000, 1),
+			msgs: 18,
+			// Every peer was sent in its own message.
+			chunk: 10000,
+		},
+		{
+			name: "many IPs",
+			cfg:  benchmarkConfig(10, 1000),
+			msgs: 7,
+			// Every 256 IPs of a peer were sent in their own message.
+			chunk: 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs int
+			c := testClient(t, func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+				msgs++
+				return []genetlink.Message{{}}, nil
+			})
+			defer c.Close()
+
+			if err := c.ConfigureDevice(okName, tt.cfg); err != nil {
+				t.Fatalf("failed to configure device: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.msgs, msgs); diff != "" {
+				t.Fatalf("unexpected number of messages (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.chunk, chunkedMessages(tt.cfg)); diff != "" {
+				t.Fatalf("unexpected number of chunked messages (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkLinuxClientConfigureDevice(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			cfg := benchmarkConfig(n, 1)
+
+			var msgs int
+			c := testClient(b, func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+				msgs++
+				return []genetlink.Message{{}}, nil
+			})
+			defer c.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := c.ConfigureDevice(okName, cfg); err != nil {
+					b.Fatalf("failed to configure device: %v", err)
+				}
+			}
+
+			// Each message is one sendmsg system call. Report the number of
+			// messages sent by fixed size chunking as a baseline.
+			b.ReportMetric(float64(msgs)/float64(b.N), "msgs/op")
+			b.ReportMetric(float64(chunkedMessages(cfg)), "chunked-msgs/op")
+		})
+	}
+}
+
+// benchmarkConfig returns a Config which replaces all peers with n peers,
+// each with an endpoint and ips IPv6 allowed IPs.
+func benchmarkConfig(n, ips int) wgtypes.Config {
+	all := generateIPs(n * ips)
+
+	cfg := wgtypes.Config{ReplacePeers: true}
+	for i := 0; i < n; i++ {
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+			PublicKey:         wgtypes.Key{byte(i), byte(i >> 8)},
+			Endpoint:          &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820 + i},
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        all[i*ips : (i+1)*ips],
+		})
+	}
+
+	return cfg
+}
+
+// chunkedMessages returns the number of messages which were used to apply
+// cfg before batches were sized by encoded length. Configurations with more
+// than 32 peers or 256 allowed IPs were split into one message per peer, and
+// one message per 256 allowed IPs of each peer.
+func chunkedMessages(cfg wgtypes.Config) int {
+	const (
+		ipChunk   = 256
+		peerChunk = 32
+	)
+
+	var ips int
+	for _, p := range cfg.Peers {
+		ips += len(p.AllowedIPs) + len(p.RemoveAllowedIPs)
+	}
+	if len(cfg.Peers) <= peerChunk && ips <= ipChunk {
+		return 1
+	}
+
+	var n int
+	for _, p := range cfg.Peers {
+		n += (len(p.AllowedIPs) + len(p.RemoveAllowedIPs) + ipChunk - 1) / ipChunk
+		if len(p.AllowedIPs)+len(p.RemoveAllowedIPs) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
 func keyBytes(s string) []byte {
 	k := wgtest.MustHexKey(s)
 	return k[:]
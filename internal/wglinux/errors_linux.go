@@ -0,0 +1,202 @@
+//go:build linux
+// +build linux
+
+package wglinux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// executeError converts an error returned by netlink for a request with the
+// specified command and attributes into a more generic error for callers.
+func executeError(command uint8, attrb []byte, err error) error {
+	// We don't want to expose netlink errors directly to callers so unpack to
+	// something more generic.
+	oerr, ok := err.(*netlink.OpError)
+	if !ok {
+		// Expect all errors to conform to netlink.OpError.
+		return fmt.Errorf("wglinux: netlink operation returned non-netlink error (please file a bug: https://golang.zx2c4.com/wireguard/wgctrl): %v", err)
+	}
+
+	switch oerr.Err {
+	// Convert "no such device" and "not a wireguard device" to an error
+	// compatible with os.ErrNotExist for easy checking.
+	case unix.ENODEV, unix.ENOTSUP:
+		return os.ErrNotExist
+	}
+
+	if oerr.Message == "" && oerr.Offset == 0 {
+		// No extended acknowledgement; expose the inner error directly (such
+		// as EPERM).
+		return oerr.Err
+	}
+
+	// The kernel explained the error, so preserve its explanation and
+	// identify the rejected attribute.
+	e := &wgtypes.OpError{
+		Op:      commandOp(command),
+		Device:  deviceName(attrb),
+		Message: oerr.Message,
+		Err:     oerr.Err,
+	}
+
+	if oerr.Offset > 0 {
+		// The offset is relative to the start of the netlink message, but
+		// attrb begins after the netlink and generic netlink headers.
+		e.Peer, e.Attribute = locateAttr(attrb, oerr.Offset-msgHeaderLen)
+	}
+
+	return e
+}
+
+// batchError annotates err with the position of the rejected message when a
+// configuration for device name was split into batches. Only errors which
+// are already a *wgtypes.OpError are annotated, so that plain system call
+// errors such as EPERM can still be compared directly.
+func batchError(err error, name string, batch, batches int) error {
+	if batches < 2 {
+		return err
+	}
+
+	var oerr *wgtypes.OpError
+	if !errors.As(err, &oerr) {
+		return err
+	}
+
+	oerr.Batch = batch
+	oerr.Batches = batches
+
+	return oerr
+}
+
+// commandOp returns the name of the operation performed by a command.
+func commandOp(command uint8) string {
+	switch command {
+	case unix.WG_CMD_GET_DEVICE:
+		return "get"
+	case unix.WG_CMD_SET_DEVICE:
+		return "set"
+	default:
+		return fmt.Sprintf("command %d", command)
+	}
+}
+
+// deviceName returns the value of the WGDEVICE_A_IFNAME attribute in attrb,
+// if present.
+func deviceName(attrb []byte) string {
+	ad, err := netlink.NewAttributeDecoder(attrb)
+	if err != nil {
+		return ""
+	}
+
+	for ad.Next() {
+		if ad.Type() == unix.WGDEVICE_A_IFNAME {
+			return ad.String()
+		}
+	}
+
+	return ""
+}
+
+// locateAttr finds the attribute which begins at offset off in attrb, and
+// returns the public key of the peer it belongs to, if any, along with its
+// name. If off does not point at an attribute, locateAttr returns the
+// innermost attribute which contains off.
+func locateAttr(attrb []byte, off int) (*wgtypes.Key, string) {
+	typ, b, off, ok := attrAt(attrb, off)
+	if !ok {
+		return nil, ""
+	}
+
+	name := attrName(deviceAttrNames, typ)
+	if typ != unix.WGDEVICE_A_PEERS || off < 0 {
+		return nil, name
+	}
+
+	// Netlink array of peers; every attribute within an element belongs to
+	// the peer identified by that element's public key.
+	_, b, off, ok = attrAt(b, off)
+	if !ok {
+		return nil, name
+	}
+
+	peer := peerKey(b)
+	if off < 0 {
+		return peer, name
+	}
+
+	typ, b, off, ok = attrAt(b, off)
+	if !ok {
+		return peer, name
+	}
+
+	name = attrName(peerAttrNames, typ)
+	if typ != unix.WGPEER_A_ALLOWEDIPS || off < 0 {
+		return peer, name
+	}
+
+	// Netlink array of allowed IPs.
+	_, b, off, ok = attrAt(b, off)
+	if !ok || off < 0 {
+		return peer, name
+	}
+
+	if typ, _, _, ok := attrAt(b, off); ok {
+		name = attrName(allowedIPAttrNames, typ)
+	}
+
+	return peer, name
+}
+
+// attrAt finds the attribute in b which contains offset off. It returns the
+// attribute's type and payload, along with off relative to the start of the
+// payload. The returned offset is negative if off points at the attribute's
+// header.
+func attrAt(b []byte, off int) (uint16, []byte, int, bool) {
+	for i := 0; i+nlaHeaderLen <= len(b); {
+		l := int(nlenc.Uint16(b[i : i+2]))
+		if l < nlaHeaderLen || i+l > len(b) {
+			return 0, nil, 0, false
+		}
+
+		if off >= i && off < i+l {
+			// Mask off the nested and byte order flags.
+			typ := nlenc.Uint16(b[i+2:i+4]) & ^uint16(netlink.Nested|netlink.NetByteOrder)
+			return typ, b[i+nlaHeaderLen : i+l], off - i - nlaHeaderLen, true
+		}
+
+		i += (l + 3) &^ 3
+	}
+
+	return 0, nil, 0, false
+}
+
+// peerKey returns the public key in the peer attributes in b, if present.
+func peerKey(b []byte) *wgtypes.Key {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil
+	}
+
+	for ad.Next() {
+		if ad.Type() != unix.WGPEER_A_PUBLIC_KEY {
+			continue
+		}
+
+		k, err := wgtypes.NewKey(ad.Bytes())
+		if err != nil {
+			return nil
+		}
+
+		return &k
+	}
+
+	return nil
+}
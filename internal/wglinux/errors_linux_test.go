@@ -0,0 +1,243 @@
+//go:build linux
+// +build linux
+
+package wglinux
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_executeError(t *testing.T) {
+	var (
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+	)
+
+	attrb, err := configAttrs(okName, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey: peerA,
+				Endpoint:  wgtest.MustUDPAddr("192.0.2.1:51820"),
+			},
+			{
+				PublicKey:  peerB,
+				Endpoint:   wgtest.MustUDPAddr("192.0.2.2:51820"),
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("192.0.2.0/24")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode attributes: %v", err)
+	}
+
+	// Attribute offsets within attrb, as encoded above:
+	//
+	//   0: WGDEVICE_A_IFNAME
+	//   8: WGDEVICE_A_PEERS
+	//  12: peer A
+	//  72: peer B
+	// 112: peer B WGPEER_A_ENDPOINT
+	// 132: peer B WGPEER_A_ALLOWEDIPS
+	// 156: peer B WGALLOWEDIP_A_CIDR_MASK
+	//
+	// Offsets reported by the kernel include the message headers.
+	offset := func(off int) int { return off + msgHeaderLen }
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "not netlink",
+			err:  errors.New("foo"),
+			want: errors.New("wglinux: netlink operation returned non-netlink error (please file a bug: https://golang.zx2c4.com/wireguard/wgctrl): foo"),
+		},
+		{
+			name: "not exist",
+			err: &netlink.OpError{
+				Err:     unix.ENODEV,
+				Message: "no such device",
+			},
+			want: os.ErrNotExist,
+		},
+		{
+			name: "errno",
+			err:  &netlink.OpError{Err: unix.EPERM},
+			want: unix.EPERM,
+		},
+		{
+			name: "message",
+			err: &netlink.OpError{
+				Err:     unix.EINVAL,
+				Message: "something went wrong",
+			},
+			want: &wgtypes.OpError{
+				Op:      "set",
+				Device:  okName,
+				Message: "something went wrong",
+				Err:     unix.EINVAL,
+			},
+		},
+		{
+			name: "device attribute",
+			err: &netlink.OpError{
+				Err:    unix.EINVAL,
+				Offset: offset(8),
+			},
+			want: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Attribute: "WGDEVICE_A_PEERS",
+				Err:       unix.EINVAL,
+			},
+		},
+		{
+			name: "peer",
+			err: &netlink.OpError{
+				Err:    unix.EINVAL,
+				Offset: offset(72),
+			},
+			want: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Peer:      &peerB,
+				Attribute: "WGDEVICE_A_PEERS",
+				Err:       unix.EINVAL,
+			},
+		},
+		{
+			name: "peer attribute",
+			err: &netlink.OpError{
+				Err:     unix.EINVAL,
+				Message: "invalid endpoint",
+				Offset:  offset(112),
+			},
+			want: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Peer:      &peerB,
+				Attribute: "WGPEER_A_ENDPOINT",
+				Message:   "invalid endpoint",
+				Err:       unix.EINVAL,
+			},
+		},
+		{
+			name: "allowed IP attribute",
+			err: &netlink.OpError{
+				Err:    unix.EINVAL,
+				Offset: offset(156),
+			},
+			want: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Peer:      &peerB,
+				Attribute: "WGALLOWEDIP_A_CIDR_MASK",
+				Err:       unix.EINVAL,
+			},
+		},
+		{
+			name: "out of bounds",
+			err: &netlink.OpError{
+				Err:     unix.EINVAL,
+				Message: "bad",
+				Offset:  offset(1 << 16),
+			},
+			want: &wgtypes.OpError{
+				Op:      "set",
+				Device:  okName,
+				Message: "bad",
+				Err:     unix.EINVAL,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := executeError(unix.WG_CMD_SET_DEVICE, attrb, tt.err)
+			if diff := cmp.Diff(tt.want, err, cmpErrors); diff != "" {
+				t.Fatalf("unexpected error (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_batchError(t *testing.T) {
+	peer := wgtest.MustPublicKey()
+
+	tests := []struct {
+		name           string
+		err            error
+		batch, batches int
+		want           error
+	}{
+		{
+			name:    "single batch",
+			err:     unix.EPERM,
+			batches: 1,
+			want:    unix.EPERM,
+		},
+		{
+			name:    "not exist",
+			err:     os.ErrNotExist,
+			batch:   1,
+			batches: 2,
+			want:    os.ErrNotExist,
+		},
+		{
+			name:    "errno",
+			err:     unix.EPERM,
+			batch:   1,
+			batches: 2,
+			want:    unix.EPERM,
+		},
+		{
+			name: "extended acknowledgement",
+			err: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Peer:      &peer,
+				Attribute: "WGPEER_A_ENDPOINT",
+				Err:       unix.EINVAL,
+			},
+			batch:   2,
+			batches: 3,
+			want: &wgtypes.OpError{
+				Op:        "set",
+				Device:    okName,
+				Batch:     2,
+				Batches:   3,
+				Peer:      &peer,
+				Attribute: "WGPEER_A_ENDPOINT",
+				Err:       unix.EINVAL,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := batchError(tt.err, okName, tt.batch, tt.batches)
+			if diff := cmp.Diff(tt.want, err, cmpErrors); diff != "" {
+				t.Fatalf("unexpected error (-want +got):\n%s", diff)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error must wrap its underlying error: %v", err)
+			}
+		})
+	}
+}
+
+// cmpErrors compares errors by their text.
+var cmpErrors = cmp.Comparer(func(x, y error) bool {
+	return x.Error() == y.Error()
+})
@@ -60,8 +60,7 @@ func parseDeviceLoop(m genetlink.Message) (*wgtypes.Device, error) {
 	for ad.Next() {
 		switch ad.Type() {
 		case unix.WGDEVICE_A_IFINDEX:
-			// Ignored; interface index isn't exposed at all in the userspace
-			// configuration protocol, and name is more friendly anyway.
+			d.Index = int(ad.Uint32())
 		case unix.WGDEVICE_A_IFNAME:
 			d.Name = ad.String()
 		case unix.WGDEVICE_A_PRIVATE_KEY:
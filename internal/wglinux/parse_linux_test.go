@@ -4,7 +4,9 @@
 package wglinux
 
 import (
+	"errors"
 	"net"
+	"os"
 	"runtime"
 	"testing"
 	"time"
@@ -150,12 +152,14 @@ func TestLinuxClientDevicesOK(t *testing.T) {
 			},
 			devices: []*wgtypes.Device{
 				{
-					Name: okName,
-					Type: wgtypes.LinuxKernel,
+					Name:  okName,
+					Index: okIndex,
+					Type:  wgtypes.LinuxKernel,
 				},
 				{
-					Name: "wg1",
-					Type: wgtypes.LinuxKernel,
+					Name:  "wg1",
+					Index: testIndex,
+					Type:  wgtypes.LinuxKernel,
 				},
 			},
 		},
@@ -269,6 +273,7 @@ func TestLinuxClientDevicesOK(t *testing.T) {
 			devices: []*wgtypes.Device{
 				{
 					Name:         okName,
+					Index:        okIndex,
 					Type:         wgtypes.LinuxKernel,
 					PrivateKey:   testKey,
 					PublicKey:    testKey,
@@ -491,6 +496,130 @@ func TestLinuxClientDevicesOK(t *testing.T) {
 	}
 }
 
+func TestLinuxClientDeviceByIndex(t *testing.T) {
+	const renamed = "wgrenamed0"
+
+	fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		// The device must be requested by index alone.
+		want := []netlink.Attribute{{
+			Type: unix.WGDEVICE_A_IFINDEX,
+			Data: nlenc.Uint32Bytes(okIndex),
+		}}
+
+		attrs, err := netlink.UnmarshalAttributes(greq.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if diff := diffAttrs(want, attrs); diff != "" {
+			panicf("unexpected request attributes (-want +got):\n%s", diff)
+		}
+
+		return []genetlink.Message{{
+			Data: m([]netlink.Attribute{
+				{
+					Type: unix.WGDEVICE_A_IFINDEX,
+					Data: nlenc.Uint32Bytes(okIndex),
+				},
+				{
+					Type: unix.WGDEVICE_A_IFNAME,
+					Data: nlenc.Bytes(renamed),
+				},
+			}...),
+		}}, nil
+	}
+
+	c := testClient(t, fn)
+	defer c.Close()
+
+	if _, err := c.DeviceByIndex(0); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected is not exist, but got: %v", err)
+	}
+
+	d, err := c.DeviceByIndex(okIndex)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	want := &wgtypes.Device{
+		Name:  renamed,
+		Index: okIndex,
+		Type:  wgtypes.LinuxKernel,
+	}
+
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Fatalf("unexpected device (-want +got):\n%s", diff)
+	}
+}
+
+func TestLinuxClientDevicesLinkInfo(t *testing.T) {
+	link := &wgtypes.Link{
+		MTU:       1420,
+		OperState: wgtypes.OperUp,
+		Stats:     &wgtypes.LinkStats{ReceiveDropped: 1},
+	}
+
+	fn := func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		return []genetlink.Message{{
+			Data: m([]netlink.Attribute{
+				{
+					Type: unix.WGDEVICE_A_IFINDEX,
+					Data: nlenc.Uint32Bytes(okIndex),
+				},
+				{
+					Type: unix.WGDEVICE_A_IFNAME,
+					Data: nlenc.Bytes(okName),
+				},
+			}...),
+		}}, nil
+	}
+
+	c := testClient(t, fn)
+	defer c.Close()
+
+	c.linkInfo = true
+	l := rtnlLink{
+		name:  okName,
+		index: okIndex,
+		link:  link,
+	}
+	c.links = func() ([]rtnlLink, error) {
+		return []rtnlLink{l}, nil
+	}
+	c.link = func(index int) (*rtnlLink, error) {
+		if index != okIndex {
+			return nil, nil
+		}
+
+		return &l, nil
+	}
+
+	want := &wgtypes.Device{
+		Name:  okName,
+		Index: okIndex,
+		Type:  wgtypes.LinuxKernel,
+		Link:  link,
+	}
+
+	ds, err := c.Devices()
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	if diff := cmp.Diff([]*wgtypes.Device{want}, ds); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+
+	d, err := c.Device(okName)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Fatalf("unexpected device (-want +got):\n%s", diff)
+	}
+}
+
 func Test_parseTimespec(t *testing.T) {
 	var zero [sizeofTimespec64]byte
 
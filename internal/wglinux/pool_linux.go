@@ -0,0 +1,136 @@
+//go:build linux
+// +build linux
+
+package wglinux
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+)
+
+// errClosed is returned when a Client is used after it has been closed.
+var errClosed = errors.New("wglinux: use of closed client")
+
+// A connPool is a bounded pool of generic netlink connections which allows
+// independent operations to proceed in parallel.
+//
+// Each operation has exclusive use of a connection for its duration, so the
+// responses to concurrent requests, such as multi-part dumps, never
+// interleave.
+type connPool struct {
+	dial func() (*genetlink.Conn, error)
+
+	// sem bounds the number of connections in use.
+	sem chan struct{}
+
+	// mu protects the following fields.
+	mu     sync.Mutex
+	idle   []*genetlink.Conn
+	closed bool
+}
+
+// newConnPool creates a connPool which initially contains c, and uses dial
+// to open up to size connections as needed. If dial is nil, only c is used.
+func newConnPool(c *genetlink.Conn, dial func() (*genetlink.Conn, error), size int) *connPool {
+	if dial == nil || size < 1 {
+		size = 1
+	}
+
+	return &connPool{
+		dial: dial,
+		sem:  make(chan struct{}, size),
+		idle: []*genetlink.Conn{c},
+	}
+}
+
+// Close closes all idle connections. Connections which are in use are closed
+// when they are returned to the pool.
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	var err error
+	for _, c := range p.idle {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	p.idle = nil
+
+	return err
+}
+
+// get returns a connection for exclusive use by the caller, waiting for one
+// to become available if all connections are in use. The connection must be
+// returned using put.
+func (p *connPool) get() (*genetlink.Conn, error) {
+	p.sem <- struct{}{}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, errClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	if p.dial == nil {
+		// The only connection was discarded and cannot be replaced.
+		<-p.sem
+		return nil, errClosed
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// put returns c to the pool after an operation which returned err. If err
+// indicates that c may be in an inconsistent state, c is closed instead.
+func (p *connPool) put(c *genetlink.Conn, err error) {
+	defer func() { <-p.sem }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || (isConnError(err) && p.dial != nil) {
+		_ = c.Close()
+		return
+	}
+
+	p.idle = append(p.idle, c)
+}
+
+// isConnError reports whether err was caused by a failed system call rather
+// than an error reported by the kernel in a netlink message, in which case
+// unread messages may remain on the connection.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var oerr *netlink.OpError
+	if !errors.As(err, &oerr) {
+		return true
+	}
+
+	var serr *os.SyscallError
+	return errors.As(oerr.Err, &serr)
+}
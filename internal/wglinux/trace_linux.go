@@ -0,0 +1,243 @@
+//go:build linux
+// +build linux
+
+package wglinux
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// traceExecute reports a netlink request and its response to the Client's
+// trace function, if one is configured.
+func (c *Client) traceExecute(start time.Time, command uint8, attrb []byte, msgs []genetlink.Message, err error) {
+	if c.trace == nil {
+		return
+	}
+
+	t := wgtypes.Trace{
+		Time:     start,
+		Duration: time.Since(start),
+		Protocol: "genetlink",
+		Op:       commandOp(command),
+		Request:  traceDevice(attrb),
+	}
+
+	for _, f := range t.Request {
+		if f.Key == "WGDEVICE_A_IFNAME" {
+			t.Device = f.Value
+		}
+	}
+
+	// Devices fetched by index are only named in the response.
+	if t.Device == "" && len(msgs) > 0 {
+		for _, f := range traceDevice(msgs[0].Data) {
+			if f.Key == "WGDEVICE_A_IFNAME" {
+				t.Device = f.Value
+			}
+		}
+	}
+
+	for _, m := range msgs {
+		t.Response = append(t.Response, wgtypes.TraceField{
+			Key:    "message",
+			Fields: traceDevice(m.Data),
+		})
+	}
+
+	if err != nil {
+		t.Error = err.Error()
+	}
+
+	c.trace(t)
+}
+
+// Names of attributes for use in traces, indexed by attribute type.
+var (
+	deviceAttrNames = map[uint16]string{
+		unix.WGDEVICE_A_IFINDEX:     "WGDEVICE_A_IFINDEX",
+		unix.WGDEVICE_A_IFNAME:      "WGDEVICE_A_IFNAME",
+		unix.WGDEVICE_A_PRIVATE_KEY: "WGDEVICE_A_PRIVATE_KEY",
+		unix.WGDEVICE_A_PUBLIC_KEY:  "WGDEVICE_A_PUBLIC_KEY",
+		unix.WGDEVICE_A_FLAGS:       "WGDEVICE_A_FLAGS",
+		unix.WGDEVICE_A_LISTEN_PORT: "WGDEVICE_A_LISTEN_PORT",
+		unix.WGDEVICE_A_FWMARK:      "WGDEVICE_A_FWMARK",
+		unix.WGDEVICE_A_PEERS:       "WGDEVICE_A_PEERS",
+	}
+
+	peerAttrNames = map[uint16]string{
+		unix.WGPEER_A_PUBLIC_KEY:                    "WGPEER_A_PUBLIC_KEY",
+		unix.WGPEER_A_PRESHARED_KEY:                 "WGPEER_A_PRESHARED_KEY",
+		unix.WGPEER_A_FLAGS:                         "WGPEER_A_FLAGS",
+		unix.WGPEER_A_ENDPOINT:                      "WGPEER_A_ENDPOINT",
+		unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL: "WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL",
+		unix.WGPEER_A_LAST_HANDSHAKE_TIME:           "WGPEER_A_LAST_HANDSHAKE_TIME",
+		unix.WGPEER_A_RX_BYTES:                      "WGPEER_A_RX_BYTES",
+		unix.WGPEER_A_TX_BYTES:                      "WGPEER_A_TX_BYTES",
+		unix.WGPEER_A_ALLOWEDIPS:                    "WGPEER_A_ALLOWEDIPS",
+		unix.WGPEER_A_PROTOCOL_VERSION:              "WGPEER_A_PROTOCOL_VERSION",
+	}
+
+	allowedIPAttrNames = map[uint16]string{
+		unix.WGALLOWEDIP_A_FAMILY:    "WGALLOWEDIP_A_FAMILY",
+		unix.WGALLOWEDIP_A_IPADDR:    "WGALLOWEDIP_A_IPADDR",
+		unix.WGALLOWEDIP_A_CIDR_MASK: "WGALLOWEDIP_A_CIDR_MASK",
+		wgAllowedIPAFlags:            "WGALLOWEDIP_A_FLAGS",
+	}
+)
+
+// attrName returns the name of attribute typ from names, or a numeric
+// placeholder for unknown attributes.
+func attrName(names map[uint16]string, typ uint16) string {
+	if s, ok := names[typ]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
+// traceDevice decodes device attributes into trace fields. Decoding errors
+// are recorded as fields rather than returned, so that a trace always
+// contains as much information as possible.
+func traceDevice(b []byte) []wgtypes.TraceField {
+	return traceAttrs(b, func(ad *netlink.AttributeDecoder) wgtypes.TraceField {
+		f := wgtypes.TraceField{Key: attrName(deviceAttrNames, ad.Type())}
+		switch ad.Type() {
+		case unix.WGDEVICE_A_IFINDEX, unix.WGDEVICE_A_FWMARK:
+			f.Value = strconv.FormatUint(uint64(ad.Uint32()), 10)
+		case unix.WGDEVICE_A_IFNAME:
+			f.Value = ad.String()
+		case unix.WGDEVICE_A_PRIVATE_KEY:
+			f.Value = wgtypes.Redacted
+		case unix.WGDEVICE_A_PUBLIC_KEY:
+			f.Value = traceKey(ad.Bytes())
+		case unix.WGDEVICE_A_FLAGS:
+			f.Value = fmt.Sprintf("%#x", ad.Uint32())
+		case unix.WGDEVICE_A_LISTEN_PORT:
+			f.Value = strconv.Itoa(int(ad.Uint16()))
+		case unix.WGDEVICE_A_PEERS:
+			f.Fields = traceArray(ad.Bytes(), tracePeer)
+		default:
+			f.Value = hex.EncodeToString(ad.Bytes())
+		}
+
+		return f
+	})
+}
+
+// tracePeer decodes peer attributes into a trace field.
+func tracePeer(ad *netlink.AttributeDecoder) wgtypes.TraceField {
+	f := wgtypes.TraceField{Key: attrName(peerAttrNames, ad.Type())}
+	switch ad.Type() {
+	case unix.WGPEER_A_PUBLIC_KEY:
+		f.Value = traceKey(ad.Bytes())
+	case unix.WGPEER_A_PRESHARED_KEY:
+		f.Value = wgtypes.Redacted
+	case unix.WGPEER_A_FLAGS:
+		f.Value = fmt.Sprintf("%#x", ad.Uint32())
+	case unix.WGPEER_A_ENDPOINT:
+		var addr net.UDPAddr
+		if err := parseSockaddr(&addr)(ad.Bytes()); err != nil {
+			f.Value = hex.EncodeToString(ad.Bytes())
+		} else {
+			f.Value = addr.String()
+		}
+	case unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL:
+		f.Value = strconv.Itoa(int(ad.Uint16()))
+	case unix.WGPEER_A_LAST_HANDSHAKE_TIME:
+		var t time.Time
+		if err := parseTimespec(&t)(ad.Bytes()); err != nil {
+			f.Value = hex.EncodeToString(ad.Bytes())
+		} else if !t.IsZero() {
+			f.Value = t.UTC().Format(time.RFC3339Nano)
+		}
+	case unix.WGPEER_A_RX_BYTES, unix.WGPEER_A_TX_BYTES:
+		f.Value = strconv.FormatUint(ad.Uint64(), 10)
+	case unix.WGPEER_A_ALLOWEDIPS:
+		f.Fields = traceArray(ad.Bytes(), traceAllowedIP)
+	case unix.WGPEER_A_PROTOCOL_VERSION:
+		f.Value = strconv.FormatUint(uint64(ad.Uint32()), 10)
+	default:
+		f.Value = hex.EncodeToString(ad.Bytes())
+	}
+
+	return f
+}
+
+// traceAllowedIP decodes allowed IP attributes into a trace field.
+func traceAllowedIP(ad *netlink.AttributeDecoder) wgtypes.TraceField {
+	f := wgtypes.TraceField{Key: attrName(allowedIPAttrNames, ad.Type())}
+	switch ad.Type() {
+	case unix.WGALLOWEDIP_A_FAMILY:
+		f.Value = strconv.Itoa(int(ad.Uint16()))
+	case unix.WGALLOWEDIP_A_IPADDR:
+		var ip net.IP
+		if err := parseAddr(&ip)(ad.Bytes()); err != nil {
+			f.Value = hex.EncodeToString(ad.Bytes())
+		} else {
+			f.Value = ip.String()
+		}
+	case unix.WGALLOWEDIP_A_CIDR_MASK:
+		f.Value = strconv.Itoa(int(ad.Uint8()))
+	case wgAllowedIPAFlags:
+		f.Value = fmt.Sprintf("%#x", ad.Uint32())
+	default:
+		f.Value = hex.EncodeToString(ad.Bytes())
+	}
+
+	return f
+}
+
+// traceArray decodes a netlink array, where each element contains nested
+// attributes decoded by fn.
+func traceArray(b []byte, fn func(ad *netlink.AttributeDecoder) wgtypes.TraceField) []wgtypes.TraceField {
+	return traceAttrs(b, func(ad *netlink.AttributeDecoder) wgtypes.TraceField {
+		return wgtypes.TraceField{
+			// Netlink arrays use type as an array index.
+			Key:    strconv.Itoa(int(ad.Type())),
+			Fields: traceAttrs(ad.Bytes(), fn),
+		}
+	})
+}
+
+// traceAttrs decodes each attribute in b using fn.
+func traceAttrs(b []byte, fn func(ad *netlink.AttributeDecoder) wgtypes.TraceField) []wgtypes.TraceField {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return []wgtypes.TraceField{traceError(err)}
+	}
+
+	var fs []wgtypes.TraceField
+	for ad.Next() {
+		fs = append(fs, fn(ad))
+	}
+
+	if err := ad.Err(); err != nil {
+		fs = append(fs, traceError(err))
+	}
+
+	return fs
+}
+
+// traceKey formats a public key for a trace.
+func traceKey(b []byte) string {
+	k, err := wgtypes.NewKey(b)
+	if err != nil {
+		return hex.EncodeToString(b)
+	}
+
+	return k.String()
+}
+
+// traceError produces a trace field which describes a decoding error.
+func traceError(err error) wgtypes.TraceField {
+	return wgtypes.TraceField{Key: "error", Value: err.Error()}
+}
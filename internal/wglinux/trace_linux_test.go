@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+package wglinux
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestLinuxClientTrace(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		pub  = wgtest.MustPublicKey()
+		psk  = wgtest.MustPresharedKey()
+	)
+
+	c := testClient(t, func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		return []genetlink.Message{{
+			Data: m([]netlink.Attribute{
+				{
+					Type: unix.WGDEVICE_A_IFNAME,
+					Data: nlenc.Bytes(okName),
+				},
+				{
+					Type: unix.WGDEVICE_A_PRIVATE_KEY,
+					Data: priv[:],
+				},
+				{
+					Type: netlink.Nested | unix.WGDEVICE_A_PEERS,
+					Data: m(netlink.Attribute{
+						Type: netlink.Nested,
+						Data: m([]netlink.Attribute{
+							{
+								Type: unix.WGPEER_A_PUBLIC_KEY,
+								Data: pub[:],
+							},
+							{
+								Type: unix.WGPEER_A_PRESHARED_KEY,
+								Data: psk[:],
+							},
+							{
+								Type: netlink.Nested | unix.WGPEER_A_ALLOWEDIPS,
+								Data: mustAllowedIPs([]net.IPNet{
+									wgtest.MustCIDR("192.0.2.0/24"),
+								}),
+							},
+						}...),
+					}),
+				},
+			}...),
+		}}, nil
+	})
+	defer c.Close()
+
+	var traces []wgtypes.Trace
+	c.trace = func(t wgtypes.Trace) {
+		traces = append(traces, t)
+	}
+
+	if _, err := c.Device(okName); err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if err := c.ConfigureDevice(okName, wgtypes.Config{PrivateKey: &priv}); err != nil {
+		t.Fatalf("failed to configure device: %v", err)
+	}
+
+	want := []wgtypes.Trace{
+		{
+			Protocol: "genetlink",
+			Device:   okName,
+			Op:       "get",
+			Request: []wgtypes.TraceField{
+				{Key: "WGDEVICE_A_IFNAME", Value: okName},
+			},
+			Response: []wgtypes.TraceField{{
+				Key: "message",
+				Fields: []wgtypes.TraceField{
+					{Key: "WGDEVICE_A_IFNAME", Value: okName},
+					{Key: "WGDEVICE_A_PRIVATE_KEY", Value: wgtypes.Redacted},
+					{Key: "WGDEVICE_A_PEERS", Fields: []wgtypes.TraceField{{
+						Key: "0",
+						Fields: []wgtypes.TraceField{
+							{Key: "WGPEER_A_PUBLIC_KEY", Value: pub.String()},
+							{Key: "WGPEER_A_PRESHARED_KEY", Value: wgtypes.Redacted},
+							{Key: "WGPEER_A_ALLOWEDIPS", Fields: []wgtypes.TraceField{{
+								Key: "0",
+								Fields: []wgtypes.TraceField{
+									{Key: "WGALLOWEDIP_A_FAMILY", Value: "2"},
+									{Key: "WGALLOWEDIP_A_IPADDR", Value: "192.0.2.0"},
+									{Key: "WGALLOWEDIP_A_CIDR_MASK", Value: "24"},
+								},
+							}}},
+						},
+					}}},
+				},
+			}},
+		},
+		{
+			Protocol: "genetlink",
+			Device:   okName,
+			Op:       "set",
+			Request: []wgtypes.TraceField{
+				{Key: "WGDEVICE_A_IFNAME", Value: okName},
+				{Key: "WGDEVICE_A_PRIVATE_KEY", Value: wgtypes.Redacted},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(len(want), len(traces)); diff != "" {
+		t.Fatalf("unexpected number of traces (-want +got):\n%s", diff)
+	}
+
+	// The acknowledgement for the set request is not inspected.
+	traces[1].Response = nil
+
+	opts := cmpopts.IgnoreFields(wgtypes.Trace{}, "Time", "Duration")
+	if diff := cmp.Diff(want, traces, opts); diff != "" {
+		t.Fatalf("unexpected traces (-want +got):\n%s", diff)
+	}
+}
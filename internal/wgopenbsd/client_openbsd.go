@@ -22,7 +22,7 @@ import (
 // ifGroupWG is the WireGuard interface group name passed to the kernel.
 var ifGroupWG = [16]byte{0: 'w', 1: 'g'}
 
-var _ wginternal.Client = &Client{}
+var _ wginternal.PartialClient = &Client{}
 
 // A Client provides access to OpenBSD WireGuard ioctl information.
 type Client struct {
@@ -31,11 +31,15 @@ type Client struct {
 	close           func() error
 	ioctlIfgroupreq func(ifg *wgh.Ifgroupreq) error
 	ioctlWGDataIO   func(data *wgh.WGDataIO) error
+
+	// workers is the number of devices fetched concurrently by Devices.
+	workers int
 }
 
 // New creates a new Client and returns whether or not the ioctl interface
-// is available.
-func New() (*Client, bool, error) {
+// is available. Up to workers devices are fetched concurrently when
+// enumerating devices; if workers is zero, GOMAXPROCS is used.
+func New(workers int) (*Client, bool, error) {
 	// The OpenBSD ioctl interface operates on a generic AF_INET socket.
 	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
 	if err != nil {
@@ -52,6 +56,7 @@ func New() (*Client, bool, error) {
 		close:           func() error { return unix.Close(fd) },
 		ioctlIfgroupreq: ioctlIfgroupreq(fd),
 		ioctlWGDataIO:   ioctlWGDataIO(fd),
+		workers:         workers,
 	}, true, nil
 }
 
@@ -62,6 +67,28 @@ func (c *Client) Close() error {
 
 // Devices implements wginternal.Client.
 func (c *Client) Devices() ([]*wgtypes.Device, error) {
+	ds, _, err := c.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// DevicesPartial implements wginternal.PartialClient.
+func (c *Client) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	ds, errs, err := c.devices()
+	if err != nil && errs == nil {
+		// Devices could not be enumerated at all.
+		return nil, nil, err
+	}
+
+	return ds, errs, nil
+}
+
+// devices fetches all devices concurrently. If errs is nil, err reports a
+// failure to enumerate devices. Otherwise, err is the first error in errs.
+func (c *Client) devices() (ds []*wgtypes.Device, errs map[string]error, err error) {
 	ifg := wgh.Ifgroupreq{
 		// Query for devices in the "wg" group.
 		Name: ifGroupWG,
@@ -69,7 +96,7 @@ func (c *Client) Devices() ([]*wgtypes.Device, error) {
 
 	// Determine how many device names we must allocate memory for.
 	if err := c.ioctlIfgroupreq(&ifg); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// ifg.Len is size in bytes; allocate enough memory for the correct number
@@ -86,24 +113,21 @@ func (c *Client) Devices() ([]*wgtypes.Device, error) {
 
 	// Now actually fetch the device names.
 	if err := c.ioctlIfgroupreq(&ifg); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Keep this alive until we're done doing the ioctl dance.
 	runtime.KeepAlive(&ifg)
 
-	devices := make([]*wgtypes.Device, 0, len(ifgrs))
+	names := make([]string, 0, len(ifgrs))
 	for _, ifgr := range ifgrs {
 		// Remove any trailing NULL bytes from the interface names.
-		d, err := c.Device(string(bytes.TrimRight(ifgr.Ifgrqu[:], "\x00")))
-		if err != nil {
-			return nil, err
-		}
-
-		devices = append(devices, d)
+		names = append(names, string(bytes.TrimRight(ifgr.Ifgrqu[:], "\x00")))
 	}
 
-	return devices, nil
+	return wginternal.FetchDevices(names, c.workers, func(i int) (*wgtypes.Device, error) {
+		return c.Device(names[i])
+	})
 }
 
 // Device implements wginternal.Client.
@@ -154,7 +178,19 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 		data.Interface = (*wgh.WGInterfaceIO)(unsafe.Pointer(&mem[0]))
 	}
 
-	return parseDevice(name, data.Interface)
+	d, err := parseDevice(name, data.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Index = wginternal.InterfaceIndex(name)
+
+	return d, nil
+}
+
+// DeviceByIndex implements wginternal.Client.
+func (c *Client) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	return wginternal.DeviceByIndex(c, index)
 }
 
 // parseDevice unpacks a Device from ifio, along with its associated peers
@@ -78,6 +78,8 @@ func TestClientDevices(t *testing.T) {
 	c := &Client{
 		ioctlIfgroupreq: ifgrFunc,
 		ioctlWGDataIO:   wgDataIOFunc,
+		// The ioctl hooks expect devices to be fetched in order.
+		workers: 1,
 	}
 
 	devices, err := c.Devices()
@@ -105,6 +107,67 @@ func TestClientDevices(t *testing.T) {
 	}
 }
 
+func TestClientDevicesPartial(t *testing.T) {
+	const (
+		n = 2
+
+		devA = "testwg0"
+		devB = "testwg1"
+	)
+
+	ifgrFunc := func(ifg *wgh.Ifgroupreq) error {
+		if ifg.Groups == nil {
+			ifg.Len = n * wgh.SizeofIfgreq
+			return nil
+		}
+
+		*(*[n]wgh.Ifgreq)(unsafe.Pointer(ifg.Groups)) = [n]wgh.Ifgreq{
+			{Ifgrqu: devName(devA)},
+			{Ifgrqu: devName(devB)},
+		}
+		return nil
+	}
+
+	// Only devA can be fetched.
+	wgDataIOFunc := func(data *wgh.WGDataIO) error {
+		if data.Name != devName(devA) {
+			return &os.SyscallError{Syscall: "ioctl", Err: unix.EPERM}
+		}
+
+		if data.Interface == nil {
+			data.Size = wgh.SizeofWGInterfaceIO
+		}
+		return nil
+	}
+
+	c := &Client{
+		ioctlIfgroupreq: ifgrFunc,
+		ioctlWGDataIO:   wgDataIOFunc,
+	}
+
+	if _, err := c.Devices(); !errors.Is(err, unix.EPERM) {
+		t.Fatalf("expected EPERM from Devices, but got: %v", err)
+	}
+
+	devices, errs, err := c.DevicesPartial()
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	want := []*wgtypes.Device{{
+		Name:  devA,
+		Type:  wgtypes.OpenBSDKernel,
+		Peers: []wgtypes.Peer{},
+	}}
+
+	if diff := cmp.Diff(want, devices); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+	if _, ok := errs[devB]; !ok || len(errs) != 1 {
+		t.Fatalf("expected an error only for %q, but got: %v", devB, errs)
+	}
+}
+
 func TestClientDeviceBasic(t *testing.T) {
 	// Fixed parameters for the test.
 	const device = "testwg0"
@@ -1,92 +1,231 @@
 package wguser
 
 import (
+	"bufio"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var _ wginternal.Client = &Client{}
+var _ wginternal.PartialClient = &Client{}
 
-// A Client provides access to userspace WireGuard device information.
+// A Client provides access to userspace WireGuard device information. A
+// Client is safe for concurrent use by multiple goroutines.
 type Client struct {
 	dial func(device string) (net.Conn, error)
 	find func() ([]string, error)
+
+	// pool is non-nil when connections and discovery results are reused
+	// between operations.
+	pool *pool
+
+	// workers is the number of devices fetched concurrently by Devices.
+	workers int
+
+	// trace, if not nil, receives a record of every exchange.
+	trace wgtypes.TraceFunc
+}
+
+// A Config specifies optional configuration for a Client. A nil Config
+// applies the default configuration.
+type Config struct {
+	// Pool specifies that connections to each device should be kept open
+	// and reused by subsequent operations, rather than dialing a new
+	// connection for every operation. Device discovery results are cached
+	// as well.
+	Pool bool
+
+	// DiscoveryTTL specifies how long device discovery results are cached
+	// when Pool is set. If zero, a default value is used.
+	DiscoveryTTL time.Duration
+
+	// Trace, if not nil, is called with a record of every request and
+	// response exchanged with a device.
+	Trace wgtypes.TraceFunc
+
+	// Workers specifies the maximum number of devices fetched concurrently
+	// when enumerating devices. If zero, GOMAXPROCS is used.
+	Workers int
 }
 
-// New creates a new Client.
-func New() (*Client, error) {
-	return &Client{
+// New creates a new Client using the optional configuration in cfg.
+func New(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	c := &Client{
 		// Operating system-specific functions which can identify and connect
 		// to userspace WireGuard devices. These functions can also be
 		// overridden for tests.
 		dial: dial,
 		find: find,
-	}, nil
+
+		workers: cfg.Workers,
+		trace:   cfg.Trace,
+	}
+
+	if cfg.Pool {
+		c.pool = newPool(cfg.DiscoveryTTL)
+	}
+
+	return c, nil
 }
 
 // Close implements wginternal.Client.
-func (c *Client) Close() error { return nil }
+func (c *Client) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+
+	return c.pool.Close()
+}
 
 // Devices implements wginternal.Client.
 func (c *Client) Devices() ([]*wgtypes.Device, error) {
-	devices, err := c.find()
+	ds, _, err := c.fetchDevices()
 	if err != nil {
 		return nil, err
 	}
 
-	wgds := make([]*wgtypes.Device, 0, len(devices))
-	for _, d := range devices {
-		wgd, err := c.getDevice(d)
-		if err != nil {
-			return nil, err
-		}
+	return ds, nil
+}
 
-		wgds = append(wgds, wgd)
+// DevicesPartial implements wginternal.PartialClient.
+func (c *Client) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	ds, errs, err := c.fetchDevices()
+	if err != nil && errs == nil {
+		// Devices could not be enumerated at all.
+		return nil, nil, err
 	}
 
-	return wgds, nil
+	return ds, errs, nil
 }
 
-// Device implements wginternal.Client.
-func (c *Client) Device(name string) (*wgtypes.Device, error) {
-	devices, err := c.find()
+// fetchDevices fetches all devices concurrently. If errs is nil, err reports
+// a failure to enumerate devices. Otherwise, err is the first error in errs.
+func (c *Client) fetchDevices() (ds []*wgtypes.Device, errs map[string]error, err error) {
+	devices, err := c.devices(false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	names := make([]string, 0, len(devices))
 	for _, d := range devices {
-		if name != deviceName(d) {
-			continue
-		}
+		names = append(names, deviceName(d))
+	}
+
+	return wginternal.FetchDevices(names, c.workers, func(i int) (*wgtypes.Device, error) {
+		return c.getDevice(devices[i])
+	})
+}
 
-		return c.getDevice(d)
+// Device implements wginternal.Client.
+func (c *Client) Device(name string) (*wgtypes.Device, error) {
+	device, err := c.lookup(name)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, os.ErrNotExist
+	return c.getDevice(device)
+}
+
+// DeviceByIndex implements wginternal.Client.
+func (c *Client) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	return wginternal.DeviceByIndex(c, index)
 }
 
 // ConfigureDevice implements wginternal.Client.
 func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
-	devices, err := c.find()
+	device, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	// The configuration protocol has no means of removing individual allowed
+	// IPs, so emulate it if necessary.
+	cfg, err = wginternal.RemoveAllowedIPs(cfg, func() (*wgtypes.Device, error) {
+		return c.getDevice(device)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.configureDevice(device, cfg)
+}
+
+// devices returns the paths of all userspace devices. When pooling is
+// enabled, cached results are returned unless refresh is set.
+func (c *Client) devices(refresh bool) ([]string, error) {
+	if c.pool == nil {
+		return c.find()
+	}
+
+	return c.pool.devices(c.find, refresh)
+}
+
+// lookup returns the path of the device specified by name.
+func (c *Client) lookup(name string) (string, error) {
+	devices, err := c.devices(false)
+	if err != nil {
+		return "", err
+	}
+
+	if d, ok := findDevice(devices, name); ok {
+		return d, nil
+	}
+
+	if c.pool == nil {
+		return "", os.ErrNotExist
+	}
+
+	// Cached discovery results may be stale, so try once more with fresh
+	// results before giving up.
+	devices, err = c.devices(true)
+	if err != nil {
+		return "", err
+	}
+
+	if d, ok := findDevice(devices, name); ok {
+		return d, nil
+	}
+
+	return "", os.ErrNotExist
+}
+
+// roundTrip performs a single request and response exchange with the device
+// specified by its path. fn must write a complete request and consume the
+// complete response. idempotent reports whether the request may be sent
+// again if its response could not be read.
+func (c *Client) roundTrip(device string, idempotent bool, fn func(rw *bufio.ReadWriter) error) error {
+	if c.pool != nil {
+		return c.pool.roundTrip(c.dial, device, idempotent, fn)
+	}
+
+	conn, err := c.dial(device)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	return fn(bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)))
+}
 
+// findDevice returns the path in devices which matches the device name.
+func findDevice(devices []string, name string) (string, bool) {
 	for _, d := range devices {
-		if name != deviceName(d) {
-			continue
+		if name == deviceName(d) {
+			return d, true
 		}
-
-		return c.configureDevice(d, cfg)
 	}
 
-	return os.ErrNotExist
+	return "", false
 }
 
 // deviceName infers a device name from an absolute file path with extension.
@@ -1,7 +1,10 @@
 package wguser
 
 import (
+	"bufio"
 	"errors"
+	"io"
+	"net"
 	"os"
 	"strings"
 	"sync"
@@ -59,6 +62,74 @@ func TestClientDevice(t *testing.T) {
 	}
 }
 
+func TestClientDevicesPartial(t *testing.T) {
+	// Serve each device over an in-memory pipe. wg1 is broken and reports
+	// an error for every request.
+	c := &Client{
+		find: func() ([]string, error) {
+			return []string{"/var/run/wireguard/wg0.sock", "/var/run/wireguard/wg1.sock", "/var/run/wireguard/wg2.sock"}, nil
+		},
+		dial: func(device string) (net.Conn, error) {
+			res := "listen_port=51820\nerrno=0\n\n"
+			if deviceName(device) == "wg1" {
+				res = "errno=1\n\n"
+			}
+
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+
+				// Consume the request before sending the response.
+				b := bufio.NewReader(server)
+				for {
+					line, err := b.ReadString('\n')
+					if err != nil || line == "\n" {
+						break
+					}
+				}
+
+				_, _ = io.WriteString(server, res)
+			}()
+
+			return client, nil
+		},
+		workers: 2,
+	}
+
+	ds, errs, err := c.DevicesPartial()
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	want := []*wgtypes.Device{
+		{
+			Name:       "wg0",
+			Type:       wgtypes.Userspace,
+			PublicKey:  wgtypes.Key{}.PublicKey(),
+			ListenPort: 51820,
+		},
+		{
+			Name:       "wg2",
+			Type:       wgtypes.Userspace,
+			PublicKey:  wgtypes.Key{}.PublicKey(),
+			ListenPort: 51820,
+		},
+	}
+
+	if diff := cmp.Diff(want, ds); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+
+	if len(errs) != 1 || errs["wg1"] == nil {
+		t.Fatalf("expected an error only for wg1, but got: %v", errs)
+	}
+
+	// Devices reports the error rather than partial results.
+	if _, err := c.Devices(); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
 func testClient(t *testing.T, res []byte) (*Client, func() []byte) {
 	t.Helper()
 
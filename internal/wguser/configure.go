@@ -1,24 +1,18 @@
 package wguser
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // configureDevice configures a device specified by its path.
 func (c *Client) configureDevice(device string, cfg wgtypes.Config) error {
-	conn, err := c.dial(device)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
 	// Start with set command.
 	var buf bytes.Buffer
 	buf.WriteString("set=1\n")
@@ -27,26 +21,44 @@ func (c *Client) configureDevice(device string, cfg wgtypes.Config) error {
 	writeConfig(&buf, cfg)
 	buf.WriteString("\n")
 
-	// Apply configuration for the device and then check the error number.
-	if _, err := io.Copy(conn, &buf); err != nil {
-		return err
-	}
+	tr := c.startTrace(device, "set")
+	tr.request(buf.Bytes())
 
-	res := make([]byte, 32)
-	n, err := conn.Read(res)
-	if err != nil {
-		return err
-	}
+	err := c.roundTrip(device, false, func(rw *bufio.ReadWriter) error {
+		tr.resetResponse()
 
-	// errno=0 indicates success, anything else returns an error number that
-	// matches definitions from errno.h.
-	str := strings.TrimSpace(string(res[:n]))
-	if str != "errno=0" {
-		// TODO(mdlayher): return actual errno on Linux?
-		return os.NewSyscallError("read", fmt.Errorf("wguser: %s", str))
-	}
+		// Apply configuration for the device and then check the error number.
+		if _, err := rw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		var res string
+		err := readPairs(rw.Reader, func(key, value string) {
+			tr.response(key, value)
+
+			if key == "errno" {
+				res = key + "=" + value
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		// errno=0 indicates success, anything else returns an error number
+		// that matches definitions from errno.h.
+		if res != "errno=0" {
+			// TODO(mdlayher): return actual errno on Linux?
+			return os.NewSyscallError("read", fmt.Errorf("wguser: %s", res))
+		}
+
+		return nil
+	})
+	tr.finish(err)
 
-	return nil
+	return err
 }
 
 // writeConfig writes textual configuration to w as specified by cfg.
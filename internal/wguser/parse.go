@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"time"
 
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wginternal"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -20,55 +21,94 @@ import (
 // getDevice gathers device information from a device specified by its path
 // and returns a Device.
 func (c *Client) getDevice(device string) (*wgtypes.Device, error) {
-	conn, err := c.dial(device)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+	const req = "get=1\n\n"
 
-	// Get information about this device.
-	if _, err := io.WriteString(conn, "get=1\n\n"); err != nil {
-		return nil, err
-	}
+	tr := c.startTrace(device, "get")
+	tr.request([]byte(req))
+
+	var d *wgtypes.Device
+	err := c.roundTrip(device, true, func(rw *bufio.ReadWriter) error {
+		tr.resetResponse()
 
-	// Parse the device from the incoming data stream.
-	d, err := parseDevice(conn)
+		// Get information about this device.
+		if _, err := rw.WriteString(req); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		// Parse the device from the incoming data stream.
+		var err error
+		d, err = parseDevice(rw.Reader, tr.response)
+		return err
+	})
+	tr.finish(err)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO(mdlayher): populate interface index too?
 	d.Name = deviceName(device)
+	d.Index = wginternal.InterfaceIndex(d.Name)
 	d.Type = wgtypes.Userspace
 
 	return d, nil
 }
 
-// parseDevice parses a Device and its Peers from an io.Reader.
-func parseDevice(r io.Reader) (*wgtypes.Device, error) {
+// parseDevice parses a Device and its Peers from a bufio.Reader. If trace is
+// not nil, it is called for each key=value pair as well.
+func parseDevice(r *bufio.Reader, trace func(key, value string)) (*wgtypes.Device, error) {
 	var dp deviceParser
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		b := s.Bytes()
+	err := readPairs(r, func(key, value string) {
+		if trace != nil {
+			trace(key, value)
+		}
+
+		dp.Parse(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dp.Device()
+}
+
+// readPairs reads key=value pairs from r and passes them to fn until an empty
+// line or the end of the stream is reached. readPairs consumes no data beyond
+// the empty line, so r may continue to be used for further exchanges.
+func readPairs(r *bufio.Reader, fn func(key, value string)) error {
+	var n int
+	for {
+		b, err := r.ReadBytes('\n')
+		switch {
+		case err == io.EOF && len(b) == 0 && n == 0:
+			// The connection was closed before any response was sent.
+			return io.ErrUnexpectedEOF
+		case err == io.EOF && len(b) == 0:
+			return nil
+		case err != nil && err != io.EOF:
+			return err
+		}
+
+		b = bytes.TrimSuffix(b, []byte("\n"))
 		if len(b) == 0 {
 			// Empty line, done parsing.
-			break
+			return nil
 		}
 
 		// All data is in key=value format.
 		kvs := bytes.Split(b, []byte("="))
 		if len(kvs) != 2 {
-			return nil, fmt.Errorf("wguser: invalid key=value pair: %q", string(b))
+			return fmt.Errorf("wguser: invalid key=value pair: %q", string(b))
 		}
 
-		dp.Parse(string(kvs[0]), string(kvs[1]))
-	}
+		fn(string(kvs[0]), string(kvs[1]))
+		n++
 
-	if err := s.Err(); err != nil {
-		return nil, err
+		if err == io.EOF {
+			return nil
+		}
 	}
-
-	return dp.Device()
 }
 
 // A deviceParser accumulates information about a Device and its Peers.
@@ -0,0 +1,231 @@
+package wguser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultDiscoveryTTL is the default amount of time that device discovery
+// results are cached by a pool.
+const defaultDiscoveryTTL = 5 * time.Second
+
+// A pool keeps userspace configuration protocol connections open so they can
+// be reused by subsequent operations, and caches device discovery results.
+//
+// The configuration protocol permits any number of get and set operations
+// over a single connection, so there is no need to dial a new connection for
+// every operation.
+type pool struct {
+	ttl time.Duration
+	now func() time.Time
+
+	// mu protects the cached discovery results.
+	mu      sync.Mutex
+	paths   []string
+	expires time.Time
+
+	// cmu protects conns. Each poolConn has its own lock so that operations
+	// on different devices are not serialized.
+	cmu   sync.Mutex
+	conns map[string]*poolConn
+}
+
+// A poolConn is a pooled connection to a single device.
+type poolConn struct {
+	mu sync.Mutex
+	c  net.Conn
+	w  *failWriter
+	rw *bufio.ReadWriter
+
+	// removed is set when the poolConn is no longer in pool.conns, so that
+	// it must not be used to dial a new connection.
+	removed bool
+}
+
+// A failWriter is an io.Writer which records whether a write failed.
+type failWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+// Write implements io.Writer.
+func (w *failWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err != nil {
+		w.failed = true
+	}
+
+	return n, err
+}
+
+// newPool creates a pool which caches discovery results for ttl.
+func newPool(ttl time.Duration) *pool {
+	if ttl <= 0 {
+		ttl = defaultDiscoveryTTL
+	}
+
+	return &pool{
+		ttl:   ttl,
+		now:   time.Now,
+		conns: make(map[string]*poolConn),
+	}
+}
+
+// Close closes all pooled connections.
+func (p *pool) Close() error {
+	p.cmu.Lock()
+	conns := p.conns
+	p.conns = make(map[string]*poolConn)
+	p.cmu.Unlock()
+
+	var err error
+	for _, pc := range conns {
+		pc.mu.Lock()
+		if pc.c != nil {
+			if cerr := pc.c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			pc.c, pc.w, pc.rw = nil, nil, nil
+		}
+		pc.removed = true
+		pc.mu.Unlock()
+	}
+
+	return err
+}
+
+// devices returns cached device paths, or calls find to populate the cache
+// if the cached results have expired or refresh is set.
+func (p *pool) devices(find func() ([]string, error), refresh bool) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !refresh && p.paths != nil && p.now().Before(p.expires) {
+		return p.paths, nil
+	}
+
+	paths, err := find()
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		// Distinguish "no devices" from "not yet cached".
+		paths = []string{}
+	}
+
+	p.paths = paths
+	p.expires = p.now().Add(p.ttl)
+
+	return paths, nil
+}
+
+// invalidate discards any cached discovery results.
+func (p *pool) invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paths = nil
+}
+
+// roundTrip performs an exchange with the device at path using a pooled
+// connection, dialing a new one if necessary.
+//
+// If an existing connection fails due to an I/O error, such as when the
+// userspace process was restarted, the connection is discarded and the
+// exchange is retried once on a freshly dialed connection. Exchanges which
+// are not idempotent are only retried if the request could not be written,
+// because the device may otherwise have applied it already.
+func (p *pool) roundTrip(dial func(string) (net.Conn, error), path string, idempotent bool, fn func(rw *bufio.ReadWriter) error) error {
+	pc := p.conn(path)
+	defer pc.mu.Unlock()
+
+	// Connections to devices which disappear are never dialed again, so
+	// don't keep their entries.
+	defer func() {
+		if pc.c == nil {
+			p.remove(path, pc)
+		}
+	}()
+
+	reused := pc.c != nil
+	for {
+		if pc.c == nil {
+			c, err := dial(path)
+			if err != nil {
+				// The device may have disappeared; make sure the next
+				// lookup observes the current state of the system.
+				p.invalidate()
+				return err
+			}
+
+			pc.c = c
+			pc.w = &failWriter{w: c}
+			pc.rw = bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(pc.w))
+		}
+
+		pc.w.failed = false
+		err := fn(pc.rw)
+		if err == nil {
+			return nil
+		}
+
+		// The connection is in an unknown state after any failure, so never
+		// reuse it.
+		unsent := pc.w.failed
+		_ = pc.c.Close()
+		pc.c, pc.w, pc.rw = nil, nil, nil
+
+		if !reused || !isConnError(err) || (!idempotent && !unsent) {
+			return err
+		}
+
+		// Only retry once.
+		reused = false
+	}
+}
+
+// conn returns the poolConn for path, creating it if necessary, with its
+// lock held.
+func (p *pool) conn(path string) *poolConn {
+	for {
+		p.cmu.Lock()
+		pc, ok := p.conns[path]
+		if !ok {
+			pc = &poolConn{}
+			p.conns[path] = pc
+		}
+		p.cmu.Unlock()
+
+		pc.mu.Lock()
+		if !pc.removed {
+			return pc
+		}
+
+		// pc was removed while waiting for its lock.
+		pc.mu.Unlock()
+	}
+}
+
+// remove removes pc, whose lock must be held, from the pool.
+func (p *pool) remove(path string, pc *poolConn) {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	if p.conns[path] == pc {
+		delete(p.conns, path)
+	}
+	pc.removed = true
+}
+
+// isConnError reports whether err indicates a broken connection rather than
+// an error reported by the device.
+func isConnError(err error) bool {
+	var nerr net.Error
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &nerr)
+}
@@ -0,0 +1,370 @@
+package wguser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestClientPoolReusesConnections(t *testing.T) {
+	c, s, done := testPoolClient(t)
+	defer done()
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Device(testDevice); err != nil {
+			t.Fatalf("failed to get device: %v", err)
+		}
+
+		if err := c.ConfigureDevice(testDevice, wgtypes.Config{}); err != nil {
+			t.Fatalf("failed to configure device: %v", err)
+		}
+	}
+
+	if diff := cmp.Diff(1, s.Accepts()); diff != "" {
+		t.Fatalf("unexpected number of connections (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(10, s.Requests()); diff != "" {
+		t.Fatalf("unexpected number of requests (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPoolReconnects(t *testing.T) {
+	c, s, done := testPoolClient(t)
+	defer done()
+
+	if _, err := c.Device(testDevice); err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	// Simulate a restart of the userspace process by closing all of its
+	// connections. The next operation must transparently dial again.
+	s.Drop()
+
+	if _, err := c.Device(testDevice); err != nil {
+		t.Fatalf("failed to get device after reconnect: %v", err)
+	}
+
+	if diff := cmp.Diff(2, s.Accepts()); diff != "" {
+		t.Fatalf("unexpected number of connections (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPoolRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     string
+		set      bool
+		ok       bool
+		requests int
+	}{
+		{
+			// The request cannot be written to the dropped connection, so
+			// it is sent again.
+			name:     "set unsent",
+			set:      true,
+			ok:       true,
+			requests: 2,
+		},
+		{
+			name:     "get no response",
+			drop:     "get=1\n",
+			ok:       true,
+			requests: 3,
+		},
+		{
+			// The device may have applied the configuration, so it must
+			// not be sent again.
+			name:     "set no response",
+			drop:     "set=1\n",
+			set:      true,
+			requests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, s, done := testPoolClient(t)
+			defer done()
+
+			if _, err := c.Device(testDevice); err != nil {
+				t.Fatalf("failed to get device: %v", err)
+			}
+
+			if tt.drop == "" {
+				s.Drop()
+			} else {
+				s.DropAfter(tt.drop)
+			}
+
+			var err error
+			if tt.set {
+				err = c.ConfigureDevice(testDevice, wgtypes.Config{})
+			} else {
+				_, err = c.Device(testDevice)
+			}
+			if tt.ok && err != nil {
+				t.Fatalf("failed to perform operation: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if diff := cmp.Diff(tt.requests, s.Requests()); diff != "" {
+				t.Fatalf("unexpected number of requests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientPoolRemovesConnections(t *testing.T) {
+	c, _, done := testPoolClient(t)
+	defer done()
+
+	if _, err := c.Device(testDevice); err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	// The device disappears, so its connection fails and it cannot be
+	// dialed again.
+	err := c.pool.roundTrip(func(string) (net.Conn, error) {
+		return nil, errors.New("device gone")
+	}, "wgnotexist0", true, func(*bufio.ReadWriter) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	if _, ok := c.pool.conns["wgnotexist0"]; ok {
+		t.Fatal("connection to a device which disappeared was not removed")
+	}
+	if diff := cmp.Diff(1, len(c.pool.conns)); diff != "" {
+		t.Fatalf("unexpected number of pooled connections (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPoolCachesDiscovery(t *testing.T) {
+	c, _, done := testPoolClient(t)
+	defer done()
+
+	var finds int
+	find := c.find
+	c.find = func() ([]string, error) {
+		finds++
+		return find()
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Devices(); err != nil {
+			t.Fatalf("failed to get devices: %v", err)
+		}
+	}
+
+	if diff := cmp.Diff(1, finds); diff != "" {
+		t.Fatalf("unexpected number of discovery calls (-want +got):\n%s", diff)
+	}
+
+	// A miss for an unknown device must refresh the cached results.
+	if _, err := c.Device("wgnotexist0"); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	if diff := cmp.Diff(2, finds); diff != "" {
+		t.Fatalf("unexpected number of discovery calls (-want +got):\n%s", diff)
+	}
+
+	// Expired results must be refreshed as well.
+	c.pool.now = func() time.Time { return time.Now().Add(time.Hour) }
+
+	if _, err := c.Devices(); err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	if diff := cmp.Diff(3, finds); diff != "" {
+		t.Fatalf("unexpected number of discovery calls (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPoolConcurrent(t *testing.T) {
+	c, s, done := testPoolClient(t)
+	defer done()
+
+	const n = 8
+
+	var wg sync.WaitGroup
+	errC := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				d, err := c.Device(testDevice)
+				if err != nil {
+					errC <- err
+					return
+				}
+				if d.ListenPort != 51820 {
+					errC <- fmt.Errorf("unexpected listen port: %d", d.ListenPort)
+					return
+				}
+
+				if err := c.ConfigureDevice(testDevice, wgtypes.Config{}); err != nil {
+					errC <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errC)
+
+	for err := range errC {
+		t.Fatalf("failed to perform concurrent operations: %v", err)
+	}
+
+	// Operations on a single device share one connection.
+	if diff := cmp.Diff(1, s.Accepts()); diff != "" {
+		t.Fatalf("unexpected number of connections (-want +got):\n%s", diff)
+	}
+}
+
+// A poolServer is a userspace device which serves any number of requests
+// on each of its connections.
+type poolServer struct {
+	mu       sync.Mutex
+	conns    []net.Conn
+	accepts  int
+	requests int
+	drop     string
+}
+
+func (s *poolServer) Accepts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepts
+}
+
+func (s *poolServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Drop closes all open connections.
+func (s *poolServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+// DropAfter closes the connection which receives the next request of
+// operation op, such as "set=1\n", without a response.
+func (s *poolServer) DropAfter(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop = op
+}
+
+func (s *poolServer) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed") {
+				return
+			}
+
+			panicf("failed to accept connection: %v", err)
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.accepts++
+		s.mu.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *poolServer) handle(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	for {
+		// Consume a complete request, terminated by an empty line.
+		var op string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\n" {
+				break
+			}
+			if op == "" {
+				op = line
+			}
+		}
+
+		s.mu.Lock()
+		s.requests++
+		drop := op == s.drop
+		if drop {
+			s.drop = ""
+		}
+		s.mu.Unlock()
+
+		if drop {
+			return
+		}
+
+		res := "errno=0\n\n"
+		if op == "get=1\n" {
+			res = "listen_port=51820\nerrno=0\n\n"
+		}
+
+		if _, err := c.Write([]byte(res)); err != nil {
+			return
+		}
+	}
+}
+
+func testPoolClient(t *testing.T) (*Client, *poolServer, func()) {
+	t.Helper()
+
+	l, dir, done := testListen(t, testDevice)
+
+	var s poolServer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.serve(l)
+	}()
+
+	c := &Client{
+		find: testFind(dir),
+		dial: dial,
+		pool: newPool(0),
+	}
+
+	return c, &s, func() {
+		_ = c.Close()
+		done()
+		wg.Wait()
+		s.Drop()
+	}
+}
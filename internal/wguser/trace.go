@@ -0,0 +1,95 @@
+package wguser
+
+import (
+	"bytes"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A tracer accumulates a wgtypes.Trace for a single exchange with a device.
+// All methods are no-ops on a nil *tracer so that callers need not check
+// whether tracing is enabled.
+type tracer struct {
+	fn wgtypes.TraceFunc
+	t  wgtypes.Trace
+}
+
+// startTrace begins tracing an operation on device, or returns nil if
+// tracing is not enabled.
+func (c *Client) startTrace(device, op string) *tracer {
+	if c.trace == nil {
+		return nil
+	}
+
+	return &tracer{
+		fn: c.trace,
+		t: wgtypes.Trace{
+			Time:     time.Now(),
+			Protocol: "uapi",
+			Device:   deviceName(device),
+			Op:       op,
+		},
+	}
+}
+
+// request records the key=value pairs of a raw request.
+func (tr *tracer) request(b []byte) {
+	if tr == nil {
+		return
+	}
+
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		kvs := bytes.SplitN(line, []byte("="), 2)
+		if len(kvs) != 2 {
+			continue
+		}
+
+		tr.t.Request = append(tr.t.Request, traceField(string(kvs[0]), string(kvs[1])))
+	}
+}
+
+// resetResponse discards any response fields, such as when an exchange is
+// retried.
+func (tr *tracer) resetResponse() {
+	if tr == nil {
+		return
+	}
+
+	tr.t.Response = nil
+}
+
+// response records a single key=value pair of a response.
+func (tr *tracer) response(key, value string) {
+	if tr == nil {
+		return
+	}
+
+	tr.t.Response = append(tr.t.Response, traceField(key, value))
+}
+
+// finish completes the trace with the result of the exchange and passes it
+// to the trace function.
+func (tr *tracer) finish(err error) {
+	if tr == nil {
+		return
+	}
+
+	tr.t.Duration = time.Since(tr.t.Time)
+	if err != nil {
+		tr.t.Error = err.Error()
+	}
+
+	tr.fn(tr.t)
+}
+
+// traceField produces a wgtypes.TraceField from a key=value pair, redacting
+// any secret values.
+func traceField(key, value string) wgtypes.TraceField {
+	switch key {
+	case "private_key", "preshared_key":
+		value = wgtypes.Redacted
+	}
+
+	return wgtypes.TraceField{Key: key, Value: value}
+}
@@ -0,0 +1,92 @@
+package wguser
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestClientTrace(t *testing.T) {
+	tests := []struct {
+		name string
+		res  []byte
+		fn   func(c *Client) error
+		want wgtypes.Trace
+	}{
+		{
+			name: "get",
+			res: []byte(`private_key=e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a
+listen_port=12912
+public_key=b85996fecc9c7f1fc6d2572a76eda11d59bcd20be8e543b15ce4bd85a8e75a33
+preshared_key=188515093e952f5f22e865cef3012e72f8b5f0b598ac0309d5dacce3b70fcf52
+errno=0
+
+`),
+			fn: func(c *Client) error {
+				_, err := c.Device(testDevice)
+				return err
+			},
+			want: wgtypes.Trace{
+				Protocol: "uapi",
+				Device:   testDevice,
+				Op:       "get",
+				Request:  []wgtypes.TraceField{{Key: "get", Value: "1"}},
+				Response: []wgtypes.TraceField{
+					{Key: "private_key", Value: wgtypes.Redacted},
+					{Key: "listen_port", Value: "12912"},
+					{Key: "public_key", Value: "b85996fecc9c7f1fc6d2572a76eda11d59bcd20be8e543b15ce4bd85a8e75a33"},
+					{Key: "preshared_key", Value: wgtypes.Redacted},
+					{Key: "errno", Value: "0"},
+				},
+			},
+		},
+		{
+			name: "set",
+			res:  []byte("errno=1\n\n"),
+			fn: func(c *Client) error {
+				return c.ConfigureDevice(testDevice, wgtypes.Config{
+					PrivateKey: keyPtr(wgtest.MustHexKey("e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a")),
+					Peers: []wgtypes.PeerConfig{{
+						PublicKey:    wgtest.MustHexKey("b85996fecc9c7f1fc6d2572a76eda11d59bcd20be8e543b15ce4bd85a8e75a33"),
+						PresharedKey: keyPtr(wgtest.MustHexKey("188515093e952f5f22e865cef3012e72f8b5f0b598ac0309d5dacce3b70fcf52")),
+					}},
+				})
+			},
+			want: wgtypes.Trace{
+				Protocol: "uapi",
+				Device:   testDevice,
+				Op:       "set",
+				Request: []wgtypes.TraceField{
+					{Key: "set", Value: "1"},
+					{Key: "private_key", Value: wgtypes.Redacted},
+					{Key: "public_key", Value: "b85996fecc9c7f1fc6d2572a76eda11d59bcd20be8e543b15ce4bd85a8e75a33"},
+					{Key: "preshared_key", Value: wgtypes.Redacted},
+				},
+				Response: []wgtypes.TraceField{{Key: "errno", Value: "1"}},
+				Error:    "read: wguser: errno=1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, done := testClient(t, tt.res)
+			defer done()
+
+			var traces []wgtypes.Trace
+			c.trace = func(t wgtypes.Trace) {
+				traces = append(traces, t)
+			}
+
+			_ = tt.fn(c)
+
+			opts := cmpopts.IgnoreFields(wgtypes.Trace{}, "Time", "Duration")
+			if diff := cmp.Diff([]wgtypes.Trace{tt.want}, traces, opts); diff != "" {
+				t.Fatalf("unexpected traces (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
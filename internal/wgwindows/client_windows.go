@@ -3,6 +3,7 @@ package wgwindows
 import (
 	"net"
 	"os"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -13,10 +14,16 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var _ wginternal.Client = &Client{}
+var _ wginternal.PartialClient = &Client{}
 
-// A Client provides access to WireGuardNT ioctl information.
+// A Client provides access to WireGuardNT ioctl information. A Client is
+// safe for concurrent use by multiple goroutines.
 type Client struct {
+	// workers is the number of devices fetched concurrently by Devices.
+	workers int
+
+	// mu protects the following fields.
+	mu               sync.Mutex
 	cachedInterfaces map[string]*uint16
 	lastLenGuess     uint32
 }
@@ -80,21 +87,30 @@ func (c *Client) refreshInterfaceCache() error {
 		}
 		cachedInterfaces[adapterName] = interface16
 	}
+	c.mu.Lock()
 	c.cachedInterfaces = cachedInterfaces
+	c.mu.Unlock()
 	return nil
 }
 
+func (c *Client) cachedInterface(name string) (*uint16, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileName, ok := c.cachedInterfaces[name]
+	return fileName, ok
+}
+
 func (c *Client) interfaceHandle(name string) (handle windows.Handle, err error) {
 	hasRefreshed := false
 	for !hasRefreshed {
-		fileName, ok := c.cachedInterfaces[name]
+		fileName, ok := c.cachedInterface(name)
 		if !ok {
 			err := c.refreshInterfaceCache()
 			if err != nil {
 				return 0, err
 			}
 			hasRefreshed = true
-			fileName, ok = c.cachedInterfaces[name]
+			fileName, ok = c.cachedInterface(name)
 			if !ok {
 				return 0, os.ErrNotExist
 			}
@@ -112,24 +128,47 @@ func (c *Client) interfaceHandle(name string) (handle windows.Handle, err error)
 
 // Devices implements wginternal.Client.
 func (c *Client) Devices() ([]*wgtypes.Device, error) {
-	err := c.refreshInterfaceCache()
+	ds, _, err := c.devices()
 	if err != nil {
 		return nil, err
 	}
-	ds := make([]*wgtypes.Device, 0, len(c.cachedInterfaces))
+
+	return ds, nil
+}
+
+// DevicesPartial implements wginternal.PartialClient.
+func (c *Client) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	ds, errs, err := c.devices()
+	if err != nil && errs == nil {
+		// Devices could not be enumerated at all.
+		return nil, nil, err
+	}
+
+	return ds, errs, nil
+}
+
+// devices fetches all devices concurrently. If errs is nil, err reports a
+// failure to enumerate devices. Otherwise, err is the first error in errs.
+func (c *Client) devices() (ds []*wgtypes.Device, errs map[string]error, err error) {
+	err = c.refreshInterfaceCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	names := make([]string, 0, len(c.cachedInterfaces))
 	for name := range c.cachedInterfaces {
-		d, err := c.Device(name)
-		if err != nil {
-			return nil, err
-		}
-		ds = append(ds, d)
+		names = append(names, name)
 	}
-	return ds, nil
+	c.mu.Unlock()
+	return wginternal.FetchDevices(names, c.workers, func(i int) (*wgtypes.Device, error) {
+		return c.Device(names[i])
+	})
 }
 
-// New creates a new Client
-func New() *Client {
-	return &Client{}
+// New creates a new Client. Up to workers devices are fetched concurrently
+// when enumerating devices; if workers is zero, GOMAXPROCS is used.
+func New(workers int) *Client {
+	return &Client{workers: workers}
 }
 
 // Close implements wginternal.Client.
@@ -145,7 +184,9 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 	}
 	defer windows.CloseHandle(handle)
 
+	c.mu.Lock()
 	size := c.lastLenGuess
+	c.mu.Unlock()
 	if size == 0 {
 		size = 512
 	}
@@ -161,10 +202,16 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 		}
 		break
 	}
+	c.mu.Lock()
 	c.lastLenGuess = size
+	c.mu.Unlock()
 	interfaze := (*ioctl.Interface)(unsafe.Pointer(&buf[0]))
 
-	device := wgtypes.Device{Type: wgtypes.WindowsKernel, Name: name}
+	device := wgtypes.Device{
+		Type:  wgtypes.WindowsKernel,
+		Name:  name,
+		Index: wginternal.InterfaceIndex(name),
+	}
 	if interfaze.Flags&ioctl.InterfaceHasPrivateKey != 0 {
 		device.PrivateKey = interfaze.PrivateKey
 	}
@@ -228,8 +275,22 @@ func (c *Client) Device(name string) (*wgtypes.Device, error) {
 	return &device, nil
 }
 
+// DeviceByIndex implements wginternal.Client.
+func (c *Client) DeviceByIndex(index int) (*wgtypes.Device, error) {
+	return wginternal.DeviceByIndex(c, index)
+}
+
 // ConfigureDevice implements wginternal.Client.
 func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	// The ioctl interface has no means of removing individual allowed IPs, so
+	// emulate it if necessary.
+	cfg, err := wginternal.RemoveAllowedIPs(cfg, func() (*wgtypes.Device, error) {
+		return c.Device(name)
+	})
+	if err != nil {
+		return err
+	}
+
 	handle, err := c.interfaceHandle(name)
 	if err != nil {
 		return err
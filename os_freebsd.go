@@ -10,12 +10,12 @@ import (
 )
 
 // newClients configures wginternal.Clients for FreeBSD systems.
-func newClients() ([]wginternal.Client, error) {
+func newClients(opts *Options) ([]wginternal.Client, error) {
 	var clients []wginternal.Client
 
 	// FreeBSD has an in-kernel WireGuard implementation. Determine if it is
 	// available and make use of it if so.
-	kc, ok, err := wgfreebsd.New()
+	kc, ok, err := wgfreebsd.New(opts.Workers)
 	if err != nil {
 		return nil, err
 	}
@@ -23,7 +23,7 @@ func newClients() ([]wginternal.Client, error) {
 		clients = append(clients, kc)
 	}
 
-	uc, err := wguser.New()
+	uc, err := wguser.New(opts.userspaceConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -10,12 +10,17 @@ import (
 )
 
 // newClients configures wginternal.Clients for Linux systems.
-func newClients() ([]wginternal.Client, error) {
+func newClients(opts *Options) ([]wginternal.Client, error) {
 	var clients []wginternal.Client
 
 	// Linux has an in-kernel WireGuard implementation. Determine if it is
 	// available and make use of it if so.
-	kc, ok, err := wglinux.New()
+	kc, ok, err := wglinux.New(&wglinux.Config{
+		Trace:    opts.Trace,
+		MaxConns: opts.MaxNetlinkConns,
+		LinkInfo: opts.LinkInfo,
+		Workers:  opts.Workers,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -25,7 +30,7 @@ func newClients() ([]wginternal.Client, error) {
 
 	// Although it isn't recommended to use userspace implementations on Linux,
 	// it can be used. We make use of it in integration tests as well.
-	uc, err := wguser.New()
+	uc, err := wguser.New(opts.userspaceConfig())
 	if err != nil {
 		return nil, err
 	}
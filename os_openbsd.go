@@ -10,12 +10,12 @@ import (
 )
 
 // newClients configures wginternal.Clients for OpenBSD systems.
-func newClients() ([]wginternal.Client, error) {
+func newClients(opts *Options) ([]wginternal.Client, error) {
 	var clients []wginternal.Client
 
 	// OpenBSD has an in-kernel WireGuard implementation. Determine if it is
 	// available and make use of it if so.
-	kc, ok, err := wgopenbsd.New()
+	kc, ok, err := wgopenbsd.New(opts.Workers)
 	if err != nil {
 		return nil, err
 	}
@@ -23,7 +23,7 @@ func newClients() ([]wginternal.Client, error) {
 		clients = append(clients, kc)
 	}
 
-	uc, err := wguser.New()
+	uc, err := wguser.New(opts.userspaceConfig())
 	if err != nil {
 		return nil, err
 	}
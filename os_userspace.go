@@ -10,8 +10,8 @@ import (
 
 // newClients configures wginternal.Clients for systems which only support
 // userspace WireGuard implementations.
-func newClients() ([]wginternal.Client, error) {
-	c, err := wguser.New()
+func newClients(opts *Options) ([]wginternal.Client, error) {
+	c, err := wguser.New(opts.userspaceConfig())
 	if err != nil {
 		return nil, err
 	}
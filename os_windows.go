@@ -10,14 +10,14 @@ import (
 )
 
 // newClients configures wginternal.Clients for Windows systems.
-func newClients() ([]wginternal.Client, error) {
+func newClients(opts *Options) ([]wginternal.Client, error) {
 	var clients []wginternal.Client
 
 	// Windows has an in-kernel WireGuard implementation.
-	kc := wgwindows.New()
+	kc := wgwindows.New(opts.Workers)
 	clients = append(clients, kc)
 
-	uc, err := wguser.New()
+	uc, err := wguser.New(opts.userspaceConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,204 @@
+package wgconf
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Drift describes the differences between a running device and a
+// configuration, as returned by Diff.
+type Drift struct {
+	// Fields are the device settings which differ.
+	Fields []FieldDrift
+
+	// Missing are the peers which are configured but not present on the
+	// device, in configuration order.
+	Missing []wgtypes.PeerConfig
+
+	// Extra are the peers which are present on the device but not
+	// configured, in device order.
+	Extra []wgtypes.Peer
+
+	// Changed are the peers which are present on the device and
+	// configured, but whose settings differ, in configuration order.
+	Changed []PeerDrift
+}
+
+// Empty reports whether the device matches the configuration.
+func (d *Drift) Empty() bool {
+	return len(d.Fields) == 0 && len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// A PeerDrift describes the settings of a peer which differ.
+type PeerDrift struct {
+	PublicKey wgtypes.Key
+	Fields    []FieldDrift
+}
+
+// A FieldDrift describes a setting which differs between a device and a
+// configuration, with both values formatted as by wg(8) show. Private and
+// preshared keys are never included; their values are "(hidden)" if set,
+// "(none)" if not set, or "(different)" for a configured key which is set
+// on the device to another value.
+type FieldDrift struct {
+	// Name is the name of the setting as shown by wg(8), such as
+	// "endpoint" or "allowed ips".
+	Name string
+
+	Device, Config string
+}
+
+// Diff reports the differences between device d and cfg, as returned by
+// Parse. Diff reports the changes which Sync would make: settings which cfg
+// leaves unspecified must be unset on d, except for the listening port and
+// peer endpoints, which are only compared if they are specified. Allowed IPs
+// are compared as sets, regardless of order.
+func Diff(d *wgtypes.Device, cfg *wgtypes.Config) *Drift {
+	var out Drift
+
+	if f, ok := diffKey("private key", d.PrivateKey, keyOrZero(cfg.PrivateKey)); ok {
+		out.Fields = append(out.Fields, f)
+	}
+	if cfg.ListenPort != nil && *cfg.ListenPort != d.ListenPort {
+		out.Fields = append(out.Fields, FieldDrift{
+			Name:   "listening port",
+			Device: strconv.Itoa(d.ListenPort),
+			Config: strconv.Itoa(*cfg.ListenPort),
+		})
+	}
+	if mark := intOrZero(cfg.FirewallMark); mark != d.FirewallMark {
+		out.Fields = append(out.Fields, FieldDrift{
+			Name:   "fwmark",
+			Device: fwmark(d.FirewallMark),
+			Config: fwmark(mark),
+		})
+	}
+
+	current := make(map[wgtypes.Key]*wgtypes.Peer, len(d.Peers))
+	for i := range d.Peers {
+		current[d.Peers[i].PublicKey] = &d.Peers[i]
+	}
+
+	want := mergePeers(cfg.Peers)
+	keep := make(map[wgtypes.Key]bool, len(want))
+	for _, pc := range want {
+		keep[pc.PublicKey] = true
+
+		p, ok := current[pc.PublicKey]
+		if !ok {
+			out.Missing = append(out.Missing, pc)
+			continue
+		}
+
+		if fs := diffPeer(p, pc); len(fs) > 0 {
+			out.Changed = append(out.Changed, PeerDrift{
+				PublicKey: p.PublicKey,
+				Fields:    fs,
+			})
+		}
+	}
+
+	for _, p := range d.Peers {
+		if !keep[p.PublicKey] {
+			out.Extra = append(out.Extra, p)
+		}
+	}
+
+	return &out
+}
+
+// diffPeer returns the settings of p which differ from pc, in the same order
+// as wg(8) show.
+func diffPeer(p *wgtypes.Peer, pc wgtypes.PeerConfig) []FieldDrift {
+	var (
+		fs []FieldDrift
+		f  wgfmt.Formatter
+	)
+
+	if fd, ok := diffKey("preshared key", p.PresharedKey, keyOrZero(pc.PresharedKey)); ok {
+		fs = append(fs, fd)
+	}
+	if pc.Endpoint != nil && (p.Endpoint == nil || !pc.Endpoint.IP.Equal(p.Endpoint.IP) || pc.Endpoint.Port != p.Endpoint.Port) {
+		fs = append(fs, FieldDrift{
+			Name:   "endpoint",
+			Device: endpoint(p.Endpoint),
+			Config: endpoint(pc.Endpoint),
+		})
+	}
+	if !sameAllowedIPs(p, pc) {
+		fs = append(fs, FieldDrift{
+			Name:   "allowed ips",
+			Device: allowedIPs(p.AllowedIPs),
+			Config: allowedIPs(pc.AllowedIPs),
+		})
+	}
+	if ka := durationOrZero(pc.PersistentKeepaliveInterval); ka != p.PersistentKeepaliveInterval {
+		fs = append(fs, FieldDrift{
+			Name:   "persistent keepalive",
+			Device: f.Keepalive(p.PersistentKeepaliveInterval),
+			Config: f.Keepalive(ka),
+		})
+	}
+
+	return fs
+}
+
+// diffKey compares a secret key on a device with a configured key without
+// revealing either, and reports whether they differ.
+func diffKey(name string, device, config wgtypes.Key) (FieldDrift, bool) {
+	if device == config {
+		return FieldDrift{}, false
+	}
+
+	hidden := func(k wgtypes.Key) string {
+		if k == (wgtypes.Key{}) {
+			return "(none)"
+		}
+
+		return "(hidden)"
+	}
+
+	f := FieldDrift{
+		Name:   name,
+		Device: hidden(device),
+		Config: hidden(config),
+	}
+	if f.Device == f.Config {
+		f.Config = "(different)"
+	}
+
+	return f, true
+}
+
+func endpoint(addr *net.UDPAddr) string {
+	if addr == nil {
+		return "(none)"
+	}
+
+	return wgfmt.Endpoint(addr)
+}
+
+func allowedIPs(ipns []net.IPNet) string {
+	if len(ipns) == 0 {
+		return "(none)"
+	}
+
+	ss := make([]string, 0, len(ipns))
+	for _, ipn := range ipns {
+		ss = append(ss, wgfmt.AllowedIP(ipn))
+	}
+
+	return strings.Join(ss, ", ")
+}
+
+func fwmark(mark int) string {
+	if mark == 0 {
+		return "off"
+	}
+
+	return "0x" + strconv.FormatInt(int64(mark), 16)
+}
@@ -0,0 +1,161 @@
+package wgconf_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDiff(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		psk  = wgtest.MustPresharedKey()
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+		pubC = wgtest.MustPublicKey()
+	)
+
+	device := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			PrivateKey:   priv,
+			ListenPort:   51820,
+			FirewallMark: 0x10,
+			Peers: []wgtypes.Peer{
+				{
+					PublicKey:                   pubA,
+					PresharedKey:                psk,
+					Endpoint:                    wgtest.MustUDPAddr("192.0.2.1:51820"),
+					PersistentKeepaliveInterval: 25 * time.Second,
+					AllowedIPs: []net.IPNet{
+						{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(24, 32)},
+						wgtest.MustCIDR("fd00::/64"),
+					},
+				},
+				{
+					PublicKey:  pubB,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.1.0/24")},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		cfg   *wgtypes.Config
+		want  *wgconf.Drift
+		empty bool
+	}{
+		{
+			name: "unchanged",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(priv),
+				ListenPort:   intPtr(51820),
+				FirewallMark: intPtr(0x10),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   pubA,
+						PresharedKey:                keyPtr(psk),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("fd00::/64"),
+							wgtest.MustCIDR("10.0.0.0/24"),
+						},
+					},
+					{
+						PublicKey:         pubB,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.1.0/24")},
+					},
+				},
+			},
+			want:  &wgconf.Drift{},
+			empty: true,
+		},
+		{
+			name: "cleared",
+			cfg:  &wgtypes.Config{},
+			want: &wgconf.Drift{
+				Fields: []wgconf.FieldDrift{
+					{Name: "private key", Device: "(hidden)", Config: "(none)"},
+					{Name: "fwmark", Device: "0x10", Config: "off"},
+				},
+				Extra: device().Peers,
+			},
+		},
+		{
+			name: "changed",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(wgtest.MustPrivateKey()),
+				ListenPort:   intPtr(51821),
+				FirewallMark: intPtr(0x10),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:         pubC,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.2.0/24")},
+					},
+					{
+						PublicKey:         pubA,
+						Endpoint:          wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+					},
+					{
+						PublicKey:                   pubB,
+						PresharedKey:                keyPtr(psk),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.1.0/24")},
+					},
+				},
+			},
+			want: &wgconf.Drift{
+				Fields: []wgconf.FieldDrift{
+					{Name: "private key", Device: "(hidden)", Config: "(different)"},
+					{Name: "listening port", Device: "51820", Config: "51821"},
+				},
+				Missing: []wgtypes.PeerConfig{{
+					PublicKey:         pubC,
+					ReplaceAllowedIPs: true,
+					AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.2.0/24")},
+				}},
+				Changed: []wgconf.PeerDrift{
+					{
+						PublicKey: pubA,
+						Fields: []wgconf.FieldDrift{
+							{Name: "preshared key", Device: "(hidden)", Config: "(none)"},
+							{Name: "endpoint", Device: "192.0.2.1:51820", Config: "[2001:db8::1]:51820"},
+							{Name: "allowed ips", Device: "10.0.0.0/24, fd00::/64", Config: "10.0.0.0/24"},
+							{Name: "persistent keepalive", Device: "every 25 seconds", Config: "off"},
+						},
+					},
+					{
+						PublicKey: pubB,
+						Fields: []wgconf.FieldDrift{
+							{Name: "preshared key", Device: "(none)", Config: "(hidden)"},
+							{Name: "persistent keepalive", Device: "off", Config: "every 25 seconds"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wgconf.Diff(device(), tt.cfg)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected Drift (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.empty, got.Empty()); diff != "" {
+				t.Fatalf("unexpected Empty (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+// Package wgconf parses WireGuard device configurations written in the syntax
+// understood by wg(8), so that programs built on wgctrl can share wg(8)
+// semantics.
+package wgconf // import "golang.zx2c4.com/wireguard/wgctrl/wgconf"
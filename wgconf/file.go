@@ -0,0 +1,235 @@
+package wgconf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Parse parses a configuration file in the format accepted by wg(8) setconf,
+// such as:
+//
+//	[Interface]
+//	PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+//	ListenPort = 51820
+//
+//	[Peer]
+//	PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+//	Endpoint = 192.95.5.67:1234
+//	AllowedIPs = 10.192.122.3/32, 10.192.124.1/24
+//
+// Section and key names are case-insensitive, whitespace is ignored, and
+// comments begin with '#'. Every peer must specify a public key, and a peer's
+// AllowedIPs replace its existing allowed IPs.
+//
+// The returned Config only contains the fields present in the file, so
+// applying it directly has the semantics of wg(8) addconf. Use Replace or
+// Sync for the semantics of wg(8) setconf or syncconf.
+func Parse(r io.Reader, opts *Options) (*wgtypes.Config, error) {
+	var (
+		cfg wgtypes.Config
+
+		section string
+		peer    *wgtypes.PeerConfig
+		hasKey  bool
+	)
+
+	// finishPeer validates the peer section which was parsed most recently.
+	finishPeer := func() error {
+		if peer != nil && !hasKey {
+			return errors.New("wgconf: a peer is missing a public key")
+		}
+
+		return nil
+	}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := stripLine(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			switch strings.ToLower(line) {
+			case "[interface]":
+			case "[peer]":
+				if err := finishPeer(); err != nil {
+					return nil, err
+				}
+
+				cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{ReplaceAllowedIPs: true})
+				peer = &cfg.Peers[len(cfg.Peers)-1]
+				hasKey = false
+			default:
+				return nil, fmt.Errorf("wgconf: line %d: unrecognized section: %s", n, line)
+			}
+
+			section = strings.ToLower(line)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("wgconf: line %d: unrecognized line: %s", n, line)
+		}
+
+		var err error
+		switch key = strings.ToLower(key); section {
+		case "[interface]":
+			err = parseInterfaceKey(&cfg, key, value)
+		case "[peer]":
+			if key == "publickey" {
+				peer.PublicKey, err = parseKey(value)
+				hasKey = err == nil
+				break
+			}
+
+			err = parsePeerKey(peer, key, value, opts)
+		default:
+			err = errUnrecognized
+		}
+		if err == errUnrecognized {
+			return nil, fmt.Errorf("wgconf: line %d: unrecognized line: %s", n, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wgconf: line %d: %v", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("wgconf: failed to read configuration: %v", err)
+	}
+
+	if err := finishPeer(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// errUnrecognized indicates a key which is not valid in its section.
+var errUnrecognized = errors.New("unrecognized key")
+
+// parseInterfaceKey applies a key and value from an Interface section to cfg.
+func parseInterfaceKey(cfg *wgtypes.Config, key, value string) error {
+	switch key {
+	case "listenport":
+		port, err := parsePort(value)
+		if err != nil {
+			return err
+		}
+		cfg.ListenPort = &port
+	case "fwmark":
+		mark, err := parseFwmark(value)
+		if err != nil {
+			return err
+		}
+		cfg.FirewallMark = &mark
+	case "privatekey":
+		k, err := parseKey(value)
+		if err != nil {
+			return err
+		}
+		cfg.PrivateKey = &k
+	default:
+		return errUnrecognized
+	}
+
+	return nil
+}
+
+// parsePeerKey applies a key and value from a Peer section to peer.
+func parsePeerKey(peer *wgtypes.PeerConfig, key, value string, opts *Options) error {
+	switch key {
+	case "presharedkey":
+		k, err := parseKey(value)
+		if err != nil {
+			return err
+		}
+		peer.PresharedKey = &k
+	case "endpoint":
+		addr, err := parseEndpoint(value, opts)
+		if err != nil {
+			return err
+		}
+		peer.Endpoint = addr
+	case "persistentkeepalive":
+		d, err := parseKeepalive(value)
+		if err != nil {
+			return err
+		}
+		peer.PersistentKeepaliveInterval = &d
+	case "allowedips":
+		if value == "" {
+			break
+		}
+
+		for _, s := range strings.Split(value, ",") {
+			ipn, err := parseAllowedIP(s)
+			if err != nil {
+				return err
+			}
+
+			peer.AllowedIPs = append(peer.AllowedIPs, ipn)
+		}
+	default:
+		return errUnrecognized
+	}
+
+	return nil
+}
+
+// parseKey parses a base64-encoded key from a configuration file.
+func parseKey(value string) (wgtypes.Key, error) {
+	k, err := wgtypes.ParseKey(value)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("invalid key: %v", err)
+	}
+
+	return k, nil
+}
+
+// stripLine removes comments and whitespace from a configuration file line.
+func stripLine(line string) string {
+	if i := strings.IndexByte(line, '#'); i != -1 {
+		line = line[:i]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+
+		return r
+	}, line)
+}
+
+// Replace returns a copy of cfg, as returned by Parse, with the semantics of
+// wg(8) setconf: cfg's peers replace all existing peers, and the private key,
+// listening port, and firewall mark are cleared unless cfg specifies them.
+func Replace(cfg *wgtypes.Config) *wgtypes.Config {
+	var (
+		out  = *cfg
+		zero wgtypes.Key
+		port int
+		mark int
+	)
+
+	out.ReplacePeers = true
+	if out.PrivateKey == nil {
+		out.PrivateKey = &zero
+	}
+	if out.ListenPort == nil {
+		out.ListenPort = &port
+	}
+	if out.FirewallMark == nil {
+		out.FirewallMark = &mark
+	}
+
+	return &out
+}
@@ -0,0 +1,161 @@
+package wgconf_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParse(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		psk  = wgtest.MustPresharedKey()
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+	)
+
+	tests := []struct {
+		name string
+		s    string
+		cfg  *wgtypes.Config
+		ok   bool
+	}{
+		{
+			name: "empty",
+			s:    "# nothing here\n\n",
+			cfg:  &wgtypes.Config{},
+			ok:   true,
+		},
+		{
+			name: "OK",
+			s: `
+[Interface]
+PrivateKey = ` + priv.String() + `
+ListenPort = 51820 # comment
+fwmark=0x10
+
+[peer]
+PublicKey = ` + pubA.String() + `
+PresharedKey = ` + psk.String() + `
+Endpoint = [2001:db8::1]:51820
+AllowedIPs = 10.0.0.0/24, fd00::1
+AllowedIPs = 192.0.2.1/32
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = ` + pubB.String() + `
+AllowedIPs =
+`,
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(priv),
+				ListenPort:   intPtr(51820),
+				FirewallMark: intPtr(0x10),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   pubA,
+						PresharedKey:                keyPtr(psk),
+						Endpoint:                    wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("10.0.0.0/24"),
+							wgtest.MustCIDR("fd00::1/128"),
+							wgtest.MustCIDR("192.0.2.1/32"),
+						},
+					},
+					{
+						PublicKey:         pubB,
+						ReplaceAllowedIPs: true,
+					},
+				},
+			},
+			ok: true,
+		},
+		{
+			name: "wg-quick key",
+			s:    "[Interface]\nAddress = 10.0.0.1/24\n",
+		},
+		{
+			name: "peer key in interface",
+			s:    "[Interface]\nPublicKey = " + pubA.String() + "\n",
+		},
+		{
+			name: "key outside section",
+			s:    "ListenPort = 51820\n",
+		},
+		{
+			name: "unknown section",
+			s:    "[Foo]\n",
+		},
+		{
+			name: "no equals",
+			s:    "[Interface]\nListenPort\n",
+		},
+		{
+			name: "missing public key",
+			s:    "[Peer]\nAllowedIPs = 10.0.0.0/8\n[Peer]\nPublicKey = " + pubA.String() + "\n",
+		},
+		{
+			name: "missing last public key",
+			s:    "[Peer]\nPublicKey = " + pubA.String() + "\n[Peer]\n",
+		},
+		{
+			name: "invalid private key",
+			s:    "[Interface]\nPrivateKey = foo\n",
+		},
+		{
+			name: "invalid allowed IP",
+			s:    "[Peer]\nPublicKey = " + pubA.String() + "\nAllowedIPs = +10.0.0.0/8\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := wgconf.Parse(strings.NewReader(tt.s), nil)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.cfg, cfg); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	port := 51820
+	cfg := &wgtypes.Config{
+		ListenPort: &port,
+		Peers:      []wgtypes.PeerConfig{{PublicKey: wgtest.MustPublicKey()}},
+	}
+
+	want := &wgtypes.Config{
+		PrivateKey:   keyPtr(wgtypes.Key{}),
+		ListenPort:   intPtr(51820),
+		FirewallMark: intPtr(0),
+		ReplacePeers: true,
+		Peers:        cfg.Peers,
+	}
+
+	if diff := cmp.Diff(want, wgconf.Replace(cfg)); diff != "" {
+		t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+	}
+
+	// The input must not be modified.
+	if cfg.ReplacePeers || cfg.PrivateKey != nil || cfg.FirewallMark != nil {
+		t.Fatal("Replace modified its input")
+	}
+}
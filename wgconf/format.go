@@ -0,0 +1,55 @@
+package wgconf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Marshal formats d as a configuration file, in the same format as wg(8)
+// showconf. Parse accepts the output of Marshal.
+func Marshal(d *wgtypes.Device) []byte {
+	var b bytes.Buffer
+
+	b.WriteString("[Interface]\n")
+	if d.ListenPort != 0 {
+		fmt.Fprintf(&b, "ListenPort = %d\n", d.ListenPort)
+	}
+	if d.FirewallMark != 0 {
+		fmt.Fprintf(&b, "FwMark = 0x%x\n", d.FirewallMark)
+	}
+	if d.PrivateKey != (wgtypes.Key{}) {
+		fmt.Fprintf(&b, "PrivateKey = %s\n", d.PrivateKey)
+	}
+
+	b.WriteString("\n")
+	for i, p := range d.Peers {
+		fmt.Fprintf(&b, "[Peer]\nPublicKey = %s\n", p.PublicKey)
+		if p.PresharedKey != (wgtypes.Key{}) {
+			fmt.Fprintf(&b, "PresharedKey = %s\n", p.PresharedKey)
+		}
+		if len(p.AllowedIPs) > 0 {
+			ss := make([]string, 0, len(p.AllowedIPs))
+			for _, ipn := range p.AllowedIPs {
+				ss = append(ss, wgfmt.AllowedIP(ipn))
+			}
+
+			fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(ss, ", "))
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(&b, "Endpoint = %s\n", wgfmt.Endpoint(p.Endpoint))
+		}
+		if p.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(&b, "PersistentKeepalive = %d\n", int(p.PersistentKeepaliveInterval.Seconds()))
+		}
+
+		if i < len(d.Peers)-1 {
+			b.WriteString("\n")
+		}
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,75 @@
+package wgconf_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMarshalParse(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		psk  = wgtest.MustPresharedKey()
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+	)
+
+	d := &wgtypes.Device{
+		PrivateKey:   priv,
+		PublicKey:    priv.PublicKey(),
+		ListenPort:   51820,
+		FirewallMark: 0x10,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:                   pubA,
+				PresharedKey:                psk,
+				Endpoint:                    wgtest.MustUDPAddr("[fd00::1]:51820"),
+				PersistentKeepaliveInterval: 25 * time.Second,
+				AllowedIPs: []net.IPNet{
+					wgtest.MustCIDR("10.0.0.0/24"),
+					wgtest.MustCIDR("::ffff:0.0.0.0/96"),
+				},
+			},
+			{
+				PublicKey: pubB,
+			},
+		},
+	}
+
+	want := `[Interface]
+ListenPort = 51820
+FwMark = 0x10
+PrivateKey = ` + priv.String() + `
+
+[Peer]
+PublicKey = ` + pubA.String() + `
+PresharedKey = ` + psk.String() + `
+AllowedIPs = 10.0.0.0/24, ::ffff:0.0.0.0/96
+Endpoint = [fd00::1]:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = ` + pubB.String() + `
+`
+
+	b := wgconf.Marshal(d)
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected configuration (-want +got):\n%s", diff)
+	}
+
+	cfg, err := wgconf.Parse(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// Applying the parsed configuration to the device is a no-op.
+	if diff := cmp.Diff(&wgtypes.Config{}, wgconf.Sync(d, cfg)); diff != "" {
+		t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,322 @@
+package wgconf
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Options configures how configurations are parsed. A nil *Options uses
+// the default value for each field.
+type Options struct {
+	// ReadFile reads the key files named by private-key and preshared-key
+	// arguments. If nil, os.ReadFile is used.
+	ReadFile func(name string) ([]byte, error)
+
+	// ResolveUDPAddr resolves peer endpoints, which may contain host names.
+	// If nil, net.ResolveUDPAddr is used.
+	ResolveUDPAddr func(network, address string) (*net.UDPAddr, error)
+}
+
+// readFile reads a file using o.ReadFile or os.ReadFile.
+func (o *Options) readFile(name string) ([]byte, error) {
+	if o != nil && o.ReadFile != nil {
+		return o.ReadFile(name)
+	}
+
+	return os.ReadFile(name)
+}
+
+// resolveUDPAddr resolves an address using o.ResolveUDPAddr or
+// net.ResolveUDPAddr.
+func (o *Options) resolveUDPAddr(network, address string) (*net.UDPAddr, error) {
+	if o != nil && o.ResolveUDPAddr != nil {
+		return o.ResolveUDPAddr(network, address)
+	}
+
+	return net.ResolveUDPAddr(network, address)
+}
+
+// ParseSet parses the arguments of a wg(8) set command which follow the
+// interface name into a Config, such as:
+//
+//	listen-port 51820 private-key /etc/wireguard/wg0.key peer <key> allowed-ips 10.0.0.2/32
+//
+// The private-key and preshared-key arguments name files containing a
+// base64-encoded key. An empty file, such as /dev/null, clears the key.
+// The fwmark and persistent-keepalive arguments accept "off" to clear them.
+//
+// The allowed-ips argument is a comma-separated list of IP addresses, with
+// or without a CIDR mask, which replaces the peer's allowed IPs. If the first
+// address is prefixed with '+' or '-', the list instead adds the addresses
+// prefixed with '+' and removes the addresses prefixed with '-', leaving the
+// peer's other allowed IPs in place.
+func ParseSet(args []string, opts *Options) (*wgtypes.Config, error) {
+	var (
+		cfg  wgtypes.Config
+		peer *wgtypes.PeerConfig
+	)
+
+	for len(args) > 0 {
+		arg := args[0]
+
+		// Every argument other than "remove" takes a value.
+		if arg == "remove" && peer != nil {
+			peer.Remove = true
+			args = args[1:]
+			continue
+		}
+
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wgconf: invalid argument: %s", arg)
+		}
+		value := args[1]
+		args = args[2:]
+
+		if arg == "peer" {
+			k, err := wgtypes.ParseKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("wgconf: invalid peer public key %q: %v", value, err)
+			}
+
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{PublicKey: k})
+			peer = &cfg.Peers[len(cfg.Peers)-1]
+			continue
+		}
+
+		var err error
+		if peer == nil {
+			err = parseDevice(&cfg, arg, value, opts)
+		} else {
+			err = parsePeer(peer, arg, value, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wgconf: %v", err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// parseDevice applies a device argument and its value to cfg.
+func parseDevice(cfg *wgtypes.Config, arg, value string, opts *Options) error {
+	switch arg {
+	case "listen-port":
+		port, err := parsePort(value)
+		if err != nil {
+			return err
+		}
+		cfg.ListenPort = &port
+	case "fwmark":
+		mark, err := parseFwmark(value)
+		if err != nil {
+			return err
+		}
+		cfg.FirewallMark = &mark
+	case "private-key":
+		k, err := readKeyFile(value, opts)
+		if err != nil {
+			return err
+		}
+		cfg.PrivateKey = &k
+	default:
+		return fmt.Errorf("invalid argument: %s", arg)
+	}
+
+	return nil
+}
+
+// parsePeer applies a peer argument and its value to peer.
+func parsePeer(peer *wgtypes.PeerConfig, arg, value string, opts *Options) error {
+	switch arg {
+	case "preshared-key":
+		k, err := readKeyFile(value, opts)
+		if err != nil {
+			return err
+		}
+		peer.PresharedKey = &k
+	case "endpoint":
+		addr, err := parseEndpoint(value, opts)
+		if err != nil {
+			return err
+		}
+		peer.Endpoint = addr
+	case "persistent-keepalive":
+		d, err := parseKeepalive(value)
+		if err != nil {
+			return err
+		}
+		peer.PersistentKeepaliveInterval = &d
+	case "allowed-ips":
+		return parseAllowedIPs(peer, value)
+	default:
+		return fmt.Errorf("invalid argument: %s", arg)
+	}
+
+	return nil
+}
+
+// parsePort parses a listening port number or service name.
+func parsePort(value string) (int, error) {
+	port, err := net.LookupPort("udp", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q: %v", value, err)
+	}
+
+	return port, nil
+}
+
+// parseFwmark parses a firewall mark, which may be "off" or a number in
+// decimal, hexadecimal, or octal.
+func parseFwmark(value string) (int, error) {
+	if value == "off" {
+		return 0, nil
+	}
+
+	mark, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fwmark %q", value)
+	}
+
+	return int(mark), nil
+}
+
+// parseKeepalive parses a persistent keepalive interval in seconds, which
+// may be "off".
+func parseKeepalive(value string) (time.Duration, error) {
+	if value == "off" {
+		return 0, nil
+	}
+
+	sec, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid persistent keepalive interval %q", value)
+	}
+
+	return time.Duration(sec) * time.Second, nil
+}
+
+// parseEndpoint resolves a host:port or [host]:port endpoint.
+func parseEndpoint(value string, opts *Options) (*net.UDPAddr, error) {
+	host, _, err := net.SplitHostPort(value)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: expected host:port or [host]:port", value)
+	}
+
+	addr, err := opts.resolveUDPAddr("udp", value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve endpoint %q: %v", value, err)
+	}
+
+	return addr, nil
+}
+
+// parseAllowedIPs applies a comma-separated list of allowed IPs to peer.
+func parseAllowedIPs(peer *wgtypes.PeerConfig, value string) error {
+	first, incremental := true, false
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		op := s[0]
+		switch op {
+		case '+', '-':
+			s = s[1:]
+		default:
+			op = 0
+		}
+
+		if first {
+			first = false
+			incremental = op != 0
+			if !incremental {
+				peer.ReplaceAllowedIPs = true
+			}
+		}
+
+		ipn, err := parseAllowedIP(s)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case op == '-' && !incremental:
+			return fmt.Errorf("cannot remove allowed IP %q from a replacement list", s)
+		case op == '-':
+			peer.RemoveAllowedIPs = append(peer.RemoveAllowedIPs, ipn)
+		default:
+			peer.AllowedIPs = append(peer.AllowedIPs, ipn)
+		}
+	}
+
+	// An empty list clears the peer's allowed IPs.
+	if first {
+		peer.ReplaceAllowedIPs = true
+	}
+
+	return nil
+}
+
+// ParseAllowedIP parses an IP address with an optional CIDR mask, as
+// accepted in wg(8) allowed IP lists. An address without a mask is treated
+// as a single host route.
+func ParseAllowedIP(s string) (net.IPNet, error) {
+	ipn, err := parseAllowedIP(s)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("wgconf: %v", err)
+	}
+
+	return ipn, nil
+}
+
+// parseAllowedIP implements ParseAllowedIP.
+func parseAllowedIP(s string) (net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return net.IPNet{}, fmt.Errorf("invalid allowed IP %q", s)
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, ipn, err := net.ParseCIDR(s)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("invalid allowed IP %q", s)
+	}
+
+	return *ipn, nil
+}
+
+// readKeyFile reads a base64-encoded key from the named file. Like wg(8),
+// an empty file produces a zero key and trailing whitespace is ignored.
+func readKeyFile(name string, opts *Options) (wgtypes.Key, error) {
+	b, err := opts.readFile(name)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	if len(b) == 0 {
+		return wgtypes.Key{}, nil
+	}
+
+	s := strings.TrimRightFunc(string(b), unicode.IsSpace)
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("invalid key in key file %q: %v", name, err)
+	}
+
+	return k, nil
+}
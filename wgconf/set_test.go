@@ -0,0 +1,259 @@
+package wgconf_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParseSet(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		psk  = wgtest.MustPresharedKey()
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+
+		files = map[string]string{
+			"priv":  priv.String() + "\n",
+			"psk":   psk.String() + " \n\n",
+			"empty": "",
+			"bad":   "foo\n",
+		}
+	)
+
+	tests := []struct {
+		name string
+		args string
+		cfg  *wgtypes.Config
+		ok   bool
+	}{
+		{
+			name: "empty",
+			cfg:  &wgtypes.Config{},
+			ok:   true,
+		},
+		{
+			name: "device",
+			args: "listen-port 51820 fwmark 0x10 private-key priv",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(priv),
+				ListenPort:   intPtr(51820),
+				FirewallMark: intPtr(0x10),
+			},
+			ok: true,
+		},
+		{
+			name: "clear",
+			args: "fwmark off private-key empty peer " + pubA.String() + " preshared-key empty persistent-keepalive off",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(wgtypes.Key{}),
+				FirewallMark: intPtr(0),
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:                   pubA,
+					PresharedKey:                keyPtr(wgtypes.Key{}),
+					PersistentKeepaliveInterval: durPtr(0),
+				}},
+			},
+			ok: true,
+		},
+		{
+			name: "peers",
+			args: "listen-port 0 peer " + pubA.String() + " remove peer " + pubB.String() +
+				" preshared-key psk endpoint [2001:db8::1]:51820 persistent-keepalive 25 allowed-ips 10.0.0.0/24,fd00::1",
+			cfg: &wgtypes.Config{
+				ListenPort: intPtr(0),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey: pubA,
+						Remove:    true,
+					},
+					{
+						PublicKey:                   pubB,
+						PresharedKey:                keyPtr(psk),
+						Endpoint:                    wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("10.0.0.0/24"),
+							wgtest.MustCIDR("fd00::1/128"),
+						},
+					},
+				},
+			},
+			ok: true,
+		},
+		{
+			name: "clear allowed IPs",
+			args: "peer " + pubA.String() + " allowed-ips ",
+			cfg: &wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:         pubA,
+					ReplaceAllowedIPs: true,
+				}},
+			},
+			ok: true,
+		},
+		{
+			name: "incremental allowed IPs",
+			args: "peer " + pubA.String() + " allowed-ips +192.0.2.1,-10.0.0.0/8,fd00::/64",
+			cfg: &wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey: pubA,
+					AllowedIPs: []net.IPNet{
+						wgtest.MustCIDR("192.0.2.1/32"),
+						wgtest.MustCIDR("fd00::/64"),
+					},
+					RemoveAllowedIPs: []net.IPNet{
+						wgtest.MustCIDR("10.0.0.0/8"),
+					},
+				}},
+			},
+			ok: true,
+		},
+		{
+			name: "remove from replacement",
+			args: "peer " + pubA.String() + " allowed-ips 192.0.2.1,-10.0.0.0/8",
+		},
+		{
+			name: "invalid allowed IP",
+			args: "peer " + pubA.String() + " allowed-ips 192.0.2.1/33",
+		},
+		{
+			name: "invalid argument",
+			args: "foo bar",
+		},
+		{
+			name: "missing value",
+			args: "listen-port",
+		},
+		{
+			name: "peer argument without peer",
+			args: "endpoint 192.0.2.1:51820",
+		},
+		{
+			name: "remove without peer",
+			args: "remove",
+		},
+		{
+			name: "device argument after peer",
+			args: "peer " + pubA.String() + " listen-port 1",
+		},
+		{
+			name: "invalid peer",
+			args: "peer foo",
+		},
+		{
+			name: "invalid port",
+			args: "listen-port 65536",
+		},
+		{
+			name: "invalid fwmark",
+			args: "fwmark -1",
+		},
+		{
+			name: "invalid keepalive",
+			args: "peer " + pubA.String() + " persistent-keepalive 65536",
+		},
+		{
+			name: "missing endpoint port",
+			args: "peer " + pubA.String() + " endpoint 192.0.2.1",
+		},
+		{
+			name: "invalid key file",
+			args: "private-key bad",
+		},
+		{
+			name: "missing key file",
+			args: "private-key missing",
+		},
+	}
+
+	opts := &wgconf.Options{
+		ReadFile: func(name string) ([]byte, error) {
+			s, ok := files[name]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+
+			return []byte(s), nil
+		},
+		ResolveUDPAddr: func(network, address string) (*net.UDPAddr, error) {
+			if network != "udp" {
+				panicf("unexpected network: %q", network)
+			}
+
+			return net.ResolveUDPAddr(network, address)
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.args != "" {
+				args = strings.Split(tt.args, " ")
+			}
+
+			cfg, err := wgconf.ParseSet(args, opts)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.cfg, cfg); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSetResolveEndpoint(t *testing.T) {
+	var (
+		pub  = wgtest.MustPublicKey()
+		want = wgtest.MustUDPAddr("192.0.2.1:51820")
+	)
+
+	opts := &wgconf.Options{
+		ResolveUDPAddr: func(_, address string) (*net.UDPAddr, error) {
+			if address != "vpn.example.com:51820" {
+				return nil, errors.New("no such host")
+			}
+
+			return want, nil
+		},
+	}
+
+	cfg, err := wgconf.ParseSet([]string{"peer", pub.String(), "endpoint", "vpn.example.com:51820"}, opts)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	if diff := cmp.Diff(want, cfg.Peers[0].Endpoint); diff != "" {
+		t.Fatalf("unexpected endpoint (-want +got):\n%s", diff)
+	}
+
+	if _, err := wgconf.ParseSet([]string{"peer", pub.String(), "endpoint", "foo.example.com:51820"}, opts); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func durPtr(d time.Duration) *time.Duration { return &d }
+func intPtr(v int) *int                     { return &v }
+func keyPtr(k wgtypes.Key) *wgtypes.Key     { return &k }
+
+func panicf(format string, a ...interface{}) {
+	panic(fmt.Sprintf(format, a...))
+}
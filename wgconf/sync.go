@@ -0,0 +1,179 @@
+package wgconf
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Sync returns a Config with the semantics of wg(8) syncconf, which changes
+// device d to match cfg, as returned by Parse, as if cfg had been applied
+// with Replace.
+//
+// Unlike Replace, the returned Config only contains the differences between
+// d and cfg, so the sessions of peers which are unchanged or only partially
+// changed are not disturbed. Peers which are not present in cfg are removed.
+// Fields which cfg leaves unspecified are cleared, except for the listening
+// port and peer endpoints, which keep their current values.
+func Sync(d *wgtypes.Device, cfg *wgtypes.Config) *wgtypes.Config {
+	var out wgtypes.Config
+
+	if k := keyOrZero(cfg.PrivateKey); k != d.PrivateKey {
+		out.PrivateKey = &k
+	}
+	if cfg.ListenPort != nil && *cfg.ListenPort != d.ListenPort {
+		port := *cfg.ListenPort
+		out.ListenPort = &port
+	}
+	if mark := intOrZero(cfg.FirewallMark); mark != d.FirewallMark {
+		out.FirewallMark = &mark
+	}
+
+	current := make(map[wgtypes.Key]*wgtypes.Peer, len(d.Peers))
+	for i := range d.Peers {
+		current[d.Peers[i].PublicKey] = &d.Peers[i]
+	}
+
+	want := mergePeers(cfg.Peers)
+	keep := make(map[wgtypes.Key]bool, len(want))
+	for _, pc := range want {
+		keep[pc.PublicKey] = true
+
+		p, ok := current[pc.PublicKey]
+		if !ok {
+			out.Peers = append(out.Peers, pc)
+			continue
+		}
+
+		if diff, ok := syncPeer(p, pc); ok {
+			out.Peers = append(out.Peers, diff)
+		}
+	}
+
+	for _, p := range d.Peers {
+		if !keep[p.PublicKey] {
+			out.Peers = append(out.Peers, wgtypes.PeerConfig{
+				PublicKey: p.PublicKey,
+				Remove:    true,
+			})
+		}
+	}
+
+	return &out
+}
+
+// syncPeer returns the changes needed to make p match pc, and reports whether
+// any changes are needed.
+func syncPeer(p *wgtypes.Peer, pc wgtypes.PeerConfig) (wgtypes.PeerConfig, bool) {
+	var (
+		diff    = wgtypes.PeerConfig{PublicKey: p.PublicKey}
+		changed bool
+	)
+
+	if k := keyOrZero(pc.PresharedKey); k != p.PresharedKey {
+		diff.PresharedKey = &k
+		changed = true
+	}
+	if pc.Endpoint != nil && (p.Endpoint == nil || !pc.Endpoint.IP.Equal(p.Endpoint.IP) || pc.Endpoint.Port != p.Endpoint.Port) {
+		diff.Endpoint = pc.Endpoint
+		changed = true
+	}
+	if d := durationOrZero(pc.PersistentKeepaliveInterval); d != p.PersistentKeepaliveInterval {
+		diff.PersistentKeepaliveInterval = &d
+		changed = true
+	}
+	if !sameAllowedIPs(p, pc) {
+		diff.ReplaceAllowedIPs = true
+		diff.AllowedIPs = pc.AllowedIPs
+		changed = true
+	}
+
+	return diff, changed
+}
+
+// mergePeers combines peer configurations which have the same public key,
+// as a device would when applying them in order.
+func mergePeers(pcs []wgtypes.PeerConfig) []wgtypes.PeerConfig {
+	var (
+		out   []wgtypes.PeerConfig
+		index = make(map[wgtypes.Key]int, len(pcs))
+	)
+
+	for _, pc := range pcs {
+		i, ok := index[pc.PublicKey]
+		if !ok {
+			index[pc.PublicKey] = len(out)
+			out = append(out, pc)
+			continue
+		}
+
+		m := &out[i]
+		if pc.PresharedKey != nil {
+			m.PresharedKey = pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			m.Endpoint = pc.Endpoint
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			m.PersistentKeepaliveInterval = pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			m.AllowedIPs = nil
+		}
+		m.AllowedIPs = append(m.AllowedIPs[:len(m.AllowedIPs):len(m.AllowedIPs)], pc.AllowedIPs...)
+	}
+
+	return out
+}
+
+// sameAllowedIPs reports whether p and pc contain the same set of allowed IPs.
+func sameAllowedIPs(p *wgtypes.Peer, pc wgtypes.PeerConfig) bool {
+	have := make(map[string]bool, len(p.AllowedIPs))
+	for _, ipn := range p.AllowedIPs {
+		have[prefixString(ipn)] = true
+	}
+
+	want := make(map[string]bool, len(pc.AllowedIPs))
+	for _, ipn := range pc.AllowedIPs {
+		s := prefixString(ipn)
+		if !have[s] {
+			return false
+		}
+		want[s] = true
+	}
+
+	return len(have) == len(want)
+}
+
+// prefixString returns a canonical string for ipn, regardless of the length
+// of its IP address.
+func prefixString(ipn net.IPNet) string {
+	ones, _ := ipn.Mask.Size()
+	return fmt.Sprintf("%s/%d", ipn.IP.Mask(ipn.Mask), ones)
+}
+
+func keyOrZero(k *wgtypes.Key) wgtypes.Key {
+	if k == nil {
+		return wgtypes.Key{}
+	}
+
+	return *k
+}
+
+func intOrZero(v *int) int {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
+
+func durationOrZero(d *time.Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	return *d
+}
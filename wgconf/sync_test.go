@@ -0,0 +1,143 @@
+package wgconf_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSync(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		psk  = wgtest.MustPresharedKey()
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+		pubC = wgtest.MustPublicKey()
+	)
+
+	device := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			PrivateKey:   priv,
+			ListenPort:   51820,
+			FirewallMark: 0x10,
+			Peers: []wgtypes.Peer{
+				{
+					PublicKey:                   pubA,
+					PresharedKey:                psk,
+					Endpoint:                    wgtest.MustUDPAddr("192.0.2.1:51820"),
+					PersistentKeepaliveInterval: 25 * time.Second,
+					AllowedIPs: []net.IPNet{
+						// Kernels may report IPv4 addresses in 16 byte form.
+						{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(24, 32)},
+						wgtest.MustCIDR("fd00::/64"),
+					},
+				},
+				{
+					PublicKey:  pubB,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.1.0/24")},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		cfg  *wgtypes.Config
+		want *wgtypes.Config
+	}{
+		{
+			name: "unchanged",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(priv),
+				FirewallMark: intPtr(0x10),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   pubA,
+						PresharedKey:                keyPtr(psk),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("fd00::/64"),
+							wgtest.MustCIDR("10.0.0.0/24"),
+						},
+					},
+					{
+						PublicKey:         pubB,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.1.0/24")},
+					},
+				},
+			},
+			want: &wgtypes.Config{},
+		},
+		{
+			name: "cleared",
+			cfg:  &wgtypes.Config{},
+			want: &wgtypes.Config{
+				PrivateKey:   keyPtr(wgtypes.Key{}),
+				FirewallMark: intPtr(0),
+				Peers: []wgtypes.PeerConfig{
+					{PublicKey: pubA, Remove: true},
+					{PublicKey: pubB, Remove: true},
+				},
+			},
+		},
+		{
+			name: "changed",
+			cfg: &wgtypes.Config{
+				PrivateKey:   keyPtr(priv),
+				ListenPort:   intPtr(51821),
+				FirewallMark: intPtr(0x10),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:         pubC,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.2.0/24")},
+					},
+					{
+						PublicKey:         pubA,
+						Endpoint:          wgtest.MustUDPAddr("192.0.2.2:51820"),
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+					},
+					{
+						// Duplicate peers are merged.
+						PublicKey:                   pubA,
+						PresharedKey:                keyPtr(psk),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+					},
+				},
+			},
+			want: &wgtypes.Config{
+				ListenPort: intPtr(51821),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:         pubC,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.2.0/24")},
+					},
+					{
+						PublicKey:         pubA,
+						Endpoint:          wgtest.MustUDPAddr("192.0.2.2:51820"),
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+					},
+					{PublicKey: pubB, Remove: true},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, wgconf.Sync(device(), tt.cfg)); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package wgctrltest
+
+import (
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgctrlinternal"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wglinux"
+)
+
+// NewClient creates a *wgctrl.Client which controls only the devices of k,
+// using the optional configuration in opts. Options which do not apply to
+// Linux kernel devices, and LinkInfo, are ignored.
+func NewClient(k *Kernel, opts *wgctrl.Options) (*wgctrl.Client, error) {
+	if opts == nil {
+		opts = &wgctrl.Options{}
+	}
+
+	cfg := &wglinux.Config{
+		Trace:    opts.Trace,
+		MaxConns: opts.MaxNetlinkConns,
+		Workers:  opts.Workers,
+	}
+
+	c, err := wglinux.NewWithDial(cfg, k.Dial, func() ([]string, error) {
+		return k.Names(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wgctrlinternal.NewClient(c).(*wgctrl.Client), nil
+}
@@ -0,0 +1,7 @@
+// Package wgctrltest provides utilities for testing code which uses package
+// wgctrl, without requiring elevated privileges or real WireGuard devices.
+//
+// On Linux, Kernel is a stateful fake of the WireGuard generic netlink
+// family, and NewClient creates a *wgctrl.Client which controls the fake
+// devices of a Kernel.
+package wgctrltest
@@ -0,0 +1,918 @@
+//go:build linux
+// +build linux
+
+package wgctrltest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/genetlink/genltest"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Allowed IP attributes and flags which are not yet defined in x/sys/unix.
+const (
+	wgAllowedIPAFlags    = 4 // WGALLOWEDIP_A_FLAGS
+	wgAllowedIPFRemoveMe = 1 // WGALLOWEDIP_F_REMOVE_ME
+)
+
+const (
+	// familyID is the arbitrary generic netlink family ID of a Kernel.
+	familyID = 26
+
+	// defaultMessageSize is NLMSG_GOODSIZE on systems with 4KiB pages, which
+	// the kernel uses to allocate each message of a dump.
+	defaultMessageSize = 3776
+
+	// msgHeaderLen is the combined length of the netlink and generic netlink
+	// headers which precede the attributes in each message.
+	msgHeaderLen = 16 + 4
+)
+
+// A Kernel is a stateful fake of the Linux WireGuard generic netlink family.
+// A Kernel is safe for concurrent use by multiple goroutines.
+//
+// A Kernel applies WG_CMD_SET_DEVICE requests to its devices with the same
+// semantics as the Linux kernel, including device and peer flags, and
+// answers WG_CMD_GET_DEVICE dumps using multiple messages when a device does
+// not fit in a single message, splitting peers and their allowed IPs between
+// messages as the Linux kernel does.
+//
+// As with the Linux kernel, a configuration which fails is not rolled back,
+// and changes made before the failure remain in effect.
+type Kernel struct {
+	size int
+
+	mu       sync.Mutex
+	devices  []*device
+	index    int
+	requests map[uint8]int
+}
+
+// A KernelConfig specifies optional configuration for a Kernel. A nil
+// KernelConfig applies the default configuration.
+type KernelConfig struct {
+	// MessageSize specifies the maximum length of each message of a
+	// WG_CMD_GET_DEVICE dump, including its headers. Smaller values produce
+	// dumps with more messages. If zero, a default value matching the Linux
+	// kernel is used.
+	MessageSize int
+}
+
+// NewKernel creates a Kernel with no devices using the optional
+// configuration in cfg.
+func NewKernel(cfg *KernelConfig) *Kernel {
+	if cfg == nil {
+		cfg = &KernelConfig{}
+	}
+
+	size := cfg.MessageSize
+	if size <= 0 {
+		size = defaultMessageSize
+	}
+
+	return &Kernel{
+		size:     size,
+		requests: make(map[uint8]int),
+	}
+}
+
+// AddDevice adds a copy of d as a WireGuard device. If d.Index is zero, an
+// interface index is assigned automatically. The public key of d and the
+// protocol version of its peers are set by the Kernel. Peer statistics such
+// as ReceiveBytes are reported as specified, and are never modified by the
+// Kernel.
+//
+// AddDevice panics if a device with the same name or index already exists.
+func (k *Kernel) AddDevice(d *wgtypes.Device) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	d = copyDevice(d)
+	d.Type = wgtypes.LinuxKernel
+	d.Link = nil
+
+	if d.Index == 0 {
+		k.index++
+		d.Index = k.index
+	}
+
+	for _, kd := range k.devices {
+		if kd.Name == d.Name || kd.Index == d.Index {
+			panicf("wgctrltest: device %q with index %d already exists", d.Name, d.Index)
+		}
+	}
+
+	kd := &device{
+		Device: d,
+		owners: make(map[string]wgtypes.Key),
+	}
+	kd.setPrivateKey(d.PrivateKey)
+
+	for i := range d.Peers {
+		p := &d.Peers[i]
+		p.ProtocolVersion = 1
+
+		ipns := p.AllowedIPs
+		p.AllowedIPs = nil
+		for _, ipn := range ipns {
+			ones, bits := ipn.Mask.Size()
+			if bits == 32 {
+				ipn.IP = ipn.IP.To4()
+			}
+
+			kd.addAllowedIP(p.PublicKey, ipn.IP, ones, bits)
+		}
+	}
+
+	if d.Index > k.index {
+		k.index = d.Index
+	}
+
+	k.devices = append(k.devices, kd)
+}
+
+// RemoveDevice removes the device specified by name and reports whether it
+// existed.
+func (k *Kernel) RemoveDevice(name string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, d := range k.devices {
+		if d.Name == name {
+			k.devices = append(k.devices[:i], k.devices[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Device returns a copy of the current state of the device specified by
+// name, and reports whether it exists.
+func (k *Kernel) Device(name string) (*wgtypes.Device, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, d := range k.devices {
+		if d.Name == name {
+			return copyDevice(d.Device), true
+		}
+	}
+
+	return nil, false
+}
+
+// Names returns the names of all devices, sorted by interface index.
+func (k *Kernel) Names() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ds := make([]*device, len(k.devices))
+	copy(ds, k.devices)
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].Index < ds[j].Index
+	})
+
+	names := make([]string, 0, len(ds))
+	for _, d := range ds {
+		names = append(names, d.Name)
+	}
+
+	return names
+}
+
+// Requests returns the number of requests received for the generic netlink
+// command, such as unix.WG_CMD_SET_DEVICE. Each message of a batched
+// configuration is a separate request.
+func (k *Kernel) Requests(command uint8) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.requests[command]
+}
+
+// Family returns the generic netlink family served by the Kernel.
+func (k *Kernel) Family() genetlink.Family {
+	return genetlink.Family{
+		ID:      familyID,
+		Version: unix.WG_GENL_VERSION,
+		Name:    unix.WG_GENL_NAME,
+	}
+}
+
+// Dial opens a generic netlink connection to the Kernel.
+func (k *Kernel) Dial() (*genetlink.Conn, error) {
+	serve := genltest.ServeFamily(k.Family(), k.Serve)
+
+	return genetlink.NewConn(nltest.Dial(func(reqs []netlink.Message) ([]netlink.Message, error) {
+		if len(reqs) != 1 {
+			return nil, fmt.Errorf("wgctrltest: expected one request, but got: %d", len(reqs))
+		}
+		req := reqs[0]
+
+		var greq genetlink.Message
+		if err := greq.UnmarshalBinary(req.Data); err != nil {
+			return nil, err
+		}
+
+		gmsgs, err := serve(greq, req)
+		if err != nil {
+			errno, ok := err.(unix.Errno)
+			if !ok {
+				return nil, err
+			}
+
+			// Report the error in a netlink error message which echoes the
+			// request, as the kernel does. genltest.Error omits the request
+			// header, which netlink cannot parse.
+			b, err := req.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+
+			return []netlink.Message{{
+				Header: netlink.Header{
+					Type:     netlink.Error,
+					Sequence: req.Header.Sequence,
+					PID:      req.Header.PID,
+				},
+				Data: append(nlenc.Int32Bytes(-int32(errno)), b...),
+			}}, nil
+		}
+
+		msgs := make([]netlink.Message, 0, len(gmsgs))
+		for _, gm := range gmsgs {
+			b, err := gm.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+
+			msgs = append(msgs, netlink.Message{
+				Header: netlink.Header{
+					Sequence: req.Header.Sequence,
+					PID:      req.Header.PID,
+				},
+				Data: b,
+			})
+		}
+
+		return msgs, nil
+	})), nil
+}
+
+// Serve handles a single generic netlink request. Serve implements
+// genltest.Func, and can be used with genltest directly to serve the
+// Kernel's family. Errors are reported as unix.Errno values.
+func (k *Kernel) Serve(greq genetlink.Message, nreq netlink.Message) ([]genetlink.Message, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.requests[greq.Header.Command]++
+
+	switch greq.Header.Command {
+	case unix.WG_CMD_GET_DEVICE:
+		if nreq.Header.Flags&netlink.Dump == 0 {
+			return nil, unix.EOPNOTSUPP
+		}
+
+		return k.get(greq.Data)
+	case unix.WG_CMD_SET_DEVICE:
+		if err := k.set(greq.Data); err != nil {
+			return nil, err
+		}
+
+		// Acknowledge the request.
+		return []genetlink.Message{{}}, nil
+	default:
+		return nil, unix.EOPNOTSUPP
+	}
+}
+
+// lookup finds the device identified by the WGDEVICE_A_IFINDEX or
+// WGDEVICE_A_IFNAME attribute in b.
+func (k *Kernel) lookup(b []byte) (*device, error) {
+	attrs, err := netlink.UnmarshalAttributes(b)
+	if err != nil {
+		return nil, unix.EINVAL
+	}
+
+	var (
+		index int
+		name  string
+	)
+
+	for _, a := range attrs {
+		switch a.Type & ^uint16(netlink.Nested) {
+		case unix.WGDEVICE_A_IFINDEX:
+			index = int(nlenc.Uint32(a.Data))
+		case unix.WGDEVICE_A_IFNAME:
+			name = nlenc.String(a.Data)
+		}
+	}
+
+	if (index == 0) == (name == "") {
+		// Exactly one identifier is required.
+		return nil, unix.EBADR
+	}
+
+	for _, d := range k.devices {
+		if (index != 0 && d.Index == index) || (name != "" && d.Name == name) {
+			return d, nil
+		}
+	}
+
+	return nil, unix.ENODEV
+}
+
+// get produces the messages of a WG_CMD_GET_DEVICE dump for the device
+// identified in b.
+func (k *Kernel) get(b []byte) ([]genetlink.Message, error) {
+	d, err := k.lookup(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Device attributes are only sent in the first message.
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint16(unix.WGDEVICE_A_LISTEN_PORT, uint16(d.ListenPort))
+	ae.Uint32(unix.WGDEVICE_A_FWMARK, uint32(d.FirewallMark))
+	ae.Uint32(unix.WGDEVICE_A_IFINDEX, uint32(d.Index))
+	ae.String(unix.WGDEVICE_A_IFNAME, d.Name)
+	if d.PrivateKey != (wgtypes.Key{}) {
+		ae.Bytes(unix.WGDEVICE_A_PRIVATE_KEY, d.PrivateKey[:])
+		ae.Bytes(unix.WGDEVICE_A_PUBLIC_KEY, d.PublicKey[:])
+	}
+
+	dev, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		msgs []genetlink.Message
+
+		// The position of the next peer and allowed IP to send. A peer whose
+		// allowed IPs did not fit in the previous message is resumed in the
+		// next message with only its public key and remaining allowed IPs.
+		peer, ip int
+		resumed  bool
+	)
+
+	for {
+		var attrs []byte
+		if len(msgs) == 0 {
+			attrs = append(attrs, dev...)
+		}
+
+		// Fill the remainder of the message with as many peers as possible.
+		var (
+			peers    []byte
+			progress bool
+		)
+
+		budget := k.size - msgHeaderLen - len(attrs) - nlaLen(0)
+		for peer < len(d.Peers) {
+			p := &d.Peers[peer]
+
+			head, err := encodePeer(p, !resumed)
+			if err != nil {
+				return nil, err
+			}
+
+			n := nlaLen(len(head))
+			if len(p.AllowedIPs) > 0 {
+				n += nlaLen(0)
+			}
+			if n > budget {
+				break
+			}
+
+			var ips []byte
+			for ip < len(p.AllowedIPs) {
+				b := encodeAllowedIP(uint16(ip), p.AllowedIPs[ip])
+				if n+len(ips)+len(b) > budget {
+					break
+				}
+
+				ips = append(ips, b...)
+				ip++
+			}
+
+			pb := head
+			if len(p.AllowedIPs) > 0 {
+				pb = append(pb, attr(netlink.Nested|unix.WGPEER_A_ALLOWEDIPS, ips)...)
+			}
+
+			peers = append(peers, attr(netlink.Nested|uint16(peer), pb)...)
+			budget -= nlaLen(len(pb))
+
+			if !resumed || len(ips) > 0 {
+				progress = true
+			}
+
+			if ip < len(p.AllowedIPs) {
+				// The remaining allowed IPs are sent in the next message.
+				resumed = true
+				break
+			}
+
+			peer++
+			ip = 0
+			resumed = false
+		}
+
+		if len(peers) > 0 {
+			attrs = append(attrs, attr(netlink.Nested|unix.WGDEVICE_A_PEERS, peers)...)
+		}
+
+		msgs = append(msgs, genetlink.Message{
+			Header: genetlink.Header{
+				Command: unix.WG_CMD_GET_DEVICE,
+				Version: unix.WG_GENL_VERSION,
+			},
+			Data: attrs,
+		})
+
+		if peer == len(d.Peers) {
+			return msgs, nil
+		}
+
+		if !progress {
+			// Not even a single peer or allowed IP fits in a message.
+			return nil, unix.EMSGSIZE
+		}
+	}
+}
+
+// set applies a WG_CMD_SET_DEVICE request in b.
+func (k *Kernel) set(b []byte) error {
+	d, err := k.lookup(b)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := netlink.UnmarshalAttributes(b)
+	if err != nil {
+		return unix.EINVAL
+	}
+
+	// Flags are applied before any other attribute, regardless of order.
+	for _, a := range attrs {
+		if a.Type != unix.WGDEVICE_A_FLAGS {
+			continue
+		}
+
+		flags := nlenc.Uint32(a.Data)
+		if flags&^unix.WGDEVICE_F_REPLACE_PEERS != 0 {
+			return unix.EOPNOTSUPP
+		}
+
+		if flags&unix.WGDEVICE_F_REPLACE_PEERS != 0 {
+			d.Peers = nil
+			d.owners = make(map[string]wgtypes.Key)
+		}
+	}
+
+	for _, a := range attrs {
+		switch a.Type & ^uint16(netlink.Nested) {
+		case unix.WGDEVICE_A_LISTEN_PORT:
+			d.ListenPort = int(nlenc.Uint16(a.Data))
+		case unix.WGDEVICE_A_FWMARK:
+			d.FirewallMark = int(nlenc.Uint32(a.Data))
+		case unix.WGDEVICE_A_PRIVATE_KEY:
+			pk, err := wgtypes.NewKey(a.Data)
+			if err != nil {
+				return unix.EINVAL
+			}
+
+			d.setPrivateKey(pk)
+
+			// A peer with the device's own public key is removed.
+			d.removePeer(d.PublicKey)
+		}
+	}
+
+	for _, a := range attrs {
+		if a.Type&^uint16(netlink.Nested) != unix.WGDEVICE_A_PEERS {
+			continue
+		}
+
+		peers, err := netlink.UnmarshalAttributes(a.Data)
+		if err != nil {
+			return unix.EINVAL
+		}
+
+		for _, p := range peers {
+			if err := d.setPeer(p.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// setPeer applies the nested peer attributes in b.
+func (d *device) setPeer(b []byte) error {
+	attrs, err := netlink.UnmarshalAttributes(b)
+	if err != nil {
+		return unix.EINVAL
+	}
+
+	var (
+		pub   *wgtypes.Key
+		flags uint32
+	)
+
+	for _, a := range attrs {
+		switch a.Type & ^uint16(netlink.Nested) {
+		case unix.WGPEER_A_PUBLIC_KEY:
+			k, err := wgtypes.NewKey(a.Data)
+			if err != nil {
+				return unix.EINVAL
+			}
+			pub = &k
+		case unix.WGPEER_A_FLAGS:
+			flags = nlenc.Uint32(a.Data)
+		case unix.WGPEER_A_PROTOCOL_VERSION:
+			if nlenc.Uint32(a.Data) != 1 {
+				return unix.EPROTONOSUPPORT
+			}
+		}
+	}
+
+	if pub == nil {
+		return unix.EINVAL
+	}
+
+	const valid = unix.WGPEER_F_REMOVE_ME | unix.WGPEER_F_REPLACE_ALLOWEDIPS | unix.WGPEER_F_UPDATE_ONLY
+	if flags&^valid != 0 {
+		return unix.EOPNOTSUPP
+	}
+
+	// A peer with the device's own public key is silently ignored.
+	if d.PrivateKey != (wgtypes.Key{}) && *pub == d.PublicKey {
+		return nil
+	}
+
+	if flags&unix.WGPEER_F_REMOVE_ME != 0 {
+		d.removePeer(*pub)
+		return nil
+	}
+
+	p := d.findPeer(*pub)
+	if p == nil {
+		if flags&unix.WGPEER_F_UPDATE_ONLY != 0 {
+			return nil
+		}
+
+		d.Peers = append(d.Peers, wgtypes.Peer{
+			PublicKey:       *pub,
+			ProtocolVersion: 1,
+		})
+	}
+
+	if flags&unix.WGPEER_F_REPLACE_ALLOWEDIPS != 0 {
+		d.replaceAllowedIPs(*pub)
+	}
+
+	for _, a := range attrs {
+		// Peers may move within d.Peers as allowed IPs are modified, so look
+		// up the peer again for every attribute.
+		p := d.findPeer(*pub)
+
+		switch a.Type & ^uint16(netlink.Nested) {
+		case unix.WGPEER_A_PRESHARED_KEY:
+			psk, err := wgtypes.NewKey(a.Data)
+			if err != nil {
+				return unix.EINVAL
+			}
+			p.PresharedKey = psk
+		case unix.WGPEER_A_ENDPOINT:
+			if ep := parseSockaddr(a.Data); ep != nil {
+				p.Endpoint = ep
+			}
+		case unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL:
+			p.PersistentKeepaliveInterval = time.Duration(nlenc.Uint16(a.Data)) * time.Second
+		case unix.WGPEER_A_ALLOWEDIPS:
+			ips, err := netlink.UnmarshalAttributes(a.Data)
+			if err != nil {
+				return unix.EINVAL
+			}
+
+			for _, ip := range ips {
+				if err := d.setAllowedIP(*pub, ip.Data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// setAllowedIP applies the nested allowed IP attributes in b to the peer
+// identified by pub.
+func (d *device) setAllowedIP(pub wgtypes.Key, b []byte) error {
+	attrs, err := netlink.UnmarshalAttributes(b)
+	if err != nil {
+		return unix.EINVAL
+	}
+
+	var (
+		family uint16
+		ip     net.IP
+		ones   = -1
+		flags  uint32
+	)
+
+	for _, a := range attrs {
+		switch a.Type {
+		case unix.WGALLOWEDIP_A_FAMILY:
+			family = nlenc.Uint16(a.Data)
+		case unix.WGALLOWEDIP_A_IPADDR:
+			ip = net.IP(append([]byte(nil), a.Data...))
+		case unix.WGALLOWEDIP_A_CIDR_MASK:
+			ones = int(nlenc.Uint8(a.Data))
+		case wgAllowedIPAFlags:
+			flags = nlenc.Uint32(a.Data)
+		}
+	}
+
+	if family == 0 || ip == nil || ones < 0 {
+		return unix.EINVAL
+	}
+
+	if flags&^wgAllowedIPFRemoveMe != 0 {
+		return unix.EOPNOTSUPP
+	}
+
+	var bits int
+	switch {
+	case family == unix.AF_INET && len(ip) == net.IPv4len:
+		bits = 32
+	case family == unix.AF_INET6 && len(ip) == net.IPv6len:
+		bits = 128
+	default:
+		return unix.EINVAL
+	}
+
+	if ones > bits {
+		return unix.EINVAL
+	}
+
+	if flags&wgAllowedIPFRemoveMe != 0 {
+		d.removeAllowedIP(pub, ip, ones, bits)
+		return nil
+	}
+
+	d.addAllowedIP(pub, ip, ones, bits)
+	return nil
+}
+
+// A device is the state of a single WireGuard device.
+type device struct {
+	*wgtypes.Device
+
+	// owners maps each allowed IP to the public key of the peer which owns
+	// it, as each allowed IP belongs to exactly one peer of a device.
+	owners map[string]wgtypes.Key
+}
+
+// addAllowedIP adds an allowed IP to the end of the allowed IPs of the peer
+// identified by pub, removing it from the peer which owned it, if any.
+func (d *device) addAllowedIP(pub wgtypes.Key, ip net.IP, ones, bits int) {
+	ipn := prefix(ip, ones, bits)
+	key := ipn.String()
+
+	if owner, ok := d.owners[key]; ok {
+		d.removeAllowedIP(owner, ip, ones, bits)
+	}
+
+	p := d.findPeer(pub)
+	p.AllowedIPs = append(p.AllowedIPs, ipn)
+	d.owners[key] = pub
+}
+
+// removeAllowedIP removes an allowed IP from the peer identified by pub, only
+// if the peer owns it.
+func (d *device) removeAllowedIP(pub wgtypes.Key, ip net.IP, ones, bits int) {
+	ipn := prefix(ip, ones, bits)
+	key := ipn.String()
+	if owner, ok := d.owners[key]; !ok || owner != pub {
+		return
+	}
+
+	delete(d.owners, key)
+
+	p := d.findPeer(pub)
+	for i := range p.AllowedIPs {
+		if p.AllowedIPs[i].String() == key {
+			p.AllowedIPs = append(p.AllowedIPs[:i:i], p.AllowedIPs[i+1:]...)
+			return
+		}
+	}
+}
+
+// replaceAllowedIPs removes all allowed IPs from the peer identified by pub.
+func (d *device) replaceAllowedIPs(pub wgtypes.Key) {
+	p := d.findPeer(pub)
+	for _, ipn := range p.AllowedIPs {
+		delete(d.owners, ipn.String())
+	}
+
+	p.AllowedIPs = nil
+}
+
+// findPeer returns the peer identified by pub, or nil if none exists.
+func (d *device) findPeer(pub wgtypes.Key) *wgtypes.Peer {
+	for i := range d.Peers {
+		if d.Peers[i].PublicKey == pub {
+			return &d.Peers[i]
+		}
+	}
+
+	return nil
+}
+
+// removePeer removes the peer identified by pub, if it exists.
+func (d *device) removePeer(pub wgtypes.Key) {
+	for i := range d.Peers {
+		if d.Peers[i].PublicKey == pub {
+			d.replaceAllowedIPs(pub)
+			d.Peers = append(d.Peers[:i:i], d.Peers[i+1:]...)
+			return
+		}
+	}
+}
+
+// setPrivateKey sets the private and public keys of the device. An all-zero
+// private key removes the device's keys.
+func (d *device) setPrivateKey(pk wgtypes.Key) {
+	d.PrivateKey = pk
+	d.PublicKey = wgtypes.Key{}
+	if pk != (wgtypes.Key{}) {
+		d.PublicKey = pk.PublicKey()
+	}
+}
+
+// prefix returns the network of ip with the specified prefix length.
+func prefix(ip net.IP, ones, bits int) net.IPNet {
+	mask := net.CIDRMask(ones, bits)
+	if bits == 32 {
+		ip = ip.To4()
+	}
+
+	return net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// encodePeer encodes the attributes of p which precede its allowed IPs. When
+// full is false, the peer was already sent in a previous message and only its
+// public key is sent.
+func encodePeer(p *wgtypes.Peer, full bool) ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.WGPEER_A_PUBLIC_KEY, p.PublicKey[:])
+
+	if full {
+		ae.Bytes(unix.WGPEER_A_PRESHARED_KEY, p.PresharedKey[:])
+		ae.Bytes(unix.WGPEER_A_LAST_HANDSHAKE_TIME, encodeTimespec(p.LastHandshakeTime))
+		ae.Uint16(unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL, uint16(p.PersistentKeepaliveInterval/time.Second))
+		ae.Uint64(unix.WGPEER_A_TX_BYTES, uint64(p.TransmitBytes))
+		ae.Uint64(unix.WGPEER_A_RX_BYTES, uint64(p.ReceiveBytes))
+		ae.Uint32(unix.WGPEER_A_PROTOCOL_VERSION, uint32(p.ProtocolVersion))
+
+		if p.Endpoint != nil {
+			ae.Bytes(unix.WGPEER_A_ENDPOINT, encodeSockaddr(p.Endpoint))
+		}
+	}
+
+	return ae.Encode()
+}
+
+// encodeAllowedIP encodes ipn as element i of a netlink array.
+func encodeAllowedIP(i uint16, ipn net.IPNet) []byte {
+	family := uint16(unix.AF_INET6)
+	ip := ipn.IP.To16()
+	if ip4 := ipn.IP.To4(); ip4 != nil && len(ipn.Mask) == net.IPv4len {
+		family = unix.AF_INET
+		ip = ip4
+	}
+
+	ones, _ := ipn.Mask.Size()
+
+	var b []byte
+	b = append(b, attr(unix.WGALLOWEDIP_A_FAMILY, nlenc.Uint16Bytes(family))...)
+	b = append(b, attr(unix.WGALLOWEDIP_A_IPADDR, ip)...)
+	b = append(b, attr(unix.WGALLOWEDIP_A_CIDR_MASK, []byte{uint8(ones)})...)
+
+	return attr(netlink.Nested|i, b)
+}
+
+// encodeSockaddr encodes addr as raw sockaddr_in or sockaddr_in6 bytes.
+func encodeSockaddr(addr *net.UDPAddr) []byte {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b := make([]byte, unix.SizeofSockaddrInet4)
+		copy(b[0:2], nlenc.Uint16Bytes(unix.AF_INET))
+		binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+		copy(b[4:8], ip4)
+		return b
+	}
+
+	b := make([]byte, unix.SizeofSockaddrInet6)
+	copy(b[0:2], nlenc.Uint16Bytes(unix.AF_INET6))
+	binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+	copy(b[8:24], addr.IP.To16())
+	return b
+}
+
+// parseSockaddr parses raw sockaddr_in or sockaddr_in6 bytes, returning nil
+// if b is not a valid sockaddr.
+func parseSockaddr(b []byte) *net.UDPAddr {
+	if len(b) < 2 {
+		return nil
+	}
+
+	family := nlenc.Uint16(b[0:2])
+	switch {
+	case family == unix.AF_INET && len(b) == unix.SizeofSockaddrInet4:
+		return &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), b[4:8]...)),
+			Port: int(binary.BigEndian.Uint16(b[2:4])),
+		}
+	case family == unix.AF_INET6 && len(b) == unix.SizeofSockaddrInet6:
+		return &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), b[8:24]...)),
+			Port: int(binary.BigEndian.Uint16(b[2:4])),
+		}
+	default:
+		return nil
+	}
+}
+
+// encodeTimespec encodes t as a raw __kernel_timespec.
+func encodeTimespec(t time.Time) []byte {
+	var sec, nsec int64
+	if !t.IsZero() {
+		sec, nsec = t.Unix(), int64(t.Nanosecond())
+	}
+
+	b := make([]byte, 0, 16)
+	b = append(b, nlenc.Uint64Bytes(uint64(sec))...)
+	return append(b, nlenc.Uint64Bytes(uint64(nsec))...)
+}
+
+// attr encodes a single netlink attribute with type typ and payload b.
+func attr(typ uint16, b []byte) []byte {
+	out := make([]byte, nlaLen(len(b)))
+	copy(out[0:2], nlenc.Uint16Bytes(uint16(4+len(b))))
+	copy(out[2:4], nlenc.Uint16Bytes(typ))
+	copy(out[4:], b)
+	return out
+}
+
+// nlaLen returns the aligned length of an attribute with an n byte payload.
+func nlaLen(n int) int {
+	return 4 + (n+3)&^3
+}
+
+// copyDevice returns a deep copy of d.
+func copyDevice(d *wgtypes.Device) *wgtypes.Device {
+	out := *d
+	out.Peers = nil
+
+	for _, p := range d.Peers {
+		if p.Endpoint != nil {
+			ep := *p.Endpoint
+			ep.IP = append(net.IP(nil), p.Endpoint.IP...)
+			p.Endpoint = &ep
+		}
+
+		var ipns []net.IPNet
+		for _, ipn := range p.AllowedIPs {
+			ipns = append(ipns, net.IPNet{
+				IP:   append(net.IP(nil), ipn.IP...),
+				Mask: append(net.IPMask(nil), ipn.Mask...),
+			})
+		}
+		p.AllowedIPs = ipns
+
+		out.Peers = append(out.Peers, p)
+	}
+
+	return &out
+}
+
+func panicf(format string, a ...interface{}) {
+	panic(fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,421 @@
+//go:build linux
+// +build linux
+
+package wgctrltest_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgctrltest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestKernelConfigureDevice(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		pub  = priv.PublicKey()
+
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+		peerC = wgtest.MustPublicKey()
+		psk   = wgtest.MustPresharedKey()
+
+		port = 51820
+	)
+
+	tests := []struct {
+		name string
+		base *wgtypes.Device
+		cfgs []wgtypes.Config
+		want *wgtypes.Device
+	}{
+		{
+			name: "device",
+			base: &wgtypes.Device{},
+			cfgs: []wgtypes.Config{{
+				PrivateKey:   &priv,
+				ListenPort:   &port,
+				FirewallMark: intPtr(1),
+			}},
+			want: &wgtypes.Device{
+				PrivateKey:   priv,
+				PublicKey:    pub,
+				ListenPort:   port,
+				FirewallMark: 1,
+			},
+		},
+		{
+			name: "add peer",
+			base: &wgtypes.Device{},
+			cfgs: []wgtypes.Config{{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:                   peerA,
+					PresharedKey:                &psk,
+					Endpoint:                    wgtest.MustUDPAddr("[fd00::1]:51820"),
+					PersistentKeepaliveInterval: durPtr(25 * time.Second),
+					AllowedIPs: []net.IPNet{
+						wgtest.MustCIDR("10.0.0.0/24"),
+						wgtest.MustCIDR("fd00::/64"),
+					},
+				}},
+			}},
+			want: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{
+					PublicKey:                   peerA,
+					PresharedKey:                psk,
+					Endpoint:                    wgtest.MustUDPAddr("[fd00::1]:51820"),
+					PersistentKeepaliveInterval: 25 * time.Second,
+					AllowedIPs: []net.IPNet{
+						wgtest.MustCIDR("10.0.0.0/24"),
+						wgtest.MustCIDR("fd00::/64"),
+					},
+					ProtocolVersion: 1,
+				}},
+			},
+		},
+		{
+			name: "replace peers",
+			base: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{PublicKey: peerA}, {PublicKey: peerB}},
+			},
+			cfgs: []wgtypes.Config{{
+				ReplacePeers: true,
+				Peers:        []wgtypes.PeerConfig{{PublicKey: peerC}},
+			}},
+			want: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{PublicKey: peerC, ProtocolVersion: 1}},
+			},
+		},
+		{
+			name: "update only and remove",
+			base: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{PublicKey: peerA}, {PublicKey: peerB}},
+			},
+			cfgs: []wgtypes.Config{{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   peerA,
+						UpdateOnly:                  true,
+						PersistentKeepaliveInterval: durPtr(10 * time.Second),
+					},
+					{
+						PublicKey: peerB,
+						Remove:    true,
+					},
+					{
+						// Does not exist, so not created.
+						PublicKey:  peerC,
+						UpdateOnly: true,
+					},
+				},
+			}},
+			want: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{
+					PublicKey:                   peerA,
+					PersistentKeepaliveInterval: 10 * time.Second,
+					ProtocolVersion:             1,
+				}},
+			},
+		},
+		{
+			name: "allowed IPs",
+			base: &wgtypes.Device{
+				Peers: []wgtypes.Peer{
+					{
+						PublicKey: peerA,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("10.0.0.0/24"),
+							wgtest.MustCIDR("10.0.1.0/24"),
+							wgtest.MustCIDR("10.0.2.0/24"),
+						},
+					},
+					{
+						PublicKey:  peerB,
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.1.0.0/24")},
+					},
+				},
+			},
+			cfgs: []wgtypes.Config{{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey: peerA,
+						// Removing an IP owned by another peer has no effect.
+						RemoveAllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("10.0.1.0/24"),
+							wgtest.MustCIDR("10.1.0.0/24"),
+						},
+					},
+					{
+						PublicKey:         peerB,
+						ReplaceAllowedIPs: true,
+						// Adding an IP owned by another peer moves it, and
+						// host bits are masked.
+						AllowedIPs: []net.IPNet{{
+							IP:   net.IPv4(10, 0, 2, 1),
+							Mask: net.CIDRMask(24, 32),
+						}},
+					},
+				},
+			}},
+			want: &wgtypes.Device{
+				Peers: []wgtypes.Peer{
+					{
+						PublicKey:       peerA,
+						AllowedIPs:      []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+						ProtocolVersion: 1,
+					},
+					{
+						PublicKey:       peerB,
+						AllowedIPs:      []net.IPNet{wgtest.MustCIDR("10.0.2.0/24")},
+						ProtocolVersion: 1,
+					},
+				},
+			},
+		},
+		{
+			name: "own public key",
+			base: &wgtypes.Device{
+				Peers: []wgtypes.Peer{{PublicKey: pub}, {PublicKey: peerA}},
+			},
+			cfgs: []wgtypes.Config{
+				// Setting the private key removes the peer with the matching
+				// public key.
+				{PrivateKey: &priv},
+				// A peer with the device's own public key is ignored.
+				{Peers: []wgtypes.PeerConfig{{PublicKey: pub}}},
+			},
+			want: &wgtypes.Device{
+				PrivateKey: priv,
+				PublicKey:  pub,
+				Peers:      []wgtypes.Peer{{PublicKey: peerA, ProtocolVersion: 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := wgctrltest.NewKernel(nil)
+			tt.base.Name = "wg0"
+			k.AddDevice(tt.base)
+
+			c, err := wgctrltest.NewClient(k, nil)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			for _, cfg := range tt.cfgs {
+				if err := c.ConfigureDevice("wg0", cfg); err != nil {
+					t.Fatalf("failed to configure device: %v", err)
+				}
+			}
+
+			tt.want.Name = "wg0"
+			tt.want.Index = 1
+			tt.want.Type = wgtypes.LinuxKernel
+
+			d, err := c.Device("wg0")
+			if err != nil {
+				t.Fatalf("failed to get device: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, d); diff != "" {
+				t.Fatalf("unexpected device (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKernelGetDeviceMultipart(t *testing.T) {
+	k := wgctrltest.NewKernel(&wgctrltest.KernelConfig{MessageSize: 512})
+
+	// Enough peers and allowed IPs to split both the peer list and a single
+	// peer's allowed IPs across messages.
+	want := &wgtypes.Device{
+		Name:       "wg0",
+		Index:      10,
+		Type:       wgtypes.LinuxKernel,
+		PrivateKey: wgtest.MustPrivateKey(),
+		ListenPort: 51820,
+	}
+	want.PublicKey = want.PrivateKey.PublicKey()
+
+	for i := 0; i < 8; i++ {
+		p := wgtypes.Peer{
+			PublicKey:         wgtest.MustPublicKey(),
+			Endpoint:          wgtest.MustUDPAddr(fmt.Sprintf("192.0.2.%d:51820", i+1)),
+			LastHandshakeTime: time.Unix(1e9+int64(i), 0),
+			ReceiveBytes:      int64(i),
+			TransmitBytes:     int64(i * 2),
+			ProtocolVersion:   1,
+		}
+
+		for j := 0; j < i*4; j++ {
+			p.AllowedIPs = append(p.AllowedIPs, wgtest.MustCIDR(fmt.Sprintf("fd00:%d::%d/128", i, j)))
+		}
+
+		want.Peers = append(want.Peers, p)
+	}
+
+	k.AddDevice(want)
+
+	// Issue a request directly to inspect the dump.
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.WGDEVICE_A_IFINDEX, 10)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode attributes: %v", err)
+	}
+
+	msgs, err := k.Serve(
+		genetlink.Message{
+			Header: genetlink.Header{Command: unix.WG_CMD_GET_DEVICE},
+			Data:   b,
+		},
+		netlink.Message{
+			Header: netlink.Header{Flags: netlink.Request | netlink.Dump},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if len(msgs) < 4 {
+		t.Fatalf("expected dump with several messages, but got %d", len(msgs))
+	}
+
+	for i, m := range msgs {
+		if l := 16 + 4 + len(m.Data); l > 512 {
+			t.Fatalf("message %d exceeds message size: %d bytes", i, l)
+		}
+	}
+
+	c, err := wgctrltest.NewClient(k, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	d, err := c.DeviceByIndex(10)
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Fatalf("unexpected device (-want +got):\n%s", diff)
+	}
+}
+
+func TestKernelConfigureDeviceBatches(t *testing.T) {
+	k := wgctrltest.NewKernel(nil)
+	k.AddDevice(&wgtypes.Device{Name: "wg0"})
+
+	c, err := wgctrltest.NewClient(k, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	// A configuration too large for a single message must be split into
+	// batches which produce the same result when merged by the kernel.
+	var (
+		peers []wgtypes.PeerConfig
+		want  []wgtypes.Peer
+	)
+
+	for i := 0; i < 3; i++ {
+		pub := wgtest.MustPublicKey()
+
+		var ipns []net.IPNet
+		for j := 0; j < 800; j++ {
+			ipns = append(ipns, wgtest.MustCIDR(fmt.Sprintf("fd00:%d::%x/128", i, j)))
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey:         pub,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        ipns,
+		})
+
+		want = append(want, wgtypes.Peer{
+			PublicKey:       pub,
+			AllowedIPs:      ipns,
+			ProtocolVersion: 1,
+		})
+	}
+
+	if err := c.ConfigureDevice("wg0", wgtypes.Config{
+		ReplacePeers: true,
+		Peers:        peers,
+	}); err != nil {
+		t.Fatalf("failed to configure device: %v", err)
+	}
+
+	if n := k.Requests(unix.WG_CMD_SET_DEVICE); n < 2 {
+		t.Fatalf("expected configuration in multiple batches, but got %d", n)
+	}
+
+	d, err := c.Device("wg0")
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if diff := cmp.Diff(want, d.Peers); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+
+	// The Kernel's own view of the device is identical.
+	kd, ok := k.Device("wg0")
+	if !ok {
+		t.Fatal("device does not exist")
+	}
+
+	if diff := cmp.Diff(d, kd); diff != "" {
+		t.Fatalf("unexpected kernel device (-want +got):\n%s", diff)
+	}
+}
+
+func TestKernelIsNotExist(t *testing.T) {
+	k := wgctrltest.NewKernel(nil)
+	k.AddDevice(&wgtypes.Device{Name: "wg0"})
+
+	c, err := wgctrltest.NewClient(k, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if !k.RemoveDevice("wg0") {
+		t.Fatal("failed to remove device")
+	}
+
+	if _, err := c.Device("wg0"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected is not exist, but got: %v", err)
+	}
+
+	if err := c.ConfigureDevice("wg0", wgtypes.Config{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected is not exist, but got: %v", err)
+	}
+
+	ds, err := c.Devices()
+	if err != nil {
+		t.Fatalf("failed to get devices: %v", err)
+	}
+
+	if len(ds) != 0 {
+		t.Fatalf("expected no devices, but got: %v", ds)
+	}
+}
+
+func durPtr(d time.Duration) *time.Duration { return &d }
+func intPtr(v int) *int                     { return &v }
@@ -0,0 +1,105 @@
+package wgfmt
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Endpoint formats addr as a numeric host and port, with IPv6 hosts in
+// brackets, such as "[2001:db8::1]:51820". The result is accepted by wg(8)
+// and package wgconf.
+func Endpoint(addr *net.UDPAddr) string {
+	host := addr.IP.String()
+	if addr.IP.To4() == nil {
+		host = ntop6(addr.IP)
+		if addr.Zone != "" {
+			host += "%" + addr.Zone
+		}
+	}
+
+	port := strconv.Itoa(addr.Port)
+	if strings.Contains(host, ":") {
+		return "[" + host + "]:" + port
+	}
+
+	return host + ":" + port
+}
+
+// AllowedIP formats ipn in CIDR notation. The result is accepted by wg(8)
+// and package wgconf.
+//
+// Unlike net.IPNet.String, the address family is determined by the mask, so
+// that IPv4-mapped IPv6 prefixes such as ::ffff:0.0.0.0/96 are formatted as
+// IPv6 prefixes which can be parsed again.
+func AllowedIP(ipn net.IPNet) string {
+	ones, bits := ipn.Mask.Size()
+	if bits == 8*net.IPv4len {
+		return fmt.Sprintf("%s/%d", ipn.IP.To4(), ones)
+	}
+
+	return fmt.Sprintf("%s/%d", ntop6(ipn.IP), ones)
+}
+
+// ntop6 formats an IPv6 address exactly like inet_ntop(3) in glibc, which
+// differs from net.IP.String for IPv4-mapped and IPv4-compatible addresses.
+func ntop6(ip net.IP) string {
+	ip = ip.To16()
+	if ip == nil {
+		return "?"
+	}
+
+	var words [8]int
+	for i := range words {
+		words[i] = int(ip[2*i])<<8 | int(ip[2*i+1])
+	}
+
+	// Find the first longest run of at least two zero words.
+	base, length := -1, 0
+	for i := 0; i < len(words); {
+		if words[i] != 0 {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(words) && words[j] == 0 {
+			j++
+		}
+		if j-i > length {
+			base, length = i, j-i
+		}
+		i = j
+	}
+	if length < 2 {
+		base = -1
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(words); i++ {
+		if base != -1 && i >= base && i < base+length {
+			if i == base {
+				b.WriteByte(':')
+			}
+			continue
+		}
+
+		if i != 0 {
+			b.WriteByte(':')
+		}
+
+		if i == 6 && base == 0 && (length == 6 || (length == 5 && words[5] == 0xffff)) {
+			b.WriteString(net.IP(ip[12:16]).String())
+			return b.String()
+		}
+
+		b.WriteString(strconv.FormatInt(int64(words[i]), 16))
+	}
+
+	if base != -1 && base+length == len(words) {
+		b.WriteByte(':')
+	}
+
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package wgfmt_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		addr *net.UDPAddr
+		want string
+	}{
+		{addr: wgtest.MustUDPAddr("192.0.2.1:51820"), want: "192.0.2.1:51820"},
+		{addr: wgtest.MustUDPAddr("[2001:db8::1]:51820"), want: "[2001:db8::1]:51820"},
+		{addr: wgtest.MustUDPAddr("[fe80::1%eth0]:51820"), want: "[fe80::1%eth0]:51820"},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, wgfmt.Endpoint(tt.addr)); diff != "" {
+			t.Fatalf("unexpected endpoint (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestAllowedIP(t *testing.T) {
+	tests := []struct {
+		ipn  net.IPNet
+		want string
+	}{
+		{ipn: wgtest.MustCIDR("192.0.2.0/24"), want: "192.0.2.0/24"},
+		{ipn: net.IPNet{IP: net.IPv4(192, 0, 2, 1), Mask: net.CIDRMask(32, 32)}, want: "192.0.2.1/32"},
+		{ipn: wgtest.MustCIDR("::/0"), want: "::/0"},
+		{ipn: wgtest.MustCIDR("::1/128"), want: "::1/128"},
+		{ipn: wgtest.MustCIDR("fd00::/64"), want: "fd00::/64"},
+		{ipn: wgtest.MustCIDR("2001:db8::1:0:0:1/128"), want: "2001:db8::1:0:0:1/128"},
+		{ipn: wgtest.MustCIDR("2001:db8:0:1:1:1:1:1/128"), want: "2001:db8:0:1:1:1:1:1/128"},
+		{ipn: wgtest.MustCIDR("::ffff:192.0.2.1/128"), want: "::ffff:192.0.2.1/128"},
+		{ipn: wgtest.MustCIDR("::192.0.2.1/128"), want: "::192.0.2.1/128"},
+		{ipn: wgtest.MustCIDR("::ffff:0:c000:201/128"), want: "::ffff:0:c000:201/128"},
+		{ipn: wgtest.MustCIDR("fe80::1:2/128"), want: "fe80::1:2/128"},
+	}
+
+	for _, tt := range tests {
+		got := wgfmt.AllowedIP(tt.ipn)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("unexpected allowed IP (-want +got):\n%s", diff)
+		}
+
+		// The output must always be accepted by ParseAllowedIP.
+		if _, err := wgconf.ParseAllowedIP(got); err != nil {
+			t.Fatalf("failed to parse %q: %v", got, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package wgfmt formats WireGuard device and peer information for people, in
+// the same way as wg(8) show: handshake times such as "1 minute, 3 seconds
+// ago", transfer in binary units such as "1.50 KiB", persistent keepalive
+// intervals such as "every 25 seconds", and endpoints with IPv6 addresses in
+// brackets.
+//
+// Output is always in English with '.' as the decimal separator, regardless
+// of the locale, so that it matches wg(8) and can be compared in tests. A
+// Formatter can optionally add the terminal colors used by wg(8).
+package wgfmt // import "golang.zx2c4.com/wireguard/wgctrl/wgfmt"
@@ -0,0 +1,152 @@
+package wgfmt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Terminal escape sequences used by wg(8).
+const (
+	termRed   = "\x1b[31m"
+	termCyan  = "\x1b[36m"
+	termReset = "\x1b[0m"
+)
+
+// A Formatter formats device and peer information like wg(8). The zero value
+// is a Formatter which produces plain text.
+type Formatter struct {
+	// Color specifies that terminal escape sequences are added to color the
+	// output like wg(8) does when writing to a terminal.
+	Color bool
+}
+
+// c returns the terminal escape sequence s if color output is enabled.
+func (f Formatter) c(s string) string {
+	if !f.Color {
+		return ""
+	}
+
+	return s
+}
+
+// Handshake describes the time elapsed between a peer's last handshake t and
+// now, such as "1 minute, 3 seconds ago". If t is zero or the UNIX epoch,
+// which devices report for peers which have never completed a handshake,
+// Handshake returns "never".
+func (f Formatter) Handshake(now, t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return "never"
+	}
+
+	switch n, sec := now.Unix(), t.Unix(); {
+	case n == sec:
+		return "Now"
+	case n < sec:
+		return "(" + f.c(termRed) + "System clock wound backward; connection problems may ensue." + f.c(termReset) + ")"
+	default:
+		return f.seconds(n-sec) + " ago"
+	}
+}
+
+// Duration describes d in years, days, hours, minutes, and seconds, such as
+// "1 hour, 2 minutes, 3 seconds". Fractions of a second are discarded, and
+// durations of less than one second are described as "0 seconds".
+func (f Formatter) Duration(d time.Duration) string {
+	if d < time.Second {
+		return "0 " + f.c(termCyan) + "seconds" + f.c(termReset)
+	}
+
+	return f.seconds(int64(d / time.Second))
+}
+
+// seconds describes a positive number of seconds.
+func (f Formatter) seconds(left int64) string {
+	const (
+		minute = 60
+		hour   = 60 * minute
+		day    = 24 * hour
+		year   = 365 * day
+	)
+
+	var ss []string
+	for _, u := range []struct {
+		name string
+		n    int64
+	}{
+		{name: "year", n: year},
+		{name: "day", n: day},
+		{name: "hour", n: hour},
+		{name: "minute", n: minute},
+		{name: "second", n: 1},
+	} {
+		v := left / u.n
+		left %= u.n
+		if v == 0 {
+			continue
+		}
+
+		plural := "s"
+		if v == 1 {
+			plural = ""
+		}
+
+		ss = append(ss, fmt.Sprintf("%d %s%s%s%s", v, f.c(termCyan), u.name, plural, f.c(termReset)))
+	}
+
+	return strings.Join(ss, ", ")
+}
+
+// Bytes describes a number of bytes using binary units, such as "1.50 KiB".
+func (f Formatter) Bytes(n int64) string {
+	b := uint64(n)
+
+	const (
+		kib = 1024
+		mib = 1024 * kib
+		gib = 1024 * mib
+		tib = 1024 * gib
+	)
+
+	unit := func(s string) string {
+		return f.c(termCyan) + s + f.c(termReset)
+	}
+
+	switch {
+	case b < kib:
+		return fmt.Sprintf("%d %s", b, unit("B"))
+	case b < mib:
+		return fmt.Sprintf("%.2f %s", float64(b)/kib, unit("KiB"))
+	case b < gib:
+		return fmt.Sprintf("%.2f %s", float64(b)/mib, unit("MiB"))
+	case b < tib:
+		return fmt.Sprintf("%.2f %s", float64(b)/gib, unit("GiB"))
+	default:
+		return fmt.Sprintf("%.2f %s", float64(b)/tib, unit("TiB"))
+	}
+}
+
+// Transfer describes the bytes received from and sent to a peer, such as
+// "1.50 KiB received, 3.00 MiB sent".
+func (f Formatter) Transfer(rx, tx int64) string {
+	return f.Bytes(rx) + " received, " + f.Bytes(tx) + " sent"
+}
+
+// Keepalive describes a persistent keepalive interval, such as "every 25
+// seconds", or "off" if it is disabled.
+func (f Formatter) Keepalive(d time.Duration) string {
+	if d < time.Second {
+		return "off"
+	}
+
+	return "every " + f.Duration(d)
+}
+
+// AllowedIP formats ipn like the package-level AllowedIP function, with the
+// '/' separating the address and prefix length colored.
+func (f Formatter) AllowedIP(ipn net.IPNet) string {
+	s := AllowedIP(ipn)
+	i := strings.LastIndexByte(s, '/')
+	return s[:i] + f.c(termCyan) + "/" + f.c(termReset) + s[i+1:]
+}
@@ -0,0 +1,139 @@
+package wgfmt_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+)
+
+func TestFormatterHandshake(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		color bool
+		want  string
+	}{
+		{
+			name: "never",
+			want: "never",
+		},
+		{
+			name: "epoch",
+			t:    time.Unix(0, 0),
+			want: "never",
+		},
+		{
+			name: "now",
+			t:    now,
+			want: "Now",
+		},
+		{
+			name: "ago",
+			t:    now.Add(-63 * time.Second),
+			want: "1 minute, 3 seconds ago",
+		},
+		{
+			name:  "ago color",
+			t:     now.Add(-63 * time.Second),
+			color: true,
+			want:  "1 \x1b[36mminute\x1b[0m, 3 \x1b[36mseconds\x1b[0m ago",
+		},
+		{
+			name: "backward",
+			t:    now.Add(time.Minute),
+			want: "(System clock wound backward; connection problems may ensue.)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := wgfmt.Formatter{Color: tt.color}
+			if diff := cmp.Diff(tt.want, f.Handshake(now, tt.t)); diff != "" {
+				t.Fatalf("unexpected handshake (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatterDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: "0 seconds"},
+		{d: 1500 * time.Millisecond, want: "1 second"},
+		{d: time.Minute, want: "1 minute"},
+		{d: time.Hour + time.Second, want: "1 hour, 1 second"},
+		{d: 2*24*time.Hour + 2*time.Second, want: "2 days, 2 seconds"},
+		{d: 365*24*time.Hour + 2*time.Minute, want: "1 year, 2 minutes"},
+	}
+
+	var f wgfmt.Formatter
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, f.Duration(tt.d)); diff != "" {
+			t.Fatalf("unexpected duration for %v (-want +got):\n%s", tt.d, diff)
+		}
+	}
+}
+
+func TestFormatterBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1024, want: "1.00 KiB"},
+		{n: 1024*1024 - 1, want: "1024.00 KiB"},
+		{n: 5 * 1024 * 1024 * 1024, want: "5.00 GiB"},
+		{n: 3 * 1024 * 1024 * 1024 * 1024, want: "3.00 TiB"},
+	}
+
+	var f wgfmt.Formatter
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, f.Bytes(tt.n)); diff != "" {
+			t.Fatalf("unexpected bytes for %d (-want +got):\n%s", tt.n, diff)
+		}
+	}
+
+	f.Color = true
+	want := "1.50 \x1b[36mKiB\x1b[0m received, 3 \x1b[36mB\x1b[0m sent"
+	if diff := cmp.Diff(want, f.Transfer(1536, 3)); diff != "" {
+		t.Fatalf("unexpected transfer (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormatterKeepalive(t *testing.T) {
+	var f wgfmt.Formatter
+
+	if diff := cmp.Diff("off", f.Keepalive(0)); diff != "" {
+		t.Fatalf("unexpected keepalive (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("every 25 seconds", f.Keepalive(25*time.Second)); diff != "" {
+		t.Fatalf("unexpected keepalive (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormatterAllowedIP(t *testing.T) {
+	tests := []struct {
+		ipn   net.IPNet
+		color bool
+		want  string
+	}{
+		{ipn: wgtest.MustCIDR("10.0.0.0/24"), want: "10.0.0.0/24"},
+		{ipn: wgtest.MustCIDR("fd00::/64"), color: true, want: "fd00::\x1b[36m/\x1b[0m64"},
+	}
+
+	for _, tt := range tests {
+		f := wgfmt.Formatter{Color: tt.color}
+		if diff := cmp.Diff(tt.want, f.AllowedIP(tt.ipn)); diff != "" {
+			t.Fatalf("unexpected allowed IP (-want +got):\n%s", diff)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Package wgipam allocates tunnel addresses for WireGuard peers.
+//
+// An Allocator hands out host addresses, such as 10.0.0.2/32 and
+// fd00::2/128, from configured IPv4 and IPv6 pools. The allowed IPs of the
+// peers of a device are the source of truth for which addresses are in use,
+// so addresses which were assigned by hand or by other tools are never
+// handed out twice. Reservations made by an Allocator can be persisted to a
+// file, which is locked while it is updated so that multiple processes can
+// share it.
+//
+// ULAPrefix and ULASubnet derive RFC 4193 unique local IPv6 prefixes
+// deterministically from a seed, such as a device's public key, so that
+// each device can have its own IPv6 tunnel subnet without coordination.
+package wgipam // import "golang.zx2c4.com/wireguard/wgctrl/wgipam"
@@ -0,0 +1,113 @@
+package wgipam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgfile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fileVersion is the version of the reservations file format, which is
+// incremented whenever the format changes incompatibly.
+const fileVersion = 1
+
+// A reservationsFile is the JSON representation of reservations.
+type reservationsFile struct {
+	Version      int                `json:"version"`
+	Reservations []reservationEntry `json:"reservations"`
+}
+
+// A reservationEntry is the JSON representation of the addresses reserved
+// for a peer.
+type reservationEntry struct {
+	PublicKey string   `json:"public_key"`
+	Addresses []string `json:"addresses"`
+}
+
+// readReservations reads the reservations file at path. A file which does
+// not exist contains no reservations.
+func readReservations(path string) (reservations, error) {
+	res := make(reservations)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return res, nil
+		}
+
+		return nil, fmt.Errorf("wgipam: failed to read reservations: %v", err)
+	}
+
+	var f reservationsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("wgipam: failed to parse reservations in %s: %v", path, err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("wgipam: unsupported reservations version %d in %s", f.Version, path)
+	}
+
+	for _, e := range f.Reservations {
+		k, err := wgtypes.ParseKey(e.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("wgipam: invalid public key in %s: %v", path, err)
+		}
+
+		ipns := make([]net.IPNet, 0, len(e.Addresses))
+		for _, s := range e.Addresses {
+			ipn, err := wgconf.ParseAllowedIP(s)
+			if err != nil {
+				return nil, fmt.Errorf("wgipam: invalid address of peer %s in %s: %v", k, path, err)
+			}
+
+			ipns = append(ipns, canonical(ipn))
+		}
+
+		res[k] = ipns
+	}
+
+	return res, nil
+}
+
+// writeReservations atomically replaces the reservations file at path with
+// res.
+func writeReservations(path string, res reservations) error {
+	f := reservationsFile{
+		Version:      fileVersion,
+		Reservations: make([]reservationEntry, 0, len(res)),
+	}
+
+	for k, ipns := range res {
+		e := reservationEntry{
+			PublicKey: k.String(),
+			Addresses: make([]string, 0, len(ipns)),
+		}
+		for _, ipn := range ipns {
+			e.Addresses = append(e.Addresses, wgfmt.AllowedIP(ipn))
+		}
+
+		f.Reservations = append(f.Reservations, e)
+	}
+
+	sort.Slice(f.Reservations, func(i, j int) bool {
+		return f.Reservations[i].PublicKey < f.Reservations[j].PublicKey
+	})
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("wgipam: failed to marshal reservations: %v", err)
+	}
+	b = append(b, '\n')
+
+	if err := wgfile.WriteFile(path, b); err != nil {
+		return fmt.Errorf("wgipam: failed to write reservations: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package wgipam
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgfile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Options configures an Allocator.
+type Options struct {
+	// Pools are the IPv4 and IPv6 prefixes from which addresses are
+	// allocated, such as 10.0.0.0/24 and a prefix returned by ULASubnet.
+	// Each peer is allocated one address from each pool. Pools must not
+	// overlap.
+	Pools []net.IPNet
+
+	// Exclude are addresses which are never allocated, such as the tunnel
+	// addresses of the device itself. The first address of each pool which
+	// contains more than one address, and the last address of IPv4 pools
+	// larger than /31, are always excluded.
+	Exclude []net.IP
+
+	// Path is the file in which reservations are persisted. The file is
+	// replaced atomically whenever reservations change, and a lock file
+	// named Path + ".lock" is locked while the file is read and updated. If
+	// empty, reservations are only kept in memory.
+	Path string
+}
+
+// An Allocator allocates tunnel addresses for peers. An Allocator is safe for
+// concurrent use, and Allocators in multiple processes may share a file of
+// reservations.
+type Allocator struct {
+	pools   []net.IPNet
+	exclude []net.IPNet
+	path    string
+
+	mu  sync.Mutex
+	res reservations
+}
+
+// reservations are the addresses reserved for peers.
+type reservations map[wgtypes.Key][]net.IPNet
+
+// New creates an Allocator using the configuration in opts.
+func New(opts *Options) (*Allocator, error) {
+	if len(opts.Pools) == 0 {
+		return nil, fmt.Errorf("wgipam: no address pools")
+	}
+
+	a := &Allocator{
+		path: opts.Path,
+		res:  make(reservations),
+	}
+
+	for _, p := range opts.Pools {
+		p = canonical(p)
+		for _, q := range a.pools {
+			if p.Contains(q.IP) || q.Contains(p.IP) {
+				return nil, fmt.Errorf("wgipam: pools %s and %s overlap", wgfmt.AllowedIP(q), wgfmt.AllowedIP(p))
+			}
+		}
+
+		a.pools = append(a.pools, p)
+	}
+
+	for _, ip := range opts.Exclude {
+		a.exclude = append(a.exclude, host(ip))
+	}
+
+	return a, nil
+}
+
+// Allocate returns the addresses of peer as host prefixes, one from each
+// pool in order, and reserves them.
+//
+// If peer already has a reservation, its addresses are returned. Otherwise,
+// if d is not nil and peer is a peer of d with an allowed IP which is a host
+// prefix in a pool, that address is reserved. Remaining addresses are the
+// first addresses of each pool which are not reserved, excluded, or within
+// the allowed IPs of any other peer of d.
+func (a *Allocator) Allocate(d *wgtypes.Device, peer wgtypes.Key) ([]net.IPNet, error) {
+	var out []net.IPNet
+	err := a.update(func(res reservations) (bool, error) {
+		if ipns, ok := res[peer]; ok {
+			out = append([]net.IPNet(nil), ipns...)
+			return false, nil
+		}
+
+		used := append([]net.IPNet(nil), a.exclude...)
+		for k, ipns := range res {
+			if k != peer {
+				used = append(used, ipns...)
+			}
+		}
+
+		var current []net.IPNet
+		if d != nil {
+			for _, p := range d.Peers {
+				if p.PublicKey == peer {
+					current = p.AllowedIPs
+					continue
+				}
+
+				for _, ipn := range p.AllowedIPs {
+					used = append(used, canonical(ipn))
+				}
+			}
+		}
+
+		for _, pool := range a.pools {
+			ipn, ok := adopt(pool, current, used)
+			if !ok {
+				var err error
+				ipn, err = allocate(pool, used)
+				if err != nil {
+					return false, err
+				}
+			}
+
+			out = append(out, ipn)
+		}
+
+		res[peer] = append([]net.IPNet(nil), out...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Release releases the reservation of peer, if any. Addresses which are
+// still within the allowed IPs of a peer of a device are not allocated
+// again, so a peer should be removed from its device before or after its
+// reservation is released.
+func (a *Allocator) Release(peer wgtypes.Key) error {
+	return a.update(func(res reservations) (bool, error) {
+		if _, ok := res[peer]; !ok {
+			return false, nil
+		}
+
+		delete(res, peer)
+		return true, nil
+	})
+}
+
+// Reservations returns the reserved addresses of all peers.
+func (a *Allocator) Reservations() (map[wgtypes.Key][]net.IPNet, error) {
+	out := make(map[wgtypes.Key][]net.IPNet)
+	err := a.update(func(res reservations) (bool, error) {
+		for k, ipns := range res {
+			out[k] = append([]net.IPNet(nil), ipns...)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// update calls fn with the current reservations, and saves them if fn
+// reports that it changed them. If the Allocator has a file, it is locked
+// for the duration of update.
+func (a *Allocator) update(fn func(res reservations) (bool, error)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.path == "" {
+		_, err := fn(a.res)
+		return err
+	}
+
+	unlock, err := wgfile.Lock(a.path)
+	if err != nil {
+		return fmt.Errorf("wgipam: failed to lock reservations: %v", err)
+	}
+	defer unlock()
+
+	res, err := readReservations(a.path)
+	if err != nil {
+		return err
+	}
+
+	changed, err := fn(res)
+	if err != nil || !changed {
+		return err
+	}
+
+	return writeReservations(a.path, res)
+}
+
+// adopt returns the first host prefix of current within pool which is not
+// used by another peer, and reports whether one was found.
+func adopt(pool net.IPNet, current, used []net.IPNet) (net.IPNet, bool) {
+	for _, ipn := range current {
+		ipn = canonical(ipn)
+		ones, bits := ipn.Mask.Size()
+		if ones != bits || !sameFamily(pool, ipn) || !pool.Contains(ipn.IP) || usedBy(ipn.IP, used) != nil {
+			continue
+		}
+
+		return ipn, true
+	}
+
+	return net.IPNet{}, false
+}
+
+// allocate returns the first available host prefix in pool.
+func allocate(pool net.IPNet, used []net.IPNet) (net.IPNet, error) {
+	ones, bits := pool.Mask.Size()
+	last := lastIP(pool)
+
+	ip := nextIP(pool.IP)
+	if bits == 8*net.IPv4len && ones < 31 {
+		// Skip the network and broadcast addresses.
+		last = prevIP(last)
+	} else if ones == bits {
+		ip = pool.IP
+	}
+
+	for pool.Contains(ip) && bytes.Compare(ip, last) <= 0 {
+		u := usedBy(ip, used)
+		if u == nil {
+			return host(ip), nil
+		}
+
+		// Skip past the entire prefix which is in use.
+		end := lastIP(*u)
+		if bytes.Compare(end, last) >= 0 {
+			break
+		}
+		ip = nextIP(end)
+	}
+
+	return net.IPNet{}, fmt.Errorf("wgipam: no addresses available in pool %s", wgfmt.AllowedIP(pool))
+}
+
+// usedBy returns the prefix in used which contains ip, or nil if none does.
+func usedBy(ip net.IP, used []net.IPNet) *net.IPNet {
+	for i := range used {
+		if sameFamily(used[i], host(ip)) && used[i].Contains(ip) {
+			return &used[i]
+		}
+	}
+
+	return nil
+}
+
+// canonical returns ipn masked, with IPv4 addresses in 4 byte form.
+func canonical(ipn net.IPNet) net.IPNet {
+	ip := ipn.IP.Mask(ipn.Mask)
+	if _, bits := ipn.Mask.Size(); bits == 8*net.IPv4len {
+		ip = ip.To4()
+	}
+
+	return net.IPNet{IP: ip, Mask: ipn.Mask}
+}
+
+// host returns the host prefix of ip.
+func host(ip net.IP) net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+	}
+
+	return net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+// sameFamily reports whether a and b are prefixes of the same address family.
+func sameFamily(a, b net.IPNet) bool {
+	_, abits := a.Mask.Size()
+	_, bbits := b.Mask.Size()
+	return abits == bbits
+}
+
+// lastIP returns the last address of ipn.
+func lastIP(ipn net.IPNet) net.IP {
+	ipn = canonical(ipn)
+	ip := make(net.IP, len(ipn.IP))
+	for i := range ip {
+		ip[i] = ipn.IP[i] | ^ipn.Mask[len(ipn.Mask)-len(ip)+i]
+	}
+
+	return ip
+}
+
+// nextIP returns the address following ip, wrapping around to zero.
+func nextIP(ip net.IP) net.IP {
+	out := append(net.IP(nil), ip...)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]++
+		if out[i] != 0 {
+			break
+		}
+	}
+
+	return out
+}
+
+// prevIP returns the address preceding ip, wrapping around to all ones.
+func prevIP(ip net.IP) net.IP {
+	out := append(net.IP(nil), ip...)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]--
+		if out[i] != 0xff {
+			break
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,292 @@
+package wgipam_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgipam"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestAllocate(t *testing.T) {
+	var (
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+		peerC = wgtest.MustPublicKey()
+		peerD = wgtest.MustPublicKey()
+		peerE = wgtest.MustPublicKey()
+	)
+
+	d := &wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey: peerA,
+				AllowedIPs: []net.IPNet{
+					// Kernels may report IPv4 addresses in 16 byte form.
+					{IP: net.IPv4(10, 0, 0, 2), Mask: net.CIDRMask(32, 32)},
+					wgtest.MustCIDR("fd00::2/128"),
+				},
+			},
+			{
+				PublicKey: peerB,
+				AllowedIPs: []net.IPNet{
+					wgtest.MustCIDR("10.0.0.4/31"),
+					wgtest.MustCIDR("fd00::3/128"),
+					wgtest.MustCIDR("192.168.0.0/16"),
+				},
+			},
+		},
+	}
+
+	a, err := wgipam.New(&wgipam.Options{
+		Pools:   []net.IPNet{wgtest.MustCIDR("10.0.0.0/29"), wgtest.MustCIDR("fd00::/64")},
+		Exclude: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")},
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+
+	allocate := func(peer wgtypes.Key, want ...string) {
+		t.Helper()
+
+		got, err := a.Allocate(d, peer)
+		if err != nil {
+			t.Fatalf("failed to allocate: %v", err)
+		}
+
+		var ipns []net.IPNet
+		for _, s := range want {
+			ipns = append(ipns, wgtest.MustCIDR(s))
+		}
+
+		if diff := cmp.Diff(ipns, got); diff != "" {
+			t.Fatalf("unexpected addresses (-want +got):\n%s", diff)
+		}
+	}
+
+	// Addresses in use by the device are skipped, including entire
+	// prefixes.
+	allocate(peerC, "10.0.0.3/32", "fd00::4/128")
+	allocate(peerD, "10.0.0.6/32", "fd00::5/128")
+
+	// Existing reservations are returned again.
+	allocate(peerC, "10.0.0.3/32", "fd00::4/128")
+
+	// Existing addresses of peers of the device are adopted.
+	allocate(peerA, "10.0.0.2/32", "fd00::2/128")
+
+	// The broadcast address is never allocated.
+	_, err = a.Allocate(d, peerE)
+	const want = "wgipam: no addresses available in pool 10.0.0.0/29"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, but got: %v", want, err)
+	}
+
+	if err := a.Release(peerC); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	allocate(peerE, "10.0.0.3/32", "fd00::4/128")
+
+	res, err := a.Reservations()
+	if err != nil {
+		t.Fatalf("failed to get reservations: %v", err)
+	}
+
+	wantRes := map[wgtypes.Key][]net.IPNet{
+		peerA: {wgtest.MustCIDR("10.0.0.2/32"), wgtest.MustCIDR("fd00::2/128")},
+		peerD: {wgtest.MustCIDR("10.0.0.6/32"), wgtest.MustCIDR("fd00::5/128")},
+		peerE: {wgtest.MustCIDR("10.0.0.3/32"), wgtest.MustCIDR("fd00::4/128")},
+	}
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Fatalf("unexpected reservations (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllocateSmallPools(t *testing.T) {
+	a, err := wgipam.New(&wgipam.Options{
+		Pools: []net.IPNet{wgtest.MustCIDR("10.0.0.1/32"), wgtest.MustCIDR("10.0.1.0/31")},
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+
+	got, err := a.Allocate(nil, wgtest.MustPublicKey())
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+
+	want := []net.IPNet{wgtest.MustCIDR("10.0.0.1/32"), wgtest.MustCIDR("10.0.1.1/32")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected addresses (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllocatorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reservations.json")
+	opts := &wgipam.Options{
+		Pools: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+		Path:  path,
+	}
+
+	// Allocators which share a file, as separate processes would, never
+	// allocate the same address.
+	const n = 8
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		peers = make(map[string]wgtypes.Key)
+	)
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+
+			a, err := wgipam.New(opts)
+			if err != nil {
+				panic(err)
+			}
+
+			k := wgtest.MustPublicKey()
+			ipns, err := a.Allocate(nil, k)
+			if err != nil {
+				panic(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if other, ok := peers[ipns[0].String()]; ok {
+				panic("address " + ipns[0].String() + " allocated twice, also to " + other.String())
+			}
+			peers[ipns[0].String()] = k
+		}()
+	}
+	wg.Wait()
+
+	a, err := wgipam.New(opts)
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+
+	res, err := a.Reservations()
+	if err != nil {
+		t.Fatalf("failed to get reservations: %v", err)
+	}
+
+	want := make(map[wgtypes.Key][]net.IPNet)
+	for s, k := range peers {
+		want[k] = []net.IPNet{wgtest.MustCIDR(s)}
+	}
+
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Fatalf("unexpected reservations (-want +got):\n%s", diff)
+	}
+
+	// The file is replaced atomically, so no temporary files remain.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	if diff := cmp.Diff([]string{"reservations.json", "reservations.json.lock"}, names); diff != "" {
+		t.Fatalf("unexpected files (-want +got):\n%s", diff)
+	}
+}
+
+func TestAllocatorFileFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reservations.json")
+	peer := mustKey("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+
+	a, err := wgipam.New(&wgipam.Options{
+		Pools: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24"), wgtest.MustCIDR("fd00::/64")},
+		Path:  path,
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+
+	if _, err := a.Allocate(nil, peer); err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read reservations: %v", err)
+	}
+
+	const want = `{
+  "version": 1,
+  "reservations": [
+    {
+      "public_key": "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",
+      "addresses": [
+        "10.0.0.1/32",
+        "fd00::1/128"
+      ]
+    }
+  ]
+}
+`
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected reservations file (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 2}`), 0o600); err != nil {
+		t.Fatalf("failed to write reservations: %v", err)
+	}
+
+	_, err = a.Reservations()
+	wantErr := "wgipam: unsupported reservations version 2 in " + path
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("expected error %q, but got: %v", wantErr, err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *wgipam.Options
+		err  string
+	}{
+		{
+			name: "no pools",
+			opts: &wgipam.Options{},
+			err:  "wgipam: no address pools",
+		},
+		{
+			name: "overlap",
+			opts: &wgipam.Options{
+				Pools: []net.IPNet{wgtest.MustCIDR("10.0.0.0/16"), wgtest.MustCIDR("10.0.1.0/24")},
+			},
+			err: "wgipam: pools 10.0.0.0/16 and 10.0.1.0/24 overlap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wgipam.New(tt.opts)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func mustKey(s string) wgtypes.Key {
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return k
+}
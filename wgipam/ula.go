@@ -0,0 +1,32 @@
+package wgipam
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+)
+
+// ULAPrefix returns an RFC 4193 unique local /48 prefix whose 40-bit global
+// ID is derived deterministically from seed, such as the public key of a
+// device, using SHA-256. RFC 4193 suggests generating the global ID
+// randomly; ULAPrefix instead derives the same prefix from the same seed, so
+// that it does not need to be stored, while different seeds are still
+// unlikely to produce the same prefix.
+func ULAPrefix(seed []byte) net.IPNet {
+	sum := sha256.Sum256(seed)
+
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	copy(ip[1:6], sum[:5])
+
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(48, 8*net.IPv6len)}
+}
+
+// ULASubnet returns the /64 prefix with subnet ID id within ULAPrefix(seed).
+func ULASubnet(seed []byte, id uint16) net.IPNet {
+	ipn := ULAPrefix(seed)
+	binary.BigEndian.PutUint16(ipn.IP[6:8], id)
+	ipn.Mask = net.CIDRMask(64, 8*net.IPv6len)
+
+	return ipn
+}
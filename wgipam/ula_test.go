@@ -0,0 +1,26 @@
+package wgipam_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgipam"
+)
+
+func TestULA(t *testing.T) {
+	seed := []byte("wg0")
+
+	if diff := cmp.Diff(wgtest.MustCIDR("fd5f:82c3:bf2a::/48"), wgipam.ULAPrefix(seed)); diff != "" {
+		t.Fatalf("unexpected prefix (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(wgtest.MustCIDR("fd5f:82c3:bf2a:1234::/64"), wgipam.ULASubnet(seed, 0x1234)); diff != "" {
+		t.Fatalf("unexpected subnet (-want +got):\n%s", diff)
+	}
+
+	// Different seeds produce different prefixes.
+	if a, b := wgipam.ULAPrefix([]byte("wg0")), wgipam.ULAPrefix([]byte("wg1")); a.IP.Equal(b.IP) {
+		t.Fatalf("seeds produced the same prefix: %s", a.String())
+	}
+}
@@ -0,0 +1,25 @@
+// Package wgmetrics exports WireGuard device and peer metrics in the
+// OpenMetrics text format, for collection by Prometheus and compatible
+// monitoring systems.
+//
+// A Handler is an http.Handler which can be embedded in an existing HTTP
+// server, or served on its own by the wgctrl exporter subcommand. The
+// following metrics are exported, with a "device" label identifying the
+// interface and, for peer metrics, a "public_key" label identifying the
+// peer:
+//
+//	wireguard_device_info                                 device public key and implementation type
+//	wireguard_device_listen_port                          UDP listening port
+//	wireguard_device_peers                                number of configured peers
+//	wireguard_peer_receive_bytes_total                    bytes received from the peer
+//	wireguard_peer_transmit_bytes_total                   bytes sent to the peer
+//	wireguard_peer_last_handshake_timestamp_seconds       UNIX time of the last handshake, or 0 if never
+//	wireguard_peer_allowed_ips                            number of allowed IP prefixes
+//	wireguard_peer_persistent_keepalive_interval_seconds  keepalive interval, or 0 if disabled
+//	wireguard_exporter_device_errors                      number of devices which could not be fetched
+//	wireguard_exporter_peers_omitted                      number of peers omitted due to Options.MaxPeers
+//
+// Additional labels may be added to every metric, and to the metrics of
+// individual peers, such as a friendly name loaded from a file with
+// ParsePeerLabels.
+package wgmetrics // import "golang.zx2c4.com/wireguard/wgctrl/wgmetrics"
@@ -0,0 +1,362 @@
+package wgmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ContentType is the media type of the responses written by a Handler.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// A Client is the subset of *wgctrl.Client used by a Handler.
+type Client interface {
+	DevicesPartial() ([]*wgtypes.Device, map[string]error, error)
+}
+
+// Options configures a Handler.
+type Options struct {
+	// Labels are added to every metric, such as a label which identifies
+	// the host in a fleet.
+	Labels map[string]string
+
+	// PeerLabels are added to the metrics of the peers with the given
+	// public keys, such as a "name" label with a friendly name for each
+	// peer. Peers which are not present have no additional labels.
+	PeerLabels map[wgtypes.Key]map[string]string
+
+	// MaxPeers specifies the maximum number of peers, across all devices,
+	// whose metrics are written by a scrape. Peers are taken from devices
+	// in order of device name; the metrics of the remaining peers are
+	// omitted and counted by wireguard_exporter_peers_omitted. If zero,
+	// the number of peers is not limited.
+	MaxPeers int
+
+	// MinInterval specifies the minimum interval between queries of the
+	// devices. Scrapes within MinInterval of the previous query are served
+	// the previous response. Concurrent scrapes are always serialized, so
+	// that at most one query is in progress at a time.
+	MinInterval time.Duration
+}
+
+// A Handler is an http.Handler which serves WireGuard metrics in the
+// OpenMetrics text format. Handlers are safe for concurrent use.
+type Handler struct {
+	c           Client
+	maxPeers    int
+	minInterval time.Duration
+	now         func() time.Time
+
+	// labels and peerLabels are pre-rendered label pairs, each beginning
+	// with a comma.
+	labels     string
+	peerLabels map[wgtypes.Key]string
+
+	mu   sync.Mutex
+	last time.Time
+	body []byte
+}
+
+// New creates a Handler which serves the metrics of the devices retrieved by
+// c, using the optional configuration in opts. If opts is nil, the default
+// configuration is used.
+func New(c Client, opts *Options) (*Handler, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.MaxPeers < 0 {
+		return nil, fmt.Errorf("wgmetrics: invalid maximum number of peers: %d", opts.MaxPeers)
+	}
+
+	labels, err := renderLabels(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	peerLabels := make(map[wgtypes.Key]string, len(opts.PeerLabels))
+	for k, ls := range opts.PeerLabels {
+		for name := range ls {
+			if _, ok := opts.Labels[name]; ok {
+				return nil, fmt.Errorf("wgmetrics: label %q of peer %s is also set for every metric", name, k)
+			}
+		}
+
+		s, err := renderLabels(ls)
+		if err != nil {
+			return nil, err
+		}
+
+		peerLabels[k] = s
+	}
+
+	return &Handler{
+		c:           c,
+		maxPeers:    opts.MaxPeers,
+		minInterval: opts.MinInterval,
+		now:         time.Now,
+		labels:      labels,
+		peerLabels:  peerLabels,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := h.scrape()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+}
+
+// scrape returns the current metrics, querying the devices unless the
+// previous response is recent enough to be reused.
+func (h *Handler) scrape() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if h.body != nil && now.Sub(h.last) < h.minInterval {
+		return h.body, nil
+	}
+
+	ds, errs, err := h.c.DevicesPartial()
+	if err != nil {
+		return nil, fmt.Errorf("wgmetrics: failed to retrieve devices: %v", err)
+	}
+
+	h.last = now
+	h.body = h.render(ds, len(errs))
+	return h.body, nil
+}
+
+// A family describes a metric family.
+type family struct {
+	name, typ, unit, help string
+}
+
+// Metric families, in the order in which they are written.
+var (
+	deviceInfo       = family{"wireguard_device", "info", "", "WireGuard device information."}
+	deviceListenPort = family{"wireguard_device_listen_port", "gauge", "", "UDP port on which the device listens."}
+	devicePeers      = family{"wireguard_device_peers", "gauge", "", "Number of peers configured on the device."}
+	peerReceive      = family{"wireguard_peer_receive_bytes", "counter", "bytes", "Bytes received from the peer."}
+	peerTransmit     = family{"wireguard_peer_transmit_bytes", "counter", "bytes", "Bytes sent to the peer."}
+	peerHandshake    = family{"wireguard_peer_last_handshake_timestamp_seconds", "gauge", "seconds", "UNIX time of the last handshake with the peer, or 0 if none has completed."}
+	peerAllowedIPs   = family{"wireguard_peer_allowed_ips", "gauge", "", "Number of allowed IP prefixes of the peer."}
+	peerKeepalive    = family{"wireguard_peer_persistent_keepalive_interval_seconds", "gauge", "seconds", "Persistent keepalive interval of the peer, or 0 if disabled."}
+	deviceErrors     = family{"wireguard_exporter_device_errors", "gauge", "", "Number of devices which could not be retrieved."}
+	peersOmitted     = family{"wireguard_exporter_peers_omitted", "gauge", "", "Number of peers whose metrics were omitted to bound the cost of a scrape."}
+)
+
+// A metricsWriter writes metrics in the OpenMetrics text format.
+type metricsWriter struct {
+	b      bytes.Buffer
+	labels string
+}
+
+// family writes the metadata of f.
+func (w *metricsWriter) family(f family) {
+	fmt.Fprintf(&w.b, "# TYPE %s %s\n", f.name, f.typ)
+	if f.unit != "" {
+		fmt.Fprintf(&w.b, "# UNIT %s %s\n", f.name, f.unit)
+	}
+	fmt.Fprintf(&w.b, "# HELP %s %s\n", f.name, f.help)
+}
+
+// sample writes a sample of f with the pre-rendered labels, which begin with
+// a comma if not empty. suffix is appended to the family name.
+func (w *metricsWriter) sample(f family, suffix, labels, value string) {
+	w.b.WriteString(f.name)
+	w.b.WriteString(suffix)
+
+	if ls := labels + w.labels; ls != "" {
+		w.b.WriteByte('{')
+		w.b.WriteString(ls[1:])
+		w.b.WriteByte('}')
+	}
+
+	w.b.WriteByte(' ')
+	w.b.WriteString(value)
+	w.b.WriteByte('\n')
+}
+
+// A selection is a device and the peers whose metrics are written.
+type selection struct {
+	d      *wgtypes.Device
+	labels string
+	peers  []wgtypes.Peer
+}
+
+// render renders the metrics of ds, and nerrs devices which could not be
+// retrieved.
+func (h *Handler) render(ds []*wgtypes.Device, nerrs int) []byte {
+	ds = append([]*wgtypes.Device(nil), ds...)
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].Name < ds[j].Name
+	})
+
+	var (
+		dps     = make([]selection, 0, len(ds))
+		left    = h.maxPeers
+		omitted int
+	)
+
+	for _, d := range ds {
+		peers := d.Peers
+		if h.maxPeers > 0 {
+			if len(peers) > left {
+				omitted += len(peers) - left
+				peers = peers[:left]
+			}
+			left -= len(peers)
+		}
+
+		dps = append(dps, selection{
+			d:      d,
+			labels: label("device", d.Name),
+			peers:  peers,
+		})
+	}
+
+	w := &metricsWriter{labels: h.labels}
+
+	w.family(deviceInfo)
+	for _, dp := range dps {
+		w.sample(deviceInfo, "_info", dp.labels+label("public_key", dp.d.PublicKey.String())+label("type", dp.d.Type.String()), "1")
+	}
+
+	w.family(deviceListenPort)
+	for _, dp := range dps {
+		w.sample(deviceListenPort, "", dp.labels, strconv.Itoa(dp.d.ListenPort))
+	}
+
+	w.family(devicePeers)
+	for _, dp := range dps {
+		w.sample(devicePeers, "", dp.labels, strconv.Itoa(len(dp.d.Peers)))
+	}
+
+	// Pre-render the labels of each peer, which are shared by all of the
+	// peer families.
+	peerLabels := make([][]string, len(dps))
+	for i, dp := range dps {
+		peerLabels[i] = make([]string, len(dp.peers))
+		for j, p := range dp.peers {
+			peerLabels[i][j] = dp.labels + label("public_key", p.PublicKey.String()) + h.peerLabels[p.PublicKey]
+		}
+	}
+
+	peerFamily := func(f family, suffix string, value func(p *wgtypes.Peer) string) {
+		w.family(f)
+		for i, dp := range dps {
+			for j := range dp.peers {
+				w.sample(f, suffix, peerLabels[i][j], value(&dp.peers[j]))
+			}
+		}
+	}
+
+	peerFamily(peerReceive, "_total", func(p *wgtypes.Peer) string {
+		return strconv.FormatInt(p.ReceiveBytes, 10)
+	})
+	peerFamily(peerTransmit, "_total", func(p *wgtypes.Peer) string {
+		return strconv.FormatInt(p.TransmitBytes, 10)
+	})
+	peerFamily(peerHandshake, "", func(p *wgtypes.Peer) string {
+		return timestamp(p.LastHandshakeTime)
+	})
+	peerFamily(peerAllowedIPs, "", func(p *wgtypes.Peer) string {
+		return strconv.Itoa(len(p.AllowedIPs))
+	})
+	peerFamily(peerKeepalive, "", func(p *wgtypes.Peer) string {
+		return strconv.FormatInt(int64(p.PersistentKeepaliveInterval/time.Second), 10)
+	})
+
+	w.family(deviceErrors)
+	w.sample(deviceErrors, "", "", strconv.Itoa(nerrs))
+
+	w.family(peersOmitted)
+	w.sample(peersOmitted, "", "", strconv.Itoa(omitted))
+
+	w.b.WriteString("# EOF\n")
+	return w.b.Bytes()
+}
+
+// timestamp formats t as fractional UNIX seconds, or 0 if t is zero or the
+// UNIX epoch, which devices report for peers which have never completed a
+// handshake.
+func timestamp(t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return "0"
+	}
+
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+// label renders a label pair, beginning with a comma.
+func label(name, value string) string {
+	return "," + name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// labelEscaper escapes label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// renderLabels validates and renders ls in order of label name.
+func renderLabels(ls map[string]string) (string, error) {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		if err := checkLabel(name); err != nil {
+			return "", err
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(label(name, ls[name]))
+	}
+
+	return b.String(), nil
+}
+
+// checkLabel reports whether name is a valid name for an additional label.
+func checkLabel(name string) error {
+	switch name {
+	case "device", "public_key", "type":
+		return fmt.Errorf("wgmetrics: label %q is reserved", name)
+	}
+
+	valid := name != "" && !strings.HasPrefix(name, "__")
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			valid = false
+		}
+	}
+
+	if !valid {
+		return fmt.Errorf("wgmetrics: invalid label name %q", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,289 @@
+package wgmetrics_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgmetrics"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestHandler(t *testing.T) {
+	var (
+		pub   = mustKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+		peerA = mustKey("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+		peerB = mustKey("HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")
+	)
+
+	devices := func() []*wgtypes.Device {
+		return []*wgtypes.Device{
+			{
+				Name:       "wg1",
+				Type:       wgtypes.Userspace,
+				ListenPort: 51821,
+			},
+			{
+				Name:       "wg0",
+				Type:       wgtypes.LinuxKernel,
+				PublicKey:  pub,
+				ListenPort: 51820,
+				Peers: []wgtypes.Peer{
+					{
+						PublicKey:                   peerA,
+						Endpoint:                    wgtest.MustUDPAddr("[fd00::1]:51820"),
+						AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.0.0/24"), wgtest.MustCIDR("fd00::/64")},
+						LastHandshakeTime:           time.Unix(1699999970, 500000000),
+						ReceiveBytes:                100,
+						TransmitBytes:               200,
+						PersistentKeepaliveInterval: 25 * time.Second,
+					},
+					{
+						PublicKey:         peerB,
+						LastHandshakeTime: time.Unix(0, 0),
+					},
+				},
+			},
+		}
+	}
+
+	const (
+		deviceFamilies = `# TYPE wireguard_device info
+# HELP wireguard_device WireGuard device information.
+wireguard_device_info{device="wg0",public_key="xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",type="Linux kernel"%[1]s} 1
+wireguard_device_info{device="wg1",public_key="AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",type="userspace"%[1]s} 1
+# TYPE wireguard_device_listen_port gauge
+# HELP wireguard_device_listen_port UDP port on which the device listens.
+wireguard_device_listen_port{device="wg0"%[1]s} 51820
+wireguard_device_listen_port{device="wg1"%[1]s} 51821
+# TYPE wireguard_device_peers gauge
+# HELP wireguard_device_peers Number of peers configured on the device.
+wireguard_device_peers{device="wg0"%[1]s} 2
+wireguard_device_peers{device="wg1"%[1]s} 0
+`
+	)
+
+	tests := []struct {
+		name string
+		opts *wgmetrics.Options
+		errs map[string]error
+		want string
+	}{
+		{
+			name: "default",
+			errs: map[string]error{"wg2": errors.New("permission denied")},
+			want: strings.ReplaceAll(deviceFamilies, "%[1]s", "") + `# TYPE wireguard_peer_receive_bytes counter
+# UNIT wireguard_peer_receive_bytes bytes
+# HELP wireguard_peer_receive_bytes Bytes received from the peer.
+wireguard_peer_receive_bytes_total{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} 100
+wireguard_peer_receive_bytes_total{device="wg0",public_key="HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="} 0
+# TYPE wireguard_peer_transmit_bytes counter
+# UNIT wireguard_peer_transmit_bytes bytes
+# HELP wireguard_peer_transmit_bytes Bytes sent to the peer.
+wireguard_peer_transmit_bytes_total{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} 200
+wireguard_peer_transmit_bytes_total{device="wg0",public_key="HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="} 0
+# TYPE wireguard_peer_last_handshake_timestamp_seconds gauge
+# UNIT wireguard_peer_last_handshake_timestamp_seconds seconds
+# HELP wireguard_peer_last_handshake_timestamp_seconds UNIX time of the last handshake with the peer, or 0 if none has completed.
+wireguard_peer_last_handshake_timestamp_seconds{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} 1699999970.5
+wireguard_peer_last_handshake_timestamp_seconds{device="wg0",public_key="HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="} 0
+# TYPE wireguard_peer_allowed_ips gauge
+# HELP wireguard_peer_allowed_ips Number of allowed IP prefixes of the peer.
+wireguard_peer_allowed_ips{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} 2
+wireguard_peer_allowed_ips{device="wg0",public_key="HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="} 0
+# TYPE wireguard_peer_persistent_keepalive_interval_seconds gauge
+# UNIT wireguard_peer_persistent_keepalive_interval_seconds seconds
+# HELP wireguard_peer_persistent_keepalive_interval_seconds Persistent keepalive interval of the peer, or 0 if disabled.
+wireguard_peer_persistent_keepalive_interval_seconds{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} 25
+wireguard_peer_persistent_keepalive_interval_seconds{device="wg0",public_key="HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw="} 0
+# TYPE wireguard_exporter_device_errors gauge
+# HELP wireguard_exporter_device_errors Number of devices which could not be retrieved.
+wireguard_exporter_device_errors 1
+# TYPE wireguard_exporter_peers_omitted gauge
+# HELP wireguard_exporter_peers_omitted Number of peers whose metrics were omitted to bound the cost of a scrape.
+wireguard_exporter_peers_omitted 0
+# EOF
+`,
+		},
+		{
+			name: "labels",
+			opts: &wgmetrics.Options{
+				Labels: map[string]string{"host": "gw1"},
+				PeerLabels: map[wgtypes.Key]map[string]string{
+					peerA: {"name": "Alice's \"laptop\"", "site": `fra\1`},
+				},
+				MaxPeers: 1,
+			},
+			want: strings.ReplaceAll(deviceFamilies, "%[1]s", `,host="gw1"`) + `# TYPE wireguard_peer_receive_bytes counter
+# UNIT wireguard_peer_receive_bytes bytes
+# HELP wireguard_peer_receive_bytes Bytes received from the peer.
+wireguard_peer_receive_bytes_total{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",name="Alice's \"laptop\"",site="fra\\1",host="gw1"} 100
+# TYPE wireguard_peer_transmit_bytes counter
+# UNIT wireguard_peer_transmit_bytes bytes
+# HELP wireguard_peer_transmit_bytes Bytes sent to the peer.
+wireguard_peer_transmit_bytes_total{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",name="Alice's \"laptop\"",site="fra\\1",host="gw1"} 200
+# TYPE wireguard_peer_last_handshake_timestamp_seconds gauge
+# UNIT wireguard_peer_last_handshake_timestamp_seconds seconds
+# HELP wireguard_peer_last_handshake_timestamp_seconds UNIX time of the last handshake with the peer, or 0 if none has completed.
+wireguard_peer_last_handshake_timestamp_seconds{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",name="Alice's \"laptop\"",site="fra\\1",host="gw1"} 1699999970.5
+# TYPE wireguard_peer_allowed_ips gauge
+# HELP wireguard_peer_allowed_ips Number of allowed IP prefixes of the peer.
+wireguard_peer_allowed_ips{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",name="Alice's \"laptop\"",site="fra\\1",host="gw1"} 2
+# TYPE wireguard_peer_persistent_keepalive_interval_seconds gauge
+# UNIT wireguard_peer_persistent_keepalive_interval_seconds seconds
+# HELP wireguard_peer_persistent_keepalive_interval_seconds Persistent keepalive interval of the peer, or 0 if disabled.
+wireguard_peer_persistent_keepalive_interval_seconds{device="wg0",public_key="TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",name="Alice's \"laptop\"",site="fra\\1",host="gw1"} 25
+# TYPE wireguard_exporter_device_errors gauge
+# HELP wireguard_exporter_device_errors Number of devices which could not be retrieved.
+wireguard_exporter_device_errors{host="gw1"} 0
+# TYPE wireguard_exporter_peers_omitted gauge
+# HELP wireguard_exporter_peers_omitted Number of peers whose metrics were omitted to bound the cost of a scrape.
+wireguard_exporter_peers_omitted{host="gw1"} 1
+# EOF
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := wgmetrics.New(&testClient{devices: devices(), errs: tt.errs}, tt.opts)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+
+			code, ctype, body := get(t, h)
+			if code != http.StatusOK {
+				t.Fatalf("unexpected status: %d: %s", code, body)
+			}
+			if ctype != wgmetrics.ContentType {
+				t.Fatalf("unexpected content type: %q", ctype)
+			}
+
+			if diff := cmp.Diff(tt.want, body); diff != "" {
+				t.Fatalf("unexpected metrics (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandlerMinInterval(t *testing.T) {
+	c := &testClient{devices: []*wgtypes.Device{{Name: "wg0"}}}
+	h, err := wgmetrics.New(c, &wgmetrics.Options{MinInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	_, _, first := get(t, h)
+	c.devices = []*wgtypes.Device{{Name: "wg1"}}
+	_, _, second := get(t, h)
+
+	if diff := cmp.Diff(1, c.calls); diff != "" {
+		t.Fatalf("unexpected number of queries (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(first, second); diff != "" {
+		t.Fatalf("unexpected second response (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	h, err := wgmetrics.New(&testClient{err: errors.New("netlink: permission denied")}, nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	code, _, body := get(t, h)
+	if diff := cmp.Diff(http.StatusInternalServerError, code); diff != "" {
+		t.Fatalf("unexpected status (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("wgmetrics: failed to retrieve devices: netlink: permission denied\n", body); diff != "" {
+		t.Fatalf("unexpected body (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *wgmetrics.Options
+		err  string
+	}{
+		{
+			name: "max peers",
+			opts: &wgmetrics.Options{MaxPeers: -1},
+			err:  "wgmetrics: invalid maximum number of peers: -1",
+		},
+		{
+			name: "label name",
+			opts: &wgmetrics.Options{Labels: map[string]string{"0host": "gw1"}},
+			err:  `wgmetrics: invalid label name "0host"`,
+		},
+		{
+			name: "reserved",
+			opts: &wgmetrics.Options{Labels: map[string]string{"device": "wg0"}},
+			err:  `wgmetrics: label "device" is reserved`,
+		},
+		{
+			name: "duplicate",
+			opts: &wgmetrics.Options{
+				Labels: map[string]string{"host": "gw1"},
+				PeerLabels: map[wgtypes.Key]map[string]string{
+					{}: {"host": "gw2"},
+				},
+			},
+			err: `wgmetrics: label "host" of peer AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA= is also set for every metric`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wgmetrics.New(&testClient{}, tt.opts)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// get performs a GET request against h and returns the response's status
+// code, content type, and body.
+func get(t *testing.T, h http.Handler) (int, string, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	res := w.Result()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	return res.StatusCode, res.Header.Get("Content-Type"), string(b)
+}
+
+type testClient struct {
+	devices []*wgtypes.Device
+	errs    map[string]error
+	err     error
+	calls   int
+}
+
+func (c *testClient) DevicesPartial() ([]*wgtypes.Device, map[string]error, error) {
+	c.calls++
+	return c.devices, c.errs, c.err
+}
+
+func mustKey(s string) wgtypes.Key {
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return k
+}
@@ -0,0 +1,78 @@
+package wgmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ParsePeerLabels parses a file of additional labels for peers, for use in
+// Options.PeerLabels. Each line contains a peer's base64 public key followed
+// by either whitespace-separated name=value pairs, or a friendly name for the
+// peer which is used as the value of the "name" label:
+//
+//	# Comments and blank lines are ignored.
+//	xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg= Alice's laptop
+//	TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0= name=gateway site=fra1
+func ParsePeerLabels(r io.Reader) (map[wgtypes.Key]map[string]string, error) {
+	out := make(map[wgtypes.Key]map[string]string)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		k, err := wgtypes.ParseKey(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("wgmetrics: line %d: invalid public key: %v", n, err)
+		}
+		if _, ok := out[k]; ok {
+			return nil, fmt.Errorf("wgmetrics: line %d: duplicate peer %s", n, k)
+		}
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("wgmetrics: line %d: no labels for peer %s", n, k)
+		}
+
+		// The public key ends in '=', so only the remaining fields determine
+		// whether the line contains a friendly name or label pairs.
+		ls := make(map[string]string)
+		if rest := strings.Join(fields[1:], " "); !strings.Contains(rest, "=") {
+			ls["name"] = rest
+			out[k] = ls
+			continue
+		}
+
+		for _, f := range fields[1:] {
+			name, value, ok := strings.Cut(f, "=")
+			if !ok {
+				return nil, fmt.Errorf("wgmetrics: line %d: invalid label %q", n, f)
+			}
+			if err := checkLabel(name); err != nil {
+				return nil, fmt.Errorf("wgmetrics: line %d: %v", n, strings.TrimPrefix(err.Error(), "wgmetrics: "))
+			}
+			if _, ok := ls[name]; ok {
+				return nil, fmt.Errorf("wgmetrics: line %d: duplicate label %q", n, name)
+			}
+
+			ls[name] = value
+		}
+
+		out[k] = ls
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("wgmetrics: failed to read peer labels: %v", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package wgmetrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgmetrics"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParsePeerLabels(t *testing.T) {
+	const (
+		keyA = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+		keyB = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+	)
+
+	tests := []struct {
+		name string
+		s    string
+		want map[wgtypes.Key]map[string]string
+		err  string
+	}{
+		{
+			name: "OK",
+			s: `# Peers
+` + keyA + `   Alice's laptop  # the new one
+
+` + keyB + "\tname=gateway site=fra1 empty=\n",
+			want: map[wgtypes.Key]map[string]string{
+				mustKey(keyA): {"name": "Alice's laptop"},
+				mustKey(keyB): {"name": "gateway", "site": "fra1", "empty": ""},
+			},
+		},
+		{
+			name: "empty",
+			want: map[wgtypes.Key]map[string]string{},
+		},
+		{
+			name: "bad key",
+			s:    "\nfoo alice\n",
+			err:  "wgmetrics: line 2: invalid public key: wgtypes: failed to parse base64-encoded key: illegal base64 data at input byte 0",
+		},
+		{
+			name: "duplicate peer",
+			s:    keyA + " alice\n" + keyA + " bob\n",
+			err:  "wgmetrics: line 2: duplicate peer " + keyA,
+		},
+		{
+			name: "no labels",
+			s:    keyA + "\n",
+			err:  "wgmetrics: line 1: no labels for peer " + keyA,
+		},
+		{
+			name: "mixed",
+			s:    keyA + " name=alice laptop\n",
+			err:  `wgmetrics: line 1: invalid label "laptop"`,
+		},
+		{
+			name: "reserved",
+			s:    keyA + " device=wg0\n",
+			err:  `wgmetrics: line 1: label "device" is reserved`,
+		},
+		{
+			name: "duplicate label",
+			s:    keyA + " name=a name=b\n",
+			err:  `wgmetrics: line 1: duplicate label "name"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wgmetrics.ParsePeerLabels(strings.NewReader(tt.s))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, but got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected labels (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package wgquick
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ConfigDir is the directory which contains configuration files named by
+// interface name.
+const ConfigDir = "/etc/wireguard"
+
+// A Config is a wg-quick(8) configuration.
+type Config struct {
+	// Name is the name of the interface, which Load derives from the name of
+	// the configuration file.
+	Name string
+
+	// Path is the path of the configuration file, which is overwritten on
+	// Down when SaveConfig is set.
+	Path string
+
+	// Device is the WireGuard configuration of the interface, as returned by
+	// wgconf.Parse. It is applied with the semantics of wg(8) setconf.
+	Device wgtypes.Config
+
+	// Addresses are the IP addresses assigned to the interface, each with the
+	// prefix length of its subnet.
+	Addresses []net.IPNet
+
+	// DNS and DNSSearch are the DNS servers and search domains used while the
+	// interface is up.
+	DNS       []net.IP
+	DNSSearch []string
+
+	// MTU is the MTU of the interface. If zero, the MTU is determined from
+	// the routes to the peers' endpoints.
+	MTU int
+
+	// Table is the routing table to which routes are added: "off" to add no
+	// routes, "auto" or empty for the main table with special handling of
+	// default routes, or a table number or "main".
+	Table string
+
+	// PreUp, PostUp, PreDown, and PostDown are shell commands which are run
+	// before and after the interface is brought up or down. Occurrences of
+	// "%i" are replaced with the name of the interface.
+	PreUp, PostUp, PreDown, PostDown []string
+
+	// SaveConfig specifies that the configuration of the interface is saved
+	// to Path when the interface is brought down.
+	SaveConfig bool
+}
+
+// validName matches valid interface names.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
+// Load loads a configuration from a file, specified either by an interface
+// name such as "wg0", in which case the file is loaded from ConfigDir, or by
+// a path to a file named after an interface, such as "/tmp/wg0.conf".
+func Load(s string, opts *wgconf.Options) (*Config, error) {
+	path := s
+	if validName.MatchString(s) {
+		path = filepath.Join(ConfigDir, s+".conf")
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".conf")
+	if !strings.HasSuffix(path, ".conf") || !validName.MatchString(name) {
+		return nil, fmt.Errorf("wgquick: the config file must be a valid interface name, followed by .conf: %q", s)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := Parse(f, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Name, cfg.Path = name, path
+	return cfg, nil
+}
+
+// Parse parses a wg-quick(8) configuration file, which is a wg(8)
+// configuration file with additional keys in its Interface section. The
+// Name and Path fields of the returned Config are not set.
+func Parse(r io.Reader, opts *wgconf.Options) (*Config, error) {
+	var (
+		cfg Config
+
+		// wg is the remaining configuration which is passed to wgconf.Parse,
+		// with wg-quick keys replaced by empty lines to preserve line numbers.
+		wg    bytes.Buffer
+		iface bool
+	)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+
+		stripped := line
+		if i := strings.IndexByte(stripped, '#'); i != -1 {
+			stripped = stripped[:i]
+		}
+
+		key, value, _ := strings.Cut(stripped, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(key, "[") {
+			iface = strings.EqualFold(key, "[Interface]")
+		}
+
+		if !iface {
+			wg.WriteString(line + "\n")
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "address":
+			err = parseList(value, func(s string) error {
+				addr, err := parseAddress(s)
+				cfg.Addresses = append(cfg.Addresses, addr)
+				return err
+			})
+		case "dns":
+			err = parseList(value, func(s string) error {
+				if ip := net.ParseIP(s); ip != nil {
+					cfg.DNS = append(cfg.DNS, ip)
+				} else {
+					cfg.DNSSearch = append(cfg.DNSSearch, s)
+				}
+				return nil
+			})
+		case "mtu":
+			cfg.MTU, err = strconv.Atoi(value)
+			if err == nil && (cfg.MTU < 68 || cfg.MTU > 65535) {
+				err = fmt.Errorf("MTU out of range: %d", cfg.MTU)
+			}
+		case "table":
+			cfg.Table = value
+			_, err = parseTable(value)
+		case "preup":
+			cfg.PreUp = append(cfg.PreUp, value)
+		case "postup":
+			cfg.PostUp = append(cfg.PostUp, value)
+		case "predown":
+			cfg.PreDown = append(cfg.PreDown, value)
+		case "postdown":
+			cfg.PostDown = append(cfg.PostDown, value)
+		case "saveconfig":
+			switch value {
+			case "true":
+				cfg.SaveConfig = true
+			case "false":
+				cfg.SaveConfig = false
+			default:
+				err = fmt.Errorf("SaveConfig must be true or false: %q", value)
+			}
+		default:
+			wg.WriteString(line + "\n")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wgquick: line %d: %v", n, err)
+		}
+
+		wg.WriteString("\n")
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("wgquick: failed to read configuration: %v", err)
+	}
+
+	dcfg, err := wgconf.Parse(&wg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Device = *dcfg
+	return &cfg, nil
+}
+
+// parseList calls fn for each element of a comma-separated list.
+func parseList(value string, fn func(s string) error) error {
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseAddress parses an interface address with an optional prefix length.
+// Unlike an allowed IP, the host bits of the address are preserved.
+func parseAddress(s string) (net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ipn, err := wgconf.ParseAllowedIP(s)
+		if err != nil {
+			return net.IPNet{}, fmt.Errorf("invalid address %q", s)
+		}
+
+		return ipn, nil
+	}
+
+	ip, ipn, err := net.ParseCIDR(s)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("invalid address %q", s)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return net.IPNet{IP: ip, Mask: ipn.Mask}, nil
+}
+
+// Routing table numbers.
+const (
+	tableAuto = -1
+	tableOff  = -2
+	tableMain = 254
+)
+
+// parseTable parses the Table key into a routing table number, tableAuto, or
+// tableOff.
+func parseTable(s string) (int, error) {
+	switch s {
+	case "", "auto":
+		return tableAuto, nil
+	case "off":
+		return tableOff, nil
+	case "main":
+		return tableMain, nil
+	}
+
+	table, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || table == 0 {
+		return 0, fmt.Errorf("invalid routing table: %q", s)
+	}
+
+	return int(table), nil
+}
+
+// marshal formats a configuration to save for the interface with the current
+// device configuration d, addresses, and MTU, like wg-quick save.
+func (c *Config) marshal(d *wgtypes.Device, addrs []net.IPNet, mtu int) []byte {
+	var b bytes.Buffer
+
+	b.WriteString("[Interface]\n")
+	for _, addr := range addrs {
+		ones, _ := addr.Mask.Size()
+		fmt.Fprintf(&b, "Address = %s/%d\n", addr.IP, ones)
+	}
+	for _, ip := range c.DNS {
+		fmt.Fprintf(&b, "DNS = %s\n", ip)
+	}
+	for _, s := range c.DNSSearch {
+		fmt.Fprintf(&b, "DNS = %s\n", s)
+	}
+	if c.MTU != 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", mtu)
+	}
+	if c.Table != "" {
+		fmt.Fprintf(&b, "Table = %s\n", c.Table)
+	}
+	if c.SaveConfig {
+		b.WriteString("SaveConfig = true\n")
+	}
+	for _, hooks := range []struct {
+		key   string
+		hooks []string
+	}{
+		{key: "PreUp", hooks: c.PreUp},
+		{key: "PostUp", hooks: c.PostUp},
+		{key: "PreDown", hooks: c.PreDown},
+		{key: "PostDown", hooks: c.PostDown},
+	} {
+		for _, h := range hooks.hooks {
+			fmt.Fprintf(&b, "%s = %s\n", hooks.key, h)
+		}
+	}
+
+	// The device's configuration follows the wg-quick keys in the Interface
+	// section.
+	b.Write(bytes.TrimPrefix(wgconf.Marshal(d), []byte("[Interface]\n")))
+	return b.Bytes()
+}
@@ -0,0 +1,151 @@
+package wgquick_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestParse(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		pub  = wgtest.MustPublicKey()
+	)
+
+	tests := []struct {
+		name string
+		s    string
+		cfg  *wgquick.Config
+		err  string
+	}{
+		{
+			name: "OK",
+			s: `
+[Interface]
+Address = 10.0.0.2/24, fd00::2
+DNS = 192.0.2.53, example.com
+MTU = 1420
+Table = off
+PrivateKey = ` + priv.String() + `
+PreUp = echo %i up # comment
+PostUp = true
+PreDown = true
+PostDown = echo %i down
+SaveConfig = true
+
+[Peer]
+PublicKey = ` + pub.String() + `
+AllowedIPs = 0.0.0.0/0
+`,
+			cfg: &wgquick.Config{
+				Device: wgtypes.Config{
+					PrivateKey: &priv,
+					Peers: []wgtypes.PeerConfig{{
+						PublicKey:         pub,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+					}},
+				},
+				Addresses: []net.IPNet{
+					{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)},
+					{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(128, 128)},
+				},
+				DNS:        []net.IP{net.ParseIP("192.0.2.53")},
+				DNSSearch:  []string{"example.com"},
+				MTU:        1420,
+				Table:      "off",
+				PreUp:      []string{"echo %i up"},
+				PostUp:     []string{"true"},
+				PreDown:    []string{"true"},
+				PostDown:   []string{"echo %i down"},
+				SaveConfig: true,
+			},
+		},
+		{
+			name: "bad MTU",
+			s:    "[Interface]\nMTU = 10\n",
+			err:  "wgquick: line 2: MTU out of range: 10",
+		},
+		{
+			name: "bad table",
+			s:    "[Interface]\nTable = foo\n",
+			err:  `wgquick: line 2: invalid routing table: "foo"`,
+		},
+		{
+			name: "bad address",
+			s:    "[Interface]\nAddress = 10.0.0.1/33\n",
+			err:  `wgquick: line 2: invalid address "10.0.0.1/33"`,
+		},
+		{
+			name: "bad SaveConfig",
+			s:    "[Interface]\nSaveConfig = yes\n",
+			err:  `wgquick: line 2: SaveConfig must be true or false: "yes"`,
+		},
+		{
+			name: "wg-quick key in peer",
+			s:    "[Peer]\nPublicKey = " + pub.String() + "\nMTU = 1420\n",
+			err:  "wgconf: line 3: unrecognized line: MTU=1420",
+		},
+		{
+			name: "line numbers preserved",
+			s:    "[Interface]\nAddress = 10.0.0.1\nMTU = 1420\nListenPort = foo\n",
+			err:  "wgconf: line 4: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := wgquick.Parse(strings.NewReader(tt.s), nil)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("expected error with prefix %q, but got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.cfg, cfg); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg-test0.conf")
+	if err := os.WriteFile(path, []byte("[Interface]\nListenPort = 51820\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := wgquick.Load(path, nil)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	if diff := cmp.Diff("wg-test0", cfg.Name); diff != "" {
+		t.Fatalf("unexpected name (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(path, cfg.Path); diff != "" {
+		t.Fatalf("unexpected path (-want +got):\n%s", diff)
+	}
+
+	for _, s := range []string{
+		filepath.Join(dir, "wg0.txt"),
+		filepath.Join(dir, "this-name-is-too-long.conf"),
+		filepath.Join(dir, "wg 0.conf"),
+	} {
+		if _, err := wgquick.Load(s, nil); err == nil || !strings.Contains(err.Error(), "valid interface name") {
+			t.Fatalf("expected invalid name error for %q, but got: %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package wgquick manages the lifecycle of WireGuard interfaces described by
+// wg-quick(8) configuration files, using wgctrl and the operating system's
+// networking APIs rather than shell commands.
+//
+// Up and Down perform the same steps as wg-quick up and wg-quick down. PlanUp
+// and PlanDown return those steps without performing them, so that they can
+// be inspected or printed.
+//
+// Firewall rules which wg-quick installs to protect default routes are not
+// managed by this package.
+package wgquick // import "golang.zx2c4.com/wireguard/wgctrl/wgquick"
@@ -0,0 +1,646 @@
+package wgquick
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgfile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Client configures WireGuard devices. *wgctrl.Client implements Client.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// A System configures the operating system's network stack and runs hook
+// commands on behalf of Up and Down.
+//
+// Errors which indicate that an object already exists or does not exist
+// should match os.ErrExist or os.ErrNotExist, respectively, with errors.Is.
+type System interface {
+	// LinkExists reports whether a network interface exists.
+	LinkExists(name string) (bool, error)
+
+	// AddLink creates a WireGuard network interface, and DeleteLink
+	// removes a network interface along with its addresses and routes.
+	AddLink(name string) error
+	DeleteLink(name string) error
+
+	// SetLinkUp sets the MTU of a network interface and brings it up.
+	SetLinkUp(name string, mtu int) error
+
+	// LinkMTU returns the MTU of a network interface.
+	LinkMTU(name string) (int, error)
+
+	// AddAddress assigns an address to a network interface, and Addresses
+	// returns the addresses assigned to a network interface.
+	AddAddress(name string, addr net.IPNet) error
+	Addresses(name string) ([]net.IPNet, error)
+
+	// RouteMTU returns the MTU of the route which would be used to reach
+	// dst, or 0 if there is no route.
+	RouteMTU(dst net.IP) (int, error)
+
+	// TableInUse reports whether a routing table contains any routes.
+	TableInUse(table int) (bool, error)
+
+	// AddRoute adds a route.
+	AddRoute(r Route) error
+
+	// AddRule and DeleteRule add and delete policy routing rules. DeleteRule
+	// deletes the first rule which matches the fields which are set in r.
+	AddRule(r Rule) error
+	DeleteRule(r Rule) error
+
+	// SetSysctl sets a kernel parameter, such as
+	// "net.ipv4.conf.all.src_valid_mark".
+	SetSysctl(key, value string) error
+
+	// SetDNS configures DNS servers and search domains for a network
+	// interface, and UnsetDNS removes them.
+	SetDNS(name string, servers []net.IP, search []string) error
+	UnsetDNS(name string) error
+
+	// Run runs a hook command with a shell.
+	Run(command string) error
+}
+
+// A Route is a route to a destination through a network interface.
+type Route struct {
+	Destination net.IPNet
+	Device      string
+
+	// Table is the routing table for the route, or 0 for the main table.
+	Table int
+}
+
+// A Rule is a policy routing rule of the kind wg-quick adds to route all
+// traffic through an interface.
+type Rule struct {
+	// IPv6 specifies an IPv6 rule rather than an IPv4 rule.
+	IPv6 bool
+
+	// Table is the routing table which the rule looks up.
+	Table int
+
+	// NotFwmark, if not zero, specifies that the rule only matches packets
+	// which do not have this firewall mark.
+	NotFwmark int
+
+	// SuppressDefault specifies that default routes in Table are ignored,
+	// like "suppress_prefixlength 0".
+	SuppressDefault bool
+}
+
+// Options configures Up and Down. A nil *Options uses the default value for
+// each field.
+type Options struct {
+	// System configures the network stack. If nil, the operating system's
+	// network stack is configured using its native APIs, such as rtnetlink
+	// on Linux. Other platforms require a System.
+	System System
+
+	// Output, if not nil, receives a line describing each step as it is
+	// performed, like the output of wg-quick.
+	Output io.Writer
+}
+
+// A Step is a single step of bringing an interface up or down.
+type Step struct {
+	// Command is the command which wg-quick would run to perform the step.
+	Command string
+
+	// Undo is the command which reverses the step if a later step fails,
+	// or empty if the step is not reversed.
+	Undo string
+
+	do, undo func() error
+}
+
+// String returns the step's command.
+func (s Step) String() string { return s.Command }
+
+// Up brings up the interface described by cfg, like wg-quick up. If a step
+// fails, the steps already performed are reversed, so that the interface is
+// removed.
+func Up(c Client, cfg *Config, opts *Options) error {
+	steps, err := PlanUp(c, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	return execute(steps, opts, true)
+}
+
+// Down brings down the interface described by cfg, like wg-quick down.
+func Down(c Client, cfg *Config, opts *Options) error {
+	steps, err := PlanDown(c, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	return execute(steps, opts, false)
+}
+
+// execute performs steps in order. If a step fails and undo is true, the
+// steps which were performed are reversed.
+func execute(steps []Step, opts *Options, undo bool) error {
+	var w io.Writer = io.Discard
+	if opts != nil && opts.Output != nil {
+		w = opts.Output
+	}
+
+	for i, s := range steps {
+		fmt.Fprintf(w, "[#] %s\n", s.Command)
+
+		err := s.do()
+		if err == nil {
+			continue
+		}
+
+		if undo {
+			// Reversing is best effort, as wg-quick does on failure.
+			for j := i - 1; j >= 0; j-- {
+				if steps[j].undo == nil {
+					continue
+				}
+
+				fmt.Fprintf(w, "[#] %s\n", steps[j].Undo)
+				_ = steps[j].undo()
+			}
+		}
+
+		return fmt.Errorf("wgquick: %s: %v", s.Command, err)
+	}
+
+	return nil
+}
+
+// A planner builds the steps for an interface.
+type planner struct {
+	c     Client
+	sys   System
+	cfg   *Config
+	steps []Step
+}
+
+// newPlanner creates a planner for cfg.
+func newPlanner(c Client, cfg *Config, opts *Options) (*planner, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("wgquick: configuration has no interface name")
+	}
+
+	var sys System
+	if opts != nil && opts.System != nil {
+		sys = opts.System
+	} else {
+		var err error
+		if sys, err = newSystem(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &planner{c: c, sys: sys, cfg: cfg}, nil
+}
+
+// add adds a step with an optional undo step.
+func (p *planner) add(command string, do func() error, undo string, undoFn func() error) {
+	p.steps = append(p.steps, Step{
+		Command: command,
+		Undo:    undo,
+		do:      do,
+		undo:    undoFn,
+	})
+}
+
+// hooks adds steps which run hook commands.
+func (p *planner) hooks(hooks []string) {
+	for _, h := range hooks {
+		h := strings.ReplaceAll(h, "%i", p.cfg.Name)
+		p.add(h, func() error { return p.sys.Run(h) }, "", nil)
+	}
+}
+
+// PlanUp returns the steps which Up would perform to bring up the interface
+// described by cfg, without performing them. Planning queries the system,
+// for example to choose the interface's MTU and routing table.
+func PlanUp(c Client, cfg *Config, opts *Options) ([]Step, error) {
+	p, err := newPlanner(c, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := parseTable(cfg.Table)
+	if err != nil {
+		return nil, fmt.Errorf("wgquick: %v", err)
+	}
+
+	name := cfg.Name
+	exists, err := p.sys.LinkExists(name)
+	if err != nil {
+		return nil, fmt.Errorf("wgquick: failed to check for interface %s: %v", name, err)
+	}
+	if exists {
+		return nil, fmt.Errorf("wgquick: interface %s already exists", name)
+	}
+
+	p.hooks(cfg.PreUp)
+
+	p.add(
+		fmt.Sprintf("ip link add %s type wireguard", name),
+		func() error { return p.sys.AddLink(name) },
+		fmt.Sprintf("ip link delete dev %s", name),
+		func() error { return p.sys.DeleteLink(name) },
+	)
+
+	source := cfg.Path
+	if source == "" {
+		source = name
+	}
+	p.add(
+		fmt.Sprintf("wg setconf %s <(wg-quick strip %s)", name, source),
+		func() error { return c.ConfigureDevice(name, *wgconf.Replace(&cfg.Device)) },
+		"", nil,
+	)
+
+	for _, addr := range cfg.Addresses {
+		addr := addr
+		ones, _ := addr.Mask.Size()
+		p.add(
+			fmt.Sprintf("ip %s address add %s/%d dev %s", family(addr), addr.IP, ones, name),
+			func() error { return p.sys.AddAddress(name, addr) },
+			"", nil,
+		)
+	}
+
+	mtu, err := p.mtu()
+	if err != nil {
+		return nil, err
+	}
+	p.add(
+		fmt.Sprintf("ip link set mtu %d up dev %s", mtu, name),
+		func() error { return p.sys.SetLinkUp(name, mtu) },
+		"", nil,
+	)
+
+	if len(cfg.DNS) > 0 || len(cfg.DNSSearch) > 0 {
+		p.add(
+			fmt.Sprintf("resolvconf -a %s -m 0 -x", name),
+			func() error { return p.sys.SetDNS(name, cfg.DNS, cfg.DNSSearch) },
+			fmt.Sprintf("resolvconf -d %s -f", name),
+			func() error { return p.sys.UnsetDNS(name) },
+		)
+	}
+
+	if table != tableOff {
+		if err := p.routes(table); err != nil {
+			return nil, err
+		}
+	}
+
+	p.hooks(cfg.PostUp)
+
+	return p.steps, nil
+}
+
+// mtu returns the MTU for the interface: either the configured MTU, or the
+// largest MTU of the routes to the peers' endpoints or the default route,
+// less the overhead of WireGuard.
+func (p *planner) mtu() (int, error) {
+	if p.cfg.MTU != 0 {
+		return p.cfg.MTU, nil
+	}
+
+	var mtu int
+	for _, pc := range p.cfg.Device.Peers {
+		if pc.Endpoint == nil {
+			continue
+		}
+
+		m, err := p.sys.RouteMTU(pc.Endpoint.IP)
+		if err != nil {
+			return 0, fmt.Errorf("wgquick: failed to find route to %s: %v", pc.Endpoint.IP, err)
+		}
+		if m > mtu {
+			mtu = m
+		}
+	}
+
+	if mtu == 0 {
+		// Use the default route.
+		m, err := p.sys.RouteMTU(net.IPv4zero)
+		if err != nil {
+			return 0, fmt.Errorf("wgquick: failed to find default route: %v", err)
+		}
+		mtu = m
+	}
+
+	if mtu == 0 {
+		mtu = 1500
+	}
+
+	return mtu - 80, nil
+}
+
+// routes adds steps which add routes for the peers' allowed IPs.
+func (p *planner) routes(table int) error {
+	name := p.cfg.Name
+
+	// Add more specific routes first, like wg-quick.
+	var dsts []net.IPNet
+	seen := make(map[string]bool)
+	for _, pc := range p.cfg.Device.Peers {
+		for _, ipn := range pc.AllowedIPs {
+			s := wgfmt.AllowedIP(ipn)
+			if !seen[s] {
+				seen[s] = true
+				dsts = append(dsts, ipn)
+			}
+		}
+	}
+	sort.SliceStable(dsts, func(i, j int) bool {
+		a, _ := dsts[i].Mask.Size()
+		b, _ := dsts[j].Mask.Size()
+		return a > b
+	})
+
+	fwmark := p.cfg.Device.FirewallMark
+	for _, dst := range dsts {
+		ones, bits := dst.Mask.Size()
+
+		switch {
+		case table != tableAuto:
+			p.route(Route{Destination: dst, Device: name, Table: table})
+		case ones == 0:
+			// Route all traffic through the interface with a separate
+			// routing table, using the interface's firewall mark to exempt
+			// its own traffic.
+			if fwmark == nil || *fwmark == 0 {
+				t, err := p.freeTable()
+				if err != nil {
+					return err
+				}
+
+				fwmark = &t
+				p.add(
+					fmt.Sprintf("wg set %s fwmark %d", name, t),
+					func() error { return p.c.ConfigureDevice(name, wgtypes.Config{FirewallMark: &t}) },
+					"", nil,
+				)
+			}
+
+			ipv6 := bits != 8*net.IPv4len
+			p.rule(Rule{IPv6: ipv6, Table: *fwmark, NotFwmark: *fwmark})
+			p.rule(Rule{IPv6: ipv6, Table: tableMain, SuppressDefault: true})
+			p.route(Route{Destination: dst, Device: name, Table: *fwmark})
+
+			if !ipv6 {
+				p.add(
+					"sysctl -q net.ipv4.conf.all.src_valid_mark=1",
+					func() error { return p.sys.SetSysctl("net.ipv4.conf.all.src_valid_mark", "1") },
+					"", nil,
+				)
+			}
+		default:
+			p.route(Route{Destination: dst, Device: name})
+		}
+	}
+
+	return nil
+}
+
+// freeTable returns the first routing table from 51820 with no routes.
+func (p *planner) freeTable() (int, error) {
+	for table := 51820; ; table++ {
+		used, err := p.sys.TableInUse(table)
+		if err != nil {
+			return 0, fmt.Errorf("wgquick: failed to check routing table %d: %v", table, err)
+		}
+		if !used {
+			return table, nil
+		}
+	}
+}
+
+// route adds a step which adds r. Routes which already exist, such as
+// routes the kernel added for the interface's addresses, are skipped.
+func (p *planner) route(r Route) {
+	cmd := fmt.Sprintf("ip %s route add %s dev %s", family(r.Destination), wgfmt.AllowedIP(r.Destination), r.Device)
+	if r.Table != 0 {
+		cmd += fmt.Sprintf(" table %d", r.Table)
+	}
+
+	p.add(cmd, func() error {
+		if err := p.sys.AddRoute(r); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		return nil
+	}, "", nil)
+}
+
+// rule adds a step which adds r, and deletes it if a later step fails.
+// Rules which already exist are shared with other interfaces, so they are
+// not deleted.
+func (p *planner) rule(r Rule) {
+	var added bool
+	p.add(
+		fmt.Sprintf("ip %s rule add %s", ruleFamily(r), r),
+		func() error {
+			err := p.sys.AddRule(r)
+			switch {
+			case err == nil:
+				added = true
+			case errors.Is(err, os.ErrExist):
+				err = nil
+			}
+
+			return err
+		},
+		fmt.Sprintf("ip %s rule delete %s", ruleFamily(r), r),
+		func() error {
+			if !added {
+				return nil
+			}
+
+			return p.sys.DeleteRule(r)
+		},
+	)
+}
+
+// String returns the arguments of ip-rule(8) for r.
+func (r Rule) String() string {
+	var ss []string
+	if r.NotFwmark != 0 {
+		ss = append(ss, fmt.Sprintf("not fwmark %d", r.NotFwmark))
+	}
+
+	if r.Table == tableMain {
+		ss = append(ss, "table main")
+	} else {
+		ss = append(ss, fmt.Sprintf("table %d", r.Table))
+	}
+
+	if r.SuppressDefault {
+		ss = append(ss, "suppress_prefixlength 0")
+	}
+
+	return strings.Join(ss, " ")
+}
+
+// maxRuleDeletes is the maximum number of times PlanDown's steps delete the
+// rules matching a single Rule.
+const maxRuleDeletes = 100
+
+// PlanDown returns the steps which Down would perform to bring down the
+// interface described by cfg, without performing them.
+func PlanDown(c Client, cfg *Config, opts *Options) ([]Step, error) {
+	p, err := newPlanner(c, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := parseTable(cfg.Table)
+	if err != nil {
+		return nil, fmt.Errorf("wgquick: %v", err)
+	}
+
+	name := cfg.Name
+	d, err := c.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("wgquick: %s is not a WireGuard interface: %v", name, err)
+	}
+
+	p.hooks(cfg.PreDown)
+
+	if cfg.SaveConfig {
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("wgquick: cannot save configuration of %s without a path", name)
+		}
+
+		p.add(fmt.Sprintf("wg showconf %s", name), p.save, "", nil)
+	}
+
+	// Remove the rules which route all traffic through the interface.
+	if table == tableAuto && d.FirewallMark != 0 {
+		for _, ipv6 := range defaultRoutes(d) {
+			for _, r := range []Rule{
+				{IPv6: ipv6, Table: d.FirewallMark},
+				{IPv6: ipv6, Table: tableMain, SuppressDefault: true},
+			} {
+				r := r
+				p.add(fmt.Sprintf("ip %s rule delete %s", ruleFamily(r), r), func() error {
+					// Like wg-quick, delete every matching rule, but give up
+					// if a System keeps reporting deletions without ever
+					// running out of matching rules.
+					for i := 0; i < maxRuleDeletes; i++ {
+						err := p.sys.DeleteRule(r)
+						switch {
+						case errors.Is(err, os.ErrNotExist):
+							return nil
+						case err != nil:
+							return err
+						}
+					}
+
+					return fmt.Errorf("wgquick: rule %q still exists after %d deletions", r.String(), maxRuleDeletes)
+				}, "", nil)
+			}
+		}
+	}
+
+	p.add(fmt.Sprintf("ip link delete dev %s", name), func() error { return p.sys.DeleteLink(name) }, "", nil)
+
+	if len(cfg.DNS) > 0 || len(cfg.DNSSearch) > 0 {
+		p.add(fmt.Sprintf("resolvconf -d %s -f", name), func() error {
+			// Like wg-quick, ignore failures to remove DNS configuration.
+			_ = p.sys.UnsetDNS(name)
+			return nil
+		}, "", nil)
+	}
+
+	p.hooks(cfg.PostDown)
+
+	return p.steps, nil
+}
+
+// save saves the current configuration of the interface to its
+// configuration file.
+func (p *planner) save() error {
+	name := p.cfg.Name
+	d, err := p.c.Device(name)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := p.sys.Addresses(name)
+	if err != nil {
+		return err
+	}
+
+	var mtu int
+	if p.cfg.MTU != 0 {
+		if mtu, err = p.sys.LinkMTU(name); err != nil {
+			return err
+		}
+	}
+
+	return wgfile.WriteFile(p.cfg.Path, p.cfg.marshal(d, addrs, mtu))
+}
+
+// defaultRoutes returns the address families of the default routes among the
+// allowed IPs of d's peers, as values for Rule.IPv6.
+func defaultRoutes(d *wgtypes.Device) []bool {
+	var v4, v6 bool
+	for _, p := range d.Peers {
+		for _, ipn := range p.AllowedIPs {
+			ones, bits := ipn.Mask.Size()
+			if ones != 0 {
+				continue
+			}
+
+			if bits == 8*net.IPv4len {
+				v4 = true
+			} else {
+				v6 = true
+			}
+		}
+	}
+
+	var out []bool
+	if v4 {
+		out = append(out, false)
+	}
+	if v6 {
+		out = append(out, true)
+	}
+
+	return out
+}
+
+// family returns the ip(8) address family flag for ipn.
+func family(ipn net.IPNet) string {
+	if _, bits := ipn.Mask.Size(); bits == 8*net.IPv4len {
+		return "-4"
+	}
+
+	return "-6"
+}
+
+// ruleFamily returns the ip(8) address family flag for r.
+func ruleFamily(r Rule) string {
+	if r.IPv6 {
+		return "-6"
+	}
+
+	return "-4"
+}
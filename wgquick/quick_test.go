@@ -0,0 +1,570 @@
+package wgquick_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgquick"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPlanUp(t *testing.T) {
+	var (
+		pubA = wgtest.MustPublicKey()
+		pubB = wgtest.MustPublicKey()
+	)
+
+	tests := []struct {
+		name  string
+		cfg   *wgquick.Config
+		sys   *testSystem
+		steps []string
+	}{
+		{
+			name: "routes",
+			cfg: &wgquick.Config{
+				Name:      "wg0",
+				Path:      "/etc/wireguard/wg0.conf",
+				Addresses: []net.IPNet{{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)}},
+				DNS:       []net.IP{net.ParseIP("192.0.2.53")},
+				PreUp:     []string{"echo %i"},
+				PostUp:    []string{"true"},
+				Device: wgtypes.Config{
+					Peers: []wgtypes.PeerConfig{
+						{
+							PublicKey: pubA,
+							Endpoint:  wgtest.MustUDPAddr("192.0.2.1:51820"),
+							AllowedIPs: []net.IPNet{
+								wgtest.MustCIDR("10.0.0.0/24"),
+								wgtest.MustCIDR("10.1.0.1/32"),
+							},
+						},
+						{
+							PublicKey:  pubB,
+							AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+						},
+					},
+				},
+			},
+			sys: &testSystem{mtu: 9000},
+			steps: []string{
+				"echo wg0",
+				"ip link add wg0 type wireguard",
+				"wg setconf wg0 <(wg-quick strip /etc/wireguard/wg0.conf)",
+				"ip -4 address add 10.0.0.2/24 dev wg0",
+				"ip link set mtu 8920 up dev wg0",
+				"resolvconf -a wg0 -m 0 -x",
+				"ip -4 route add 10.1.0.1/32 dev wg0",
+				"ip -4 route add 10.0.0.0/24 dev wg0",
+				"true",
+			},
+		},
+		{
+			name: "default route",
+			cfg: &wgquick.Config{
+				Name: "wg0",
+				MTU:  1420,
+				Device: wgtypes.Config{
+					Peers: []wgtypes.PeerConfig{{
+						PublicKey: pubA,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("0.0.0.0/0"),
+							wgtest.MustCIDR("::/0"),
+						},
+					}},
+				},
+			},
+			sys: &testSystem{tables: map[int]bool{51820: true}},
+			steps: []string{
+				"ip link add wg0 type wireguard",
+				"wg setconf wg0 <(wg-quick strip wg0)",
+				"ip link set mtu 1420 up dev wg0",
+				"wg set wg0 fwmark 51821",
+				"ip -4 rule add not fwmark 51821 table 51821",
+				"ip -4 rule add table main suppress_prefixlength 0",
+				"ip -4 route add 0.0.0.0/0 dev wg0 table 51821",
+				"sysctl -q net.ipv4.conf.all.src_valid_mark=1",
+				"ip -6 rule add not fwmark 51821 table 51821",
+				"ip -6 rule add table main suppress_prefixlength 0",
+				"ip -6 route add ::/0 dev wg0 table 51821",
+			},
+		},
+		{
+			name: "table",
+			cfg: &wgquick.Config{
+				Name:  "wg0",
+				Table: "1234",
+				Device: wgtypes.Config{
+					Peers: []wgtypes.PeerConfig{{
+						PublicKey:  pubA,
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+					}},
+				},
+			},
+			sys: &testSystem{},
+			steps: []string{
+				"ip link add wg0 type wireguard",
+				"wg setconf wg0 <(wg-quick strip wg0)",
+				"ip link set mtu 1420 up dev wg0",
+				"ip -4 route add 0.0.0.0/0 dev wg0 table 1234",
+			},
+		},
+		{
+			name: "table off",
+			cfg: &wgquick.Config{
+				Name:  "wg0",
+				Table: "off",
+				Device: wgtypes.Config{
+					Peers: []wgtypes.PeerConfig{{
+						PublicKey:  pubA,
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+					}},
+				},
+			},
+			sys: &testSystem{},
+			steps: []string{
+				"ip link add wg0 type wireguard",
+				"wg setconf wg0 <(wg-quick strip wg0)",
+				"ip link set mtu 1420 up dev wg0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := wgquick.PlanUp(&testClient{}, tt.cfg, &wgquick.Options{System: tt.sys})
+			if err != nil {
+				t.Fatalf("failed to plan: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.steps, stepStrings(steps)); diff != "" {
+				t.Fatalf("unexpected steps (-want +got):\n%s", diff)
+			}
+
+			// Planning must not change the system.
+			if diff := cmp.Diff([]string(nil), tt.sys.calls); diff != "" {
+				t.Fatalf("unexpected system calls (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPlanUpExists(t *testing.T) {
+	sys := &testSystem{links: map[string]bool{"wg0": true}}
+
+	_, err := wgquick.PlanUp(&testClient{}, &wgquick.Config{Name: "wg0"}, &wgquick.Options{System: sys})
+	if diff := cmp.Diff("wgquick: interface wg0 already exists", fmt.Sprint(err)); diff != "" {
+		t.Fatalf("unexpected error (-want +got):\n%s", diff)
+	}
+}
+
+func TestUp(t *testing.T) {
+	pub := wgtest.MustPublicKey()
+
+	cfg := &wgquick.Config{
+		Name:      "wg0",
+		Addresses: []net.IPNet{{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)}},
+		Device: wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:  pub,
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+			}},
+		},
+	}
+
+	var (
+		c   = &testClient{}
+		sys = &testSystem{}
+		out bytes.Buffer
+	)
+
+	if err := wgquick.Up(c, cfg, &wgquick.Options{System: sys, Output: &out}); err != nil {
+		t.Fatalf("failed to bring up: %v", err)
+	}
+
+	want := []string{
+		"AddLink wg0",
+		"AddAddress wg0 10.0.0.2/24",
+		"SetLinkUp wg0 1420",
+		"AddRule -4 not fwmark 51820 table 51820",
+		"AddRule -4 table main suppress_prefixlength 0",
+		"AddRoute 0.0.0.0/0 wg0 51820",
+		"SetSysctl net.ipv4.conf.all.src_valid_mark 1",
+	}
+	if diff := cmp.Diff(want, sys.calls); diff != "" {
+		t.Fatalf("unexpected system calls (-want +got):\n%s", diff)
+	}
+
+	mark := 51820
+	wantCfgs := []wgtypes.Config{
+		{
+			PrivateKey:   &wgtypes.Key{},
+			ListenPort:   new(int),
+			FirewallMark: new(int),
+			ReplacePeers: true,
+			Peers:        cfg.Device.Peers,
+		},
+		{FirewallMark: &mark},
+	}
+	if diff := cmp.Diff(wantCfgs, c.configured); diff != "" {
+		t.Fatalf("unexpected device configurations (-want +got):\n%s", diff)
+	}
+
+	if !strings.HasPrefix(out.String(), "[#] ip link add wg0 type wireguard\n") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestUpUndo(t *testing.T) {
+	cfg := &wgquick.Config{
+		Name: "wg0",
+		DNS:  []net.IP{net.ParseIP("192.0.2.53")},
+		Device: wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey: wgtest.MustPublicKey(),
+				AllowedIPs: []net.IPNet{
+					wgtest.MustCIDR("0.0.0.0/0"),
+					wgtest.MustCIDR("10.0.0.0/8"),
+				},
+			}},
+		},
+		PostUp: []string{"false"},
+	}
+
+	var (
+		sys = &testSystem{
+			// The suppressing rule is shared with another interface.
+			rules: map[string]bool{"-4 table main suppress_prefixlength 0": true},
+			fail:  map[string]bool{"Run false": true},
+		}
+		out bytes.Buffer
+	)
+
+	err := wgquick.Up(&testClient{}, cfg, &wgquick.Options{System: sys, Output: &out})
+	if diff := cmp.Diff("wgquick: false: command failed", fmt.Sprint(err)); diff != "" {
+		t.Fatalf("unexpected error (-want +got):\n%s", diff)
+	}
+
+	want := []string{
+		"AddLink wg0",
+		"SetLinkUp wg0 1420",
+		"SetDNS wg0 [192.0.2.53] []",
+		"AddRoute 10.0.0.0/8 wg0 0",
+		"AddRule -4 not fwmark 51820 table 51820",
+		"AddRule -4 table main suppress_prefixlength 0",
+		"AddRoute 0.0.0.0/0 wg0 51820",
+		"SetSysctl net.ipv4.conf.all.src_valid_mark 1",
+		"Run false",
+		// Undo, leaving the existing rule in place.
+		"DeleteRule -4 not fwmark 51820 table 51820",
+		"UnsetDNS wg0",
+		"DeleteLink wg0",
+	}
+	if diff := cmp.Diff(want, sys.calls); diff != "" {
+		t.Fatalf("unexpected system calls (-want +got):\n%s", diff)
+	}
+
+	wantOut := []string{
+		"[#] ip link add wg0 type wireguard",
+		"[#] wg setconf wg0 <(wg-quick strip wg0)",
+		"[#] ip link set mtu 1420 up dev wg0",
+		"[#] resolvconf -a wg0 -m 0 -x",
+		"[#] ip -4 route add 10.0.0.0/8 dev wg0",
+		"[#] wg set wg0 fwmark 51820",
+		"[#] ip -4 rule add not fwmark 51820 table 51820",
+		"[#] ip -4 rule add table main suppress_prefixlength 0",
+		"[#] ip -4 route add 0.0.0.0/0 dev wg0 table 51820",
+		"[#] sysctl -q net.ipv4.conf.all.src_valid_mark=1",
+		"[#] false",
+		"[#] ip -4 rule delete table main suppress_prefixlength 0",
+		"[#] ip -4 rule delete not fwmark 51820 table 51820",
+		"[#] resolvconf -d wg0 -f",
+		"[#] ip link delete dev wg0",
+	}
+	if diff := cmp.Diff(wantOut, strings.Split(strings.TrimSpace(out.String()), "\n")); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+}
+
+func TestDown(t *testing.T) {
+	var (
+		priv = wgtest.MustPrivateKey()
+		pub  = wgtest.MustPublicKey()
+		path = filepath.Join(t.TempDir(), "wg0.conf")
+	)
+
+	cfg := &wgquick.Config{
+		Name:       "wg0",
+		Path:       path,
+		DNS:        []net.IP{net.ParseIP("192.0.2.53")},
+		MTU:        1380,
+		SaveConfig: true,
+		PreDown:    []string{"echo %i down"},
+	}
+
+	c := &testClient{
+		device: &wgtypes.Device{
+			Name:         "wg0",
+			PrivateKey:   priv,
+			FirewallMark: 51820,
+			Peers: []wgtypes.Peer{{
+				PublicKey:  pub,
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+			}},
+		},
+	}
+
+	sys := &testSystem{
+		addrs: []net.IPNet{{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)}},
+		rules: map[string]bool{
+			"-4 table 51820":                        true,
+			"-4 table main suppress_prefixlength 0": true,
+		},
+		mtu: 1400,
+	}
+
+	steps, err := wgquick.PlanDown(c, cfg, &wgquick.Options{System: sys})
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+
+	wantSteps := []string{
+		"echo wg0 down",
+		"wg showconf wg0",
+		"ip -4 rule delete table 51820",
+		"ip -4 rule delete table main suppress_prefixlength 0",
+		"ip link delete dev wg0",
+		"resolvconf -d wg0 -f",
+	}
+	if diff := cmp.Diff(wantSteps, stepStrings(steps)); diff != "" {
+		t.Fatalf("unexpected steps (-want +got):\n%s", diff)
+	}
+
+	if err := wgquick.Down(c, cfg, &wgquick.Options{System: sys}); err != nil {
+		t.Fatalf("failed to bring down: %v", err)
+	}
+
+	wantCalls := []string{
+		"Run echo wg0 down",
+		"DeleteRule -4 table 51820",
+		"DeleteRule -4 table 51820",
+		"DeleteRule -4 table main suppress_prefixlength 0",
+		"DeleteRule -4 table main suppress_prefixlength 0",
+		"DeleteLink wg0",
+		"UnsetDNS wg0",
+	}
+	if diff := cmp.Diff(wantCalls, sys.calls); diff != "" {
+		t.Fatalf("unexpected system calls (-want +got):\n%s", diff)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read saved config: %v", err)
+	}
+
+	saved, err := wgquick.Parse(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("failed to parse saved config:\n%s\nerror: %v", b, err)
+	}
+
+	mark := 51820
+	want := &wgquick.Config{
+		Device: wgtypes.Config{
+			PrivateKey:   &priv,
+			FirewallMark: &mark,
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:         pub,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+			}},
+		},
+		Addresses:  sys.addrs,
+		DNS:        cfg.DNS,
+		MTU:        1400,
+		SaveConfig: true,
+		PreDown:    []string{"echo %i down"},
+	}
+	if diff := cmp.Diff(want, saved); diff != "" {
+		t.Fatalf("unexpected saved config (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownRuleNotDeleted(t *testing.T) {
+	c := &testClient{
+		device: &wgtypes.Device{
+			Name:         "wg0",
+			FirewallMark: 51820,
+			Peers: []wgtypes.Peer{{
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("0.0.0.0/0")},
+			}},
+		},
+	}
+
+	sys := &testSystem{
+		rules: map[string]bool{"-4 table 51820": true},
+		stuck: map[string]bool{"-4 table 51820": true},
+	}
+
+	err := wgquick.Down(c, &wgquick.Config{Name: "wg0"}, &wgquick.Options{System: sys})
+	const want = `wgquick: rule "table 51820" still exists after 100 deletions`
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected error %q, but got: %v", want, err)
+	}
+
+	var deletes int
+	for _, c := range sys.calls {
+		if c == "DeleteRule -4 table 51820" {
+			deletes++
+		}
+	}
+	if diff := cmp.Diff(100, deletes); diff != "" {
+		t.Fatalf("unexpected number of rule deletions (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownNotExist(t *testing.T) {
+	c := &testClient{err: os.ErrNotExist}
+
+	_, err := wgquick.PlanDown(c, &wgquick.Config{Name: "wg0"}, &wgquick.Options{System: &testSystem{}})
+	if err == nil || !strings.Contains(err.Error(), "wg0 is not a WireGuard interface") {
+		t.Fatalf("expected not a WireGuard interface error, but got: %v", err)
+	}
+}
+
+func stepStrings(steps []wgquick.Step) []string {
+	ss := make([]string, 0, len(steps))
+	for _, s := range steps {
+		ss = append(ss, s.String())
+	}
+
+	return ss
+}
+
+var _ wgquick.Client = &testClient{}
+
+// A testClient is a wgquick.Client which records device configurations.
+type testClient struct {
+	device     *wgtypes.Device
+	err        error
+	configured []wgtypes.Config
+}
+
+func (c *testClient) Device(_ string) (*wgtypes.Device, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.device, nil
+}
+
+func (c *testClient) ConfigureDevice(_ string, cfg wgtypes.Config) error {
+	c.configured = append(c.configured, cfg)
+	return nil
+}
+
+var _ wgquick.System = &testSystem{}
+
+// A testSystem is a wgquick.System which records the calls which change the
+// system.
+type testSystem struct {
+	links  map[string]bool
+	tables map[int]bool
+	rules  map[string]bool
+	addrs  []net.IPNet
+	mtu    int
+
+	// fail contains calls which fail, and stuck contains rules which are
+	// never deleted even though DeleteRule succeeds.
+	fail  map[string]bool
+	stuck map[string]bool
+	calls []string
+}
+
+func (s *testSystem) call(format string, a ...interface{}) error {
+	c := fmt.Sprintf(format, a...)
+	s.calls = append(s.calls, c)
+	if s.fail[c] {
+		return errors.New("command failed")
+	}
+
+	return nil
+}
+
+func (s *testSystem) LinkExists(name string) (bool, error) { return s.links[name], nil }
+func (s *testSystem) AddLink(name string) error            { return s.call("AddLink %s", name) }
+func (s *testSystem) DeleteLink(name string) error         { return s.call("DeleteLink %s", name) }
+func (s *testSystem) LinkMTU(_ string) (int, error)        { return s.mtu, nil }
+
+func (s *testSystem) SetLinkUp(name string, mtu int) error {
+	return s.call("SetLinkUp %s %d", name, mtu)
+}
+
+func (s *testSystem) AddAddress(name string, addr net.IPNet) error {
+	return s.call("AddAddress %s %s", name, addr.String())
+}
+
+func (s *testSystem) Addresses(_ string) ([]net.IPNet, error) { return s.addrs, nil }
+func (s *testSystem) RouteMTU(_ net.IP) (int, error)          { return s.mtu, nil }
+func (s *testSystem) TableInUse(table int) (bool, error)      { return s.tables[table], nil }
+
+func (s *testSystem) AddRoute(r wgquick.Route) error {
+	return s.call("AddRoute %s %s %d", r.Destination.String(), r.Device, r.Table)
+}
+
+func (s *testSystem) AddRule(r wgquick.Rule) error {
+	key := ruleKey(r)
+	if s.rules[key] {
+		s.calls = append(s.calls, "AddRule "+key)
+		return os.ErrExist
+	}
+
+	if s.rules == nil {
+		s.rules = make(map[string]bool)
+	}
+	s.rules[key] = true
+
+	return s.call("AddRule %s", key)
+}
+
+func (s *testSystem) DeleteRule(r wgquick.Rule) error {
+	key := ruleKey(r)
+	if err := s.call("DeleteRule %s", key); err != nil {
+		return err
+	}
+	if !s.rules[key] {
+		return os.ErrNotExist
+	}
+
+	if !s.stuck[key] {
+		delete(s.rules, key)
+	}
+	return nil
+}
+
+func (s *testSystem) SetSysctl(key, value string) error {
+	return s.call("SetSysctl %s %s", key, value)
+}
+
+func (s *testSystem) SetDNS(name string, servers []net.IP, search []string) error {
+	return s.call("SetDNS %s %v %v", name, servers, search)
+}
+
+func (s *testSystem) UnsetDNS(name string) error { return s.call("UnsetDNS %s", name) }
+func (s *testSystem) Run(command string) error   { return s.call("Run %s", command) }
+
+func ruleKey(r wgquick.Rule) string {
+	family := "-4"
+	if r.IPv6 {
+		family = "-6"
+	}
+
+	return family + " " + r.String()
+}
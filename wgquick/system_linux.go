@@ -0,0 +1,448 @@
+//go:build linux
+// +build linux
+
+package wgquick
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+var _ System = &rtnlSystem{}
+
+// newSystem returns a System which uses rtnetlink to configure the network
+// stack.
+func newSystem() (System, error) {
+	return &rtnlSystem{
+		dial: func() (*netlink.Conn, error) { return netlink.Dial(unix.NETLINK_ROUTE, nil) },
+	}, nil
+}
+
+// An rtnlSystem is a System which uses rtnetlink, and which runs external
+// commands for DNS configuration and hooks like wg-quick.
+type rtnlSystem struct {
+	dial func() (*netlink.Conn, error)
+}
+
+// execute sends a single rtnetlink request and returns the replies.
+func (s *rtnlSystem) execute(typ netlink.HeaderType, flags netlink.HeaderFlags, data []byte) ([]netlink.Message, error) {
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  typ,
+			Flags: netlink.Request | netlink.Acknowledge | flags,
+		},
+		Data: data,
+	})
+}
+
+// LinkExists implements System.
+func (s *rtnlSystem) LinkExists(name string) (bool, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+
+	_, err := s.execute(unix.RTM_GETLINK, 0, encode(ifinfomsg(0, 0, 0), ae))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, unix.ENODEV):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// AddLink implements System.
+func (s *rtnlSystem) AddLink(name string) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, "wireguard")
+		return nil
+	})
+
+	_, err := s.execute(unix.RTM_NEWLINK, netlink.Create|netlink.Excl, encode(ifinfomsg(0, 0, 0), ae))
+	return err
+}
+
+// DeleteLink implements System.
+func (s *rtnlSystem) DeleteLink(name string) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.execute(unix.RTM_DELLINK, 0, ifinfomsg(ifi.Index, 0, 0))
+	return err
+}
+
+// SetLinkUp implements System.
+func (s *rtnlSystem) SetLinkUp(name string, mtu int) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.IFLA_MTU, uint32(mtu))
+
+	_, err = s.execute(unix.RTM_NEWLINK, 0, encode(ifinfomsg(ifi.Index, unix.IFF_UP, unix.IFF_UP), ae))
+	return err
+}
+
+// LinkMTU implements System.
+func (s *rtnlSystem) LinkMTU(name string) (int, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return ifi.MTU, nil
+}
+
+// AddAddress implements System.
+func (s *rtnlSystem) AddAddress(name string, addr net.IPNet) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	family, ip := ipFamily(addr.IP)
+	ones, _ := addr.Mask.Size()
+
+	// struct ifaddrmsg.
+	b := make([]byte, unix.SizeofIfAddrmsg)
+	b[0] = family
+	b[1] = uint8(ones)
+	nlenc.PutUint32(b[4:8], uint32(ifi.Index))
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.IFA_LOCAL, ip)
+	ae.Bytes(unix.IFA_ADDRESS, ip)
+
+	_, err = s.execute(unix.RTM_NEWADDR, netlink.Create|netlink.Excl, encode(b, ae))
+	return err
+}
+
+// Addresses implements System.
+func (s *rtnlSystem) Addresses(name string) ([]net.IPNet, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []net.IPNet
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			out = append(out, *ipn)
+		}
+	}
+
+	return out, nil
+}
+
+// RouteMTU implements System.
+func (s *rtnlSystem) RouteMTU(dst net.IP) (int, error) {
+	family, ip := ipFamily(dst)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, ip)
+
+	msgs, err := s.execute(unix.RTM_GETROUTE, 0, encode(rtmsg(family, uint8(8*len(ip)), 0), ae))
+	switch {
+	case errors.Is(err, unix.ENETUNREACH), errors.Is(err, unix.EHOSTUNREACH):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	for _, m := range msgs {
+		if len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+
+		var (
+			oif int
+			mtu int
+		)
+
+		ad, err := netlink.NewAttributeDecoder(m.Data[unix.SizeofRtMsg:])
+		if err != nil {
+			return 0, err
+		}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.RTA_OIF:
+				oif = int(ad.Uint32())
+			case unix.RTA_METRICS:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					for nad.Next() {
+						if nad.Type() == unix.RTAX_MTU {
+							mtu = int(nad.Uint32())
+						}
+					}
+					return nil
+				})
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return 0, err
+		}
+
+		if mtu != 0 {
+			return mtu, nil
+		}
+
+		if oif != 0 {
+			ifi, err := net.InterfaceByIndex(oif)
+			if err != nil {
+				return 0, err
+			}
+
+			return ifi.MTU, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// TableInUse implements System.
+func (s *rtnlSystem) TableInUse(table int) (bool, error) {
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		msgs, err := s.execute(unix.RTM_GETROUTE, netlink.Dump, rtmsg(family, 0, 0))
+		if err != nil {
+			return false, err
+		}
+
+		for _, m := range msgs {
+			if len(m.Data) < unix.SizeofRtMsg {
+				continue
+			}
+
+			t := int(m.Data[4])
+			ad, err := netlink.NewAttributeDecoder(m.Data[unix.SizeofRtMsg:])
+			if err != nil {
+				return false, err
+			}
+			for ad.Next() {
+				if ad.Type() == unix.RTA_TABLE {
+					t = int(ad.Uint32())
+				}
+			}
+			if err := ad.Err(); err != nil {
+				return false, err
+			}
+
+			if t == table {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// AddRoute implements System.
+func (s *rtnlSystem) AddRoute(r Route) error {
+	ifi, err := net.InterfaceByName(r.Device)
+	if err != nil {
+		return err
+	}
+
+	table := r.Table
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+
+	family, ip := ipFamily(r.Destination.IP)
+	ones, _ := r.Destination.Mask.Size()
+
+	b := rtmsg(family, uint8(ones), table)
+	b[5] = unix.RTPROT_BOOT
+	b[6] = unix.RT_SCOPE_LINK
+	b[7] = unix.RTN_UNICAST
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, ip.Mask(net.CIDRMask(ones, 8*len(ip))))
+	ae.Uint32(unix.RTA_OIF, uint32(ifi.Index))
+	ae.Uint32(unix.RTA_TABLE, uint32(table))
+
+	_, err = s.execute(unix.RTM_NEWROUTE, netlink.Create|netlink.Excl, encode(b, ae))
+	return err
+}
+
+// AddRule implements System.
+func (s *rtnlSystem) AddRule(r Rule) error {
+	_, err := s.execute(unix.RTM_NEWRULE, netlink.Create|netlink.Excl, fibRule(r))
+	return err
+}
+
+// DeleteRule implements System.
+func (s *rtnlSystem) DeleteRule(r Rule) error {
+	_, err := s.execute(unix.RTM_DELRULE, 0, fibRule(r))
+	return err
+}
+
+// SetSysctl implements System.
+func (s *rtnlSystem) SetSysctl(key, value string) error {
+	path := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+	return os.WriteFile(path, []byte(value), 0o644)
+}
+
+// SetDNS implements System.
+func (s *rtnlSystem) SetDNS(name string, servers []net.IP, search []string) error {
+	var b strings.Builder
+	for _, ip := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", ip)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+
+	cmd := exec.Command("resolvconf", "-a", resolvconfPrefix()+name, "-m", "0", "-x")
+	cmd.Stdin = strings.NewReader(b.String())
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// UnsetDNS implements System.
+func (s *rtnlSystem) UnsetDNS(name string) error {
+	cmd := exec.Command("resolvconf", "-d", resolvconfPrefix()+name, "-f")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// interfacePrefix matches resolvconf interface-order entries such as "tun*".
+var interfacePrefix = regexp.MustCompile(`^([A-Za-z0-9-]+)\*$`)
+
+// resolvconfPrefix returns the prefix which resolvconf expects for the
+// names of VPN interfaces, like wg-quick.
+func resolvconfPrefix() string {
+	f, err := os.Open("/etc/resolvconf/interface-order")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m := interfacePrefix.FindStringSubmatch(s.Text()); m != nil {
+			return m[1] + "."
+		}
+	}
+
+	return ""
+}
+
+// Run implements System.
+func (s *rtnlSystem) Run(command string) error {
+	// Like wg-quick, prefer bash for compatibility with existing hooks.
+	shell := "bash"
+	if _, err := exec.LookPath(shell); err != nil {
+		shell = "sh"
+	}
+
+	cmd := exec.Command(shell, "-c", command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// ifinfomsg returns a struct ifinfomsg.
+func ifinfomsg(index int, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	nlenc.PutInt32(b[4:8], int32(index))
+	nlenc.PutUint32(b[8:12], flags)
+	nlenc.PutUint32(b[12:16], change)
+	return b
+}
+
+// rtmsg returns a struct rtmsg.
+func rtmsg(family, dstLen uint8, table int) []byte {
+	b := make([]byte, unix.SizeofRtMsg)
+	b[0] = family
+	b[1] = dstLen
+	b[4] = tableByte(table)
+	return b
+}
+
+// fibRule returns a struct fib_rule_hdr and attributes for r.
+func fibRule(r Rule) []byte {
+	family := uint8(unix.AF_INET)
+	if r.IPv6 {
+		family = unix.AF_INET6
+	}
+
+	// struct fib_rule_hdr.
+	b := make([]byte, 12)
+	b[0] = family
+	b[4] = tableByte(r.Table)
+	b[7] = unix.FR_ACT_TO_TBL
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.FRA_TABLE, uint32(r.Table))
+	if r.NotFwmark != 0 {
+		nlenc.PutUint32(b[8:12], unix.FIB_RULE_INVERT)
+		ae.Uint32(unix.FRA_FWMARK, uint32(r.NotFwmark))
+	}
+	if r.SuppressDefault {
+		ae.Uint32(unix.FRA_SUPPRESS_PREFIXLEN, 0)
+	}
+
+	return encode(b, ae)
+}
+
+// tableByte returns the value of a routing table in the 8-bit table field of
+// rtnetlink headers. Larger tables are only specified by attributes.
+func tableByte(table int) uint8 {
+	if table > 255 {
+		return unix.RT_TABLE_UNSPEC
+	}
+
+	return uint8(table)
+}
+
+// ipFamily returns the address family of ip and ip in the form used by
+// rtnetlink for that family.
+func ipFamily(ip net.IP) (uint8, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return unix.AF_INET, ip4
+	}
+
+	return unix.AF_INET6, ip.To16()
+}
+
+// encode appends the attributes in ae to the fixed-size header b.
+func encode(b []byte, ae *netlink.AttributeEncoder) []byte {
+	attrs, err := ae.Encode()
+	if err != nil {
+		// Attributes are only created by this package.
+		panicf("wgquick: failed to encode attributes: %v", err)
+	}
+
+	return append(b, attrs...)
+}
+
+func panicf(format string, a ...interface{}) {
+	panic(fmt.Sprintf(format, a...))
+}
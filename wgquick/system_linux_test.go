@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+package wgquick
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
+)
+
+func TestRtnlSystemAddLink(t *testing.T) {
+	var got netlink.Message
+	s := testRtnlSystem(func(req []netlink.Message) ([]netlink.Message, error) {
+		got = req[0]
+		return req, nil
+	})
+
+	if err := s.AddLink("wg0"); err != nil {
+		t.Fatalf("failed to add link: %v", err)
+	}
+
+	want := append(ifinfomsg(0, 0, 0), nltest.MustMarshalAttributes([]netlink.Attribute{
+		{Type: unix.IFLA_IFNAME, Data: []byte("wg0\x00")},
+		{
+			Type: netlink.Nested | unix.IFLA_LINKINFO,
+			Data: nltest.MustMarshalAttributes([]netlink.Attribute{
+				{Type: unix.IFLA_INFO_KIND, Data: []byte("wireguard\x00")},
+			}),
+		},
+	})...)
+
+	if diff := cmp.Diff(netlink.HeaderType(unix.RTM_NEWLINK), got.Header.Type); diff != "" {
+		t.Fatalf("unexpected message type (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, got.Data); diff != "" {
+		t.Fatalf("unexpected message data (-want +got):\n%s", diff)
+	}
+}
+
+func TestRtnlSystemLinkExists(t *testing.T) {
+	s := testRtnlSystem(func(_ []netlink.Message) ([]netlink.Message, error) {
+		return nil, unix.ENODEV
+	})
+
+	ok, err := s.LinkExists("wg0")
+	if err != nil {
+		t.Fatalf("failed to check link: %v", err)
+	}
+	if ok {
+		t.Fatal("link should not exist")
+	}
+}
+
+func TestFibRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     Rule
+		hdr   []byte
+		attrs []netlink.Attribute
+	}{
+		{
+			name: "not fwmark",
+			r:    Rule{Table: 51820, NotFwmark: 51820},
+			hdr:  []byte{unix.AF_INET, 0, 0, 0, 0, 0, 0, unix.FR_ACT_TO_TBL, 0x02, 0, 0, 0},
+			attrs: []netlink.Attribute{
+				{Type: unix.FRA_TABLE, Data: []byte{0x6c, 0xca, 0, 0}},
+				{Type: unix.FRA_FWMARK, Data: []byte{0x6c, 0xca, 0, 0}},
+			},
+		},
+		{
+			name: "suppress default",
+			r:    Rule{IPv6: true, Table: tableMain, SuppressDefault: true},
+			hdr:  []byte{unix.AF_INET6, 0, 0, 0, tableMain, 0, 0, unix.FR_ACT_TO_TBL, 0, 0, 0, 0},
+			attrs: []netlink.Attribute{
+				{Type: unix.FRA_TABLE, Data: []byte{tableMain, 0, 0, 0}},
+				{Type: unix.FRA_SUPPRESS_PREFIXLEN, Data: []byte{0, 0, 0, 0}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := append(tt.hdr, nltest.MustMarshalAttributes(tt.attrs)...)
+			if diff := cmp.Diff(want, fibRule(tt.r)); diff != "" {
+				t.Fatalf("unexpected rule (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func testRtnlSystem(fn nltest.Func) *rtnlSystem {
+	return &rtnlSystem{
+		dial: func() (*netlink.Conn, error) { return nltest.Dial(fn), nil },
+	}
+}
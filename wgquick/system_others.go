@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package wgquick
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// newSystem returns an error, because no native System is available on this
+// platform.
+func newSystem() (System, error) {
+	return nil, fmt.Errorf("wgquick: no System available on %s, one must be set in Options", runtime.GOOS)
+}
@@ -0,0 +1,16 @@
+// Package wgreconcile declaratively converges WireGuard devices to a desired
+// state.
+//
+// A State describes the desired device settings and the complete set of
+// peers, keyed by public key. A Reconciler compares a State with a device and
+// computes a Plan: the exact wgtypes.Config which would be sent with
+// ConfigureDevice. A Plan only contains the differences, so that peers which
+// are unchanged keep their sessions, and it never uses ReplacePeers. Peers
+// whose allowed IPs differ are updated with ReplaceAllowedIPs, and updates
+// to existing peers use UpdateOnly, so that a peer which is removed
+// concurrently is not recreated with partial settings. Devices which do not
+// support UpdateOnly, such as on FreeBSD, are configured without it.
+//
+// Plans can be inspected before they are applied, and Loop re-converges a
+// device on an interval and reports any drift which was found.
+package wgreconcile // import "golang.zx2c4.com/wireguard/wgctrl/wgreconcile"
@@ -0,0 +1,372 @@
+package wgreconcile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgconf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Client is the subset of *wgctrl.Client used by a Reconciler.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// A State is the desired state of a device.
+type State struct {
+	// PrivateKey, ListenPort, and FirewallMark specify the desired device
+	// settings. Settings which are nil are not managed, and keep their
+	// current values.
+	PrivateKey   *wgtypes.Key
+	ListenPort   *int
+	FirewallMark *int
+
+	// Peers is the complete set of desired peers, keyed by public key.
+	// Peers which are present on the device but not in Peers are removed.
+	Peers map[wgtypes.Key]Peer
+}
+
+// A Peer is the desired state of a peer.
+type Peer struct {
+	// PresharedKey is the preshared key, or the zero key for none.
+	PresharedKey wgtypes.Key
+
+	// Endpoint is the endpoint, or nil to keep the endpoint which the
+	// device has learned from the peer, if any.
+	Endpoint *net.UDPAddr
+
+	// PersistentKeepaliveInterval is the persistent keepalive interval, or
+	// zero to disable persistent keepalive.
+	PersistentKeepaliveInterval time.Duration
+
+	// AllowedIPs are the allowed IPs of the peer, in any order. An allowed
+	// IP may only be assigned to one peer.
+	AllowedIPs []net.IPNet
+}
+
+// A Plan is the configuration which changes a device to match a State.
+type Plan struct {
+	// Device is the name of the device.
+	Device string
+
+	// Config is the configuration which is sent to the device with
+	// ConfigureDevice when the Plan is applied.
+	Config wgtypes.Config
+}
+
+// Empty reports whether the Plan makes no changes, which means that the
+// device matched the State.
+func (p *Plan) Empty() bool {
+	c := p.Config
+	return c.PrivateKey == nil && c.ListenPort == nil && c.FirewallMark == nil && !c.ReplacePeers && len(c.Peers) == 0
+}
+
+// String describes the operations of the Plan, one per line, such as
+// "remove peer <key>". Keys other than public keys are never included.
+func (p *Plan) String() string {
+	var (
+		b bytes.Buffer
+		c = p.Config
+	)
+
+	if c.PrivateKey != nil {
+		b.WriteString("set private key\n")
+	}
+	if c.ListenPort != nil {
+		fmt.Fprintf(&b, "set listen port %d\n", *c.ListenPort)
+	}
+	if c.FirewallMark != nil {
+		fmt.Fprintf(&b, "set fwmark 0x%x\n", *c.FirewallMark)
+	}
+
+	for _, pc := range c.Peers {
+		switch {
+		case pc.Remove:
+			fmt.Fprintf(&b, "remove peer %s\n", pc.PublicKey)
+			continue
+		case pc.UpdateOnly:
+			fmt.Fprintf(&b, "update peer %s:", pc.PublicKey)
+		default:
+			fmt.Fprintf(&b, "add peer %s:", pc.PublicKey)
+		}
+
+		var ops []string
+		if pc.PresharedKey != nil {
+			if *pc.PresharedKey == (wgtypes.Key{}) {
+				ops = append(ops, "clear preshared key")
+			} else {
+				ops = append(ops, "set preshared key")
+			}
+		}
+		if pc.Endpoint != nil {
+			ops = append(ops, "set endpoint "+wgfmt.Endpoint(pc.Endpoint))
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			ops = append(ops, "set persistent keepalive "+wgfmt.Formatter{}.Keepalive(*pc.PersistentKeepaliveInterval))
+		}
+		if pc.ReplaceAllowedIPs || len(pc.AllowedIPs) > 0 {
+			ips := make([]string, 0, len(pc.AllowedIPs))
+			for _, ipn := range pc.AllowedIPs {
+				ips = append(ips, wgfmt.AllowedIP(ipn))
+			}
+			if len(ips) == 0 {
+				ips = append(ips, "(none)")
+			}
+
+			ops = append(ops, "replace allowed ips "+strings.Join(ips, ", "))
+		}
+
+		fmt.Fprintf(&b, " %s\n", strings.Join(ops, ", "))
+	}
+
+	return b.String()
+}
+
+// A Reconciler converges a device to a State.
+type Reconciler struct {
+	c      Client
+	device string
+}
+
+// New creates a Reconciler which uses c to converge the device specified by
+// name.
+func New(c Client, name string) *Reconciler {
+	return &Reconciler{
+		c:      c,
+		device: name,
+	}
+}
+
+// Plan computes the Plan which changes the device to match s, without
+// changing the device. Errors from the Client are returned unmodified, so
+// that a device which does not exist can be detected with
+// errors.Is(err, os.ErrNotExist).
+func (r *Reconciler) Plan(s *State) (*Plan, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	d, err := r.c.Device(r.device)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plan{
+		Device: r.device,
+		Config: plan(d, s),
+	}, nil
+}
+
+// Apply applies p to its device. Applying an empty Plan does nothing.
+//
+// If the device does not support UpdateOnly, such as on FreeBSD, p is
+// applied without UpdateOnly instead. A peer which is removed concurrently
+// may then be recreated with only the settings which p changes.
+func (r *Reconciler) Apply(p *Plan) error {
+	if p.Empty() {
+		return nil
+	}
+
+	err := r.c.ConfigureDevice(p.Device, p.Config)
+	if !errors.Is(err, wgtypes.ErrUpdateOnlyNotSupported) {
+		return err
+	}
+
+	return r.c.ConfigureDevice(p.Device, withoutUpdateOnly(p.Config))
+}
+
+// withoutUpdateOnly returns a copy of cfg whose peers do not use UpdateOnly.
+func withoutUpdateOnly(cfg wgtypes.Config) wgtypes.Config {
+	peers := make([]wgtypes.PeerConfig, 0, len(cfg.Peers))
+	for _, pc := range cfg.Peers {
+		pc.UpdateOnly = false
+		peers = append(peers, pc)
+	}
+
+	cfg.Peers = peers
+	return cfg
+}
+
+// Reconcile computes the Plan which changes the device to match s and
+// applies it. The Plan is returned even if it could not be applied.
+func (r *Reconciler) Reconcile(s *State) (*Plan, error) {
+	p, err := r.Plan(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, r.Apply(p)
+}
+
+// DefaultInterval is the interval used by Loop if none is specified.
+const DefaultInterval = 30 * time.Second
+
+// LoopOptions configures Loop.
+type LoopOptions struct {
+	// Interval specifies the interval between reconciliations. If zero,
+	// DefaultInterval is used.
+	Interval time.Duration
+
+	// DryRun specifies that Plans are computed and reported, but not
+	// applied.
+	DryRun bool
+
+	// Report, if not nil, is called after each reconciliation with the
+	// Plan, which is empty unless the device had drifted from the State,
+	// and any error. p is nil if the Plan could not be computed.
+	Report func(p *Plan, err error)
+}
+
+// Loop reconciles the device on an interval until ctx is canceled, and then
+// returns ctx.Err(). state is called before each reconciliation to retrieve
+// the desired State, so that changes to the State take effect on the next
+// reconciliation. Errors are reported with opts.Report, and do not stop the
+// loop.
+func (r *Reconciler) Loop(ctx context.Context, state func() (*State, error), opts *LoopOptions) error {
+	if opts == nil {
+		opts = &LoopOptions{}
+	}
+
+	interval := opts.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p, err := r.loopOnce(state, opts.DryRun)
+		if opts.Report != nil {
+			opts.Report(p, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// loopOnce performs a single reconciliation for Loop.
+func (r *Reconciler) loopOnce(state func() (*State, error), dryRun bool) (*Plan, error) {
+	s, err := state()
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return r.Plan(s)
+	}
+
+	return r.Reconcile(s)
+}
+
+// validate reports whether s can be applied to a device.
+func (s *State) validate() error {
+	keys := make([]wgtypes.Key, 0, len(s.Peers))
+	for k := range s.Peers {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+
+	// A device assigns each allowed IP to a single peer, so a State which
+	// assigns an allowed IP to multiple peers could never converge.
+	owners := make(map[string]wgtypes.Key)
+	for _, k := range keys {
+		for _, ipn := range s.Peers[k].AllowedIPs {
+			ones, _ := ipn.Mask.Size()
+			prefix := fmt.Sprintf("%s/%d", ipn.IP.Mask(ipn.Mask), ones)
+
+			if other, ok := owners[prefix]; ok && other != k {
+				return fmt.Errorf("wgreconcile: allowed IP %s is assigned to peers %s and %s",
+					wgfmt.AllowedIP(ipn), other, k)
+			}
+			owners[prefix] = k
+		}
+	}
+
+	return nil
+}
+
+// plan returns the configuration which changes d to match s.
+func plan(d *wgtypes.Device, s *State) wgtypes.Config {
+	cfg := &wgtypes.Config{
+		PrivateKey:   s.PrivateKey,
+		ListenPort:   s.ListenPort,
+		FirewallMark: s.FirewallMark,
+	}
+
+	// Sync clears settings which are not specified, so specify the current
+	// values of unmanaged settings. Sync already keeps the current listening
+	// port and endpoints when they are not specified.
+	if cfg.PrivateKey == nil {
+		cfg.PrivateKey = &d.PrivateKey
+	}
+	if cfg.FirewallMark == nil {
+		cfg.FirewallMark = &d.FirewallMark
+	}
+
+	keys := make([]wgtypes.Key, 0, len(s.Peers))
+	for k := range s.Peers {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+
+	for _, k := range keys {
+		p := s.Peers[k]
+		pc := wgtypes.PeerConfig{
+			PublicKey:         k,
+			Endpoint:          p.Endpoint,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        p.AllowedIPs,
+		}
+		if p.PresharedKey != (wgtypes.Key{}) {
+			psk := p.PresharedKey
+			pc.PresharedKey = &psk
+		}
+		if p.PersistentKeepaliveInterval != 0 {
+			ka := p.PersistentKeepaliveInterval
+			pc.PersistentKeepaliveInterval = &ka
+		}
+
+		cfg.Peers = append(cfg.Peers, pc)
+	}
+
+	out := wgconf.Sync(d, cfg)
+
+	current := make(map[wgtypes.Key]bool, len(d.Peers))
+	for _, p := range d.Peers {
+		current[p.PublicKey] = true
+	}
+
+	for i := range out.Peers {
+		pc := &out.Peers[i]
+		if !pc.Remove && current[pc.PublicKey] {
+			pc.UpdateOnly = true
+		}
+	}
+
+	return *out
+}
+
+// sortKeys sorts keys by their base64 representation.
+func sortKeys(keys []wgtypes.Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+}
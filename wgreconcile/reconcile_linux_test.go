@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package wgreconcile_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgctrltest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgreconcile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestReconcileKernel(t *testing.T) {
+	var (
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+		peerC = wgtest.MustPublicKey()
+		psk   = wgtest.MustPresharedKey()
+	)
+
+	k := wgctrltest.NewKernel(nil)
+	k.AddDevice(&wgtypes.Device{
+		Name:       "wg0",
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey: peerA,
+				Endpoint:  wgtest.MustUDPAddr("192.0.2.1:51820"),
+				AllowedIPs: []net.IPNet{
+					wgtest.MustCIDR("10.0.0.2/32"),
+					wgtest.MustCIDR("10.0.0.3/32"),
+				},
+			},
+			{
+				PublicKey:  peerB,
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.4/32")},
+			},
+		},
+	})
+
+	c, err := wgctrltest.NewClient(k, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	// Move 10.0.0.3/32 from peer A to the new peer C, which requires
+	// replacing the allowed IPs of peer A.
+	state := &wgreconcile.State{
+		Peers: map[wgtypes.Key]wgreconcile.Peer{
+			peerA: {
+				PresharedKey:                psk,
+				PersistentKeepaliveInterval: 25 * time.Second,
+				AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")},
+			},
+			peerC: {
+				AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")},
+			},
+		},
+	}
+
+	r := wgreconcile.New(c, "wg0")
+	p, err := r.Reconcile(state)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+	if p.Empty() {
+		t.Fatal("expected changes to be applied")
+	}
+
+	d, ok := k.Device("wg0")
+	if !ok {
+		t.Fatal("device was removed")
+	}
+
+	got := make(map[wgtypes.Key]wgreconcile.Peer)
+	for _, p := range d.Peers {
+		got[p.PublicKey] = wgreconcile.Peer{
+			PresharedKey:                p.PresharedKey,
+			PersistentKeepaliveInterval: p.PersistentKeepaliveInterval,
+			AllowedIPs:                  p.AllowedIPs,
+		}
+	}
+
+	if diff := cmp.Diff(state.Peers, got); diff != "" {
+		t.Fatalf("unexpected peers (-want +got):\n%s", diff)
+	}
+
+	// The endpoint of peer A is unmanaged, and the device is now converged.
+	if diff := cmp.Diff(wgtest.MustUDPAddr("192.0.2.1:51820"), d.Peers[0].Endpoint); diff != "" {
+		t.Fatalf("unexpected endpoint (-want +got):\n%s", diff)
+	}
+
+	p, err = r.Plan(state)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if !p.Empty() {
+		t.Fatalf("expected device to be converged, but got plan:\n%s", p)
+	}
+}
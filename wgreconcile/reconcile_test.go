@@ -0,0 +1,398 @@
+package wgreconcile_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgreconcile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPlan(t *testing.T) {
+	var (
+		priv  = mustKey("GHuMwljFfqd2a7cs6BaUOmHflK23zME8VNvC5B37S3k=")
+		peerA = mustKey("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+		peerB = mustKey("HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")
+		peerC = mustKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+		psk   = wgtest.MustPresharedKey()
+	)
+
+	device := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			Name:         "wg0",
+			PrivateKey:   priv,
+			ListenPort:   51820,
+			FirewallMark: 0x10,
+			Peers: []wgtypes.Peer{
+				{
+					PublicKey:                   peerA,
+					PresharedKey:                psk,
+					Endpoint:                    wgtest.MustUDPAddr("192.0.2.1:51820"),
+					PersistentKeepaliveInterval: 25 * time.Second,
+					AllowedIPs: []net.IPNet{
+						wgtest.MustCIDR("10.0.0.2/32"),
+						wgtest.MustCIDR("fd00::2/128"),
+					},
+				},
+				{
+					PublicKey:  peerB,
+					Endpoint:   wgtest.MustUDPAddr("192.0.2.2:51820"),
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		state *wgreconcile.State
+		want  wgtypes.Config
+		s     string
+	}{
+		{
+			name: "converged",
+			state: &wgreconcile.State{
+				Peers: map[wgtypes.Key]wgreconcile.Peer{
+					peerA: {
+						PresharedKey:                psk,
+						PersistentKeepaliveInterval: 25 * time.Second,
+						AllowedIPs: []net.IPNet{
+							wgtest.MustCIDR("fd00::2/128"),
+							wgtest.MustCIDR("10.0.0.2/32"),
+						},
+					},
+					peerB: {
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")},
+					},
+				},
+			},
+		},
+		{
+			name: "changed",
+			state: &wgreconcile.State{
+				ListenPort:   intPtr(51821),
+				FirewallMark: intPtr(0),
+				Peers: map[wgtypes.Key]wgreconcile.Peer{
+					peerA: {
+						Endpoint:   wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")},
+					},
+					peerC: {
+						PresharedKey:                psk,
+						Endpoint:                    wgtest.MustUDPAddr("192.0.2.3:51820"),
+						PersistentKeepaliveInterval: 25 * time.Second,
+						AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.0.4/32")},
+					},
+				},
+			},
+			want: wgtypes.Config{
+				ListenPort:   intPtr(51821),
+				FirewallMark: intPtr(0),
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:                   peerA,
+						UpdateOnly:                  true,
+						PresharedKey:                keyPtr(wgtypes.Key{}),
+						Endpoint:                    wgtest.MustUDPAddr("[2001:db8::1]:51820"),
+						PersistentKeepaliveInterval: durPtr(0),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")},
+					},
+					{
+						PublicKey:                   peerC,
+						PresharedKey:                &psk,
+						Endpoint:                    wgtest.MustUDPAddr("192.0.2.3:51820"),
+						PersistentKeepaliveInterval: durPtr(25 * time.Second),
+						ReplaceAllowedIPs:           true,
+						AllowedIPs:                  []net.IPNet{wgtest.MustCIDR("10.0.0.4/32")},
+					},
+					{
+						PublicKey: peerB,
+						Remove:    true,
+					},
+				},
+			},
+			s: `set listen port 51821
+set fwmark 0x0
+update peer TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=: clear preshared key, set endpoint [2001:db8::1]:51820, set persistent keepalive off, replace allowed ips 10.0.0.2/32
+add peer xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=: set preshared key, set endpoint 192.0.2.3:51820, set persistent keepalive every 25 seconds, replace allowed ips 10.0.0.4/32
+remove peer HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=
+`,
+		},
+		{
+			name: "private key",
+			state: &wgreconcile.State{
+				PrivateKey: keyPtr(wgtypes.Key{}),
+				Peers: map[wgtypes.Key]wgreconcile.Peer{
+					peerA: {
+						PresharedKey:                psk,
+						PersistentKeepaliveInterval: 25 * time.Second,
+					},
+					peerB: {
+						AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")},
+					},
+				},
+			},
+			want: wgtypes.Config{
+				PrivateKey: keyPtr(wgtypes.Key{}),
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:         peerA,
+					UpdateOnly:        true,
+					ReplaceAllowedIPs: true,
+				}},
+			},
+			s: `set private key
+update peer TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=: replace allowed ips (none)
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &testClient{device: device()}
+			p, err := wgreconcile.New(c, "wg0").Plan(tt.state)
+			if err != nil {
+				t.Fatalf("failed to plan: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, p.Config); diff != "" {
+				t.Fatalf("unexpected Config (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.s, p.String()); diff != "" {
+				t.Fatalf("unexpected plan (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.s == "", p.Empty()); diff != "" {
+				t.Fatalf("unexpected Empty (-want +got):\n%s", diff)
+			}
+
+			if len(c.configured) > 0 {
+				t.Fatal("Plan must not configure the device")
+			}
+		})
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	var (
+		peerA = mustKey("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+		peerB = mustKey("HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")
+	)
+
+	_, err := wgreconcile.New(&testClient{device: &wgtypes.Device{Name: "wg0"}}, "wg0").Plan(&wgreconcile.State{
+		Peers: map[wgtypes.Key]wgreconcile.Peer{
+			peerA: {AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")}},
+			peerB: {AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")}},
+		},
+	})
+
+	const want = "wgreconcile: allowed IP 10.0.0.0/24 is assigned to peers HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw= and TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, but got: %v", want, err)
+	}
+
+	_, err = wgreconcile.New(&testClient{}, "wg1").Plan(&wgreconcile.State{})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, but got: %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	peer := wgtest.MustPublicKey()
+	state := &wgreconcile.State{
+		Peers: map[wgtypes.Key]wgreconcile.Peer{
+			peer: {AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")}},
+		},
+	}
+
+	c := &testClient{device: &wgtypes.Device{Name: "wg0"}}
+	r := wgreconcile.New(c, "wg0")
+
+	p, err := r.Reconcile(state)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+
+	if diff := cmp.Diff([]wgtypes.Config{p.Config}, c.configured); diff != "" {
+		t.Fatalf("unexpected configuration (-want +got):\n%s", diff)
+	}
+
+	// Once the device has converged, nothing is applied.
+	c.device.Peers = []wgtypes.Peer{{
+		PublicKey:  peer,
+		AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")},
+	}}
+
+	p, err = r.Reconcile(state)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+	if !p.Empty() || len(c.configured) != 1 {
+		t.Fatalf("expected no changes, but got plan %q and %d configurations", p, len(c.configured))
+	}
+}
+
+func TestReconcileWithoutUpdateOnly(t *testing.T) {
+	var (
+		peerA = wgtest.MustPublicKey()
+		peerB = wgtest.MustPublicKey()
+		ka    = 25 * time.Second
+	)
+
+	// Like FreeBSD, the device rejects peers with UpdateOnly.
+	c := &testClient{
+		device: &wgtypes.Device{
+			Name:  "wg0",
+			Peers: []wgtypes.Peer{{PublicKey: peerA}},
+		},
+		noUpdateOnly: true,
+	}
+
+	p, err := wgreconcile.New(c, "wg0").Reconcile(&wgreconcile.State{
+		Peers: map[wgtypes.Key]wgreconcile.Peer{
+			peerA: {PersistentKeepaliveInterval: ka},
+			peerB: {},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+
+	// The Plan still updates peerA with UpdateOnly, but it is applied
+	// without it.
+	var updateOnly int
+	for _, pc := range p.Config.Peers {
+		if pc.UpdateOnly {
+			updateOnly++
+		}
+	}
+	if diff := cmp.Diff(1, updateOnly); diff != "" {
+		t.Fatalf("unexpected number of UpdateOnly peers (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(1, len(c.configured)); diff != "" {
+		t.Fatalf("unexpected number of configurations (-want +got):\n%s", diff)
+	}
+	for _, pc := range c.configured[0].Peers {
+		if pc.UpdateOnly {
+			t.Fatalf("peer %s was configured with UpdateOnly", pc.PublicKey)
+		}
+	}
+}
+
+func TestLoop(t *testing.T) {
+	peer := wgtest.MustPublicKey()
+
+	tests := []struct {
+		name       string
+		dryRun     bool
+		configured int
+	}{
+		{name: "apply", configured: 3},
+		{name: "dry run", dryRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &testClient{device: &wgtypes.Device{Name: "wg0"}}
+			r := wgreconcile.New(c, "wg0")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var (
+				calls   int
+				reports []string
+			)
+
+			state := func() (*wgreconcile.State, error) {
+				calls++
+				if calls == 2 {
+					return nil, errors.New("control plane unavailable")
+				}
+
+				return &wgreconcile.State{
+					Peers: map[wgtypes.Key]wgreconcile.Peer{peer: {}},
+				}, nil
+			}
+
+			err := r.Loop(ctx, state, &wgreconcile.LoopOptions{
+				Interval: time.Millisecond,
+				DryRun:   tt.dryRun,
+				Report: func(p *wgreconcile.Plan, err error) {
+					switch {
+					case err != nil:
+						reports = append(reports, "error: "+err.Error())
+					case p.Empty():
+						reports = append(reports, "converged")
+					default:
+						reports = append(reports, "drift")
+					}
+
+					if len(reports) == 4 {
+						cancel()
+					}
+				},
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context canceled, but got: %v", err)
+			}
+
+			// The fake device never converges, so every successful
+			// reconciliation reports drift.
+			want := []string{"drift", "error: control plane unavailable", "drift", "drift"}
+			if diff := cmp.Diff(want, reports); diff != "" {
+				t.Fatalf("unexpected reports (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.configured, len(c.configured)); diff != "" {
+				t.Fatalf("unexpected number of configurations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+type testClient struct {
+	device       *wgtypes.Device
+	configured   []wgtypes.Config
+	noUpdateOnly bool
+}
+
+func (c *testClient) Device(name string) (*wgtypes.Device, error) {
+	if c.device == nil || c.device.Name != name {
+		return nil, os.ErrNotExist
+	}
+
+	return c.device, nil
+}
+
+func (c *testClient) ConfigureDevice(_ string, cfg wgtypes.Config) error {
+	for _, pc := range cfg.Peers {
+		if pc.UpdateOnly && c.noUpdateOnly {
+			return wgtypes.ErrUpdateOnlyNotSupported
+		}
+	}
+
+	c.configured = append(c.configured, cfg)
+	return nil
+}
+
+func mustKey(s string) wgtypes.Key {
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return k
+}
+
+func durPtr(d time.Duration) *time.Duration { return &d }
+func intPtr(v int) *int                     { return &v }
+func keyPtr(k wgtypes.Key) *wgtypes.Key     { return &k }
@@ -0,0 +1,29 @@
+// Package wgrotate rotates the private keys and preshared keys of WireGuard
+// devices across a set of nodes, updating every node which has the rotated
+// node as a peer.
+//
+// A Rotator computes a Plan for a Rotation, containing the new keys and
+// the wgtypes.Config sent to each node, and applies it in phases so that
+// established tunnels are interrupted as briefly as possible:
+//
+//   - Stage: for private key rotations, each affected node adds a peer
+//     with the new public key, without allowed IPs, so that it accepts
+//     handshakes from the new key while its tunnel with the old key keeps
+//     working.
+//   - Switch: the rotated node starts using the new private key, or the new
+//     preshared keys.
+//   - Commit: each affected node moves the allowed IPs of the old key to
+//     the new key and removes the old key, or starts using the new
+//     preshared key.
+//
+// If a Stage or Switch step fails, any Stage steps which were applied are
+// reverted, and the rotated node keeps its keys. Once the Commit phase is
+// complete, the Rotator polls the devices until the LastHandshakeTime of
+// each affected pair of nodes shows that a handshake with the new keys
+// succeeded. Handshakes only occur when there is traffic, so nodes which
+// must be verified promptly should use persistent keepalive.
+//
+// Each applied Rotation can be recorded in a History file, which also
+// stores the Schedule of rotation intervals used to find the rotations
+// which are due.
+package wgrotate // import "golang.zx2c4.com/wireguard/wgctrl/wgrotate"
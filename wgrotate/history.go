@@ -0,0 +1,382 @@
+package wgrotate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgfile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Status is the outcome of a Rotation.
+type Status int
+
+// Possible Status values.
+const (
+	// Completed indicates that all Steps were applied and all Checks
+	// succeeded.
+	Completed Status = iota
+
+	// Unverified indicates that all Steps were applied, but not all Checks
+	// succeeded before the verification timeout expired.
+	Unverified
+
+	// Failed indicates that a Step could not be applied. If the failed Step
+	// preceded the Commit phase, the rotated node kept its keys.
+	Failed
+)
+
+// String returns the string representation of a Status.
+func (s Status) String() string {
+	switch s {
+	case Completed:
+		return "completed"
+	case Unverified:
+		return "unverified"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// A Record describes an applied Rotation.
+type Record struct {
+	// Time is the time at which the Rotation was applied.
+	Time time.Time
+
+	// Rotation is the Rotation which was applied.
+	Rotation Rotation
+
+	// PreviousPublicKey and PublicKey are the public keys of the rotated
+	// node before and after the Rotation.
+	PreviousPublicKey wgtypes.Key
+	PublicKey         wgtypes.Key
+
+	// Nodes are the names of the nodes which were affected.
+	Nodes []string
+
+	// Status is the outcome of the Rotation, and Error describes the
+	// problem if the Status is not Completed.
+	Status Status
+	Error  string
+}
+
+// A Schedule specifies how often the keys of each node are rotated. A zero
+// interval disables rotations of that Kind.
+type Schedule struct {
+	PrivateKey   time.Duration
+	PresharedKey time.Duration
+}
+
+// A History records the Schedule and the Rotations which were applied, in
+// a JSON file. The file is replaced atomically whenever the History changes,
+// and a lock file named after it with a ".lock" suffix is locked while the
+// file is read and updated. A History is safe for concurrent use, and
+// Histories in multiple processes may share a file.
+type History struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewHistory creates a History which is stored in the file at path. The
+// file is created when the History is first changed.
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// Schedule returns the Schedule. A History without a Schedule returns the
+// zero Schedule.
+func (h *History) Schedule() (Schedule, error) {
+	var s Schedule
+	err := h.update(func(f *historyFile) (bool, error) {
+		s = f.schedule
+		return false, nil
+	})
+
+	return s, err
+}
+
+// SetSchedule sets the Schedule.
+func (h *History) SetSchedule(s Schedule) error {
+	if s.PrivateKey < 0 || s.PresharedKey < 0 {
+		return fmt.Errorf("wgrotate: negative rotation interval")
+	}
+
+	return h.update(func(f *historyFile) (bool, error) {
+		f.schedule = s
+		return true, nil
+	})
+}
+
+// Records returns all Records, in the order in which they were added.
+func (h *History) Records() ([]Record, error) {
+	var out []Record
+	err := h.update(func(f *historyFile) (bool, error) {
+		out = f.records
+		return false, nil
+	})
+
+	return out, err
+}
+
+// Add adds r to the History.
+func (h *History) Add(r Record) error {
+	return h.update(func(f *historyFile) (bool, error) {
+		f.records = append(f.records, r)
+		return true, nil
+	})
+}
+
+// Due returns the Rotations of nodes which are due at now according to the
+// Schedule, in the order of nodes, with private key rotations first for
+// each node. A Rotation is due if it has no Record other than failed ones,
+// or if its interval has passed since the latest such Record.
+func (h *History) Due(nodes []string, now time.Time) ([]Rotation, error) {
+	var out []Rotation
+	err := h.update(func(f *historyFile) (bool, error) {
+		last := make(map[Rotation]time.Time)
+		for _, r := range f.records {
+			if r.Status != Failed && r.Time.After(last[r.Rotation]) {
+				last[r.Rotation] = r.Time
+			}
+		}
+
+		for _, n := range nodes {
+			for _, k := range []Kind{PrivateKey, PresharedKey} {
+				interval := f.schedule.PrivateKey
+				if k == PresharedKey {
+					interval = f.schedule.PresharedKey
+				}
+				if interval == 0 {
+					continue
+				}
+
+				rot := Rotation{Kind: k, Node: n}
+				t, ok := last[rot]
+				if !ok || !now.Before(t.Add(interval)) {
+					out = append(out, rot)
+				}
+			}
+		}
+
+		return false, nil
+	})
+
+	return out, err
+}
+
+// update reads the History file and calls fn with its contents, and saves
+// them if fn reports that it changed them. The file is locked for the
+// duration of update.
+func (h *History) update(fn func(f *historyFile) (bool, error)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	unlock, err := wgfile.Lock(h.path)
+	if err != nil {
+		return fmt.Errorf("wgrotate: failed to lock history: %v", err)
+	}
+	defer unlock()
+
+	f, err := readHistory(h.path)
+	if err != nil {
+		return err
+	}
+
+	changed, err := fn(f)
+	if err != nil || !changed {
+		return err
+	}
+
+	return writeHistory(h.path, f)
+}
+
+// historyVersion identifies the format of History files. Files written in
+// any other format are rejected rather than misread.
+const historyVersion = 1
+
+// A historyFile is the contents of a History file.
+type historyFile struct {
+	schedule Schedule
+	records  []Record
+}
+
+// historyJSON is the JSON representation of a historyFile.
+type historyJSON struct {
+	Version   int          `json:"version"`
+	Schedule  scheduleJSON `json:"schedule"`
+	Rotations []recordJSON `json:"rotations"`
+}
+
+// scheduleJSON is the JSON representation of a Schedule.
+type scheduleJSON struct {
+	PrivateKey   string `json:"private_key,omitempty"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+}
+
+// recordJSON is the JSON representation of a Record.
+type recordJSON struct {
+	Time              time.Time `json:"time"`
+	Kind              string    `json:"kind"`
+	Node              string    `json:"node"`
+	PreviousPublicKey string    `json:"previous_public_key"`
+	PublicKey         string    `json:"public_key"`
+	Nodes             []string  `json:"nodes"`
+	Status            string    `json:"status"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// readHistory reads the History file at path. A file which does not exist
+// is an empty History.
+func readHistory(path string) (*historyFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &historyFile{}, nil
+		}
+
+		return nil, fmt.Errorf("wgrotate: failed to read history: %v", err)
+	}
+
+	var hj historyJSON
+	if err := json.Unmarshal(b, &hj); err != nil {
+		return nil, fmt.Errorf("wgrotate: failed to parse history in %s: %v", path, err)
+	}
+	if hj.Version != historyVersion {
+		return nil, fmt.Errorf("wgrotate: unsupported history version %d in %s", hj.Version, path)
+	}
+
+	f := &historyFile{
+		records: make([]Record, 0, len(hj.Rotations)),
+	}
+
+	if f.schedule.PrivateKey, err = parseInterval(hj.Schedule.PrivateKey); err != nil {
+		return nil, fmt.Errorf("wgrotate: invalid private key interval in %s: %v", path, err)
+	}
+	if f.schedule.PresharedKey, err = parseInterval(hj.Schedule.PresharedKey); err != nil {
+		return nil, fmt.Errorf("wgrotate: invalid preshared key interval in %s: %v", path, err)
+	}
+
+	for i, rj := range hj.Rotations {
+		r, err := rj.record()
+		if err != nil {
+			return nil, fmt.Errorf("wgrotate: invalid rotation %d in %s: %v", i, path, err)
+		}
+
+		f.records = append(f.records, *r)
+	}
+
+	return f, nil
+}
+
+// writeHistory atomically replaces the History file at path with f.
+func writeHistory(path string, f *historyFile) error {
+	hj := historyJSON{
+		Version:   historyVersion,
+		Rotations: make([]recordJSON, 0, len(f.records)),
+	}
+
+	if d := f.schedule.PrivateKey; d != 0 {
+		hj.Schedule.PrivateKey = d.String()
+	}
+	if d := f.schedule.PresharedKey; d != 0 {
+		hj.Schedule.PresharedKey = d.String()
+	}
+
+	for _, r := range f.records {
+		hj.Rotations = append(hj.Rotations, recordJSON{
+			Time:              r.Time,
+			Kind:              r.Rotation.Kind.String(),
+			Node:              r.Rotation.Node,
+			PreviousPublicKey: r.PreviousPublicKey.String(),
+			PublicKey:         r.PublicKey.String(),
+			Nodes:             r.Nodes,
+			Status:            r.Status.String(),
+			Error:             r.Error,
+		})
+	}
+
+	b, err := json.MarshalIndent(hj, "", "  ")
+	if err != nil {
+		return fmt.Errorf("wgrotate: failed to marshal history: %v", err)
+	}
+	b = append(b, '\n')
+
+	if err := wgfile.WriteFile(path, b); err != nil {
+		return fmt.Errorf("wgrotate: failed to write history: %v", err)
+	}
+
+	return nil
+}
+
+// record parses the Record in rj.
+func (rj recordJSON) record() (*Record, error) {
+	r := &Record{
+		Time:     rj.Time,
+		Rotation: Rotation{Node: rj.Node},
+		Nodes:    rj.Nodes,
+		Error:    rj.Error,
+	}
+
+	var ok bool
+	if r.Rotation.Kind, ok = parseKind(rj.Kind); !ok {
+		return nil, fmt.Errorf("unknown kind %q", rj.Kind)
+	}
+	if r.Status, ok = parseStatus(rj.Status); !ok {
+		return nil, fmt.Errorf("unknown status %q", rj.Status)
+	}
+
+	var err error
+	if r.PreviousPublicKey, err = wgtypes.ParseKey(rj.PreviousPublicKey); err != nil {
+		return nil, err
+	}
+	if r.PublicKey, err = wgtypes.ParseKey(rj.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// parseInterval parses a Schedule interval, which is zero if s is empty.
+func parseInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative interval %s", s)
+	}
+
+	return d, nil
+}
+
+// parseKind parses the string representation of a Kind.
+func parseKind(s string) (Kind, bool) {
+	for _, k := range []Kind{PrivateKey, PresharedKey} {
+		if k.String() == s {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseStatus parses the string representation of a Status.
+func parseStatus(s string) (Status, bool) {
+	for _, st := range []Status{Completed, Unverified, Failed} {
+		if st.String() == s {
+			return st, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,187 @@
+package wgrotate_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/wgrotate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestHistoryDue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h := wgrotate.NewHistory(path)
+
+	// Nothing is due without a Schedule.
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	due, err := h.Due([]string{"a", "b"}, now)
+	if err != nil {
+		t.Fatalf("failed to get due rotations: %v", err)
+	}
+	if diff := cmp.Diff([]wgrotate.Rotation(nil), due); diff != "" {
+		t.Fatalf("unexpected due rotations (-want +got):\n%s", diff)
+	}
+
+	s := wgrotate.Schedule{
+		PrivateKey:   90 * 24 * time.Hour,
+		PresharedKey: 30 * 24 * time.Hour,
+	}
+	if err := h.SetSchedule(s); err != nil {
+		t.Fatalf("failed to set schedule: %v", err)
+	}
+
+	add := func(kind wgrotate.Kind, node string, age time.Duration, status wgrotate.Status) {
+		t.Helper()
+
+		err := h.Add(wgrotate.Record{
+			Time:     now.Add(-age),
+			Rotation: wgrotate.Rotation{Kind: kind, Node: node},
+			Status:   status,
+		})
+		if err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+	}
+
+	day := 24 * time.Hour
+	add(wgrotate.PrivateKey, "a", 100*day, wgrotate.Completed)
+	add(wgrotate.PrivateKey, "a", 10*day, wgrotate.Unverified)
+	add(wgrotate.PresharedKey, "a", 30*day, wgrotate.Completed)
+	add(wgrotate.PrivateKey, "b", 1*day, wgrotate.Failed)
+	add(wgrotate.PresharedKey, "b", 29*day, wgrotate.Completed)
+
+	due, err = h.Due([]string{"a", "b", "c"}, now)
+	if err != nil {
+		t.Fatalf("failed to get due rotations: %v", err)
+	}
+
+	want := []wgrotate.Rotation{
+		{Kind: wgrotate.PresharedKey, Node: "a"},
+		{Kind: wgrotate.PrivateKey, Node: "b"},
+		{Kind: wgrotate.PrivateKey, Node: "c"},
+		{Kind: wgrotate.PresharedKey, Node: "c"},
+	}
+	if diff := cmp.Diff(want, due); diff != "" {
+		t.Fatalf("unexpected due rotations (-want +got):\n%s", diff)
+	}
+
+	// The Schedule is read from the file again.
+	got, err := wgrotate.NewHistory(path).Schedule()
+	if err != nil {
+		t.Fatalf("failed to get schedule: %v", err)
+	}
+	if diff := cmp.Diff(s, got); diff != "" {
+		t.Fatalf("unexpected schedule (-want +got):\n%s", diff)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h := wgrotate.NewHistory(path)
+
+	if err := h.SetSchedule(wgrotate.Schedule{PrivateKey: 720 * time.Hour}); err != nil {
+		t.Fatalf("failed to set schedule: %v", err)
+	}
+
+	var (
+		old = mustKey("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+		pub = mustKey("HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")
+	)
+
+	err := h.Add(wgrotate.Record{
+		Time:              time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+		Rotation:          wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"},
+		PreviousPublicKey: old,
+		PublicKey:         pub,
+		Nodes:             []string{"b"},
+		Status:            wgrotate.Unverified,
+		Error:             "wgrotate: no handshake with the new keys between b and a",
+	})
+	if err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+
+	const want = `{
+  "version": 1,
+  "schedule": {
+    "private_key": "720h0m0s"
+  },
+  "rotations": [
+    {
+      "time": "2024-03-01T12:00:00Z",
+      "kind": "private key",
+      "node": "a",
+      "previous_public_key": "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",
+      "public_key": "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=",
+      "nodes": [
+        "b"
+      ],
+      "status": "unverified",
+      "error": "wgrotate: no handshake with the new keys between b and a"
+    }
+  ]
+}
+`
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected history file (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 2}`), 0o600); err != nil {
+		t.Fatalf("failed to write history: %v", err)
+	}
+
+	_, err = h.Records()
+	wantErr := "wgrotate: unsupported history version 2 in " + path
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("expected error %q, but got: %v", wantErr, err)
+	}
+}
+
+func TestHistoryShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	// Histories which share a file do not lose each other's Records.
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, h := range []*wgrotate.History{wgrotate.NewHistory(path), wgrotate.NewHistory(path)} {
+		go func(h *wgrotate.History) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				err := h.Add(wgrotate.Record{
+					Rotation: wgrotate.Rotation{Kind: wgrotate.PresharedKey, Node: "a"},
+				})
+				if err != nil {
+					panic(err)
+				}
+			}
+		}(h)
+	}
+	wg.Wait()
+
+	records, err := wgrotate.NewHistory(path).Records()
+	if err != nil {
+		t.Fatalf("failed to get records: %v", err)
+	}
+	if diff := cmp.Diff(2*n, len(records)); diff != "" {
+		t.Fatalf("unexpected number of records (-want +got):\n%s", diff)
+	}
+}
+
+func mustKey(s string) wgtypes.Key {
+	k, err := wgtypes.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return k
+}
@@ -0,0 +1,700 @@
+package wgrotate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Client is the subset of *wgctrl.Client used by a Rotator.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// A Node is a WireGuard device which takes part in rotations.
+type Node struct {
+	// Name is the unique name of the node.
+	Name string
+
+	// Device is the name of the node's WireGuard device.
+	Device string
+
+	// Client is used to retrieve and configure the device. Nodes may share
+	// a Client when their devices are on the same host.
+	Client Client
+}
+
+// A Kind is a kind of key which is rotated.
+type Kind int
+
+// Possible Kind values.
+const (
+	// PrivateKey rotates the private key of a node. Each node which has the
+	// node as a peer is updated to use its new public key.
+	PrivateKey Kind = iota
+
+	// PresharedKey rotates the preshared keys which a node shares with
+	// each node which it has as a peer, and which has it as a peer in
+	// return. Each pair of nodes uses a distinct preshared key.
+	PresharedKey
+)
+
+// String returns the string representation of a Kind.
+func (k Kind) String() string {
+	switch k {
+	case PrivateKey:
+		return "private key"
+	case PresharedKey:
+		return "preshared key"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// A Rotation specifies a key rotation.
+type Rotation struct {
+	// Kind is the kind of key which is rotated.
+	Kind Kind
+
+	// Node is the name of the node whose keys are rotated.
+	Node string
+}
+
+// A Phase is a phase of the application of a Plan.
+type Phase int
+
+// Possible Phase values, in the order in which they are applied.
+const (
+	// Stage prepares the affected nodes for a new key, without
+	// interrupting their tunnels with the rotated node.
+	Stage Phase = iota
+
+	// Switch changes the keys of the rotated node.
+	Switch
+
+	// Commit changes the keys of the affected nodes, and removes the old
+	// keys.
+	Commit
+)
+
+// String returns the string representation of a Phase.
+func (p Phase) String() string {
+	switch p {
+	case Stage:
+		return "stage"
+	case Switch:
+		return "switch"
+	case Commit:
+		return "commit"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// A Step is a configuration which is applied to a node.
+type Step struct {
+	// Phase is the phase in which the Step is applied.
+	Phase Phase
+
+	// Node is the name of the node which is configured.
+	Node string
+
+	// Config is the configuration which is sent to the node's device with
+	// ConfigureDevice.
+	Config wgtypes.Config
+}
+
+// String describes the operations of the Step, such as
+// "stage b: add peer <key>". Keys other than public keys are never
+// included.
+func (s Step) String() string {
+	var ops []string
+	if s.Config.PrivateKey != nil {
+		ops = append(ops, "set private key")
+	}
+
+	for _, pc := range s.Config.Peers {
+		switch {
+		case pc.Remove:
+			ops = append(ops, fmt.Sprintf("remove peer %s", pc.PublicKey))
+		case pc.ReplaceAllowedIPs:
+			ops = append(ops, fmt.Sprintf("move allowed ips to peer %s", pc.PublicKey))
+		case s.Phase != Stage && pc.PresharedKey != nil:
+			ops = append(ops, fmt.Sprintf("set preshared key of peer %s", pc.PublicKey))
+		default:
+			ops = append(ops, fmt.Sprintf("add peer %s", pc.PublicKey))
+		}
+	}
+
+	return fmt.Sprintf("%s %s: %s", s.Phase, s.Node, strings.Join(ops, ", "))
+}
+
+// A Check is a handshake which verifies that a Plan was applied: the device
+// of Node must complete a handshake with the peer PublicKey, which belongs
+// to the node Peer.
+type Check struct {
+	Node      string
+	Peer      string
+	PublicKey wgtypes.Key
+}
+
+// A Plan is the set of configurations which applies a Rotation.
+type Plan struct {
+	// Rotation is the Rotation which is applied.
+	Rotation Rotation
+
+	// PreviousPublicKey and PublicKey are the public keys of the rotated
+	// node before and after the Rotation. They are equal unless the
+	// private key is rotated.
+	PreviousPublicKey wgtypes.Key
+	PublicKey         wgtypes.Key
+
+	// Nodes are the names of the nodes which are affected by the Rotation,
+	// in the order in which the nodes were passed to New.
+	Nodes []string
+
+	// Unmanaged are the public keys of peers of the rotated node which do
+	// not belong to any node. They cannot be updated, so their tunnels
+	// with the rotated node stop working if its private key is rotated.
+	Unmanaged []wgtypes.Key
+
+	// Steps are the configurations which are applied, in order.
+	Steps []Step
+
+	// Checks are the handshakes which are awaited after all Steps are
+	// applied.
+	Checks []Check
+}
+
+// String describes the Steps and Checks of the Plan, one per line. Keys
+// other than public keys are never included.
+func (p *Plan) String() string {
+	var b bytes.Buffer
+	for _, s := range p.Steps {
+		fmt.Fprintf(&b, "%s\n", s)
+	}
+	for _, c := range p.Checks {
+		fmt.Fprintf(&b, "verify %s: handshake with peer %s\n", c.Node, c.PublicKey)
+	}
+
+	return b.String()
+}
+
+const (
+	// DefaultVerifyTimeout is the time which a Rotator waits for handshakes
+	// with new keys if none is specified. It is longer than the two minute
+	// interval after which WireGuard renews sessions, so that peers which
+	// exchange traffic perform a handshake before it expires.
+	DefaultVerifyTimeout = 3 * time.Minute
+
+	// DefaultPollInterval is the interval at which a Rotator polls devices
+	// for handshakes if none is specified.
+	DefaultPollInterval = 5 * time.Second
+)
+
+// Options configures a Rotator.
+type Options struct {
+	// VerifyTimeout specifies how long to wait for handshakes with new
+	// keys after a Plan is applied. If zero, DefaultVerifyTimeout is used.
+	VerifyTimeout time.Duration
+
+	// PollInterval specifies the interval at which devices are polled for
+	// handshakes. If zero, DefaultPollInterval is used.
+	PollInterval time.Duration
+
+	// AllowUnmanaged specifies that the private key of a node may be
+	// rotated even if it has peers which are not nodes, breaking their
+	// tunnels.
+	AllowUnmanaged bool
+
+	// History, if not nil, records each Plan which is applied.
+	History *History
+}
+
+// A Rotator rotates the keys of a set of nodes.
+type Rotator struct {
+	nodes []Node
+	index map[string]*Node
+
+	verifyTimeout  time.Duration
+	pollInterval   time.Duration
+	allowUnmanaged bool
+	history        *History
+}
+
+// New creates a Rotator for nodes, using the optional configuration in
+// opts.
+func New(nodes []Node, opts *Options) (*Rotator, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	r := &Rotator{
+		nodes:          make([]Node, len(nodes)),
+		index:          make(map[string]*Node, len(nodes)),
+		verifyTimeout:  opts.VerifyTimeout,
+		pollInterval:   opts.PollInterval,
+		allowUnmanaged: opts.AllowUnmanaged,
+		history:        opts.History,
+	}
+	copy(r.nodes, nodes)
+
+	if r.verifyTimeout == 0 {
+		r.verifyTimeout = DefaultVerifyTimeout
+	}
+	if r.pollInterval == 0 {
+		r.pollInterval = DefaultPollInterval
+	}
+
+	for i := range r.nodes {
+		n := &r.nodes[i]
+		switch {
+		case n.Name == "":
+			return nil, fmt.Errorf("wgrotate: node %d has no name", i)
+		case n.Device == "":
+			return nil, fmt.Errorf("wgrotate: node %q has no device", n.Name)
+		case n.Client == nil:
+			return nil, fmt.Errorf("wgrotate: node %q has no client", n.Name)
+		}
+
+		if _, ok := r.index[n.Name]; ok {
+			return nil, fmt.Errorf("wgrotate: duplicate node %q", n.Name)
+		}
+		r.index[n.Name] = n
+	}
+
+	return r, nil
+}
+
+// Plan retrieves the devices of all nodes and computes the Plan which
+// applies rot, generating new keys, without changing any device.
+func (r *Rotator) Plan(rot Rotation) (*Plan, error) {
+	if _, ok := r.index[rot.Node]; !ok {
+		return nil, fmt.Errorf("wgrotate: %q is not a node", rot.Node)
+	}
+	if rot.Kind != PrivateKey && rot.Kind != PresharedKey {
+		return nil, fmt.Errorf("wgrotate: invalid rotation kind: %s", rot.Kind)
+	}
+
+	var (
+		devices = make(map[string]*wgtypes.Device, len(r.nodes))
+		owners  = make(map[wgtypes.Key]string, len(r.nodes))
+	)
+
+	for _, n := range r.nodes {
+		d, err := n.Client.Device(n.Device)
+		if err != nil {
+			return nil, fmt.Errorf("wgrotate: failed to get device of node %q: %v", n.Name, err)
+		}
+
+		devices[n.Name] = d
+		if n.Name != rot.Node && d.PublicKey != (wgtypes.Key{}) {
+			owners[d.PublicKey] = n.Name
+		}
+	}
+
+	d := devices[rot.Node]
+	p := &Plan{
+		Rotation:          rot,
+		PreviousPublicKey: d.PublicKey,
+		PublicKey:         d.PublicKey,
+	}
+
+	peers := make(map[wgtypes.Key]bool, len(d.Peers))
+	for _, peer := range d.Peers {
+		peers[peer.PublicKey] = true
+		if _, ok := owners[peer.PublicKey]; !ok {
+			p.Unmanaged = append(p.Unmanaged, peer.PublicKey)
+		}
+	}
+
+	// The affected nodes, and their peer configuration of the rotated node.
+	var affected []wgtypes.Peer
+	if d.PublicKey != (wgtypes.Key{}) {
+		for _, n := range r.nodes {
+			if n.Name == rot.Node {
+				continue
+			}
+
+			nd := devices[n.Name]
+			if rot.Kind == PresharedKey && !peers[nd.PublicKey] {
+				continue
+			}
+
+			for _, peer := range nd.Peers {
+				if peer.PublicKey == d.PublicKey {
+					p.Nodes = append(p.Nodes, n.Name)
+					affected = append(affected, peer)
+					break
+				}
+			}
+		}
+	}
+
+	var err error
+	switch rot.Kind {
+	case PrivateKey:
+		err = p.rotatePrivateKey(affected)
+	case PresharedKey:
+		err = p.rotatePresharedKeys(devices)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// rotatePrivateKey adds the Steps and Checks which rotate the private key
+// of the node, given the peer configuration of the node on each affected
+// node.
+func (p *Plan) rotatePrivateKey(affected []wgtypes.Peer) error {
+	priv, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return fmt.Errorf("wgrotate: failed to generate private key: %v", err)
+	}
+	p.PublicKey = priv.PublicKey()
+
+	var commits []Step
+	for i, peer := range affected {
+		name := p.Nodes[i]
+
+		// The new peer inherits the settings of the old peer, except for its
+		// allowed IPs, which would otherwise be removed from the old peer
+		// before the rotated node can use the new key.
+		stage := wgtypes.PeerConfig{
+			PublicKey: p.PublicKey,
+			Endpoint:  peer.Endpoint,
+		}
+		if peer.PresharedKey != (wgtypes.Key{}) {
+			psk := peer.PresharedKey
+			stage.PresharedKey = &psk
+		}
+		if peer.PersistentKeepaliveInterval != 0 {
+			interval := peer.PersistentKeepaliveInterval
+			stage.PersistentKeepaliveInterval = &interval
+		}
+
+		p.Steps = append(p.Steps, Step{
+			Phase:  Stage,
+			Node:   name,
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{stage}},
+		})
+
+		// The new peer was just added by the Stage step, so the Commit step
+		// does not need UpdateOnly, which is not supported on all platforms.
+		commits = append(commits, Step{
+			Phase: Commit,
+			Node:  name,
+			Config: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{
+					{
+						PublicKey:         p.PublicKey,
+						ReplaceAllowedIPs: true,
+						AllowedIPs:        peer.AllowedIPs,
+					},
+					{
+						PublicKey: p.PreviousPublicKey,
+						Remove:    true,
+					},
+				},
+			},
+		})
+
+		p.Checks = append(p.Checks, Check{
+			Node:      name,
+			Peer:      p.Rotation.Node,
+			PublicKey: p.PublicKey,
+		})
+	}
+
+	p.Steps = append(p.Steps, Step{
+		Phase:  Switch,
+		Node:   p.Rotation.Node,
+		Config: wgtypes.Config{PrivateKey: &priv},
+	})
+	p.Steps = append(p.Steps, commits...)
+
+	return nil
+}
+
+// rotatePresharedKeys adds the Steps and Checks which rotate the preshared
+// keys of the node with each affected node.
+func (p *Plan) rotatePresharedKeys(devices map[string]*wgtypes.Device) error {
+	var (
+		update  wgtypes.Config
+		commits []Step
+	)
+
+	for _, name := range p.Nodes {
+		psk, err := wgtypes.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("wgrotate: failed to generate preshared key: %v", err)
+		}
+
+		pub := devices[name].PublicKey
+		update.Peers = append(update.Peers, wgtypes.PeerConfig{
+			PublicKey:    pub,
+			PresharedKey: &psk,
+		})
+
+		commits = append(commits, Step{
+			Phase: Commit,
+			Node:  name,
+			Config: wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{
+					PublicKey:    p.PublicKey,
+					PresharedKey: &psk,
+				}},
+			},
+		})
+
+		p.Checks = append(p.Checks, Check{
+			Node:      p.Rotation.Node,
+			Peer:      name,
+			PublicKey: pub,
+		})
+	}
+
+	if len(update.Peers) > 0 {
+		p.Steps = append(p.Steps, Step{
+			Phase:  Switch,
+			Node:   p.Rotation.Node,
+			Config: update,
+		})
+	}
+	p.Steps = append(p.Steps, commits...)
+
+	return nil
+}
+
+// Apply applies the Steps of p in order, and then waits until all of its
+// Checks succeed, the verification timeout expires, or ctx is canceled.
+//
+// If a Stage or Switch step fails, the Stage steps which were applied are
+// reverted. Commit steps are applied to all nodes even if some of them
+// fail. The returned Record describes the outcome, and is also added to the
+// History, if any. Apply returns a nil Record only if p was not applied at
+// all, because the private key of a node with unmanaged peers would be
+// rotated and unmanaged peers are not allowed.
+func (r *Rotator) Apply(ctx context.Context, p *Plan) (*Record, error) {
+	if p.Rotation.Kind == PrivateKey && len(p.Unmanaged) > 0 && !r.allowUnmanaged {
+		keys := make([]string, 0, len(p.Unmanaged))
+		for _, k := range p.Unmanaged {
+			keys = append(keys, k.String())
+		}
+
+		return nil, fmt.Errorf("wgrotate: node %q has peers which are not nodes: %s",
+			p.Rotation.Node, strings.Join(keys, ", "))
+	}
+
+	rec := &Record{
+		Time:              time.Now(),
+		Rotation:          p.Rotation,
+		PreviousPublicKey: p.PreviousPublicKey,
+		PublicKey:         p.PublicKey,
+		Nodes:             append([]string(nil), p.Nodes...),
+	}
+
+	status, err := r.apply(ctx, p)
+	rec.Status = status
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	if r.history != nil {
+		if herr := r.history.Add(*rec); herr != nil && err == nil {
+			err = herr
+		}
+	}
+
+	return rec, err
+}
+
+// Rotate computes the Plan which applies rot and applies it.
+func (r *Rotator) Rotate(ctx context.Context, rot Rotation) (*Record, error) {
+	p, err := r.Plan(rot)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Apply(ctx, p)
+}
+
+// apply applies p for Apply and returns the Status of the Rotation.
+func (r *Rotator) apply(ctx context.Context, p *Plan) (Status, error) {
+	var staged []Step
+	for _, s := range p.Steps {
+		if s.Phase != Stage {
+			continue
+		}
+
+		if err := r.configure(s); err != nil {
+			return Failed, r.revert(staged, err)
+		}
+		staged = append(staged, s)
+	}
+
+	// Handshakes which precede the switch do not verify the new keys.
+	before, err := r.handshakes(p.Checks)
+	if err != nil {
+		return Failed, r.revert(staged, err)
+	}
+
+	for _, s := range p.Steps {
+		if s.Phase != Switch {
+			continue
+		}
+
+		if err := r.configure(s); err != nil {
+			return Failed, r.revert(staged, err)
+		}
+	}
+
+	var errs []string
+	for _, s := range p.Steps {
+		if s.Phase != Commit {
+			continue
+		}
+
+		if err := r.configure(s); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return Failed, fmt.Errorf("wgrotate: failed to commit rotation: %s", strings.Join(errs, "; "))
+	}
+
+	pending := r.verify(ctx, p.Checks, before)
+	if len(pending) > 0 {
+		pairs := make([]string, 0, len(pending))
+		for _, c := range pending {
+			pairs = append(pairs, fmt.Sprintf("%s and %s", c.Node, c.Peer))
+		}
+
+		return Unverified, fmt.Errorf("wgrotate: no handshake with the new keys between %s",
+			strings.Join(pairs, ", "))
+	}
+
+	return Completed, nil
+}
+
+// configure applies s to its node.
+func (r *Rotator) configure(s Step) error {
+	n := r.index[s.Node]
+	if err := n.Client.ConfigureDevice(n.Device, s.Config); err != nil {
+		return fmt.Errorf("wgrotate: failed to configure node %q: %v", n.Name, err)
+	}
+
+	return nil
+}
+
+// revert removes the peers added by the Stage steps in staged, and returns
+// err along with any errors which occurred while doing so.
+func (r *Rotator) revert(staged []Step, err error) error {
+	var errs []string
+	for _, s := range staged {
+		var cfg wgtypes.Config
+		for _, pc := range s.Config.Peers {
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+				PublicKey: pc.PublicKey,
+				Remove:    true,
+			})
+		}
+
+		if err := r.configure(Step{Node: s.Node, Config: cfg}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%v; failed to revert: %s", err, strings.Join(errs, "; "))
+}
+
+// handshakes returns the last handshake time of each Check.
+func (r *Rotator) handshakes(checks []Check) (map[Check]time.Time, error) {
+	out := make(map[Check]time.Time, len(checks))
+	devices := make(map[string]*wgtypes.Device)
+
+	for _, c := range checks {
+		t, err := r.handshake(c, devices)
+		if err != nil {
+			return nil, err
+		}
+
+		out[c] = t
+	}
+
+	return out, nil
+}
+
+// verify polls the devices of checks until each Check has a handshake
+// after the time in before, the verification timeout expires, or ctx is
+// canceled, and returns the Checks which did not succeed.
+func (r *Rotator) verify(ctx context.Context, checks []Check, before map[Check]time.Time) []Check {
+	ctx, cancel := context.WithTimeout(ctx, r.verifyTimeout)
+	defer cancel()
+
+	t := time.NewTicker(r.pollInterval)
+	defer t.Stop()
+
+	pending := checks
+	for {
+		// Devices are retrieved at most once per poll, and errors are
+		// treated as a missing handshake, so that a node which is briefly
+		// unavailable is checked again.
+		devices := make(map[string]*wgtypes.Device)
+
+		var next []Check
+		for _, c := range pending {
+			hs, err := r.handshake(c, devices)
+			if err != nil || !hs.After(before[c]) {
+				next = append(next, c)
+			}
+		}
+
+		pending = next
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-t.C:
+		}
+	}
+}
+
+// handshake returns the last handshake time of c, using and populating the
+// cache of devices. A peer which does not exist has no handshake.
+func (r *Rotator) handshake(c Check, devices map[string]*wgtypes.Device) (time.Time, error) {
+	d, ok := devices[c.Node]
+	if !ok {
+		n := r.index[c.Node]
+
+		var err error
+		d, err = n.Client.Device(n.Device)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("wgrotate: failed to get device of node %q: %v", n.Name, err)
+		}
+
+		devices[c.Node] = d
+	}
+
+	for _, p := range d.Peers {
+		if p.PublicKey == c.PublicKey {
+			return p.LastHandshakeTime, nil
+		}
+	}
+
+	return time.Time{}, nil
+}
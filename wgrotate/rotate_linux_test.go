@@ -0,0 +1,385 @@
+//go:build linux
+// +build linux
+
+package wgrotate_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgctrltest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgrotate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRotatePrivateKey(t *testing.T) {
+	n := newNetwork(t, "a", "b", "c")
+	history := wgrotate.NewHistory(filepath.Join(t.TempDir(), "history.json"))
+
+	r, err := wgrotate.New(testNodes(n, "a", "b", "c"), &wgrotate.Options{
+		PollInterval: 10 * time.Millisecond,
+		History:      history,
+	})
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	old := n.device("a").PublicKey
+	pskAB := n.peer("b", old).PresharedKey
+
+	rec, err := r.Rotate(context.Background(), wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"})
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+
+	pub := n.device("a").PublicKey
+	if pub == old {
+		t.Fatal("private key was not rotated")
+	}
+
+	for _, name := range []string{"b", "c"} {
+		if p := n.peer(name, old); p != nil {
+			t.Fatalf("node %q still has the old public key as a peer", name)
+		}
+
+		p := n.peer(name, pub)
+		if p == nil {
+			t.Fatalf("node %q does not have the new public key as a peer", name)
+		}
+
+		if diff := cmp.Diff(n.routes("a"), p.AllowedIPs); diff != "" {
+			t.Fatalf("unexpected allowed IPs on node %q (-want +got):\n%s", name, diff)
+		}
+		if diff := cmp.Diff(n.endpoint("a"), p.Endpoint); diff != "" {
+			t.Fatalf("unexpected endpoint on node %q (-want +got):\n%s", name, diff)
+		}
+	}
+
+	// The preshared key of a and b is kept.
+	if diff := cmp.Diff(pskAB, n.peer("b", pub).PresharedKey); diff != "" {
+		t.Fatalf("unexpected preshared key (-want +got):\n%s", diff)
+	}
+
+	want := []wgrotate.Record{{
+		Time:              rec.Time,
+		Rotation:          wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"},
+		PreviousPublicKey: old,
+		PublicKey:         pub,
+		Nodes:             []string{"b", "c"},
+		Status:            wgrotate.Completed,
+	}}
+
+	if diff := cmp.Diff(want[0], *rec); diff != "" {
+		t.Fatalf("unexpected record (-want +got):\n%s", diff)
+	}
+
+	records, err := history.Records()
+	if err != nil {
+		t.Fatalf("failed to get records: %v", err)
+	}
+
+	// Times are only compared at the precision of the history file.
+	opt := cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })
+	if diff := cmp.Diff(want, records, opt); diff != "" {
+		t.Fatalf("unexpected records (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotatePresharedKey(t *testing.T) {
+	n := newNetwork(t, "a", "b", "c")
+
+	r, err := wgrotate.New(testNodes(n, "a", "b", "c"), &wgrotate.Options{
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	var (
+		pubA = n.device("a").PublicKey
+		pubB = n.device("b").PublicKey
+		pubC = n.device("c").PublicKey
+		pskB = n.peer("a", pubB).PresharedKey
+		pskC = n.peer("a", pubC).PresharedKey
+	)
+
+	rec, err := r.Rotate(context.Background(), wgrotate.Rotation{Kind: wgrotate.PresharedKey, Node: "a"})
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	if diff := cmp.Diff(wgrotate.Completed, rec.Status); diff != "" {
+		t.Fatalf("unexpected status (-want +got):\n%s", diff)
+	}
+
+	newB, newC := n.peer("a", pubB).PresharedKey, n.peer("a", pubC).PresharedKey
+	if newB == pskB || newC == pskC || newB == newC {
+		t.Fatal("preshared keys were not rotated to distinct keys")
+	}
+
+	if diff := cmp.Diff(newB, n.peer("b", pubA).PresharedKey); diff != "" {
+		t.Fatalf("unexpected preshared key of b (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(newC, n.peer("c", pubA).PresharedKey); diff != "" {
+		t.Fatalf("unexpected preshared key of c (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotateRevert(t *testing.T) {
+	n := newNetwork(t, "a", "b", "c")
+	n.fail["wg-c"] = true
+
+	r, err := wgrotate.New(testNodes(n, "a", "b", "c"), nil)
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	before := map[string]*wgtypes.Device{
+		"a": n.device("a"),
+		"b": n.device("b"),
+		"c": n.device("c"),
+	}
+
+	rec, err := r.Rotate(context.Background(), wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"})
+	const want = `wgrotate: failed to configure node "c": device unavailable`
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, but got: %v", want, err)
+	}
+
+	if diff := cmp.Diff(wgrotate.Failed, rec.Status); diff != "" {
+		t.Fatalf("unexpected status (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, rec.Error); diff != "" {
+		t.Fatalf("unexpected record error (-want +got):\n%s", diff)
+	}
+
+	// The peer staged on b is removed again, and a keeps its private key.
+	for name, d := range before {
+		if diff := cmp.Diff(d, n.device(name)); diff != "" {
+			t.Fatalf("unexpected device of node %q (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+func TestRotateUnverified(t *testing.T) {
+	n := newNetwork(t, "a", "b", "c")
+	n.down["wg-c"] = true
+
+	r, err := wgrotate.New(testNodes(n, "a", "b", "c"), &wgrotate.Options{
+		VerifyTimeout: 50 * time.Millisecond,
+		PollInterval:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	rec, err := r.Rotate(context.Background(), wgrotate.Rotation{Kind: wgrotate.PresharedKey, Node: "a"})
+	const want = "wgrotate: no handshake with the new keys between a and c"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, but got: %v", want, err)
+	}
+
+	if diff := cmp.Diff(wgrotate.Unverified, rec.Status); diff != "" {
+		t.Fatalf("unexpected status (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotateWithoutUpdateOnly(t *testing.T) {
+	// Like FreeBSD, the devices reject peers with UpdateOnly.
+	n := newNetwork(t, "a", "b", "c")
+	n.noUpdateOnly = true
+
+	r, err := wgrotate.New(testNodes(n, "a", "b", "c"), &wgrotate.Options{
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	for _, kind := range []wgrotate.Kind{wgrotate.PrivateKey, wgrotate.PresharedKey} {
+		rec, err := r.Rotate(context.Background(), wgrotate.Rotation{Kind: kind, Node: "a"})
+		if err != nil {
+			t.Fatalf("failed to rotate %s: %v", kind, err)
+		}
+		if diff := cmp.Diff(wgrotate.Completed, rec.Status); diff != "" {
+			t.Fatalf("unexpected status of %s rotation (-want +got):\n%s", kind, diff)
+		}
+	}
+}
+
+// A network is a wgrotate.Client for a full mesh of devices in a fake
+// kernel, which simulates handshakes between devices whose keys match.
+type network struct {
+	t *testing.T
+	k *wgctrltest.Kernel
+	c *wgctrl.Client
+
+	mu           sync.Mutex
+	down         map[string]bool
+	fail         map[string]bool
+	noUpdateOnly bool
+}
+
+// newNetwork creates a network of a full mesh of nodes with the specified
+// names, with a distinct preshared key for each pair of nodes.
+func newNetwork(t *testing.T, names ...string) *network {
+	t.Helper()
+
+	n := &network{
+		t:    t,
+		k:    wgctrltest.NewKernel(nil),
+		down: make(map[string]bool),
+		fail: make(map[string]bool),
+	}
+
+	keys := make(map[string]wgtypes.Key)
+	for _, name := range names {
+		keys[name] = wgtest.MustPrivateKey()
+	}
+
+	psks := make(map[[2]string]wgtypes.Key)
+	for i, a := range names {
+		d := &wgtypes.Device{
+			Name:       "wg-" + a,
+			PrivateKey: keys[a],
+			ListenPort: n.endpoint(a).Port,
+		}
+
+		for j, b := range names {
+			if a == b {
+				continue
+			}
+
+			pair := [2]string{a, b}
+			if j < i {
+				pair = [2]string{b, a}
+			}
+			if _, ok := psks[pair]; !ok {
+				psks[pair] = wgtest.MustPresharedKey()
+			}
+
+			d.Peers = append(d.Peers, wgtypes.Peer{
+				PublicKey:    keys[b].PublicKey(),
+				PresharedKey: psks[pair],
+				Endpoint:     n.endpoint(b),
+				AllowedIPs:   n.routes(b),
+			})
+		}
+
+		n.k.AddDevice(d)
+	}
+
+	c, err := wgctrltest.NewClient(n.k, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	n.c = c
+
+	return n
+}
+
+func (n *network) Device(name string) (*wgtypes.Device, error) {
+	d, err := n.c.Device(name)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Peers which are connected have just completed a handshake.
+	now := time.Now()
+	for i := range d.Peers {
+		if n.connected(d, &d.Peers[i]) {
+			d.Peers[i].LastHandshakeTime = now
+		}
+	}
+
+	return d, nil
+}
+
+func (n *network) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	n.mu.Lock()
+	fail, noUpdateOnly := n.fail[name], n.noUpdateOnly
+	n.mu.Unlock()
+
+	if fail {
+		return errors.New("device unavailable")
+	}
+
+	for _, pc := range cfg.Peers {
+		if pc.UpdateOnly && noUpdateOnly {
+			return wgtypes.ErrUpdateOnlyNotSupported
+		}
+	}
+
+	return n.c.ConfigureDevice(name, cfg)
+}
+
+// connected reports whether device d and its peer p have each other as
+// peers with the same preshared key.
+func (n *network) connected(d *wgtypes.Device, p *wgtypes.Peer) bool {
+	if n.down[d.Name] {
+		return false
+	}
+
+	for _, name := range n.k.Names() {
+		other, _ := n.k.Device(name)
+		if n.down[name] || other.PublicKey != p.PublicKey {
+			continue
+		}
+
+		for _, op := range other.Peers {
+			if op.PublicKey == d.PublicKey && op.PresharedKey == p.PresharedKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// device returns the device of the node name.
+func (n *network) device(name string) *wgtypes.Device {
+	d, ok := n.k.Device("wg-" + name)
+	if !ok {
+		n.t.Fatalf("device of node %q does not exist", name)
+	}
+
+	return d
+}
+
+// peer returns the peer pub of the node name, or nil if it does not exist.
+func (n *network) peer(name string, pub wgtypes.Key) *wgtypes.Peer {
+	d := n.device(name)
+	for i := range d.Peers {
+		if d.Peers[i].PublicKey == pub {
+			return &d.Peers[i]
+		}
+	}
+
+	return nil
+}
+
+// endpoint returns the endpoint of the node name.
+func (n *network) endpoint(name string) *net.UDPAddr {
+	return &net.UDPAddr{
+		IP:   net.IPv4(192, 0, 2, name[0]).To4(),
+		Port: 51820,
+	}
+}
+
+// routes returns the tunnel addresses of the node name.
+func (n *network) routes(name string) []net.IPNet {
+	return []net.IPNet{
+		{IP: net.IPv4(10, 0, 0, name[0]).To4(), Mask: net.CIDRMask(32, 32)},
+	}
+}
@@ -0,0 +1,327 @@
+package wgrotate_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgrotate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPlan(t *testing.T) {
+	var (
+		privA     = wgtest.MustPrivateKey()
+		privB     = wgtest.MustPrivateKey()
+		privC     = wgtest.MustPrivateKey()
+		unmanaged = wgtest.MustPublicKey()
+		psk       = wgtest.MustPresharedKey()
+		keepalive = 25 * time.Second
+		endpointA = wgtest.MustUDPAddr("192.0.2.1:51820")
+		routesA   = []net.IPNet{wgtest.MustCIDR("10.0.0.1/32")}
+	)
+
+	c := &testClient{
+		devices: map[string]*wgtypes.Device{
+			"wg-a": {
+				Name:       "wg-a",
+				PrivateKey: privA,
+				PublicKey:  privA.PublicKey(),
+				Peers: []wgtypes.Peer{
+					{PublicKey: privB.PublicKey()},
+					{PublicKey: unmanaged},
+				},
+			},
+			"wg-b": {
+				Name:       "wg-b",
+				PrivateKey: privB,
+				PublicKey:  privB.PublicKey(),
+				Peers: []wgtypes.Peer{{
+					PublicKey:                   privA.PublicKey(),
+					PresharedKey:                psk,
+					Endpoint:                    endpointA,
+					PersistentKeepaliveInterval: keepalive,
+					AllowedIPs:                  routesA,
+				}},
+			},
+			// c has a as a peer, but a does not have c as a peer in return.
+			"wg-c": {
+				Name:       "wg-c",
+				PrivateKey: privC,
+				PublicKey:  privC.PublicKey(),
+				Peers: []wgtypes.Peer{{
+					PublicKey:  privA.PublicKey(),
+					AllowedIPs: routesA,
+				}},
+			},
+		},
+	}
+
+	r, err := wgrotate.New(testNodes(c, "a", "b", "c"), nil)
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	t.Run("private key", func(t *testing.T) {
+		p, err := r.Plan(wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"})
+		if err != nil {
+			t.Fatalf("failed to plan: %v", err)
+		}
+
+		// The new private key is generated, so retrieve it from the Plan.
+		priv := *p.Steps[2].Config.PrivateKey
+		pub := priv.PublicKey()
+
+		want := &wgrotate.Plan{
+			Rotation:          wgrotate.Rotation{Kind: wgrotate.PrivateKey, Node: "a"},
+			PreviousPublicKey: privA.PublicKey(),
+			PublicKey:         pub,
+			Nodes:             []string{"b", "c"},
+			Unmanaged:         []wgtypes.Key{unmanaged},
+			Steps: []wgrotate.Step{
+				{
+					Phase: wgrotate.Stage,
+					Node:  "b",
+					Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+						PublicKey:                   pub,
+						PresharedKey:                &psk,
+						Endpoint:                    endpointA,
+						PersistentKeepaliveInterval: &keepalive,
+					}}},
+				},
+				{
+					Phase:  wgrotate.Stage,
+					Node:   "c",
+					Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: pub}}},
+				},
+				{
+					Phase:  wgrotate.Switch,
+					Node:   "a",
+					Config: wgtypes.Config{PrivateKey: &priv},
+				},
+				commit("b", pub, privA.PublicKey(), routesA),
+				commit("c", pub, privA.PublicKey(), routesA),
+			},
+			Checks: []wgrotate.Check{
+				{Node: "b", Peer: "a", PublicKey: pub},
+				{Node: "c", Peer: "a", PublicKey: pub},
+			},
+		}
+
+		if diff := cmp.Diff(want, p); diff != "" {
+			t.Fatalf("unexpected plan (-want +got):\n%s", diff)
+		}
+
+		s := "stage b: add peer " + pub.String() + "\n" +
+			"stage c: add peer " + pub.String() + "\n" +
+			"switch a: set private key\n" +
+			"commit b: move allowed ips to peer " + pub.String() + ", remove peer " + privA.PublicKey().String() + "\n" +
+			"commit c: move allowed ips to peer " + pub.String() + ", remove peer " + privA.PublicKey().String() + "\n" +
+			"verify b: handshake with peer " + pub.String() + "\n" +
+			"verify c: handshake with peer " + pub.String() + "\n"
+		if diff := cmp.Diff(s, p.String()); diff != "" {
+			t.Fatalf("unexpected plan string (-want +got):\n%s", diff)
+		}
+
+		// The private key of a node with unmanaged peers is not rotated.
+		_, err = r.Apply(context.Background(), p)
+		const errs = `wgrotate: node "a" has peers which are not nodes: `
+		if err == nil || err.Error() != errs+unmanaged.String() {
+			t.Fatalf("expected unmanaged peers error, but got: %v", err)
+		}
+		if diff := cmp.Diff(0, len(c.configured)); diff != "" {
+			t.Fatalf("unexpected number of configurations (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("preshared key", func(t *testing.T) {
+		p, err := r.Plan(wgrotate.Rotation{Kind: wgrotate.PresharedKey, Node: "a"})
+		if err != nil {
+			t.Fatalf("failed to plan: %v", err)
+		}
+
+		// Only b and a have each other as peers.
+		newPSK := *p.Steps[0].Config.Peers[0].PresharedKey
+		if newPSK == psk {
+			t.Fatal("preshared key was not changed")
+		}
+
+		want := &wgrotate.Plan{
+			Rotation:          wgrotate.Rotation{Kind: wgrotate.PresharedKey, Node: "a"},
+			PreviousPublicKey: privA.PublicKey(),
+			PublicKey:         privA.PublicKey(),
+			Nodes:             []string{"b"},
+			Unmanaged:         []wgtypes.Key{unmanaged},
+			Steps: []wgrotate.Step{
+				{
+					Phase: wgrotate.Switch,
+					Node:  "a",
+					Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+						PublicKey:    privB.PublicKey(),
+						PresharedKey: &newPSK,
+					}}},
+				},
+				{
+					Phase: wgrotate.Commit,
+					Node:  "b",
+					Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+						PublicKey:    privA.PublicKey(),
+						PresharedKey: &newPSK,
+					}}},
+				},
+			},
+			Checks: []wgrotate.Check{{Node: "a", Peer: "b", PublicKey: privB.PublicKey()}},
+		}
+
+		if diff := cmp.Diff(want, p); diff != "" {
+			t.Fatalf("unexpected plan (-want +got):\n%s", diff)
+		}
+
+		str := "switch a: set preshared key of peer " + privB.PublicKey().String() + "\n" +
+			"commit b: set preshared key of peer " + privA.PublicKey().String() + "\n" +
+			"verify a: handshake with peer " + privB.PublicKey().String() + "\n"
+		if diff := cmp.Diff(str, p.String()); diff != "" {
+			t.Fatalf("unexpected plan string (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestPlanErrors(t *testing.T) {
+	c := &testClient{
+		devices: map[string]*wgtypes.Device{"wg-a": {Name: "wg-a"}},
+	}
+
+	r, err := wgrotate.New(testNodes(c, "a", "b"), nil)
+	if err != nil {
+		t.Fatalf("failed to create rotator: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		rot  wgrotate.Rotation
+		err  string
+	}{
+		{
+			name: "node",
+			rot:  wgrotate.Rotation{Node: "c"},
+			err:  `wgrotate: "c" is not a node`,
+		},
+		{
+			name: "kind",
+			rot:  wgrotate.Rotation{Kind: 10, Node: "a"},
+			err:  "wgrotate: invalid rotation kind: unknown(10)",
+		},
+		{
+			name: "device",
+			rot:  wgrotate.Rotation{Node: "a"},
+			err:  `wgrotate: failed to get device of node "b": file does not exist`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Plan(tt.rot)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	c := &testClient{}
+
+	tests := []struct {
+		name  string
+		nodes []wgrotate.Node
+		err   string
+	}{
+		{
+			name:  "no name",
+			nodes: []wgrotate.Node{{Device: "wg0", Client: c}},
+			err:   "wgrotate: node 0 has no name",
+		},
+		{
+			name:  "no device",
+			nodes: []wgrotate.Node{{Name: "a", Client: c}},
+			err:   `wgrotate: node "a" has no device`,
+		},
+		{
+			name:  "no client",
+			nodes: []wgrotate.Node{{Name: "a", Device: "wg0"}},
+			err:   `wgrotate: node "a" has no client`,
+		},
+		{
+			name:  "duplicate",
+			nodes: append(testNodes(c, "a"), testNodes(c, "a")...),
+			err:   `wgrotate: duplicate node "a"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wgrotate.New(tt.nodes, nil)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// commit returns the Commit step which moves routes from the peer old to
+// the peer pub on node.
+func commit(node string, pub, old wgtypes.Key, routes []net.IPNet) wgrotate.Step {
+	return wgrotate.Step{
+		Phase: wgrotate.Commit,
+		Node:  node,
+		Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey:         pub,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        routes,
+			},
+			{
+				PublicKey: old,
+				Remove:    true,
+			},
+		}},
+	}
+}
+
+// testNodes returns nodes with the specified names, whose devices are named
+// "wg-" followed by the node's name.
+func testNodes(c wgrotate.Client, names ...string) []wgrotate.Node {
+	nodes := make([]wgrotate.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, wgrotate.Node{
+			Name:   name,
+			Device: "wg-" + name,
+			Client: c,
+		})
+	}
+
+	return nodes
+}
+
+type testClient struct {
+	devices    map[string]*wgtypes.Device
+	configured []wgtypes.Config
+}
+
+func (c *testClient) Device(name string) (*wgtypes.Device, error) {
+	d, ok := c.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return d, nil
+}
+
+func (c *testClient) ConfigureDevice(_ string, cfg wgtypes.Config) error {
+	c.configured = append(c.configured, cfg)
+	return nil
+}
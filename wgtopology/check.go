@@ -0,0 +1,205 @@
+package wgtopology
+
+import (
+	"fmt"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgfmt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// An IssueKind is a kind of consistency problem found by Check.
+type IssueKind int
+
+// Possible IssueKind values.
+const (
+	// AsymmetricPeer indicates that a node has a peer which does not have
+	// the node as a peer.
+	AsymmetricPeer IssueKind = iota
+
+	// UnknownPeer indicates that a node has a peer whose public key does
+	// not belong to any node.
+	UnknownPeer
+
+	// PresharedKeyMismatch indicates that two nodes use different
+	// preshared keys for each other, so they cannot complete a handshake.
+	PresharedKeyMismatch
+
+	// Unreachable indicates that neither of two peered nodes has an
+	// endpoint for the other, so neither can initiate a handshake.
+	Unreachable
+
+	// OverlappingAllowedIPs indicates that allowed IPs of two peers of a
+	// node overlap. Identical prefixes are only routed to one of the
+	// peers, and nested prefixes route the more specific networks away
+	// from the peer with the less specific prefix.
+	OverlappingAllowedIPs
+)
+
+// String returns the string representation of an IssueKind.
+func (k IssueKind) String() string {
+	switch k {
+	case AsymmetricPeer:
+		return "asymmetric peer"
+	case UnknownPeer:
+		return "unknown peer"
+	case PresharedKeyMismatch:
+		return "preshared key mismatch"
+	case Unreachable:
+		return "unreachable"
+	case OverlappingAllowedIPs:
+		return "overlapping allowed IPs"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// An Issue is a consistency problem found by Check.
+type Issue struct {
+	Kind IssueKind
+
+	// Node is the name of the node whose configuration has the problem,
+	// and Peer is the name of the peer node involved, or the peer's public
+	// key if it is not a node.
+	Node, Peer string
+
+	// Detail describes the problem.
+	Detail string
+}
+
+// String returns a description of the Issue.
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: peer %s: %s: %s", i.Node, i.Peer, i.Kind, i.Detail)
+}
+
+// A Report is the result of Check.
+type Report struct {
+	Issues []Issue
+}
+
+// OK reports whether no issues were found.
+func (r *Report) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Check checks the configurations of nodes, keyed by node name, for
+// consistency. Nodes without a configuration are treated as having no peers.
+// Issues are reported in the order of nodes and their peers, and issues
+// which concern both nodes of a pair are reported once.
+func Check(nodes []Node, configs map[string]wgtypes.Config) Report {
+	var (
+		r     Report
+		names = make(map[wgtypes.Key]string, len(nodes))
+		peers = make(map[string]map[wgtypes.Key]*wgtypes.PeerConfig, len(nodes))
+	)
+
+	for _, n := range nodes {
+		names[n.PublicKey] = n.Name
+
+		cfg := configs[n.Name]
+		m := make(map[wgtypes.Key]*wgtypes.PeerConfig, len(cfg.Peers))
+		for i := range cfg.Peers {
+			if pc := &cfg.Peers[i]; !pc.Remove {
+				m[pc.PublicKey] = pc
+			}
+		}
+		peers[n.Name] = m
+	}
+
+	add := func(kind IssueKind, node, peer, format string, v ...interface{}) {
+		r.Issues = append(r.Issues, Issue{
+			Kind:   kind,
+			Node:   node,
+			Peer:   peer,
+			Detail: fmt.Sprintf(format, v...),
+		})
+	}
+
+	for _, n := range nodes {
+		cfg := configs[n.Name]
+		for i := range cfg.Peers {
+			pc := &cfg.Peers[i]
+			if pc.Remove {
+				continue
+			}
+
+			name, ok := names[pc.PublicKey]
+			if !ok {
+				add(UnknownPeer, n.Name, pc.PublicKey.String(), "public key does not belong to a node")
+				continue
+			}
+
+			back, ok := peers[name][n.PublicKey]
+			if !ok {
+				add(AsymmetricPeer, n.Name, name, "%s does not have %s as a peer", name, n.Name)
+				continue
+			}
+
+			// Report problems with both directions of a pair only once.
+			if n.Name > name {
+				continue
+			}
+
+			if keyOrZero(pc.PresharedKey) != keyOrZero(back.PresharedKey) {
+				add(PresharedKeyMismatch, n.Name, name, "%s and %s use different preshared keys", n.Name, name)
+			}
+			if pc.Endpoint == nil && back.Endpoint == nil {
+				add(Unreachable, n.Name, name, "neither %s nor %s has an endpoint for the other", n.Name, name)
+			}
+		}
+
+		checkOverlap(n.Name, cfg.Peers, names, add)
+	}
+
+	return r
+}
+
+// checkOverlap reports allowed IPs which overlap between peers of the node
+// with name.
+func checkOverlap(name string, pcs []wgtypes.PeerConfig, names map[wgtypes.Key]string, add func(IssueKind, string, string, string, ...interface{})) {
+	peerName := func(k wgtypes.Key) string {
+		if name, ok := names[k]; ok {
+			return name
+		}
+
+		return k.String()
+	}
+
+	for i := range pcs {
+		for j := i + 1; j < len(pcs); j++ {
+			a, b := &pcs[i], &pcs[j]
+			if a.Remove || b.Remove {
+				continue
+			}
+
+			for _, x := range a.AllowedIPs {
+				for _, y := range b.AllowedIPs {
+					if overlaps(x, y) {
+						add(OverlappingAllowedIPs, name, peerName(a.PublicKey), "%s overlaps %s of %s",
+							wgfmt.AllowedIP(x), wgfmt.AllowedIP(y), peerName(b.PublicKey))
+					}
+				}
+			}
+		}
+	}
+}
+
+// overlaps reports whether prefixes a and b of the same address family
+// overlap.
+func overlaps(a, b net.IPNet) bool {
+	_, abits := a.Mask.Size()
+	_, bbits := b.Mask.Size()
+	if abits != bbits {
+		return false
+	}
+
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func keyOrZero(k *wgtypes.Key) wgtypes.Key {
+	if k == nil {
+		return wgtypes.Key{}
+	}
+
+	return *k
+}
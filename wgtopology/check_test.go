@@ -0,0 +1,127 @@
+package wgtopology_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtopology"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestCheck(t *testing.T) {
+	var (
+		keyA    = wgtest.MustPublicKey()
+		keyB    = wgtest.MustPublicKey()
+		keyC    = wgtest.MustPublicKey()
+		unknown = wgtest.MustPublicKey()
+		psk     = wgtest.MustPresharedKey()
+	)
+
+	nodes := []wgtopology.Node{
+		{Name: "a", PublicKey: keyA},
+		{Name: "b", PublicKey: keyB},
+		{Name: "c", PublicKey: keyC},
+	}
+
+	configs := map[string]wgtypes.Config{
+		"a": {
+			Peers: []wgtypes.PeerConfig{
+				{
+					PublicKey:    keyB,
+					PresharedKey: &psk,
+					AllowedIPs:   []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+				},
+				{
+					PublicKey:  keyC,
+					Endpoint:   wgtest.MustUDPAddr("192.0.2.3:51820"),
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32"), wgtest.MustCIDR("fd00::3/128")},
+				},
+				{
+					PublicKey:  unknown,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("fd00::/64")},
+				},
+				{
+					// Removed peers are ignored.
+					PublicKey:  keyC,
+					Remove:     true,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.0/24")},
+				},
+			},
+		},
+		"b": {
+			Peers: []wgtypes.PeerConfig{
+				{
+					PublicKey:  keyA,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.1/32")},
+				},
+				{
+					PublicKey:  keyC,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")},
+				},
+			},
+		},
+		"c": {
+			Peers: []wgtypes.PeerConfig{
+				{
+					PublicKey:  keyA,
+					AllowedIPs: []net.IPNet{wgtest.MustCIDR("10.0.0.1/32")},
+				},
+			},
+		},
+	}
+
+	want := []wgtopology.Issue{
+		{
+			Kind:   wgtopology.PresharedKeyMismatch,
+			Node:   "a",
+			Peer:   "b",
+			Detail: "a and b use different preshared keys",
+		},
+		{
+			Kind:   wgtopology.Unreachable,
+			Node:   "a",
+			Peer:   "b",
+			Detail: "neither a nor b has an endpoint for the other",
+		},
+		{
+			Kind:   wgtopology.UnknownPeer,
+			Node:   "a",
+			Peer:   unknown.String(),
+			Detail: "public key does not belong to a node",
+		},
+		{
+			Kind:   wgtopology.OverlappingAllowedIPs,
+			Node:   "a",
+			Peer:   "b",
+			Detail: "10.0.0.0/24 overlaps 10.0.0.3/32 of c",
+		},
+		{
+			Kind:   wgtopology.OverlappingAllowedIPs,
+			Node:   "a",
+			Peer:   "c",
+			Detail: "fd00::3/128 overlaps fd00::/64 of " + unknown.String(),
+		},
+		{
+			Kind:   wgtopology.AsymmetricPeer,
+			Node:   "b",
+			Peer:   "c",
+			Detail: "c does not have b as a peer",
+		},
+	}
+
+	r := wgtopology.Check(nodes, configs)
+	if diff := cmp.Diff(want, r.Issues); diff != "" {
+		t.Fatalf("unexpected issues (-want +got):\n%s", diff)
+	}
+
+	if r.OK() {
+		t.Fatal("expected report with issues not to be OK")
+	}
+
+	const s = "a: peer b: preshared key mismatch: a and b use different preshared keys"
+	if diff := cmp.Diff(s, r.Issues[0].String()); diff != "" {
+		t.Fatalf("unexpected issue string (-want +got):\n%s", diff)
+	}
+}
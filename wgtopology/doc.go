@@ -0,0 +1,16 @@
+// Package wgtopology generates the WireGuard configuration of each node in a
+// network of nodes, for full-mesh, hub-and-spoke, and partial-mesh
+// topologies, and checks sets of configurations for consistency.
+//
+// Generate produces one wgtypes.Config per node. Each peer's allowed IPs
+// are the peer node's tunnel addresses, as host prefixes, and the subnets
+// routed to it. Nodes behind NAT send persistent keepalives to peers which
+// they can reach, so that their NAT mappings stay open, and a preshared key
+// can be used for each pair of nodes. Private keys are not handled by
+// wgtopology; each node keeps its own private key, and only public keys are
+// shared.
+//
+// Check reports problems in a set of configurations, such as a node whose
+// peer does not have it as a peer in return, or allowed IPs which overlap
+// between the peers of a node.
+package wgtopology // import "golang.zx2c4.com/wireguard/wgctrl/wgtopology"
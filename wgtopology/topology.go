@@ -0,0 +1,339 @@
+package wgtopology
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// A Kind is a kind of topology.
+type Kind int
+
+// Possible Kind values.
+const (
+	// FullMesh connects every node to every other node.
+	FullMesh Kind = iota
+
+	// HubAndSpoke connects every node to a single hub node, which routes
+	// traffic between the other nodes, the spokes.
+	HubAndSpoke
+
+	// PartialMesh connects the pairs of nodes specified by
+	// Topology.Links.
+	PartialMesh
+)
+
+// String returns the string representation of a Kind.
+func (k Kind) String() string {
+	switch k {
+	case FullMesh:
+		return "full mesh"
+	case HubAndSpoke:
+		return "hub and spoke"
+	case PartialMesh:
+		return "partial mesh"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// A Node is a member of a topology.
+type Node struct {
+	// Name uniquely identifies the node.
+	Name string
+
+	// PublicKey is the public key of the node's WireGuard device.
+	PublicKey wgtypes.Key
+
+	// Endpoint is the address at which other nodes can reach the node, or
+	// nil if the node cannot be reached directly, such as a node behind
+	// NAT.
+	Endpoint *net.UDPAddr
+
+	// ListenPort is the listening port of the node's device. If zero, the
+	// listening port is not configured.
+	ListenPort int
+
+	// Addresses are the node's tunnel addresses, such as 10.0.0.1/24. Other
+	// nodes route each address to the node as a host prefix, such as
+	// 10.0.0.1/32.
+	Addresses []net.IPNet
+
+	// Subnets are the networks routed to the node through the tunnel.
+	Subnets []net.IPNet
+
+	// NAT specifies that the node is behind NAT, so that it sends
+	// persistent keepalives to the peers which it can reach.
+	NAT bool
+}
+
+// A Pair is an unordered pair of node names. Pairs created with NewPair can
+// be compared and used as map keys.
+type Pair struct {
+	A, B string
+}
+
+// NewPair returns the Pair of nodes a and b, with the names in order.
+func NewPair(a, b string) Pair {
+	if b < a {
+		a, b = b, a
+	}
+
+	return Pair{A: a, B: b}
+}
+
+// DefaultKeepalive is the persistent keepalive interval used for nodes behind
+// NAT if Topology.Keepalive is zero.
+const DefaultKeepalive = 25 * time.Second
+
+// A Topology describes a network of nodes.
+type Topology struct {
+	// Kind specifies how the nodes are connected.
+	Kind Kind
+
+	// Nodes are the nodes of the topology. The peers of each generated
+	// configuration are in the same order as Nodes.
+	Nodes []Node
+
+	// Hub is the name of the hub node of a HubAndSpoke topology.
+	Hub string
+
+	// Links are the connected pairs of nodes of a PartialMesh topology.
+	Links []Pair
+
+	// Keepalive is the persistent keepalive interval of nodes behind NAT.
+	// If zero, DefaultKeepalive is used.
+	Keepalive time.Duration
+
+	// PresharedKeys are existing preshared keys for pairs of nodes, keyed
+	// by pairs created with NewPair.
+	PresharedKeys map[Pair]wgtypes.Key
+
+	// GeneratePresharedKeys specifies that a preshared key is generated for
+	// each connected pair of nodes which does not have one in
+	// PresharedKeys.
+	GeneratePresharedKeys bool
+}
+
+// A Result is the output of Generate.
+type Result struct {
+	// Configs are the configurations of the nodes, keyed by node name.
+	// Each configuration replaces all of the peers of a device, and does
+	// not specify a private key.
+	Configs map[string]wgtypes.Config
+
+	// PresharedKeys are the preshared keys of the connected pairs of
+	// nodes, including generated keys, keyed by pairs created with NewPair.
+	// Generated keys must be stored so that they can be passed to
+	// Topology.PresharedKeys when the topology changes.
+	PresharedKeys map[Pair]wgtypes.Key
+
+	// Report is the result of checking Configs with Check.
+	Report Report
+}
+
+// Generate generates the configuration of each node of t.
+func Generate(t *Topology) (*Result, error) {
+	nodes, err := t.index()
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := t.links(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	keepalive := t.Keepalive
+	if keepalive == 0 {
+		keepalive = DefaultKeepalive
+	}
+
+	res := &Result{
+		Configs:       make(map[string]wgtypes.Config, len(t.Nodes)),
+		PresharedKeys: make(map[Pair]wgtypes.Key),
+	}
+
+	for _, n := range t.Nodes {
+		cfg := wgtypes.Config{ReplacePeers: true}
+		if n.ListenPort != 0 {
+			port := n.ListenPort
+			cfg.ListenPort = &port
+		}
+
+		for _, p := range t.Nodes {
+			pair := NewPair(n.Name, p.Name)
+			if !links[pair] {
+				continue
+			}
+
+			pc := wgtypes.PeerConfig{
+				PublicKey:         p.PublicKey,
+				Endpoint:          p.Endpoint,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        t.routes(&p),
+			}
+
+			// A node behind NAT must keep its mapping open so that peers can
+			// reach it, which is only possible if it can reach the peer.
+			if n.NAT && p.Endpoint != nil {
+				ka := keepalive
+				pc.PersistentKeepaliveInterval = &ka
+			}
+
+			psk, err := t.presharedKey(pair, res.PresharedKeys)
+			if err != nil {
+				return nil, err
+			}
+			if psk != nil {
+				pc.PresharedKey = psk
+			}
+
+			cfg.Peers = append(cfg.Peers, pc)
+		}
+
+		res.Configs[n.Name] = cfg
+	}
+
+	res.Report = Check(t.Nodes, res.Configs)
+	return res, nil
+}
+
+// index validates the nodes of t and returns them keyed by name.
+func (t *Topology) index() (map[string]*Node, error) {
+	var (
+		nodes = make(map[string]*Node, len(t.Nodes))
+		keys  = make(map[wgtypes.Key]string, len(t.Nodes))
+	)
+
+	for i := range t.Nodes {
+		n := &t.Nodes[i]
+		if n.Name == "" {
+			return nil, fmt.Errorf("wgtopology: node %d has no name", i)
+		}
+		if _, ok := nodes[n.Name]; ok {
+			return nil, fmt.Errorf("wgtopology: duplicate node %q", n.Name)
+		}
+		if n.PublicKey == (wgtypes.Key{}) {
+			return nil, fmt.Errorf("wgtopology: node %q has no public key", n.Name)
+		}
+		if other, ok := keys[n.PublicKey]; ok {
+			return nil, fmt.Errorf("wgtopology: nodes %q and %q have the same public key", other, n.Name)
+		}
+
+		nodes[n.Name] = n
+		keys[n.PublicKey] = n.Name
+	}
+
+	return nodes, nil
+}
+
+// links returns the connected pairs of nodes of t.
+func (t *Topology) links(nodes map[string]*Node) (map[Pair]bool, error) {
+	links := make(map[Pair]bool)
+
+	switch t.Kind {
+	case FullMesh:
+		for i, a := range t.Nodes {
+			for _, b := range t.Nodes[i+1:] {
+				links[NewPair(a.Name, b.Name)] = true
+			}
+		}
+	case HubAndSpoke:
+		if _, ok := nodes[t.Hub]; !ok {
+			return nil, fmt.Errorf("wgtopology: hub %q is not a node", t.Hub)
+		}
+
+		for _, n := range t.Nodes {
+			if n.Name != t.Hub {
+				links[NewPair(t.Hub, n.Name)] = true
+			}
+		}
+	case PartialMesh:
+		for _, l := range t.Links {
+			for _, name := range []string{l.A, l.B} {
+				if _, ok := nodes[name]; !ok {
+					return nil, fmt.Errorf("wgtopology: link %s-%s: %q is not a node", l.A, l.B, name)
+				}
+			}
+			if l.A == l.B {
+				return nil, fmt.Errorf("wgtopology: link %s-%s connects a node to itself", l.A, l.B)
+			}
+
+			links[NewPair(l.A, l.B)] = true
+		}
+	default:
+		return nil, fmt.Errorf("wgtopology: invalid topology kind: %s", t.Kind)
+	}
+
+	return links, nil
+}
+
+// routes returns the allowed IPs of node n as a peer: its tunnel addresses
+// as host prefixes and its subnets. In a HubAndSpoke topology, the spokes
+// route the networks of the other spokes through the hub.
+func (t *Topology) routes(n *Node) []net.IPNet {
+	ipns := nodeRoutes(n)
+	if t.Kind != HubAndSpoke || n.Name != t.Hub {
+		return ipns
+	}
+
+	for i := range t.Nodes {
+		if s := &t.Nodes[i]; s.Name != t.Hub {
+			ipns = append(ipns, nodeRoutes(s)...)
+		}
+	}
+
+	return ipns
+}
+
+// nodeRoutes returns the networks of n: its tunnel addresses as host
+// prefixes and its subnets.
+func nodeRoutes(n *Node) []net.IPNet {
+	ipns := make([]net.IPNet, 0, len(n.Addresses)+len(n.Subnets))
+	for _, a := range n.Addresses {
+		ip, bits := a.IP.To4(), 8*net.IPv4len
+		if ip == nil {
+			ip, bits = a.IP.To16(), 8*net.IPv6len
+		}
+
+		ipns = append(ipns, net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		})
+	}
+
+	for _, s := range n.Subnets {
+		ipns = append(ipns, net.IPNet{
+			IP:   s.IP.Mask(s.Mask),
+			Mask: s.Mask,
+		})
+	}
+
+	return ipns
+}
+
+// presharedKey returns the preshared key of pair, or nil if the pair has
+// none, and records it in out.
+func (t *Topology) presharedKey(pair Pair, out map[Pair]wgtypes.Key) (*wgtypes.Key, error) {
+	psk, ok := out[pair]
+	if !ok {
+		psk, ok = t.PresharedKeys[pair]
+	}
+	if !ok && t.GeneratePresharedKeys {
+		k, err := wgtypes.GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("wgtopology: failed to generate preshared key: %v", err)
+		}
+
+		psk, ok = k, true
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	out[pair] = psk
+	return &psk, nil
+}
@@ -0,0 +1,314 @@
+package wgtopology_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.zx2c4.com/wireguard/wgctrl/internal/wgtest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtopology"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestGenerate(t *testing.T) {
+	var (
+		keyA = wgtest.MustPublicKey()
+		keyB = wgtest.MustPublicKey()
+		keyC = wgtest.MustPublicKey()
+		psk  = wgtest.MustPresharedKey()
+	)
+
+	nodes := func() []wgtopology.Node {
+		return []wgtopology.Node{
+			{
+				Name:       "a",
+				PublicKey:  keyA,
+				Endpoint:   wgtest.MustUDPAddr("192.0.2.1:51820"),
+				ListenPort: 51820,
+				Addresses: []net.IPNet{
+					{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(24, 32)},
+					{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)},
+				},
+				Subnets: []net.IPNet{wgtest.MustCIDR("192.168.1.0/24")},
+			},
+			{
+				Name:      "b",
+				PublicKey: keyB,
+				Endpoint:  wgtest.MustUDPAddr("192.0.2.2:51820"),
+				Addresses: []net.IPNet{{IP: net.IPv4(10, 0, 0, 2), Mask: net.CIDRMask(24, 32)}},
+			},
+			{
+				Name:      "c",
+				PublicKey: keyC,
+				Addresses: []net.IPNet{{IP: net.IPv4(10, 0, 0, 3), Mask: net.CIDRMask(24, 32)}},
+				NAT:       true,
+			},
+		}
+	}
+
+	var (
+		routesA = []net.IPNet{
+			wgtest.MustCIDR("10.0.0.1/32"),
+			wgtest.MustCIDR("fd00::1/128"),
+			wgtest.MustCIDR("192.168.1.0/24"),
+		}
+		routesB = []net.IPNet{wgtest.MustCIDR("10.0.0.2/32")}
+		routesC = []net.IPNet{wgtest.MustCIDR("10.0.0.3/32")}
+	)
+
+	peer := func(k wgtypes.Key, endpoint *net.UDPAddr, keepalive time.Duration, psk *wgtypes.Key, ipns ...[]net.IPNet) wgtypes.PeerConfig {
+		pc := wgtypes.PeerConfig{
+			PublicKey:         k,
+			PresharedKey:      psk,
+			Endpoint:          endpoint,
+			ReplaceAllowedIPs: true,
+		}
+		if keepalive != 0 {
+			pc.PersistentKeepaliveInterval = &keepalive
+		}
+		for _, s := range ipns {
+			pc.AllowedIPs = append(pc.AllowedIPs, s...)
+		}
+
+		return pc
+	}
+
+	var (
+		endpointA = wgtest.MustUDPAddr("192.0.2.1:51820")
+		endpointB = wgtest.MustUDPAddr("192.0.2.2:51820")
+		port      = 51820
+	)
+
+	tests := []struct {
+		name string
+		t    *wgtopology.Topology
+		want map[string]wgtypes.Config
+		psks map[wgtopology.Pair]wgtypes.Key
+	}{
+		{
+			name: "full mesh",
+			t: &wgtopology.Topology{
+				Kind:  wgtopology.FullMesh,
+				Nodes: nodes(),
+				PresharedKeys: map[wgtopology.Pair]wgtypes.Key{
+					wgtopology.NewPair("b", "a"): psk,
+				},
+			},
+			want: map[string]wgtypes.Config{
+				"a": {
+					ListenPort:   &port,
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyB, endpointB, 0, &psk, routesB),
+						peer(keyC, nil, 0, nil, routesC),
+					},
+				},
+				"b": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyA, endpointA, 0, &psk, routesA),
+						peer(keyC, nil, 0, nil, routesC),
+					},
+				},
+				"c": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyA, endpointA, 25*time.Second, nil, routesA),
+						peer(keyB, endpointB, 25*time.Second, nil, routesB),
+					},
+				},
+			},
+			psks: map[wgtopology.Pair]wgtypes.Key{
+				{A: "a", B: "b"}: psk,
+			},
+		},
+		{
+			name: "hub and spoke",
+			t: &wgtopology.Topology{
+				Kind:      wgtopology.HubAndSpoke,
+				Nodes:     nodes(),
+				Hub:       "a",
+				Keepalive: 10 * time.Second,
+			},
+			want: map[string]wgtypes.Config{
+				"a": {
+					ListenPort:   &port,
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyB, endpointB, 0, nil, routesB),
+						peer(keyC, nil, 0, nil, routesC),
+					},
+				},
+				"b": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyA, endpointA, 0, nil, routesA, routesB, routesC),
+					},
+				},
+				"c": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyA, endpointA, 10*time.Second, nil, routesA, routesB, routesC),
+					},
+				},
+			},
+			psks: map[wgtopology.Pair]wgtypes.Key{},
+		},
+		{
+			name: "partial mesh",
+			t: &wgtopology.Topology{
+				Kind:  wgtopology.PartialMesh,
+				Nodes: nodes()[1:],
+				Links: []wgtopology.Pair{{A: "c", B: "b"}},
+			},
+			want: map[string]wgtypes.Config{
+				"b": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyC, nil, 0, nil, routesC),
+					},
+				},
+				"c": {
+					ReplacePeers: true,
+					Peers: []wgtypes.PeerConfig{
+						peer(keyB, endpointB, 25*time.Second, nil, routesB),
+					},
+				},
+			},
+			psks: map[wgtopology.Pair]wgtypes.Key{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := wgtopology.Generate(tt.t)
+			if err != nil {
+				t.Fatalf("failed to generate: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, res.Configs); diff != "" {
+				t.Fatalf("unexpected configurations (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.psks, res.PresharedKeys); diff != "" {
+				t.Fatalf("unexpected preshared keys (-want +got):\n%s", diff)
+			}
+
+			if !res.Report.OK() {
+				t.Fatalf("unexpected issues: %v", res.Report.Issues)
+			}
+		})
+	}
+}
+
+func TestGenerateKeys(t *testing.T) {
+	var nodes []wgtopology.Node
+	for _, name := range []string{"a", "b", "c", "d"} {
+		nodes = append(nodes, wgtopology.Node{
+			Name:      name,
+			PublicKey: wgtest.MustPublicKey(),
+			Endpoint:  wgtest.MustUDPAddr("192.0.2.1:51820"),
+		})
+	}
+
+	existing := wgtest.MustPresharedKey()
+	res, err := wgtopology.Generate(&wgtopology.Topology{
+		Nodes: nodes,
+		PresharedKeys: map[wgtopology.Pair]wgtypes.Key{
+			wgtopology.NewPair("c", "a"): existing,
+		},
+		GeneratePresharedKeys: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+
+	if diff := cmp.Diff(6, len(res.PresharedKeys)); diff != "" {
+		t.Fatalf("unexpected number of preshared keys (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(existing, res.PresharedKeys[wgtopology.Pair{A: "a", B: "c"}]); diff != "" {
+		t.Fatalf("unexpected existing preshared key (-want +got):\n%s", diff)
+	}
+
+	seen := make(map[wgtypes.Key]bool)
+	for pair, psk := range res.PresharedKeys {
+		if seen[psk] {
+			t.Fatalf("preshared key of %v is reused", pair)
+		}
+		seen[psk] = true
+	}
+
+	// Check reports any preshared keys which do not match.
+	if !res.Report.OK() {
+		t.Fatalf("unexpected issues: %v", res.Report.Issues)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	var (
+		keyA = wgtest.MustPublicKey()
+		keyB = wgtest.MustPublicKey()
+	)
+
+	nodes := []wgtopology.Node{
+		{Name: "a", PublicKey: keyA},
+		{Name: "b", PublicKey: keyB},
+	}
+
+	tests := []struct {
+		name string
+		t    *wgtopology.Topology
+		err  string
+	}{
+		{
+			name: "no name",
+			t:    &wgtopology.Topology{Nodes: []wgtopology.Node{{PublicKey: keyA}}},
+			err:  "wgtopology: node 0 has no name",
+		},
+		{
+			name: "duplicate name",
+			t:    &wgtopology.Topology{Nodes: []wgtopology.Node{nodes[0], nodes[0]}},
+			err:  `wgtopology: duplicate node "a"`,
+		},
+		{
+			name: "no key",
+			t:    &wgtopology.Topology{Nodes: []wgtopology.Node{{Name: "a"}}},
+			err:  `wgtopology: node "a" has no public key`,
+		},
+		{
+			name: "duplicate key",
+			t:    &wgtopology.Topology{Nodes: []wgtopology.Node{nodes[0], {Name: "b", PublicKey: keyA}}},
+			err:  `wgtopology: nodes "a" and "b" have the same public key`,
+		},
+		{
+			name: "hub",
+			t:    &wgtopology.Topology{Kind: wgtopology.HubAndSpoke, Nodes: nodes, Hub: "c"},
+			err:  `wgtopology: hub "c" is not a node`,
+		},
+		{
+			name: "link node",
+			t:    &wgtopology.Topology{Kind: wgtopology.PartialMesh, Nodes: nodes, Links: []wgtopology.Pair{{A: "a", B: "c"}}},
+			err:  `wgtopology: link a-c: "c" is not a node`,
+		},
+		{
+			name: "link self",
+			t:    &wgtopology.Topology{Kind: wgtopology.PartialMesh, Nodes: nodes, Links: []wgtopology.Pair{{A: "a", B: "a"}}},
+			err:  "wgtopology: link a-a connects a node to itself",
+		},
+		{
+			name: "kind",
+			t:    &wgtopology.Topology{Kind: 10, Nodes: nodes},
+			err:  "wgtopology: invalid topology kind: unknown(10)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wgtopology.Generate(tt.t)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
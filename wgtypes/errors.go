@@ -2,9 +2,72 @@ package wgtypes
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 // ErrUpdateOnlyNotSupported is returned due to missing kernel support of
 // the PeerConfig UpdateOnly flag.
 var ErrUpdateOnlyNotSupported = errors.New("the UpdateOnly flag is not supported by this platform")
 
+// An OpError is an error reported by the operating system while querying or
+// configuring a device, along with any additional information the operating
+// system provided about the cause of the error.
+//
+// OpErrors wrap the underlying error, so callers should use errors.Is or
+// errors.As to inspect errors rather than comparing them directly.
+type OpError struct {
+	// Op is the operation which failed, either "get" or "set".
+	Op string
+
+	// Device is the name of the device, if known.
+	Device string
+
+	// Batch and Batches identify the message which was rejected when a
+	// configuration was split into multiple messages. Batch is the zero-based
+	// index of the rejected message out of Batches total messages. Batches is
+	// zero if the operation used a single message.
+	Batch, Batches int
+
+	// Peer is the public key of the peer whose configuration was rejected,
+	// if known.
+	Peer *Key
+
+	// Attribute is the name of the protocol attribute which was rejected,
+	// if known, such as "WGPEER_A_ENDPOINT".
+	Attribute string
+
+	// Message is the explanation of the error reported by the operating
+	// system, if any, such as "invalid endpoint".
+	Message string
+
+	// Err is the underlying error, such as a system call error number.
+	Err error
+}
+
+// Error implements error.
+func (e *OpError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Op)
+	if e.Device != "" {
+		sb.WriteString(" " + e.Device)
+	}
+	if e.Batches > 0 {
+		fmt.Fprintf(&sb, " (batch %d of %d)", e.Batch+1, e.Batches)
+	}
+	if e.Peer != nil {
+		fmt.Fprintf(&sb, ": peer %s", e.Peer)
+	}
+	if e.Attribute != "" {
+		fmt.Fprintf(&sb, ": attribute %s", e.Attribute)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&sb, ": %s", e.Message)
+	}
+	fmt.Fprintf(&sb, ": %v", e.Err)
+
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error { return e.Err }
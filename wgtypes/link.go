@@ -0,0 +1,99 @@
+package wgtypes
+
+import (
+	"fmt"
+	"net"
+)
+
+// A Link contains information about the network interface which backs a
+// device, as reported by the operating system.
+type Link struct {
+	// MTU is the maximum transmission unit of the interface.
+	MTU int
+
+	// Flags are the interface's flags, such as whether it is up.
+	Flags net.Flags
+
+	// OperState is the operational state of the interface.
+	OperState OperState
+
+	// Alias is the administratively assigned alias of the interface, if
+	// any.
+	Alias string
+
+	// Stats contains the interface's statistics, or nil if the operating
+	// system does not report statistics.
+	Stats *LinkStats
+}
+
+// An OperState is the operational state of a network interface, as defined
+// in RFC 2863.
+type OperState int
+
+// Possible OperState values.
+const (
+	OperUnknown OperState = iota
+	OperNotPresent
+	OperDown
+	OperLowerLayerDown
+	OperTesting
+	OperDormant
+	OperUp
+)
+
+// String returns the string representation of an OperState.
+func (s OperState) String() string {
+	switch s {
+	case OperUnknown:
+		return "unknown"
+	case OperNotPresent:
+		return "notpresent"
+	case OperDown:
+		return "down"
+	case OperLowerLayerDown:
+		return "lowerlayerdown"
+	case OperTesting:
+		return "testing"
+	case OperDormant:
+		return "dormant"
+	case OperUp:
+		return "up"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// LinkStats contains the packet and error counters of a network interface.
+// Counters which are not reported by the operating system are zero.
+type LinkStats struct {
+	ReceivePackets  uint64
+	TransmitPackets uint64
+	ReceiveBytes    uint64
+	TransmitBytes   uint64
+	ReceiveErrors   uint64
+	TransmitErrors  uint64
+	ReceiveDropped  uint64
+	TransmitDropped uint64
+	Multicast       uint64
+	Collisions      uint64
+
+	// Detailed receive errors.
+	ReceiveLengthErrors uint64
+	ReceiveOverErrors   uint64
+	ReceiveCRCErrors    uint64
+	ReceiveFrameErrors  uint64
+	ReceiveFIFOErrors   uint64
+	ReceiveMissedErrors uint64
+
+	// Detailed transmit errors.
+	TransmitAbortedErrors   uint64
+	TransmitCarrierErrors   uint64
+	TransmitFIFOErrors      uint64
+	TransmitHeartbeatErrors uint64
+	TransmitWindowErrors    uint64
+
+	// Compression and miscellaneous counters.
+	ReceiveCompressed  uint64
+	TransmitCompressed uint64
+	ReceiveNoHandler   uint64
+}
@@ -0,0 +1,64 @@
+package wgtypes
+
+import (
+	"time"
+)
+
+// Redacted is the value reported in place of private and preshared keys in
+// a Trace.
+const Redacted = "(redacted)"
+
+// A Trace is a record of a single request and response exchange between
+// wgctrl and the operating system or userspace process which implements a
+// WireGuard device.
+//
+// Traces are intended to aid debugging and may be encoded as JSON for
+// inclusion in bug reports. Private and preshared keys are always replaced
+// with the value of Redacted.
+type Trace struct {
+	// Time is the time at which the request was issued.
+	Time time.Time `json:"time"`
+
+	// Duration is the amount of time taken by the exchange.
+	Duration time.Duration `json:"duration"`
+
+	// Protocol names the configuration protocol used for the exchange, such
+	// as "uapi" for the userspace configuration protocol or "genetlink" for
+	// Linux generic netlink.
+	Protocol string `json:"protocol"`
+
+	// Device is the name of the device, if known.
+	Device string `json:"device,omitempty"`
+
+	// Op is the operation performed, either "get" or "set".
+	Op string `json:"op"`
+
+	// Request contains the decoded fields of the request.
+	Request []TraceField `json:"request,omitempty"`
+
+	// Response contains the decoded fields of the response. For protocols
+	// which may split a response into multiple messages, each message
+	// appears as a separate field with nested Fields.
+	Response []TraceField `json:"response,omitempty"`
+
+	// Error is the text of the error returned by the exchange, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// A TraceField is a single decoded protocol field within a Trace, such as
+// a userspace configuration protocol key=value pair or a netlink attribute.
+type TraceField struct {
+	// Key is the name of the field.
+	Key string `json:"key"`
+
+	// Value is the textual representation of the field's value. Value is
+	// empty for fields which only contain nested Fields.
+	Value string `json:"value,omitempty"`
+
+	// Fields contains nested fields, if any.
+	Fields []TraceField `json:"fields,omitempty"`
+}
+
+// A TraceFunc receives a Trace for each exchange performed by a Client.
+// A TraceFunc may be called concurrently from multiple goroutines.
+type TraceFunc func(t Trace)
@@ -46,6 +46,11 @@ type Device struct {
 	// Name is the name of the device.
 	Name string
 
+	// Index is the interface index of the device, if reported by the
+	// operating system. Unlike Name, Index does not change when a device is
+	// renamed. Index is zero if unknown.
+	Index int
+
 	// Type specifies the underlying implementation of the device.
 	Type DeviceType
 
@@ -66,6 +71,11 @@ type Device struct {
 
 	// Peers is the list of network peers associated with this device.
 	Peers []Peer
+
+	// Link contains information about the device's network interface, such
+	// as its MTU and statistics. Link is only populated when requested and
+	// supported by the operating system, and is nil otherwise.
+	Link *Link
 }
 
 // KeyLen is the expected key length for a WireGuard key.
@@ -273,4 +283,14 @@ type PeerConfig struct {
 	// AllowedIPs specifies a list of allowed IP addresses in CIDR notation
 	// for this peer.
 	AllowedIPs []net.IPNet
+
+	// RemoveAllowedIPs specifies a list of allowed IP addresses in CIDR
+	// notation which should be removed from this peer, leaving any other
+	// allowed IPs in place. Removals are applied after AllowedIPs.
+	//
+	// Devices which cannot remove individual allowed IPs natively emulate
+	// removal by retrieving the peer's current allowed IPs and replacing
+	// them with the remaining list. Emulated removal is not atomic with
+	// respect to other changes made to the device concurrently.
+	RemoveAllowedIPs []net.IPNet
 }